@@ -228,6 +228,12 @@ func registerQuery(app *extkingpin.App) {
 	reqLogConfig := extkingpin.RegisterRequestLoggingFlags(cmd)
 
 	alertQueryURL := cmd.Flag("alert.query-url", "The external Thanos Query URL that would be set in all alerts 'Source' field.").String()
+	requestReplaySize := cmd.Flag("store.request-replay-size", "Number of recent Series requests kept so that they can be replayed with POST /debug/replay/{index}, 0 being the most recent one. 0 disables request replay. Hidden and only used for debugging.").
+		Default("0").Hidden().Int()
+
+	storeHealthProbeInterval := extkingpin.ModelDuration(cmd.Flag("store.health-probe-interval", "Interval at which all stores are probed with an Info call. If set, /ready responds with 200 only while a store responded recently, and with 503 otherwise. 0 disables the probe and the endpoint.").
+		Default("0s").Hidden())
+
 	grpcProxyStrategy := cmd.Flag("grpc.proxy-strategy", "Strategy to use when proxying Series requests to leaf nodes. Hidden and only used for testing, will be removed after lazy becomes the default.").Default(string(store.EagerRetrieval)).Hidden().Enum(string(store.EagerRetrieval), string(store.LazyRetrieval))
 
 	queryTelemetryDurationQuantiles := cmd.Flag("query.telemetry.request-duration-seconds-quantiles", "The quantiles for exporting metrics about the request duration quantiles.").Default("0.1", "0.25", "0.75", "1.25", "1.75", "2.5", "3", "5", "10").Float64List()
@@ -362,6 +368,8 @@ func registerQuery(app *extkingpin.App) {
 			*webDisableCORS,
 			*alertQueryURL,
 			*grpcProxyStrategy,
+			*requestReplaySize,
+			time.Duration(*storeHealthProbeInterval),
 			component.Query,
 			*queryTelemetryDurationQuantiles,
 			*queryTelemetrySamplesQuantiles,
@@ -446,6 +454,8 @@ func runQuery(
 	disableCORS bool,
 	alertQueryURL string,
 	grpcProxyStrategy string,
+	requestReplaySize int,
+	storeHealthProbeInterval time.Duration,
 	comp component.Component,
 	queryTelemetryDurationQuantiles []float64,
 	queryTelemetrySamplesQuantiles []float64,
@@ -536,6 +546,8 @@ func runQuery(
 	options := []store.ProxyStoreOption{
 		store.WithTSDBSelector(tsdbSelector),
 		store.WithProxyStoreDebugLogging(debugLogging),
+		store.WithRequestReplay(requestReplaySize),
+		store.WithHealthProbe(storeHealthProbeInterval),
 	}
 
 	var (
@@ -781,6 +793,12 @@ func runQuery(
 			httpserver.WithTLSConfig(httpTLSConfig),
 		)
 		srv.Handle("/", router)
+		if requestReplaySize > 0 {
+			srv.Handle("/debug/replay/", proxy.ReplayHandler())
+		}
+		if storeHealthProbeInterval > 0 {
+			srv.Handle("/ready", proxy.ReadyHandler())
+		}
 
 		g.Add(func() error {
 			statusProber.Healthy()
@@ -6,6 +6,8 @@ package main
 import (
 	"net"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -46,6 +48,9 @@ type queryFrontendConfig struct {
 	http           httpConfig
 	webDisableCORS bool
 	orgIdHeaders   []string
+
+	failedQueryCacheGossipKeyFile string
+	allowGzipRequests             bool
 }
 
 func registerQueryFrontend(app *extkingpin.App) {
@@ -149,9 +154,30 @@ func registerQueryFrontend(app *extkingpin.App) {
 
 	cmd.Flag("query-frontend.log-failed-queries", "Log failed queries due to any reason").Default("true").BoolVar(&cfg.CortexHandlerConfig.LogFailedQueries)
 
+	cmd.Flag("query-frontend.allow-gzip-requests", "Decompress query request bodies sent with Content-Encoding: gzip.").
+		Default("true").BoolVar(&cfg.allowGzipRequests)
+
+	cmd.Flag("query-frontend.audit-log-enabled", "Write an audit record of every query to the audit log file.").
+		Default("false").BoolVar(&cfg.CortexHandlerConfig.AuditLogEnabled)
+
+	cmd.Flag("query-frontend.audit-log-file", "File the audit log is appended to, as JSON lines.").
+		Default("").StringVar(&cfg.CortexHandlerConfig.AuditLogFile)
+
 	cmd.Flag("failed-query-cache-capacity", "Capacity of cache for failed queries. 0 means this feature is disabled.").
 		Default("0").IntVar(&cfg.CortexHandlerConfig.FailedQueryCacheCapacity)
 
+	cmd.Flag("query-frontend.failed-query-cache-metrics-max-queries", "Number of the most recently failed queries held by the failed query cache to expose as the thanos_blocked_query_info metric. 0 disables the metric.").
+		Default("0").IntVar(&cfg.CortexHandlerConfig.FailedQueryCacheMetricsMaxQueries)
+
+	cmd.Flag("query-frontend.failed-query-cache-gossip-bind-address", "UDP address on which cached failed queries are shared with the other query frontend replicas. Empty disables sharing.").
+		Default("").StringVar(&cfg.CortexHandlerConfig.FailedQueryCacheGossipBindAddr)
+
+	cmd.Flag("query-frontend.failed-query-cache-gossip-peer", "UDP address of another query frontend replica sharing cached failed queries (repeated).").
+		PlaceHolder("<host:port>").StringsVar(&cfg.CortexHandlerConfig.FailedQueryCacheGossipPeers)
+
+	cmd.Flag("query-frontend.failed-query-cache-gossip-key-file", "File with the key, shared by all replicas, authenticating the shared failed queries. Required if sharing is enabled.").
+		Default("").StringVar(&cfg.failedQueryCacheGossipKeyFile)
+
 	cmd.Flag("query-frontend.org-id-header", "Deprecation Warning - This flag will be soon deprecated in favor of query-frontend.tenant-header"+
 		" and both flags cannot be used at the same time. "+
 		"Request header names used to identify the source of slow queries (repeated flag). "+
@@ -256,6 +282,19 @@ func runQueryFrontend(
 		cfg.orgIdHeaders = append(cfg.orgIdHeaders, cfg.TenantHeader)
 	}
 
+	cfg.CortexHandlerConfig.DisableGzipRequests = !cfg.allowGzipRequests
+
+	if cfg.CortexHandlerConfig.FailedQueryCacheGossipBindAddr != "" {
+		if cfg.failedQueryCacheGossipKeyFile == "" {
+			return errors.New("query-frontend.failed-query-cache-gossip-key-file is required to share the failed query cache")
+		}
+		key, err := os.ReadFile(cfg.failedQueryCacheGossipKeyFile)
+		if err != nil {
+			return errors.Wrap(err, "read failed query cache gossip key")
+		}
+		cfg.CortexHandlerConfig.FailedQueryCacheGossipKey = strings.TrimSpace(string(key))
+	}
+
 	// Temporarily manually adding the default tenant header into the list of headers to forward and org id headers.
 	// This facilitates the transition from org id to tenant id with minimal amount of changes.
 	cfg.ForwardHeaders = append(cfg.ForwardHeaders, tenancy.DefaultTenantHeader)
@@ -326,7 +365,19 @@ func runQueryFrontend(
 	roundTripper = tripperWare(roundTripper)
 
 	// Create the query frontend transport.
-	handler := transport.NewHandler(*cfg.CortexHandlerConfig, roundTripper, logger, nil)
+	var handlerOpts []transport.HandlerOption
+	if cfg.CortexHandlerConfig.AuditLogEnabled {
+		auditLog, err := transport.OpenAuditLogFile(cfg.CortexHandlerConfig.AuditLogFile)
+		if err != nil {
+			return errors.Wrap(err, "open audit log file")
+		}
+		handlerOpts = append(handlerOpts, transport.WithAuditLogWriter(auditLog))
+	}
+	handler, err := transport.NewHandler(*cfg.CortexHandlerConfig, roundTripper, logger, reg, handlerOpts...)
+	if err != nil {
+		return errors.Wrap(err, "create query frontend handler")
+	}
+	frontendHandler := handler.(*transport.Handler)
 	if cfg.CompressResponses {
 		handler = gzhttp.GzipHandler(handler)
 	}
@@ -384,6 +435,9 @@ func runQueryFrontend(
 			defer statusProber.NotHealthy(err)
 
 			srv.Shutdown(err)
+			if err := frontendHandler.Close(); err != nil {
+				level.Warn(logger).Log("msg", "failed to close query frontend handler", "err", err)
+			}
 		})
 	}
 
@@ -0,0 +1,104 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package transport
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// OpenAuditLogFile opens the audit log file for appending, creating it if needed.
+func OpenAuditLogFile(file string) (io.WriteCloser, error) {
+	return os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+}
+
+// WithAuditLogWriter writes the audit log to w instead of HandlerConfig.AuditLogFile. It is only used if
+// HandlerConfig.AuditLogEnabled is set, and is closed by Handler.Close.
+func WithAuditLogWriter(w io.WriteCloser) HandlerOption {
+	return func(h *Handler) {
+		h.auditWriter = w
+	}
+}
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Tenant       string    `json:"tenant"`
+	Method       string    `json:"method"`
+	Path         string    `json:"path"`
+	Query        string    `json:"query"`
+	ResponseCode int       `json:"response_code"`
+	Duration     string    `json:"duration"`
+}
+
+// auditLog writes audit records as JSON lines. Records are buffered and flushed once written, so that they are
+// not lost if the process is killed.
+type auditLog struct {
+	mtx    sync.Mutex
+	w      io.WriteCloser
+	buf    *bufio.Writer
+	closed bool
+}
+
+func newAuditLog(w io.WriteCloser) *auditLog {
+	return &auditLog{w: w, buf: bufio.NewWriter(w)}
+}
+
+func (l *auditLog) Write(rec auditRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	if _, err := l.buf.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return l.buf.Flush()
+}
+
+// Close closes the underlying writer. Records written afterwards are dropped.
+func (l *auditLog) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	flushErr := l.buf.Flush()
+	if err := l.w.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// statusRecorder records the status code of the response.
+type statusRecorder struct {
+	http.ResponseWriter
+
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(statusCode int) {
+	w.status = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Flush implements http.Flusher, so that streamed responses are still flushed to the client.
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestHandler_AuditLog(t *testing.T) {
+	audit := &bufferCloser{}
+	handler := mustNewHandler(t, HandlerConfig{MaxBodySize: 1024, AuditLogEnabled: true},
+		roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			// Read the body like downstream queriers, so that it is buffered.
+			require.NoError(t, r.ParseForm())
+			if r.Form.Get("query") == "fail" {
+				return nil, httpgrpc.Errorf(http.StatusBadRequest, "bad query")
+			}
+			return okRoundTripper(nil).RoundTrip(r)
+		}), log.NewNopLogger(), nil, WithAuditLogWriter(audit))
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil),
+		httptest.NewRequest(http.MethodGet, "/api/v1/query?query=fail", nil),
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(user.InjectOrgID(req.Context(), "team-a")))
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/query_range", strings.NewReader(url.Values{"query": {"sum(up)"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Records are written as soon as the query is answered.
+	require.Equal(t, 3, strings.Count(audit.String(), "\n"))
+	require.NoError(t, handler.Close())
+	require.True(t, audit.closed)
+
+	var records []auditRecord
+	scanner := bufio.NewScanner(bytes.NewReader(audit.Bytes()))
+	for scanner.Scan() {
+		var rec auditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		require.NotZero(t, rec.Timestamp)
+		require.NotEmpty(t, rec.Duration)
+		records = append(records, rec)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, records, 3)
+
+	for i, expected := range []struct {
+		tenant, method, path, query string
+		code                        int
+	}{
+		{tenant: "team-a", method: http.MethodGet, path: "/api/v1/query", query: "up", code: http.StatusOK},
+		{tenant: "team-a", method: http.MethodGet, path: "/api/v1/query", query: "fail", code: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/api/v1/query_range", query: "sum(up)", code: http.StatusOK},
+	} {
+		require.Equal(t, expected.tenant, records[i].Tenant)
+		require.Equal(t, expected.method, records[i].Method)
+		require.Equal(t, expected.path, records[i].Path)
+		require.Equal(t, expected.query, records[i].Query)
+		require.Equal(t, expected.code, records[i].ResponseCode)
+	}
+
+	// Queries after close are not written.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil))
+	require.Equal(t, 3, strings.Count(audit.String(), "\n"))
+}
+
+func TestHandler_AuditLogFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	handler := mustNewHandler(t, HandlerConfig{MaxBodySize: 1024, AuditLogEnabled: true, AuditLogFile: file}, okRoundTripper(nil), log.NewNopLogger(), nil)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil))
+	defer func() { require.NoError(t, handler.Close()) }()
+
+	// The record is in the file before the handler is closed.
+	b, err := os.ReadFile(file)
+	require.NoError(t, err)
+	var rec auditRecord
+	require.NoError(t, json.Unmarshal(b, &rec))
+	require.Equal(t, "up", rec.Query)
+}
+
+func TestHandler_AuditLogFileError(t *testing.T) {
+	_, err := NewHandler(HandlerConfig{MaxBodySize: 1024, AuditLogEnabled: true, AuditLogFile: filepath.Join(t.TempDir(), "missing", "audit.log")},
+		okRoundTripper(nil), log.NewNopLogger(), nil)
+	require.ErrorContains(t, err, "open audit log file")
+}
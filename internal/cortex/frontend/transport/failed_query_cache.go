@@ -0,0 +1,262 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package transport
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/golang-lru"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// FailedQueryCache caches the time range, in seconds, for which a query failed with a cacheable
+// error, so that the same query over a range not longer than that is rejected early.
+type FailedQueryCache struct {
+	lruCache *lru.Cache
+	regex    *regexp.Regexp
+
+	pathScoped         bool
+	floatNormalization bool
+	maxCachedRange     int
+	ttl                time.Duration
+	now                func() time.Time
+
+	gossipBindAddr string
+	gossipPeers    []string
+	gossipKey      []byte
+	gossip         *failedQueryGossip
+}
+
+// failedQueryEntry is the value cached for a failed query.
+type failedQueryEntry struct {
+	rangeLength int
+	// expiresAt is zero if the entry does not expire.
+	expiresAt time.Time
+}
+
+// FailedQueryCacheOption configures a FailedQueryCache.
+type FailedQueryCacheOption func(*FailedQueryCache)
+
+// WithPathScopedCache scopes the cached failures to the URL path of the request, so that a query
+// failing on /api/v1/query_range is not rejected on /api/v1/query.
+func WithPathScopedCache() FailedQueryCacheOption {
+	return func(f *FailedQueryCache) {
+		f.pathScoped = true
+	}
+}
+
+// WithFloatNormalization normalizes queries by parsing them and printing them back, so that number literals
+// written differently, like 0.001 and 1e-3, are printed in the same shortest form and share a cache entry.
+// Queries which cannot be parsed are only normalized for whitespace.
+func WithFloatNormalization() FailedQueryCacheOption {
+	return func(f *FailedQueryCache) {
+		f.floatNormalization = true
+	}
+}
+
+// WithMaxCachedRange caps the time range, in seconds, cached for a failed query, so that a failure over a
+// very long range only rejects the same query over ranges up to maxSeconds.
+func WithMaxCachedRange(maxSeconds int) FailedQueryCacheOption {
+	return func(f *FailedQueryCache) {
+		f.maxCachedRange = maxSeconds
+	}
+}
+
+// WithTTL expires cached failures ttl after the query last failed, so that queries which failed because of a
+// temporary issue are not rejected until they are evicted from the cache.
+func WithTTL(ttl time.Duration) FailedQueryCacheOption {
+	return func(f *FailedQueryCache) {
+		f.ttl = ttl
+	}
+}
+
+// NewFailedQueryCache creates a new FailedQueryCache holding at most capacity queries.
+func NewFailedQueryCache(capacity int, opts ...FailedQueryCacheOption) (*FailedQueryCache, error) {
+	f := &FailedQueryCache{
+		regex: regexp.MustCompile(`[\s\n\t]+`),
+		now:   time.Now,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	var (
+		lruCache *lru.Cache
+		err      error
+	)
+	if f.gossipBindAddr != "" {
+		if f.gossip, err = newFailedQueryGossip(f, f.gossipBindAddr, f.gossipPeers, f.gossipKey); err != nil {
+			return nil, err
+		}
+		lruCache, err = lru.NewWithEvict(capacity, f.gossip.onEvict)
+	} else {
+		lruCache, err = lru.New(capacity)
+	}
+	if err != nil {
+		if f.gossip != nil {
+			// The gossip is not started yet, so only its connection has to be closed.
+			_ = f.gossip.conn.Close()
+		}
+		return nil, err
+	}
+	f.lruCache = lruCache
+	if f.gossip != nil {
+		f.gossip.start()
+	}
+	return f, nil
+}
+
+// Close stops the synchronization of the cache with its peers, if enabled with WithGossipSync.
+func (f *FailedQueryCache) Close() error {
+	if f.gossip == nil {
+		return nil
+	}
+	return f.gossip.close()
+}
+
+// NormalizeQuery returns the normalized form of the query expression used in the cache.
+func (f *FailedQueryCache) NormalizeQuery(query string) string {
+	if f.floatNormalization {
+		// The printer formats number literals with strconv.FormatFloat(v, 'g', -1, 64).
+		if expr, err := parser.ParseExpr(query); err == nil {
+			return expr.String()
+		}
+	}
+	return f.regex.ReplaceAllString(query, " ")
+}
+
+func (f *FailedQueryCache) cacheKey(path, queryExpressionNormalized string) string {
+	if f.pathScoped {
+		return path + ":" + queryExpressionNormalized
+	}
+	return queryExpressionNormalized
+}
+
+// QueryHitCache returns true if the normalized query sent to the given path previously failed
+// for a time range at least as long as queryExpressionRangeLength.
+func (f *FailedQueryCache) QueryHitCache(path, queryExpressionNormalized string, queryExpressionRangeLength int) bool {
+	key := f.cacheKey(path, queryExpressionNormalized)
+	value, ok := f.lruCache.Get(key)
+	if !ok {
+		return false
+	}
+	entry := value.(failedQueryEntry)
+	if f.expired(entry) {
+		f.lruCache.Remove(key)
+		return false
+	}
+	return f.capRange(entry.rangeLength) >= queryExpressionRangeLength
+}
+
+func (f *FailedQueryCache) expired(entry failedQueryEntry) bool {
+	return !entry.expiresAt.IsZero() && !f.now().Before(entry.expiresAt)
+}
+
+func (f *FailedQueryCache) capRange(queryExpressionRangeLength int) int {
+	if f.maxCachedRange > 0 {
+		return min(queryExpressionRangeLength, f.maxCachedRange)
+	}
+	return queryExpressionRangeLength
+}
+
+// UpdateFailedQueryCache records that the normalized query sent to the given path failed for the given
+// time range. It returns the time range now cached for the query, which is the shortest one seen since
+// the entry expired, if ever. The TTL of the entry is reset.
+func (f *FailedQueryCache) UpdateFailedQueryCache(path, queryExpressionNormalized string, queryExpressionRangeLength int) int {
+	key := f.cacheKey(path, queryExpressionNormalized)
+	queryExpressionRangeLength = f.add(key, queryExpressionRangeLength)
+	if f.gossip != nil {
+		f.gossip.broadcast(gossipMessage{Key: key, RangeLength: queryExpressionRangeLength})
+	}
+	return queryExpressionRangeLength
+}
+
+// add caches the given time range for the key, keeping the cached one if it is shorter, and returns the
+// time range now cached.
+func (f *FailedQueryCache) add(key string, queryExpressionRangeLength int) int {
+	queryExpressionRangeLength = f.capRange(queryExpressionRangeLength)
+
+	// Checks if queryExpression is already in cache, and updates time range length value to min of stored and new value.
+	if oldValue, ok := f.lruCache.Get(key); ok {
+		if entry := oldValue.(failedQueryEntry); !f.expired(entry) {
+			queryExpressionRangeLength = min(queryExpressionRangeLength, entry.rangeLength)
+		}
+	}
+	entry := failedQueryEntry{rangeLength: queryExpressionRangeLength}
+	if f.ttl > 0 {
+		entry.expiresAt = f.now().Add(f.ttl)
+	}
+	f.lruCache.Add(key, entry)
+	return queryExpressionRangeLength
+}
+
+// Invalidate removes the given query from the cache, for all paths if the cache is path scoped. It returns
+// whether the query was cached.
+func (f *FailedQueryCache) Invalidate(queryExpr string) bool {
+	queryExpressionNormalized := f.NormalizeQuery(queryExpr)
+	if !f.pathScoped {
+		return f.lruCache.Remove(queryExpressionNormalized)
+	}
+
+	found := false
+	suffix := ":" + queryExpressionNormalized
+	for _, key := range f.lruCache.Keys() {
+		k := key.(string)
+		if strings.HasSuffix(k, suffix) && !strings.Contains(strings.TrimSuffix(k, suffix), ":") {
+			found = f.lruCache.Remove(k) || found
+		}
+	}
+	return found
+}
+
+// InvalidateAll removes all queries from the cache.
+func (f *FailedQueryCache) InvalidateAll() {
+	f.lruCache.Purge()
+}
+
+var blockedQueryInfoDesc = prometheus.NewDesc(
+	"thanos_blocked_query_info",
+	"Queries currently rejected by the failed query cache, with the shortest failed range in seconds. "+
+		"This metric is unstable and only exposes a limited number of the most recently failed queries.",
+	[]string{"query", "min_range_seconds"}, nil,
+)
+
+// failedQueryCacheCollector exposes the most recently failed queries held by a FailedQueryCache.
+type failedQueryCacheCollector struct {
+	cache      *FailedQueryCache
+	maxQueries int
+}
+
+// NewFailedQueryCacheCollector returns a collector exposing up to maxQueries of the most recently failed
+// queries held by the cache, to bound the cardinality of the metric.
+func NewFailedQueryCacheCollector(f *FailedQueryCache, maxQueries int) prometheus.Collector {
+	return &failedQueryCacheCollector{cache: f, maxQueries: maxQueries}
+}
+
+func (c *failedQueryCacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- blockedQueryInfoDesc
+}
+
+func (c *failedQueryCacheCollector) Collect(ch chan<- prometheus.Metric) {
+	// Keys are ordered from the oldest to the most recently used.
+	keys := c.cache.lruCache.Keys()
+	if len(keys) > c.maxQueries {
+		keys = keys[len(keys)-c.maxQueries:]
+	}
+	for _, key := range keys {
+		value, ok := c.cache.lruCache.Peek(key)
+		if !ok {
+			continue
+		}
+		entry := value.(failedQueryEntry)
+		if c.cache.expired(entry) {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(blockedQueryInfoDesc, prometheus.GaugeValue, 1, key.(string), strconv.Itoa(entry.rangeLength))
+	}
+}
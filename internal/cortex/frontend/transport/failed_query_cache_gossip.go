@@ -0,0 +1,244 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package transport
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxGossipMessageSize is the maximum size of a UDP datagram payload, including the HMAC of the message.
+// Entries with longer keys are not shared.
+const maxGossipMessageSize = 65507
+
+// maxGossipMessageAge is how far the timestamp of a received message may be from the local time. Older messages
+// are dropped, and the nonces of accepted messages are remembered for this long to drop replayed messages.
+const maxGossipMessageAge = time.Minute
+
+// WithGossipSync shares the entries of the cache with the caches of other query-frontend replicas, so that a
+// query failing on one replica is also rejected by the others. New entries and evictions are sent as UDP
+// datagrams from bindAddr to each of the peers, which update their own cache on receipt without forwarding
+// them. Peers have to use the same cache options, for their keys to match. Delivery is best effort.
+// Datagrams are authenticated with an HMAC-SHA256 of the given key, which all peers have to share, and
+// datagrams failing authentication are dropped. Signed messages carry a timestamp and a nonce, so that stale or
+// replayed messages are dropped too. The clocks of the peers have to be within a minute of each other.
+func WithGossipSync(bindAddr string, peers []string, key []byte) FailedQueryCacheOption {
+	return func(f *FailedQueryCache) {
+		f.gossipBindAddr = bindAddr
+		f.gossipPeers = peers
+		f.gossipKey = key
+	}
+}
+
+// gossipMessage is the message sent to peers when an entry is added to or evicted from the cache.
+type gossipMessage struct {
+	Key         string `json:"key"`
+	RangeLength int    `json:"range_length,omitempty"`
+	Evicted     bool   `json:"evicted,omitempty"`
+	// Timestamp, in Unix milliseconds, and Nonce protect against replayed messages.
+	Timestamp int64  `json:"ts"`
+	Nonce     string `json:"nonce"`
+}
+
+type failedQueryGossip struct {
+	cache *FailedQueryCache
+	conn  net.PacketConn
+	peers []net.Addr
+	key   []byte
+	done  chan struct{}
+
+	mtx sync.Mutex
+	// received counts, per key, the evictions received from peers which are being applied, so that the
+	// eviction callback does not send them back.
+	received map[string]int
+
+	// seen holds the nonces of the accepted messages with the Unix millisecond time until which they are kept.
+	// It is only used by the receiving goroutine.
+	seen      map[string]int64
+	lastPrune int64
+}
+
+func newFailedQueryGossip(cache *FailedQueryCache, bindAddr string, peers []string, key []byte) (*failedQueryGossip, error) {
+	if len(key) == 0 {
+		return nil, errors.New("a shared key is required to authenticate failed query cache peers")
+	}
+	addrs := make([]net.Addr, 0, len(peers))
+	for _, peer := range peers {
+		addr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolve failed query cache peer %q", peer)
+		}
+		addrs = append(addrs, addr)
+	}
+	conn, err := net.ListenPacket("udp", bindAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "listen for failed query cache peers")
+	}
+	return &failedQueryGossip{
+		cache:    cache,
+		conn:     conn,
+		peers:    addrs,
+		key:      key,
+		done:     make(chan struct{}),
+		received: map[string]int{},
+		seen:     map[string]int64{},
+	}, nil
+}
+
+func (g *failedQueryGossip) start() {
+	go g.receive()
+}
+
+func (g *failedQueryGossip) close() error {
+	err := g.conn.Close()
+	<-g.done
+	return err
+}
+
+func (g *failedQueryGossip) broadcast(msg gossipMessage) {
+	b, err := g.encode(msg)
+	if err != nil || len(b) > maxGossipMessageSize {
+		return
+	}
+	for _, peer := range g.peers {
+		// Peers which are down miss the message, as with any other delivery failure.
+		_, _ = g.conn.WriteTo(b, peer)
+	}
+}
+
+// onEvict is the eviction callback of the cache, broadcasting entries evicted by this replica.
+func (g *failedQueryGossip) onEvict(key, _ interface{}) {
+	k := key.(string)
+	if g.consumeReceived(k) {
+		return
+	}
+	g.broadcast(gossipMessage{Key: k, Evicted: true})
+}
+
+// consumeReceived returns whether an eviction of the key received from a peer is being applied, and marks it
+// as done.
+func (g *failedQueryGossip) consumeReceived(key string) bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if g.received[key] == 0 {
+		return false
+	}
+	if g.received[key]--; g.received[key] == 0 {
+		delete(g.received, key)
+	}
+	return true
+}
+
+// encode returns the signed datagram of the message, with a new timestamp and nonce.
+func (g *failedQueryGossip) encode(msg gossipMessage) ([]byte, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	msg.Timestamp = g.cache.now().UnixMilli()
+	msg.Nonce = hex.EncodeToString(nonce)
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return append(g.sign(payload), payload...), nil
+}
+
+// decode returns the message of the datagram, and false if it is not authenticated by the shared key, is
+// stale, or was already received.
+func (g *failedQueryGossip) decode(b []byte) (gossipMessage, bool) {
+	var msg gossipMessage
+	payload, ok := g.verify(b)
+	if !ok {
+		return msg, false
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil || msg.Key == "" || msg.Nonce == "" {
+		return msg, false
+	}
+
+	now := g.cache.now().UnixMilli()
+	maxAge := maxGossipMessageAge.Milliseconds()
+	if msg.Timestamp < now-maxAge || msg.Timestamp > now+maxAge {
+		return msg, false
+	}
+	if now-g.lastPrune > maxAge {
+		for nonce, until := range g.seen {
+			if until < now {
+				delete(g.seen, nonce)
+			}
+		}
+		g.lastPrune = now
+	}
+	if _, ok := g.seen[msg.Nonce]; ok {
+		return msg, false
+	}
+	g.seen[msg.Nonce] = msg.Timestamp + maxAge
+	return msg, true
+}
+
+// sign returns the HMAC of the payload.
+func (g *failedQueryGossip) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, g.key)
+	_, _ = mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// verify returns the payload of the datagram, and false if it is not authenticated by the shared key.
+func (g *failedQueryGossip) verify(b []byte) ([]byte, bool) {
+	if len(b) < sha256.Size {
+		return nil, false
+	}
+	sum, payload := b[:sha256.Size], b[sha256.Size:]
+	return payload, hmac.Equal(sum, g.sign(payload))
+}
+
+func (g *failedQueryGossip) receive() {
+	defer close(g.done)
+
+	buf := make([]byte, maxGossipMessageSize)
+	for {
+		n, _, err := g.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		msg, ok := g.decode(buf[:n])
+		if !ok {
+			continue
+		}
+		g.apply(msg)
+	}
+}
+
+// apply updates the cache with a message received from a peer.
+func (g *failedQueryGossip) apply(msg gossipMessage) {
+	f := g.cache
+	if msg.Evicted {
+		if !f.lruCache.Contains(msg.Key) {
+			return
+		}
+		g.mtx.Lock()
+		g.received[msg.Key]++
+		g.mtx.Unlock()
+		if !f.lruCache.Remove(msg.Key) {
+			// The entry was evicted concurrently, so the callback is not called for this removal.
+			g.consumeReceived(msg.Key)
+		}
+		return
+	}
+
+	f.add(msg.Key, msg.RangeLength)
+}
@@ -0,0 +1,243 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package transport
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailedQueryCache(t *testing.T) {
+	f, err := NewFailedQueryCache(10)
+	require.NoError(t, err)
+
+	query := f.NormalizeQuery("sum(\n\trate(up[5m]))")
+	require.Equal(t, "sum( rate(up[5m]))", query)
+	require.False(t, f.QueryHitCache("/api/v1/query_range", query, 3600))
+
+	require.Equal(t, 3600, f.UpdateFailedQueryCache("/api/v1/query_range", query, 3600))
+	require.Equal(t, 1800, f.UpdateFailedQueryCache("/api/v1/query_range", query, 1800))
+	require.Equal(t, 1800, f.UpdateFailedQueryCache("/api/v1/query_range", query, 7200))
+
+	require.True(t, f.QueryHitCache("/api/v1/query_range", query, 1800))
+	require.True(t, f.QueryHitCache("/api/v1/query_range", query, 600))
+	require.False(t, f.QueryHitCache("/api/v1/query_range", query, 3600))
+	require.True(t, f.QueryHitCache("/api/v1/query", query, 0))
+}
+
+func TestFailedQueryCache_PathScoped(t *testing.T) {
+	f, err := NewFailedQueryCache(10, WithPathScopedCache())
+	require.NoError(t, err)
+
+	f.UpdateFailedQueryCache("/api/v1/query_range", "up", 3600)
+	require.True(t, f.QueryHitCache("/api/v1/query_range", "up", 3600))
+	require.False(t, f.QueryHitCache("/api/v1/query", "up", 0))
+}
+
+func TestFailedQueryCache_FloatNormalization(t *testing.T) {
+	f, err := NewFailedQueryCache(10, WithFloatNormalization())
+	require.NoError(t, err)
+
+	require.Equal(t, f.NormalizeQuery("rate(x[5m]) > 0.001"), f.NormalizeQuery("rate(x[5m]) > 1e-3"))
+	require.Equal(t, "rate(x[5m]) > 0.001", f.NormalizeQuery("rate(x[5m])   >\n1e-3"))
+	// Queries which cannot be parsed are only normalized for whitespace.
+	require.Equal(t, "rate(x[5m] > 1e-3", f.NormalizeQuery("rate(x[5m]\t> 1e-3"))
+
+	f.UpdateFailedQueryCache("/api/v1/query_range", f.NormalizeQuery("rate(x[5m]) > 0.001"), 3600)
+	require.True(t, f.QueryHitCache("/api/v1/query_range", f.NormalizeQuery("rate(x[5m]) > 1e-3"), 3600))
+}
+
+func TestFailedQueryCache_MaxCachedRange(t *testing.T) {
+	f, err := NewFailedQueryCache(10, WithMaxCachedRange(3600))
+	require.NoError(t, err)
+
+	require.Equal(t, 3600, f.UpdateFailedQueryCache("/api/v1/query_range", "up", 7*24*3600))
+	require.True(t, f.QueryHitCache("/api/v1/query_range", "up", 3600))
+	require.False(t, f.QueryHitCache("/api/v1/query_range", "up", 7200))
+
+	require.Equal(t, 1800, f.UpdateFailedQueryCache("/api/v1/query_range", "up", 1800))
+	require.False(t, f.QueryHitCache("/api/v1/query_range", "up", 3600))
+}
+
+func TestFailedQueryCacheCollector(t *testing.T) {
+	f, err := NewFailedQueryCache(10)
+	require.NoError(t, err)
+
+	f.UpdateFailedQueryCache("/api/v1/query_range", "up", 3600)
+	f.UpdateFailedQueryCache("/api/v1/query_range", "down", 600)
+	f.UpdateFailedQueryCache("/api/v1/query_range", "sideways", 7200)
+
+	require.NoError(t, testutil.CollectAndCompare(NewFailedQueryCacheCollector(f, 2), strings.NewReader(`
+# HELP thanos_blocked_query_info Queries currently rejected by the failed query cache, with the shortest failed range in seconds. This metric is unstable and only exposes a limited number of the most recently failed queries.
+# TYPE thanos_blocked_query_info gauge
+thanos_blocked_query_info{min_range_seconds="600",query="down"} 1
+thanos_blocked_query_info{min_range_seconds="7200",query="sideways"} 1
+`)))
+}
+
+func TestFailedQueryCache_TTL(t *testing.T) {
+	f, err := NewFailedQueryCache(10, WithTTL(time.Minute))
+	require.NoError(t, err)
+	now := time.Now()
+	f.now = func() time.Time { return now }
+
+	require.Equal(t, 3600, f.UpdateFailedQueryCache("/api/v1/query_range", "up", 3600))
+	now = now.Add(30 * time.Second)
+	require.True(t, f.QueryHitCache("/api/v1/query_range", "up", 3600))
+
+	// Updating the entry resets its TTL.
+	require.Equal(t, 1800, f.UpdateFailedQueryCache("/api/v1/query_range", "up", 1800))
+	now = now.Add(45 * time.Second)
+	require.True(t, f.QueryHitCache("/api/v1/query_range", "up", 1800))
+
+	// Expired entries are deleted and their range is not kept on the next failure.
+	now = now.Add(15 * time.Second)
+	require.False(t, f.QueryHitCache("/api/v1/query_range", "up", 1800))
+	require.Equal(t, 0, f.lruCache.Len())
+	require.Equal(t, 3600, f.UpdateFailedQueryCache("/api/v1/query_range", "up", 3600))
+}
+
+func TestFailedQueryCache_Invalidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []FailedQueryCacheOption
+	}{
+		{name: "global"},
+		{name: "path scoped", opts: []FailedQueryCacheOption{WithPathScopedCache()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := NewFailedQueryCache(10, tc.opts...)
+			require.NoError(t, err)
+
+			f.UpdateFailedQueryCache("/api/v1/query_range", "sum( up)", 3600)
+			f.UpdateFailedQueryCache("/api/v1/query", "sum( up)", 0)
+			f.UpdateFailedQueryCache("/api/v1/query_range", "rate(x[5m])", 3600)
+
+			require.True(t, f.Invalidate("sum(\n\tup)"))
+			require.False(t, f.QueryHitCache("/api/v1/query_range", "sum( up)", 0))
+			require.False(t, f.QueryHitCache("/api/v1/query", "sum( up)", 0))
+			require.True(t, f.QueryHitCache("/api/v1/query_range", "rate(x[5m])", 3600))
+			require.False(t, f.Invalidate("sum( up)"))
+
+			f.InvalidateAll()
+			require.False(t, f.QueryHitCache("/api/v1/query_range", "rate(x[5m])", 3600))
+		})
+	}
+}
+
+func TestFailedQueryCache_GossipSync(t *testing.T) {
+	addrA, addrB := freeUDPAddr(t), freeUDPAddr(t)
+	key := []byte("secret")
+	a, err := NewFailedQueryCache(10, WithGossipSync(addrA, []string{addrB}, key))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, a.Close()) })
+	b, err := NewFailedQueryCache(10, WithGossipSync(addrB, []string{addrA}, key))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, b.Close()) })
+
+	a.UpdateFailedQueryCache("/api/v1/query_range", "sum( up)", 3600)
+	require.Eventually(t, func() bool {
+		return b.QueryHitCache("/api/v1/query_range", "sum( up)", 3600)
+	}, 500*time.Millisecond, 5*time.Millisecond)
+
+	// The shortest range wins on both replicas.
+	b.UpdateFailedQueryCache("/api/v1/query_range", "sum( up)", 1800)
+	require.Eventually(t, func() bool {
+		return !a.QueryHitCache("/api/v1/query_range", "sum( up)", 3600) &&
+			a.QueryHitCache("/api/v1/query_range", "sum( up)", 1800)
+	}, 500*time.Millisecond, 5*time.Millisecond)
+
+	require.True(t, b.Invalidate("sum( up)"))
+	require.Eventually(t, func() bool {
+		return !a.QueryHitCache("/api/v1/query_range", "sum( up)", 0)
+	}, 500*time.Millisecond, 5*time.Millisecond)
+}
+
+func TestFailedQueryCache_GossipAuthentication(t *testing.T) {
+	_, err := NewFailedQueryCache(10, WithGossipSync(freeUDPAddr(t), nil, nil))
+	require.Error(t, err)
+
+	addr, peerAddr := freeUDPAddr(t), freeUDPAddr(t)
+	f, err := NewFailedQueryCache(10, WithGossipSync(addr, nil, []byte("secret")))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, f.Close()) })
+	peer, err := NewFailedQueryCache(10, WithGossipSync(peerAddr, []string{addr}, []byte("other secret")))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, peer.Close()) })
+
+	// Entries of a peer with another key and unsigned datagrams are dropped.
+	peer.UpdateFailedQueryCache("/api/v1/query", "up", 3600)
+	conn, err := net.Dial("udp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	b, err := json.Marshal(gossipMessage{Key: "sum(up)", RangeLength: 3600})
+	require.NoError(t, err)
+	_, err = conn.Write(append(make([]byte, 32), b...))
+	require.NoError(t, err)
+	_, err = conn.Write(b)
+	require.NoError(t, err)
+
+	// Datagrams are handled in order, so once a signed one is applied the others have been dropped.
+	signed, err := f.gossip.encode(gossipMessage{Key: "count(up)", RangeLength: 3600})
+	require.NoError(t, err)
+	_, err = conn.Write(signed)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return f.QueryHitCache("/api/v1/query", "count(up)", 3600)
+	}, 500*time.Millisecond, 5*time.Millisecond)
+	require.False(t, f.QueryHitCache("/api/v1/query", "up", 0))
+	require.False(t, f.QueryHitCache("/api/v1/query", "sum(up)", 0))
+
+	// Replayed and stale messages are dropped too.
+	replayed, err := f.gossip.encode(gossipMessage{Key: "max(up)", RangeLength: 3600})
+	require.NoError(t, err)
+	_, err = conn.Write(replayed)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return f.QueryHitCache("/api/v1/query", "max(up)", 3600)
+	}, 500*time.Millisecond, 5*time.Millisecond)
+	require.True(t, f.Invalidate("max(up)"))
+	_, err = conn.Write(replayed)
+	require.NoError(t, err)
+	stale, err := json.Marshal(gossipMessage{Key: "min(up)", RangeLength: 3600, Timestamp: time.Now().Add(-2 * maxGossipMessageAge).UnixMilli(), Nonce: "stale"})
+	require.NoError(t, err)
+	_, err = conn.Write(append(f.gossip.sign(stale), stale...))
+	require.NoError(t, err)
+
+	fresh, err := f.gossip.encode(gossipMessage{Key: "avg(up)", RangeLength: 3600})
+	require.NoError(t, err)
+	_, err = conn.Write(fresh)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return f.QueryHitCache("/api/v1/query", "avg(up)", 3600)
+	}, 500*time.Millisecond, 5*time.Millisecond)
+	require.False(t, f.QueryHitCache("/api/v1/query", "max(up)", 0))
+	require.False(t, f.QueryHitCache("/api/v1/query", "min(up)", 0))
+}
+
+func TestFailedQueryCache_GossipInvalidCapacity(t *testing.T) {
+	addr := freeUDPAddr(t)
+	_, err := NewFailedQueryCache(0, WithGossipSync(addr, nil, []byte("secret")))
+	require.Error(t, err)
+
+	// The address of the gossip is released.
+	conn, err := net.ListenPacket("udp", addr)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+}
+
+// freeUDPAddr returns a local UDP address which is free to bind to.
+func freeUDPAddr(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := conn.LocalAddr().String()
+	require.NoError(t, conn.Close())
+	return addr
+}
@@ -5,13 +5,17 @@ package transport
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -19,28 +23,40 @@ import (
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
-	"github.com/hashicorp/golang-lru"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	querier_stats "github.com/thanos-io/thanos/internal/cortex/querier/stats"
 	"github.com/thanos-io/thanos/internal/cortex/tenant"
 	"github.com/thanos-io/thanos/internal/cortex/util"
 	util_log "github.com/thanos-io/thanos/internal/cortex/util/log"
+	"github.com/thanos-io/thanos/pkg/errutil"
 	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/httpgrpc/server"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
 	// StatusClientClosedRequest is the status code for when a client request cancellation of an http request
 	StatusClientClosedRequest = 499
 	ServiceTimingHeaderName   = "Server-Timing"
+
+	// SlowQueryLogFieldQueryStartTime is the optional slow query log field with the time the query was received.
+	SlowQueryLogFieldQueryStartTime = "query_start_time"
+	// SlowQueryLogFieldQueryEndTime is the optional slow query log field with the time the query finished.
+	SlowQueryLogFieldQueryEndTime = "query_end_time"
+
+	// InvalidateFailedQueryCachePath is the path of the endpoint removing queries from the failed query cache. POST
+	// requests remove the given query, or all queries with all=true.
+	InvalidateFailedQueryCachePath = "/admin/query-cache/invalidate"
 )
 
 var (
 	errCanceled              = httpgrpc.Errorf(StatusClientClosedRequest, context.Canceled.Error())
 	errDeadlineExceeded      = httpgrpc.Errorf(http.StatusGatewayTimeout, context.DeadlineExceeded.Error())
 	errRequestEntityTooLarge = httpgrpc.Errorf(http.StatusRequestEntityTooLarge, "http: request body too large")
-	cacheableResponseCodes   = []int{http.StatusRequestTimeout, http.StatusGatewayTimeout, http.StatusBadRequest}
+	errResponseBodyTooLarge  = httpgrpc.Errorf(http.StatusBadGateway, "response body too large")
+	// defaultCacheableErrorCodes are the status codes of the failed queries cached by default.
+	defaultCacheableErrorCodes = []int{http.StatusRequestTimeout, http.StatusGatewayTimeout, http.StatusBadRequest}
 )
 
 // HandlerConfig Config for a Handler.
@@ -50,6 +66,54 @@ type HandlerConfig struct {
 	QueryStatsEnabled        bool          `yaml:"query_stats_enabled"`
 	LogFailedQueries         bool          `yaml:"log_failed_queries"`
 	FailedQueryCacheCapacity int           `yaml:"failed_query_cache_capacity"`
+	// FailedQueryCachePathScoped scopes cached failed queries to the URL path they were sent to.
+	FailedQueryCachePathScoped bool `yaml:"failed_query_cache_path_scoped"`
+	// FailedQueryCacheFloatNormalization normalizes number literals of cached failed queries.
+	FailedQueryCacheFloatNormalization bool `yaml:"failed_query_cache_float_normalization"`
+	// FailedQueryCacheMaxRange caps the range, in seconds, cached for a failed query. 0 means no cap.
+	FailedQueryCacheMaxRange int `yaml:"failed_query_cache_max_range"`
+	// FailedQueryCacheTTL expires cached failed queries after this duration. 0 means they only expire on eviction.
+	FailedQueryCacheTTL time.Duration `yaml:"failed_query_cache_ttl"`
+	// FailedQueryCacheMetricsMaxQueries exposes up to this many cached failed queries as metrics. 0 disables it.
+	FailedQueryCacheMetricsMaxQueries int `yaml:"failed_query_cache_metrics_max_queries"`
+	// FailedQueryCacheGossipBindAddr is the UDP address on which cached failed queries are shared with the
+	// replicas at FailedQueryCacheGossipPeers. Empty disables sharing.
+	FailedQueryCacheGossipBindAddr string `yaml:"failed_query_cache_gossip_bind_addr"`
+	// FailedQueryCacheGossipPeers are the UDP addresses of the other replicas sharing cached failed queries.
+	FailedQueryCacheGossipPeers []string `yaml:"failed_query_cache_gossip_peers"`
+	// FailedQueryCacheGossipKey is the key, shared by all replicas, authenticating the shared failed queries.
+	FailedQueryCacheGossipKey string `yaml:"failed_query_cache_gossip_key"`
+	// SlowQueryLogFields lists optional fields to add to the slow query log, e.g. "query_start_time" and "query_end_time".
+	SlowQueryLogFields []string `yaml:"slow_query_log_fields"`
+	// TimeToFirstByteEnabled enables the histogram of the time until the first byte of the response is written.
+	TimeToFirstByteEnabled bool `yaml:"time_to_first_byte_enabled"`
+	// ExemplarsEnabled enables the query response time histogram, with the trace ID of the query as exemplar.
+	ExemplarsEnabled bool `yaml:"exemplars_enabled"`
+	// JSONQueryLogging logs the parameters of queries as a single JSON encoded field instead of one field per parameter.
+	JSONQueryLogging bool `yaml:"json_query_logging"`
+	// DeduplicateRequests sends identical concurrent requests only once, sharing the response between their callers.
+	DeduplicateRequests bool `yaml:"deduplicate_requests"`
+	// CacheableErrorCodes are the status codes of the failed queries to cache. Defaults to 408, 504 and 400.
+	CacheableErrorCodes []int `yaml:"cacheable_error_codes"`
+	// DisableGzipRequests forwards request bodies sent with Content-Encoding: gzip as is. By default, they are
+	// decompressed before being handled.
+	DisableGzipRequests bool `yaml:"disable_gzip_requests"`
+	// MaxQueryCostEstimate rejects queries whose estimated cost exceeds it, if a QueryCostEstimator is set with
+	// WithQueryCostEstimator. 0 means no limit.
+	MaxQueryCostEstimate int64 `yaml:"max_query_cost_estimate"`
+	// SlowQueryLogSampleRate is the fraction, between 0 and 1, of slow queries to log, 0 logging none. Failed
+	// queries are always logged. Defaults to 1 if nil, logging every slow query.
+	SlowQueryLogSampleRate *float64 `yaml:"slow_query_log_sample_rate"`
+	// AuditLogEnabled writes a JSON line for every query to the audit log, separately from the operational log.
+	AuditLogEnabled bool `yaml:"audit_log_enabled"`
+	// AuditLogFile is the file the audit log is appended to, unless a writer is set with WithAuditLogWriter.
+	AuditLogFile string `yaml:"audit_log_file"`
+	// MaxResponseBodySize fails queries whose downstream response body is larger than it with 502. The response
+	// is buffered up to this size before it is written. 0 means no limit.
+	MaxResponseBodySize int64 `yaml:"max_response_body_size"`
+	// TimingHeaderExtensions are additional metrics of the Server-Timing header, rendered as "<name>;dur=<ms>"
+	// in name order. They are only written if QueryStatsEnabled is set.
+	TimingHeaderExtensions map[string]func(*querier_stats.Stats) time.Duration `yaml:"-"`
 }
 
 // Handler accepts queries and forwards them to RoundTripper. It can log slow queries,
@@ -58,40 +122,97 @@ type Handler struct {
 	cfg          HandlerConfig
 	log          log.Logger
 	roundTripper http.RoundTripper
-	lruCache     *lru.Cache
-	regex        *regexp.Regexp
 	errorExtract *regexp.Regexp
 
+	failedQueryCache *FailedQueryCache
+	inflightRequests singleflight.Group
+	costEstimator    QueryCostEstimator
+	auditWriter      io.WriteCloser
+	auditLog         *auditLog
+
+	// slowQueryLogSampleRate is the sample rate of the slow query log, defaulted and clamped between 0 and 1.
+	slowQueryLogSampleRate float64
+
+	// onDeduplicatedWait is called once a deduplicated request waits for its response, so that tests can wait for
+	// concurrent requests to be deduplicated.
+	onDeduplicatedWait func()
+
 	// Metrics.
 	querySeconds *prometheus.CounterVec
 	querySeries  *prometheus.CounterVec
 	queryBytes   *prometheus.CounterVec
+	querySamples *prometheus.CounterVec
 	cachedHits   prometheus.Counter
 	activeUsers  *util.ActiveUsersCleanupService
-}
 
-// NewHandler creates a new frontend handler.
-func NewHandler(cfg HandlerConfig, roundTripper http.RoundTripper, log log.Logger, reg prometheus.Registerer) http.Handler {
-	var (
-		LruCache *lru.Cache
-		err      error
-	)
+	timeToFirstByte      prometheus.Histogram
+	responseTime         prometheus.Histogram
+	deduplicatedRequests prometheus.Counter
+}
 
-	if cfg.FailedQueryCacheCapacity > 0 {
-		LruCache, err = lru.New(cfg.FailedQueryCacheCapacity)
-		if err != nil {
-			LruCache = nil
-			level.Warn(log).Log("msg", "Failed to create LruCache", "error", err)
-		}
+// NewHandler creates a new frontend handler. It returns an error if the audit log file cannot be opened or the
+// failed query cache cannot be created.
+func NewHandler(cfg HandlerConfig, roundTripper http.RoundTripper, log log.Logger, reg prometheus.Registerer, opts ...HandlerOption) (http.Handler, error) {
+	if len(cfg.CacheableErrorCodes) == 0 {
+		cfg.CacheableErrorCodes = defaultCacheableErrorCodes
+	}
+	slowQueryLogSampleRate := 1.0
+	if cfg.SlowQueryLogSampleRate != nil {
+		slowQueryLogSampleRate = min(max(*cfg.SlowQueryLogSampleRate, 0), 1)
 	}
-
 	h := &Handler{
 		cfg:          cfg,
 		log:          log,
 		roundTripper: roundTripper,
-		lruCache:     LruCache,
-		regex:        regexp.MustCompile(`[\s\n\t]+`),
 		errorExtract: regexp.MustCompile(`Code\((\d+)\)`),
+
+		slowQueryLogSampleRate: slowQueryLogSampleRate,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if cfg.AuditLogEnabled {
+		if h.auditWriter == nil {
+			f, err := OpenAuditLogFile(cfg.AuditLogFile)
+			if err != nil {
+				return nil, fmt.Errorf("open audit log file: %w", err)
+			}
+			h.auditWriter = f
+		}
+		h.auditLog = newAuditLog(h.auditWriter)
+	}
+
+	if cfg.FailedQueryCacheCapacity > 0 {
+		var opts []FailedQueryCacheOption
+		if cfg.FailedQueryCachePathScoped {
+			opts = append(opts, WithPathScopedCache())
+		}
+		if cfg.FailedQueryCacheFloatNormalization {
+			opts = append(opts, WithFloatNormalization())
+		}
+		if cfg.FailedQueryCacheMaxRange > 0 {
+			opts = append(opts, WithMaxCachedRange(cfg.FailedQueryCacheMaxRange))
+		}
+		if cfg.FailedQueryCacheTTL > 0 {
+			opts = append(opts, WithTTL(cfg.FailedQueryCacheTTL))
+		}
+		if cfg.FailedQueryCacheGossipBindAddr != "" {
+			opts = append(opts, WithGossipSync(cfg.FailedQueryCacheGossipBindAddr, cfg.FailedQueryCacheGossipPeers, []byte(cfg.FailedQueryCacheGossipKey)))
+		}
+		failedQueryCache, err := NewFailedQueryCache(cfg.FailedQueryCacheCapacity, opts...)
+		if err != nil {
+			if h.auditLog != nil {
+				_ = h.auditLog.Close()
+			}
+			return nil, fmt.Errorf("create failed query cache: %w", err)
+		}
+		h.failedQueryCache = failedQueryCache
+		if cfg.FailedQueryCacheMetricsMaxQueries > 0 && reg != nil {
+			if err := reg.Register(NewFailedQueryCacheCollector(failedQueryCache, cfg.FailedQueryCacheMetricsMaxQueries)); err != nil {
+				level.Warn(log).Log("msg", "Failed to register failed query cache metrics", "error", err)
+			}
+		}
 	}
 
 	if cfg.QueryStatsEnabled {
@@ -110,32 +231,82 @@ func NewHandler(cfg HandlerConfig, roundTripper http.RoundTripper, log log.Logge
 			Help: "Size of all chunks fetched to execute a query in bytes.",
 		}, []string{"user"})
 
+		h.querySamples = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_query_fetched_samples_total",
+			Help: "Number of samples fetched to execute a query.",
+		}, []string{"user"})
+
 		h.activeUsers = util.NewActiveUsersCleanupWithDefaultValues(func(user string) {
 			h.querySeconds.DeleteLabelValues(user)
 			h.querySeries.DeleteLabelValues(user)
 			h.queryBytes.DeleteLabelValues(user)
+			h.querySamples.DeleteLabelValues(user)
 		})
 
 		// If cleaner stops or fail, we will simply not clean the metrics for inactive users.
 		_ = h.activeUsers.StartAsync(context.Background())
 	}
 
+	if cfg.TimeToFirstByteEnabled {
+		h.timeToFirstByte = promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name:    "cortex_query_time_to_first_byte_seconds",
+			Help:    "Time from receiving a query until the first byte of its response is written.",
+			Buckets: prometheus.DefBuckets,
+		})
+	}
+
+	if cfg.ExemplarsEnabled {
+		h.responseTime = promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name:                            "cortex_query_response_time_seconds",
+			Help:                            "Time taken to respond to a query, with the trace ID of the query as exemplar.",
+			Buckets:                         prometheus.DefBuckets,
+			NativeHistogramBucketFactor:     1.1,
+			NativeHistogramMaxBucketNumber:  100,
+			NativeHistogramMinResetDuration: 1 * time.Hour,
+		})
+	}
+
+	if cfg.DeduplicateRequests {
+		h.deduplicatedRequests = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "frontend_deduplicated_requests_total",
+			Help: "Total number of requests answered with the response of an identical request in flight.",
+		})
+	}
+
 	h.cachedHits = promauto.With(reg).NewCounter(prometheus.CounterOpts{
 		Name: "cached_failed_queries_count",
 		Help: "Total number of queries that hit the failed query cache.",
 	})
 
-	return h
+	return h, nil
 }
 
 func (f *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.failedQueryCache != nil && r.URL.Path == InvalidateFailedQueryCachePath {
+		f.invalidateFailedQueryCache(w, r)
+		return
+	}
+
 	var (
 		stats                      *querier_stats.Stats
 		queryString                url.Values
 		queryExpressionNormalized  string
 		queryExpressionRangeLength int
+		queryStartTime             = time.Now()
 	)
 
+	if f.timeToFirstByte != nil {
+		w = newTimeToFirstByteWriter(w, queryStartTime, f.timeToFirstByte)
+	}
+
+	if f.auditLog != nil {
+		sw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		w = sw
+		defer func() {
+			f.writeAuditRecord(r, queryString, sw.status, queryStartTime)
+		}()
+	}
+
 	// Initialise the stats in the context and make sure it's propagated
 	// down the request chain.
 	if f.cfg.QueryStatsEnabled {
@@ -148,21 +319,40 @@ func (f *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		_ = r.Body.Close()
 	}()
 
-	// Buffer the body for later use to track slow queries.
+	if !f.cfg.DisableGzipRequests && strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeError(w, httpgrpc.Errorf(http.StatusBadRequest, "invalid gzip request body: %v", err))
+			return
+		}
+		defer func() {
+			_ = gz.Close()
+		}()
+		// The body is forwarded decompressed, with an unknown length.
+		r.Body = gz
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+	}
+
+	// Buffer the body for later use to track slow queries. The size limit applies to the decompressed body.
 	var buf bytes.Buffer
 	r.Body = http.MaxBytesReader(w, r.Body, f.cfg.MaxBodySize)
+	if f.costEstimator != nil && f.cfg.MaxQueryCostEstimate > 0 && f.rejectCostlyQuery(w, r) {
+		return
+	}
 	r.Body = io.NopCloser(io.TeeReader(r.Body, &buf))
 
 	// Check if caching is enabled.
-	if f.lruCache != nil {
+	if f.failedQueryCache != nil {
 		// Store query expression.
-		queryExpressionNormalized = f.regex.ReplaceAllString(r.URL.Query().Get("query"), " ")
+		queryExpressionNormalized = f.failedQueryCache.NormalizeQuery(r.URL.Query().Get("query"))
 
 		// Store query time range length.
 		queryExpressionRangeLength = getQueryRangeSeconds(r)
 
 		// Check if query in cache and whether value exceeds time range length.
-		if value, ok := f.lruCache.Get(queryExpressionNormalized); ok && value.(int) >= queryExpressionRangeLength {
+		if f.failedQueryCache.QueryHitCache(r.URL.Path, queryExpressionNormalized, queryExpressionRangeLength) {
 			w.WriteHeader(http.StatusForbidden)
 			level.Info(util_log.WithContext(r.Context(), f.log)).Log(
 				"msg", "Retrieved query from cache",
@@ -175,15 +365,25 @@ func (f *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	startTime := time.Now()
-	resp, err := f.roundTripper.RoundTrip(r)
-	queryResponseTime := time.Since(startTime)
+	var (
+		resp *http.Response
+		err  error
+	)
+	if f.cfg.DeduplicateRequests {
+		resp, err = f.deduplicatedRoundTrip(r)
+	} else {
+		resp, err = f.roundTripper.RoundTrip(r)
+	}
+	queryEndTime := time.Now()
+	queryResponseTime := queryEndTime.Sub(startTime)
 
 	if err != nil {
 		writeError(w, err)
+		f.observeResponseTime(queryResponseTime, "")
 		queryString = f.parseRequestQueryString(r, buf)
 
 		// Check if caching is enabled.
-		if f.lruCache != nil {
+		if f.failedQueryCache != nil {
 			f.updateFailedQueryCache(err, queryExpressionNormalized, queryExpressionRangeLength, r)
 		}
 
@@ -193,13 +393,29 @@ func (f *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if f.cfg.MaxResponseBodySize > 0 {
+		body, err := readLimitedBody(resp, f.cfg.MaxResponseBodySize)
+		if err != nil {
+			level.Error(util_log.WithContext(r.Context(), f.log)).Log("msg", "failed to read response body", "limit", f.cfg.MaxResponseBodySize, "err", err)
+			if errors.Is(err, errResponseBodyTooLarge) {
+				writeError(w, errResponseBodyTooLarge)
+			} else {
+				writeError(w, httpgrpc.Errorf(http.StatusBadGateway, "read response body: %v", err))
+			}
+			f.observeResponseTime(queryResponseTime, "")
+			return
+		}
+		resp.Body = body
+	}
+
 	hs := w.Header()
 	for h, vs := range resp.Header {
 		hs[h] = vs
 	}
+	f.observeResponseTime(queryResponseTime, hs.Get("X-Thanos-Trace-Id"))
 
 	if f.cfg.QueryStatsEnabled {
-		writeServiceTimingHeader(queryResponseTime, hs, stats)
+		writeServiceTimingHeader(queryResponseTime, hs, stats, f.cfg.TimingHeaderExtensions)
 	}
 
 	w.WriteHeader(resp.StatusCode)
@@ -211,18 +427,30 @@ func (f *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Check whether we should parse the query string.
 	shouldReportSlowQuery := f.cfg.LogQueriesLongerThan != 0 && queryResponseTime > f.cfg.LogQueriesLongerThan
-	if shouldReportSlowQuery || f.cfg.QueryStatsEnabled {
+	if shouldReportSlowQuery || f.cfg.QueryStatsEnabled || f.auditLog != nil {
 		queryString = f.parseRequestQueryString(r, buf)
 	}
 
 	if shouldReportSlowQuery {
-		f.reportSlowQuery(r, hs, queryString, queryResponseTime)
+		f.reportSlowQuery(r, hs, queryString, queryResponseTime, queryStartTime, queryEndTime)
 	}
 	if f.cfg.QueryStatsEnabled {
 		f.reportQueryStats(r, queryString, queryResponseTime, stats)
 	}
 }
 
+// observeResponseTime records the response time of a query, with its trace ID as exemplar if there is one.
+func (f *Handler) observeResponseTime(queryResponseTime time.Duration, traceID string) {
+	if f.responseTime == nil {
+		return
+	}
+	if traceID == "" {
+		f.responseTime.Observe(queryResponseTime.Seconds())
+		return
+	}
+	f.responseTime.(prometheus.ExemplarObserver).ObserveWithExemplar(queryResponseTime.Seconds(), prometheus.Labels{"trace_id": traceID})
+}
+
 func (f *Handler) updateFailedQueryCache(err error, queryExpressionNormalized string, queryExpressionRangeLength int, r *http.Request) {
 	// Extracting error code from error string.
 	codeExtract := f.errorExtract.FindStringSubmatch(err.Error())
@@ -251,7 +479,7 @@ func (f *Handler) updateFailedQueryCache(err error, queryExpressionNormalized st
 	}
 
 	// If error should be cached, store it in cache.
-	if !isCacheableError(errCode) {
+	if !isCacheableError(errCode, f.cfg.CacheableErrorCodes) {
 		level.Debug(util_log.WithContext(r.Context(), f.log)).Log(
 			"msg", "Query not cached due to non-cacheable error code",
 			"normalized_query", queryExpressionNormalized,
@@ -261,13 +489,7 @@ func (f *Handler) updateFailedQueryCache(err error, queryExpressionNormalized st
 		return
 	}
 
-	// Checks if queryExpression is already in cache, and updates time range length value to min of stored and new value.
-	if contains, _ := f.lruCache.ContainsOrAdd(queryExpressionNormalized, queryExpressionRangeLength); contains {
-		if oldValue, ok := f.lruCache.Get(queryExpressionNormalized); ok {
-			queryExpressionRangeLength = min(queryExpressionRangeLength, oldValue.(int))
-		}
-		f.lruCache.Add(queryExpressionNormalized, queryExpressionRangeLength)
-	}
+	queryExpressionRangeLength = f.failedQueryCache.UpdateFailedQueryCache(r.URL.Path, queryExpressionNormalized, queryExpressionRangeLength)
 
 	level.Debug(util_log.WithContext(r.Context(), f.log)).Log(
 		"msg", "Cached a failed query",
@@ -278,9 +500,39 @@ func (f *Handler) updateFailedQueryCache(err error, queryExpressionNormalized st
 
 }
 
-// isCacheableError Returns true if response code is in pre-defined cacheable errors list, else returns false.
-func isCacheableError(statusCode int) bool {
-	for _, errStatusCode := range cacheableResponseCodes {
+// invalidateFailedQueryCache removes the query given by the query parameter from the failed query cache, or
+// all queries if the parameter is not set. It responds with 404 if the given query was not cached.
+func (f *Handler) invalidateFailedQueryCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.Form.Get("query")
+	if query == "" {
+		// An empty query is more likely a mistake than a request to purge the whole cache, which has to be explicit.
+		if r.Form.Get("all") != "true" {
+			http.Error(w, "either a query or all=true is required", http.StatusBadRequest)
+			return
+		}
+		f.failedQueryCache.InvalidateAll()
+		level.Info(util_log.WithContext(r.Context(), f.log)).Log("msg", "invalidated all cached failed queries")
+		return
+	}
+	if !f.failedQueryCache.Invalidate(query) {
+		http.Error(w, "query not cached", http.StatusNotFound)
+		return
+	}
+	level.Info(util_log.WithContext(r.Context(), f.log)).Log("msg", "invalidated cached failed query", "query", query)
+}
+
+// isCacheableError Returns true if response code is in the given cacheable errors list, else returns false.
+func isCacheableError(statusCode int, cacheableErrorCodes []int) bool {
+	for _, errStatusCode := range cacheableErrorCodes {
 		if errStatusCode == statusCode {
 			return true
 		}
@@ -323,13 +575,17 @@ func (f *Handler) reportFailedQuery(r *http.Request, queryString url.Values, err
 		"error", err.Error(),
 		"grafana_dashboard_uid", grafanaDashboardUID,
 		"grafana_panel_id", grafanaPanelID,
-	}, formatQueryString(queryString)...)
+	}, f.formatQueryString(queryString)...)
 
 	level.Error(util_log.WithContext(r.Context(), f.log)).Log(logMessage...)
 }
 
-// reportSlowQuery reports slow queries.
-func (f *Handler) reportSlowQuery(r *http.Request, responseHeaders http.Header, queryString url.Values, queryResponseTime time.Duration) {
+// reportSlowQuery reports slow queries, sampled by the configured slow query log sample rate.
+func (f *Handler) reportSlowQuery(r *http.Request, responseHeaders http.Header, queryString url.Values, queryResponseTime time.Duration, queryStartTime, queryEndTime time.Time) {
+	if f.slowQueryLogSampleRate < 1 && rand.Float64() >= f.slowQueryLogSampleRate {
+		return
+	}
+
 	// NOTE(GiedriusS): see https://github.com/grafana/grafana/pull/60301 for more info.
 	grafanaDashboardUID := "-"
 	if dashboardUID := r.Header.Get("X-Dashboard-Uid"); dashboardUID != "" {
@@ -357,7 +613,16 @@ func (f *Handler) reportSlowQuery(r *http.Request, responseHeaders http.Header,
 		"grafana_dashboard_uid", grafanaDashboardUID,
 		"grafana_panel_id", grafanaPanelID,
 		"trace_id", thanosTraceID,
-	}, formatQueryString(queryString)...)
+	}, f.formatQueryString(queryString)...)
+
+	for _, field := range f.cfg.SlowQueryLogFields {
+		switch field {
+		case SlowQueryLogFieldQueryStartTime:
+			logMessage = append(logMessage, field, queryStartTime.Format(time.RFC3339))
+		case SlowQueryLogFieldQueryEndTime:
+			logMessage = append(logMessage, field, queryEndTime.Format(time.RFC3339))
+		}
+	}
 
 	level.Info(util_log.WithContext(r.Context(), f.log)).Log(logMessage...)
 }
@@ -371,12 +636,14 @@ func (f *Handler) reportQueryStats(r *http.Request, queryString url.Values, quer
 	wallTime := stats.LoadWallTime()
 	numSeries := stats.LoadFetchedSeries()
 	numBytes := stats.LoadFetchedChunkBytes()
+	numSamples := stats.LoadFetchedSamples()
 	remoteUser, _, _ := r.BasicAuth()
 
 	// Track stats.
 	f.querySeconds.WithLabelValues(userID).Add(wallTime.Seconds())
 	f.querySeries.WithLabelValues(userID).Add(float64(numSeries))
 	f.queryBytes.WithLabelValues(userID).Add(float64(numBytes))
+	f.querySamples.WithLabelValues(userID).Add(float64(numSamples))
 	f.activeUsers.UpdateUserTimestamp(userID, time.Now())
 
 	// Log stats.
@@ -391,11 +658,51 @@ func (f *Handler) reportQueryStats(r *http.Request, queryString url.Values, quer
 		"query_wall_time_seconds", wallTime.Seconds(),
 		"fetched_series_count", numSeries,
 		"fetched_chunks_bytes", numBytes,
-	}, formatQueryString(queryString)...)
+		"fetched_samples_count", numSamples,
+	}, f.formatQueryString(queryString)...)
 
 	level.Info(util_log.WithContext(r.Context(), f.log)).Log(logMessage...)
 }
 
+// writeAuditRecord writes the query to the audit log. The query is taken from the parsed query string if
+// there is one, otherwise the form of the request is parsed, as the request was not sent downstream.
+func (f *Handler) writeAuditRecord(r *http.Request, queryString url.Values, status int, queryStartTime time.Time) {
+	if queryString == nil {
+		if err := r.ParseForm(); err == nil {
+			queryString = scrubbedQueryString(r.Context(), r.Form)
+		}
+	}
+	var userID string
+	if tenantIDs, err := tenant.TenantIDs(r.Context()); err == nil {
+		userID = tenant.JoinTenantIDs(tenantIDs)
+	}
+
+	err := f.auditLog.Write(auditRecord{
+		Timestamp:    queryStartTime.UTC(),
+		Tenant:       userID,
+		Method:       r.Method,
+		Path:         r.URL.Path,
+		Query:        queryString.Get("query"),
+		ResponseCode: status,
+		Duration:     time.Since(queryStartTime).String(),
+	})
+	if err != nil {
+		level.Error(util_log.WithContext(r.Context(), f.log)).Log("msg", "failed to write audit log", "err", err)
+	}
+}
+
+// Close stops sharing the failed query cache with other replicas and closes the audit log, if they are enabled.
+func (f *Handler) Close() error {
+	var errs errutil.MultiError
+	if f.failedQueryCache != nil {
+		errs.Add(f.failedQueryCache.Close())
+	}
+	if f.auditLog != nil {
+		errs.Add(f.auditLog.Close())
+	}
+	return errs.Err()
+}
+
 func (f *Handler) parseRequestQueryString(r *http.Request, bodyBuf bytes.Buffer) url.Values {
 	// Use previously buffered body.
 	r.Body = io.NopCloser(&bodyBuf)
@@ -407,10 +714,19 @@ func (f *Handler) parseRequestQueryString(r *http.Request, bodyBuf bytes.Buffer)
 		return nil
 	}
 
-	return r.Form
+	return scrubbedQueryString(r.Context(), r.Form)
 }
 
-func formatQueryString(queryString url.Values) (fields []interface{}) {
+func (f *Handler) formatQueryString(queryString url.Values) (fields []interface{}) {
+	if f.cfg.JSONQueryLogging {
+		params := make(map[string]string, len(queryString))
+		for k, v := range queryString {
+			params[k] = strings.Join(v, ",")
+		}
+		// Marshaling a map of strings cannot fail. Keys are sorted, so that the field is stable.
+		b, _ := json.Marshal(params)
+		return []interface{}{"query_params", string(b)}
+	}
 	for k, v := range queryString {
 		fields = append(fields, fmt.Sprintf("param_%s", k), strings.Join(v, ","))
 	}
@@ -431,16 +747,90 @@ func writeError(w http.ResponseWriter, err error) {
 	server.WriteError(w, err)
 }
 
-func writeServiceTimingHeader(queryResponseTime time.Duration, headers http.Header, stats *querier_stats.Stats) {
+// readLimitedBody reads and closes the body of the response. It returns errResponseBodyTooLarge if the body is
+// larger than limit, without reading more than limit+1 bytes.
+func readLimitedBody(resp *http.Response, limit int64) (io.ReadCloser, error) {
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.ContentLength > limit {
+		return nil, errResponseBodyTooLarge
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, errResponseBodyTooLarge
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func writeServiceTimingHeader(queryResponseTime time.Duration, headers http.Header, stats *querier_stats.Stats, extensions map[string]func(*querier_stats.Stats) time.Duration) {
 	if stats != nil {
 		parts := make([]string, 0)
 		parts = append(parts, statsValue("querier_wall_time", stats.LoadWallTime()))
 		parts = append(parts, statsValue("response_time", queryResponseTime))
+		parts = append(parts, "fetched_samples;desc="+strconv.FormatUint(stats.LoadFetchedSamples(), 10))
+
+		names := make([]string, 0, len(extensions))
+		for name := range extensions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			parts = append(parts, statsValue(name, extensions[name](stats)))
+		}
 		headers.Set(ServiceTimingHeaderName, strings.Join(parts, ", "))
 	}
 }
 
+// FetchedSeriesCountTiming is a Server-Timing header extension, meant to be named "fetched_series_count_millis",
+// which encodes the number of fetched series as its duration in milliseconds for client side tooling.
+func FetchedSeriesCountTiming(stats *querier_stats.Stats) time.Duration {
+	return time.Duration(stats.LoadFetchedSeries()) * time.Millisecond
+}
+
 func statsValue(name string, d time.Duration) string {
 	durationInMs := strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
 	return name + ";dur=" + durationInMs
 }
+
+// timeToFirstByteWriter observes the time until the header or the first byte of the response is written.
+type timeToFirstByteWriter struct {
+	http.ResponseWriter
+
+	start    time.Time
+	observer prometheus.Observer
+	written  bool
+}
+
+func newTimeToFirstByteWriter(w http.ResponseWriter, start time.Time, observer prometheus.Observer) *timeToFirstByteWriter {
+	return &timeToFirstByteWriter{ResponseWriter: w, start: start, observer: observer}
+}
+
+func (w *timeToFirstByteWriter) observe() {
+	if w.written {
+		return
+	}
+	w.written = true
+	w.observer.Observe(time.Since(w.start).Seconds())
+}
+
+func (w *timeToFirstByteWriter) WriteHeader(statusCode int) {
+	w.observe()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *timeToFirstByteWriter) Write(b []byte) (int, error) {
+	w.observe()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, so that streamed responses are still flushed to the client.
+func (w *timeToFirstByteWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
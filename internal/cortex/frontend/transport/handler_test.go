@@ -0,0 +1,579 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package transport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	querier_stats "github.com/thanos-io/thanos/internal/cortex/querier/stats"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func okRoundTripper(downstreamQueries *[]string) http.RoundTripper {
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if downstreamQueries != nil {
+			*downstreamQueries = append(*downstreamQueries, r.URL.Query().Get("query"))
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("{}")),
+		}, nil
+	})
+}
+
+// mustNewHandler returns a new Handler, failing the test if it cannot be created.
+func mustNewHandler(t testing.TB, cfg HandlerConfig, roundTripper http.RoundTripper, log log.Logger, reg prometheus.Registerer, opts ...HandlerOption) *Handler {
+	t.Helper()
+	h, err := NewHandler(cfg, roundTripper, log, reg, opts...)
+	require.NoError(t, err)
+	return h.(*Handler)
+}
+
+func TestHandler_PIIScrubberMiddleware(t *testing.T) {
+	for _, tc := range []struct {
+		query         string
+		expectedQuery string
+	}{
+		{
+			query:         `up{user_id=~"123.*"}`,
+			expectedQuery: `up{user_id=~"[REDACTED]"}`,
+		},
+		{
+			query:         `sum(rate(http_requests_total{user_id="12345",job="api"}[5m]))`,
+			expectedQuery: `sum(rate(http_requests_total{job="api",user_id="[REDACTED]"}[5m]))`,
+		},
+		{
+			query:         `up{user_id!="12345"}`,
+			expectedQuery: `up{user_id!="12345"}`,
+		},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			var (
+				logs              bytes.Buffer
+				downstreamQueries []string
+			)
+			h := mustNewHandler(t, HandlerConfig{LogQueriesLongerThan: -1, MaxBodySize: 1024}, okRoundTripper(&downstreamQueries), log.NewLogfmtLogger(&logs), nil)
+			handler := NewPIIScrubberMiddleware([]string{"user_id"})(h)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/query?query="+url.QueryEscape(tc.query), nil)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			require.Equal(t, http.StatusOK, resp.Code)
+			require.Equal(t, []string{tc.query}, downstreamQueries)
+			require.Contains(t, logs.String(), "param_query="+strconv.Quote(tc.expectedQuery))
+		})
+	}
+}
+
+func TestHandler_TimeToFirstByte(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	upstream := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		time.Sleep(50 * time.Millisecond)
+		return okRoundTripper(nil).RoundTrip(r)
+	})
+	handler := mustNewHandler(t, HandlerConfig{TimeToFirstByteEnabled: true}, upstream, log.NewNopLogger(), reg)
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	var found bool
+	for _, mf := range mfs {
+		if mf.GetName() != "cortex_query_time_to_first_byte_seconds" {
+			continue
+		}
+		found = true
+		h := mf.GetMetric()[0].GetHistogram()
+		require.Equal(t, uint64(1), h.GetSampleCount())
+		require.GreaterOrEqual(t, h.GetSampleSum(), (50 * time.Millisecond).Seconds())
+	}
+	require.True(t, found)
+}
+
+func TestHandler_FetchedSamples(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	upstream := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		querier_stats.FromContext(r.Context()).AddFetchedSamples(42)
+		return okRoundTripper(nil).RoundTrip(r)
+	})
+	var logs bytes.Buffer
+	handler := mustNewHandler(t, HandlerConfig{QueryStatsEnabled: true, MaxBodySize: 1024}, upstream, log.NewLogfmtLogger(&logs), reg)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil)
+	req = req.WithContext(user.InjectOrgID(req.Context(), "user-1"))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	require.Contains(t, resp.Header().Get(ServiceTimingHeaderName), "fetched_samples;desc=42")
+	require.Equal(t, 42.0, promtest.ToFloat64(handler.querySamples.WithLabelValues("user-1")))
+	require.Contains(t, logs.String(), "fetched_samples_count=42")
+}
+
+func TestHandler_TimingHeaderExtensions(t *testing.T) {
+	upstream := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		stats := querier_stats.FromContext(r.Context())
+		stats.AddFetchedSeries(7)
+		stats.AddWallTime(250 * time.Millisecond)
+		return okRoundTripper(nil).RoundTrip(r)
+	})
+	handler := mustNewHandler(t, HandlerConfig{QueryStatsEnabled: true, MaxBodySize: 1024, TimingHeaderExtensions: map[string]func(*querier_stats.Stats) time.Duration{
+		"fetched_series_count_millis": FetchedSeriesCountTiming,
+		"double_wall_time": func(stats *querier_stats.Stats) time.Duration {
+			return 2 * stats.LoadWallTime()
+		},
+	}}, upstream, log.NewNopLogger(), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil)
+	req = req.WithContext(user.InjectOrgID(req.Context(), "user-1"))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	parts := strings.Split(resp.Header().Get(ServiceTimingHeaderName), ", ")
+	require.Len(t, parts, 5)
+	require.Equal(t, []string{"double_wall_time;dur=500", "fetched_series_count_millis;dur=7"}, parts[3:])
+}
+
+func TestHandler_GzipRequests(t *testing.T) {
+	form := url.Values{"query": {"sum(rate(http_requests_total[5m]))"}, "start": {"0"}, "end": {"3600"}, "step": {"60"}}
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	_, err := gz.Write([]byte(form.Encode()))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	for _, allow := range []bool{true, false} {
+		t.Run(fmt.Sprintf("allow=%v", allow), func(t *testing.T) {
+			var downstream *http.Request
+			upstream := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				require.NoError(t, r.ParseForm())
+				downstream = r
+				return okRoundTripper(nil).RoundTrip(r)
+			})
+			handler := mustNewHandler(t, HandlerConfig{DisableGzipRequests: !allow, MaxBodySize: 1024}, upstream, log.NewNopLogger(), nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/query_range", bytes.NewReader(body.Bytes()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Content-Encoding", "gzip")
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			require.Equal(t, http.StatusOK, resp.Code)
+
+			if !allow {
+				// The body is forwarded as is.
+				require.Equal(t, "gzip", downstream.Header.Get("Content-Encoding"))
+				return
+			}
+			require.Empty(t, downstream.Header.Get("Content-Encoding"))
+			require.Equal(t, form, downstream.PostForm)
+		})
+	}
+
+	t.Run("invalid body", func(t *testing.T) {
+		handler := mustNewHandler(t, HandlerConfig{MaxBodySize: 1024}, okRoundTripper(nil), log.NewNopLogger(), nil)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/query_range", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+}
+
+func TestHandler_Exemplars(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	upstream := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"X-Thanos-Trace-Id": []string{"abc123"}},
+			Body:       io.NopCloser(strings.NewReader("{}")),
+		}, nil
+	})
+	handler := mustNewHandler(t, HandlerConfig{ExemplarsEnabled: true}, upstream, log.NewNopLogger(), reg)
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	var exemplars []string
+	for _, mf := range mfs {
+		if mf.GetName() != "cortex_query_response_time_seconds" {
+			continue
+		}
+		h := mf.GetMetric()[0].GetHistogram()
+		require.Equal(t, uint64(1), h.GetSampleCount())
+		for _, b := range h.GetBucket() {
+			if e := b.GetExemplar(); e != nil {
+				for _, l := range e.GetLabel() {
+					exemplars = append(exemplars, l.GetName()+"="+l.GetValue())
+				}
+			}
+		}
+	}
+	require.Equal(t, []string{"trace_id=abc123"}, exemplars)
+}
+
+func TestHandler_JSONQueryLogging(t *testing.T) {
+	query := `sum(rate(http_requests_total{job="api",code=~"5.."}[5m]))`
+
+	var logs bytes.Buffer
+	handler := mustNewHandler(t, HandlerConfig{LogQueriesLongerThan: -1, MaxBodySize: 1024, JSONQueryLogging: true}, okRoundTripper(nil), log.NewJSONLogger(&logs), nil)
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/v1/query_range?query="+url.QueryEscape(query)+"&step=15", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var line map[string]string
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &line))
+	require.NotContains(t, line, "param_query")
+
+	var params map[string]string
+	require.NoError(t, json.Unmarshal([]byte(line["query_params"]), &params))
+	require.Equal(t, map[string]string{"query": query, "step": "15"}, params)
+}
+
+func TestHandler_SlowQueryLogSampleRate(t *testing.T) {
+	rate := func(r float64) *float64 { return &r }
+
+	const queries = 1000
+	for _, tc := range []struct {
+		name     string
+		rate     *float64
+		expected int
+		delta    float64
+	}{
+		{name: "default", expected: queries},
+		{name: "sampled", rate: rate(0.1), expected: queries / 10, delta: 50},
+		{name: "none", rate: rate(0), expected: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			handler := mustNewHandler(t, HandlerConfig{LogQueriesLongerThan: -1, MaxBodySize: 1024, SlowQueryLogSampleRate: tc.rate}, okRoundTripper(nil), log.NewLogfmtLogger(&logs), nil)
+
+			for i := 0; i < queries; i++ {
+				resp := httptest.NewRecorder()
+				handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil))
+				require.Equal(t, http.StatusOK, resp.Code)
+			}
+			require.InDelta(t, tc.expected, strings.Count(logs.String(), "slow query detected"), tc.delta)
+		})
+	}
+
+	// Failed queries are not sampled.
+	var logs bytes.Buffer
+	handler := mustNewHandler(t, HandlerConfig{LogQueriesLongerThan: -1, MaxBodySize: 1024, SlowQueryLogSampleRate: rate(0), LogFailedQueries: true},
+		roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return nil, httpgrpc.Errorf(http.StatusInternalServerError, "failed")
+		}), log.NewLogfmtLogger(&logs), nil)
+	for i := 0; i < 10; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil))
+	}
+	require.Equal(t, 10, strings.Count(logs.String(), "failed query"))
+}
+
+func TestHandler_MaxResponseBodySize(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		body          string
+		contentLength int64
+		expectedCode  int
+	}{
+		{name: "below limit", body: strings.Repeat("a", 1024), contentLength: -1, expectedCode: http.StatusOK},
+		{name: "above limit", body: strings.Repeat("a", 1025), contentLength: -1, expectedCode: http.StatusBadGateway},
+		{name: "above limit with content length", body: strings.Repeat("a", 10*1024*1024), contentLength: 10 * 1024 * 1024, expectedCode: http.StatusBadGateway},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			handler := mustNewHandler(t, HandlerConfig{MaxBodySize: 1024, MaxResponseBodySize: 1024},
+				roundTripperFunc(func(*http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode:    http.StatusOK,
+						Header:        http.Header{"Content-Type": []string{"application/json"}},
+						Body:          io.NopCloser(strings.NewReader(tc.body)),
+						ContentLength: tc.contentLength,
+					}, nil
+				}), log.NewLogfmtLogger(&logs), nil)
+
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil))
+			require.Equal(t, tc.expectedCode, resp.Code)
+			if tc.expectedCode == http.StatusOK {
+				require.Equal(t, tc.body, resp.Body.String())
+				require.Empty(t, logs.String())
+				return
+			}
+			require.Contains(t, resp.Body.String(), "response body too large")
+			require.Contains(t, logs.String(), "level=error")
+		})
+	}
+}
+
+func TestHandler_FailedQueryCacheGossip(t *testing.T) {
+	addrA, addrB := freeUDPAddr(t), freeUDPAddr(t)
+	newHandler := func(addr, peer string) *Handler {
+		return mustNewHandler(t, HandlerConfig{
+			MaxBodySize:                    1024,
+			FailedQueryCacheCapacity:       10,
+			FailedQueryCacheGossipBindAddr: addr,
+			FailedQueryCacheGossipPeers:    []string{peer},
+			FailedQueryCacheGossipKey:      "secret",
+		}, roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return nil, httpgrpc.Errorf(http.StatusBadRequest, "bad query")
+		}), log.NewNopLogger(), nil)
+	}
+	a, b := newHandler(addrA, addrB), newHandler(addrB, addrA)
+
+	a.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil))
+	require.Eventually(t, func() bool {
+		resp := httptest.NewRecorder()
+		b.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil))
+		return resp.Code == http.StatusForbidden
+	}, 500*time.Millisecond, 5*time.Millisecond)
+
+	// Closing the handlers stops the gossip, releasing their addresses.
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+	conn, err := net.ListenPacket("udp", addrA)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+}
+
+func TestHandler_FailedQueryCacheGossipError(t *testing.T) {
+	// A gossip without key cannot be set up, which fails the creation of the handler.
+	_, err := NewHandler(HandlerConfig{
+		MaxBodySize:                    1024,
+		FailedQueryCacheCapacity:       10,
+		FailedQueryCacheGossipBindAddr: freeUDPAddr(t),
+	}, okRoundTripper(nil), log.NewNopLogger(), nil)
+	require.Error(t, err)
+}
+
+func TestHandler_FailedQueryCacheMetrics(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	cfg := HandlerConfig{MaxBodySize: 1024, FailedQueryCacheCapacity: 10, FailedQueryCacheMetricsMaxQueries: 5}
+	handler := mustNewHandler(t, cfg, roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, "bad query")
+	}), log.NewNopLogger(), reg)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up", nil))
+	n, err := promtest.GatherAndCount(reg, "thanos_blocked_query_info")
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	// A collector already registered on the registry is not an error.
+	var logs bytes.Buffer
+	other, err := NewFailedQueryCache(10)
+	require.NoError(t, err)
+	reg = prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(NewFailedQueryCacheCollector(other, 5)))
+	require.NotPanics(t, func() {
+		mustNewHandler(t, cfg, okRoundTripper(nil), log.NewLogfmtLogger(&logs), reg)
+	})
+	require.Contains(t, logs.String(), "Failed to register failed query cache metrics")
+}
+
+func TestHandler_DeduplicateRequests(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		leaderErr    error
+		expectedCode int
+		calls        int
+		deduplicated float64
+	}{
+		{name: "response shared", expectedCode: http.StatusOK, calls: 1, deduplicated: 2},
+		{name: "error not shared", leaderErr: errors.New("upstream failure"), expectedCode: http.StatusInternalServerError, calls: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mtx     sync.Mutex
+				calls   int
+				called  = make(chan struct{})
+				release = make(chan struct{})
+			)
+			upstream := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				mtx.Lock()
+				calls++
+				first := calls == 1
+				mtx.Unlock()
+				if first {
+					close(called)
+					<-release
+					if tc.leaderErr != nil {
+						return nil, tc.leaderErr
+					}
+				}
+				return okRoundTripper(nil).RoundTrip(r)
+			})
+			reg := prometheus.NewPedanticRegistry()
+			handler := mustNewHandler(t, HandlerConfig{DeduplicateRequests: true, MaxBodySize: 1024}, upstream, log.NewNopLogger(), reg)
+			waiting := make(chan struct{}, 3)
+			handler.onDeduplicatedWait = func() { waiting <- struct{}{} }
+
+			serve := func() *httptest.ResponseRecorder {
+				resp := httptest.NewRecorder()
+				handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up&time=1", nil))
+				return resp
+			}
+
+			var (
+				wg      sync.WaitGroup
+				waiters = make([]*httptest.ResponseRecorder, 2)
+				leader  *httptest.ResponseRecorder
+			)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				leader = serve()
+			}()
+			<-called
+			for i := range waiters {
+				i := i
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					waiters[i] = serve()
+				}()
+			}
+			// Wait for the leader and both waiters to wait for the in-flight request.
+			for i := 0; i < cap(waiting); i++ {
+				<-waiting
+			}
+			close(release)
+			wg.Wait()
+
+			require.Equal(t, tc.expectedCode, leader.Code)
+			for _, w := range waiters {
+				require.Equal(t, http.StatusOK, w.Code)
+				require.Equal(t, "{}", w.Body.String())
+			}
+			require.Equal(t, tc.calls, calls)
+			require.Equal(t, tc.deduplicated, promtest.ToFloat64(handler.deduplicatedRequests))
+		})
+	}
+}
+
+func TestRequestFingerprint(t *testing.T) {
+	fingerprint := func(r *http.Request) string {
+		f, err := requestFingerprint(r)
+		require.NoError(t, err)
+		return f
+	}
+	get := httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up&time=1", nil)
+
+	require.Equal(t, fingerprint(get), fingerprint(httptest.NewRequest(http.MethodGet, "/api/v1/query?time=1&query=up", nil)))
+	require.NotEqual(t, fingerprint(get), fingerprint(httptest.NewRequest(http.MethodGet, "/api/v1/query_range?query=up&time=1", nil)))
+
+	otherTenant := httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up&time=1", nil)
+	otherTenant.Header.Set("X-Scope-OrgID", "other")
+	require.NotEqual(t, fingerprint(get), fingerprint(otherTenant))
+
+	// Responses in other formats or encodings are not shared.
+	for _, h := range []string{"Accept", "Accept-Encoding"} {
+		other := httptest.NewRequest(http.MethodGet, "/api/v1/query?query=up&time=1", nil)
+		other.Header.Set(h, "other")
+		require.NotEqual(t, fingerprint(get), fingerprint(other), h)
+	}
+
+	post := httptest.NewRequest(http.MethodPost, "/api/v1/query", strings.NewReader("time=1&query=up"))
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	postFingerprint := fingerprint(post)
+	require.Equal(t, "POST"+strings.TrimPrefix(fingerprint(get), "GET"), postFingerprint)
+
+	// The body can still be read once the fingerprint is computed.
+	body, err := io.ReadAll(post.Body)
+	require.NoError(t, err)
+	require.Equal(t, "time=1&query=up", string(body))
+}
+
+func TestHandler_CacheableErrorCodes(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		codes        []int
+		expectCached bool
+	}{
+		{name: "default codes", expectCached: false},
+		{name: "custom codes", codes: []int{http.StatusInternalServerError}, expectCached: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			upstream := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				calls++
+				return nil, httpgrpc.Errorf(http.StatusInternalServerError, "internal error")
+			})
+			handler := mustNewHandler(t, HandlerConfig{
+				MaxBodySize:              1024,
+				FailedQueryCacheCapacity: 10,
+				CacheableErrorCodes:      tc.codes,
+			}, upstream, log.NewNopLogger(), nil)
+
+			for i := 0; i < 2; i++ {
+				resp := httptest.NewRecorder()
+				handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/v1/query_range?query=up&start=0&end=100", nil))
+			}
+			if tc.expectCached {
+				require.Equal(t, 1, calls)
+			} else {
+				require.Equal(t, 2, calls)
+			}
+		})
+	}
+}
+
+func TestHandler_InvalidateFailedQueryCache(t *testing.T) {
+	handler := mustNewHandler(t, HandlerConfig{MaxBodySize: 1024, FailedQueryCacheCapacity: 10}, okRoundTripper(nil), log.NewNopLogger(), nil)
+	handler.failedQueryCache.UpdateFailedQueryCache("/api/v1/query_range", "up", 3600)
+	handler.failedQueryCache.UpdateFailedQueryCache("/api/v1/query_range", "sum(up)", 3600)
+
+	invalidate := func(method string, form url.Values) int {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest(method, InvalidateFailedQueryCachePath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	require.Equal(t, http.StatusMethodNotAllowed, invalidate(http.MethodGet, url.Values{"query": {"up"}}))
+	require.Equal(t, http.StatusOK, invalidate(http.MethodPost, url.Values{"query": {"up"}}))
+	require.Equal(t, http.StatusNotFound, invalidate(http.MethodPost, url.Values{"query": {"up"}}))
+	require.True(t, handler.failedQueryCache.QueryHitCache("/api/v1/query_range", "sum(up)", 3600))
+
+	// Purging the whole cache has to be explicit.
+	require.Equal(t, http.StatusBadRequest, invalidate(http.MethodPost, url.Values{"query": {""}}))
+	require.Equal(t, http.StatusBadRequest, invalidate(http.MethodPost, url.Values{"all": {"false"}}))
+	require.True(t, handler.failedQueryCache.QueryHitCache("/api/v1/query_range", "sum(up)", 3600))
+	require.Equal(t, http.StatusOK, invalidate(http.MethodPost, url.Values{"all": {"true"}}))
+	require.False(t, handler.failedQueryCache.QueryHitCache("/api/v1/query_range", "sum(up)", 3600))
+}
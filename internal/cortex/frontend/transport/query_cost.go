@@ -0,0 +1,140 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/thanos-io/thanos/internal/cortex/util"
+	util_log "github.com/thanos-io/thanos/internal/cortex/util/log"
+)
+
+// QueryCostEstimator estimates the cost of queries, so that the ones too costly can be rejected before being
+// sent downstream.
+type QueryCostEstimator interface {
+	// Estimate returns the estimated cost of the query over the given time range, in milliseconds since epoch.
+	Estimate(ctx context.Context, query string, start, end int64) (int64, error)
+}
+
+// HandlerOption configures a Handler.
+type HandlerOption func(*Handler)
+
+// WithQueryCostEstimator rejects queries whose cost estimated by e exceeds HandlerConfig.MaxQueryCostEstimate
+// with 429. Queries whose cost cannot be estimated are sent downstream.
+func WithQueryCostEstimator(e QueryCostEstimator) HandlerOption {
+	return func(h *Handler) {
+		h.costEstimator = e
+	}
+}
+
+// rejectCostlyQuery responds with 429 and returns true if the estimated cost of the query exceeds the maximum.
+// It also responds with 413 if the body of the request is too large, or 400 if it cannot be read, and returns true.
+func (f *Handler) rejectCostlyQuery(w http.ResponseWriter, r *http.Request) bool {
+	params, _, err := requestParams(r)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, errRequestEntityTooLarge)
+		} else {
+			writeError(w, httpgrpc.Errorf(http.StatusBadRequest, "invalid request body: %v", err))
+		}
+		return true
+	}
+	query := params.Get("query")
+	if query == "" {
+		return false
+	}
+	start, end, err := queryTimeRange(params)
+	if err != nil {
+		return false
+	}
+
+	cost, err := f.costEstimator.Estimate(r.Context(), query, start, end)
+	if err != nil {
+		level.Warn(util_log.WithContext(r.Context(), f.log)).Log("msg", "failed to estimate query cost", "query", query, "err", err)
+		return false
+	}
+	if cost <= f.cfg.MaxQueryCostEstimate {
+		return false
+	}
+	writeError(w, httpgrpc.Errorf(http.StatusTooManyRequests,
+		"the estimated cost %d of the query exceeds the maximum of %d, reduce its time range or the number of series it selects", cost, f.cfg.MaxQueryCostEstimate))
+	return true
+}
+
+// queryTimeRange returns the time range of range queries, or the evaluation time of instant queries.
+func queryTimeRange(params url.Values) (int64, int64, error) {
+	if params.Get("start") == "" && params.Get("end") == "" {
+		ts := util.TimeToMillis(time.Now())
+		if t := params.Get("time"); t != "" {
+			var err error
+			if ts, err = util.ParseTime(t); err != nil {
+				return 0, 0, err
+			}
+		}
+		return ts, ts, nil
+	}
+	start, err := util.ParseTime(params.Get("start"))
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := util.ParseTime(params.Get("end"))
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+type cardinalityCostEstimator struct {
+	cardinality        map[string]int64
+	defaultCardinality int64
+}
+
+// NewCardinalityCostEstimator returns a QueryCostEstimator estimating the cost of a query as the number of series
+// selected by its selectors multiplied by its time range in seconds, counting instant queries as one second.
+// The number of series of a selector is the one of its metric name in cardinality, or defaultCardinality for
+// other metric names and for selectors without a metric name.
+func NewCardinalityCostEstimator(cardinality map[string]int64, defaultCardinality int64) QueryCostEstimator {
+	return &cardinalityCostEstimator{cardinality: cardinality, defaultCardinality: defaultCardinality}
+}
+
+func (e *cardinalityCostEstimator) Estimate(_ context.Context, query string, start, end int64) (int64, error) {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return 0, err
+	}
+
+	var series int64
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		if vs, ok := node.(*parser.VectorSelector); ok {
+			series += e.selectorCardinality(vs)
+		}
+		return nil
+	})
+	return series * max(1, (end-start)/1000), nil
+}
+
+func (e *cardinalityCostEstimator) selectorCardinality(vs *parser.VectorSelector) int64 {
+	name := vs.Name
+	if name == "" {
+		for _, m := range vs.LabelMatchers {
+			if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+				name = m.Value
+			}
+		}
+	}
+	if c, ok := e.cardinality[name]; ok {
+		return c
+	}
+	return e.defaultCardinality
+}
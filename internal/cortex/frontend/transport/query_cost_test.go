@@ -0,0 +1,115 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCardinalityCostEstimator(t *testing.T) {
+	e := NewCardinalityCostEstimator(map[string]int64{"up": 10, "http_requests_total": 1000}, 100)
+
+	for _, tc := range []struct {
+		query      string
+		start, end int64
+		expected   int64
+	}{
+		{query: "up", expected: 10},
+		{query: `{__name__="up", job="a"}`, expected: 10},
+		{query: "up", start: 0, end: 3600 * 1000, expected: 36000},
+		{query: "sum(rate(http_requests_total[5m])) / on() group_left up", start: 0, end: 60 * 1000, expected: 60600},
+		{query: `{job="a"}`, expected: 100},
+		{query: "unknown_metric", expected: 100},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			cost, err := e.Estimate(context.Background(), tc.query, tc.start, tc.end)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, cost)
+		})
+	}
+
+	_, err := e.Estimate(context.Background(), "sum(", 0, 0)
+	require.Error(t, err)
+}
+
+func TestHandler_QueryCostEstimator(t *testing.T) {
+	estimator := NewCardinalityCostEstimator(map[string]int64{"up": 10}, 100000)
+
+	for _, tc := range []struct {
+		name         string
+		method, path string
+		params       url.Values
+		expectedCode int
+	}{
+		{name: "cheap range query", method: http.MethodGet, path: "/api/v1/query_range", params: url.Values{"query": {"up"}, "start": {"0"}, "end": {"3600"}, "step": {"60"}}, expectedCode: http.StatusOK},
+		{name: "costly range query", method: http.MethodGet, path: "/api/v1/query_range", params: url.Values{"query": {"up"}, "start": {"0"}, "end": {"86400"}, "step": {"60"}}, expectedCode: http.StatusTooManyRequests},
+		{name: "costly POST range query", method: http.MethodPost, path: "/api/v1/query_range", params: url.Values{"query": {"up"}, "start": {"0"}, "end": {"86400"}, "step": {"60"}}, expectedCode: http.StatusTooManyRequests},
+		{name: "costly instant query", method: http.MethodGet, path: "/api/v1/query", params: url.Values{"query": {"sum(other)"}, "time": {"0"}}, expectedCode: http.StatusTooManyRequests},
+		{name: "invalid query", method: http.MethodGet, path: "/api/v1/query", params: url.Values{"query": {"sum("}}, expectedCode: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var downstreamQueries []string
+			handler := mustNewHandler(t, HandlerConfig{MaxBodySize: 1024, MaxQueryCostEstimate: 50000},
+				roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+					require.NoError(t, r.ParseForm())
+					downstreamQueries = append(downstreamQueries, r.Form.Get("query"))
+					return okRoundTripper(nil).RoundTrip(r)
+				}), log.NewNopLogger(), nil, WithQueryCostEstimator(estimator))
+
+			var req *http.Request
+			if tc.method == http.MethodPost {
+				req = httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.params.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest(http.MethodGet, tc.path+"?"+tc.params.Encode(), nil)
+			}
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			require.Equal(t, tc.expectedCode, resp.Code)
+
+			if tc.expectedCode != http.StatusOK {
+				require.Contains(t, resp.Body.String(), "estimated cost")
+				require.Empty(t, downstreamQueries)
+				return
+			}
+			// The query, including the body of POST requests, is still sent downstream.
+			require.Equal(t, []string{tc.params.Get("query")}, downstreamQueries)
+		})
+	}
+}
+
+func TestHandler_QueryCostEstimatorBodyErrors(t *testing.T) {
+	estimator := NewCardinalityCostEstimator(map[string]int64{"up": 10}, 100000)
+
+	for _, tc := range []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{name: "body too large", body: url.Values{"query": {strings.Repeat("up or ", 200) + "up"}}.Encode(), expectedCode: http.StatusRequestEntityTooLarge},
+		{name: "malformed body", body: "query=%zz", expectedCode: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := mustNewHandler(t, HandlerConfig{MaxBodySize: 1024, MaxQueryCostEstimate: 50000},
+				roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+					t.Fatal("the request should not be sent downstream")
+					return nil, nil
+				}), log.NewNopLogger(), nil, WithQueryCostEstimator(estimator))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/query", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			require.Equal(t, tc.expectedCode, resp.Code)
+		})
+	}
+}
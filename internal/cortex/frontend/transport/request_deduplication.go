@@ -0,0 +1,124 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package transport
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// fingerprintHeaders are the request headers, identifying the caller or the format of the response, which are
+// part of the fingerprint of deduplicated requests. Requests differing only in other headers share their response.
+var fingerprintHeaders = []string{"Authorization", "X-Scope-OrgID", "THANOS-TENANT", "Accept", "Accept-Encoding"}
+
+// sharedResponse is a response read in full, so that it can be sent to all callers of a deduplicated request.
+type sharedResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+func (s *sharedResponse) response() *http.Response {
+	return &http.Response{
+		StatusCode: s.statusCode,
+		Header:     s.header.Clone(),
+		Body:       io.NopCloser(bytes.NewReader(s.body)),
+	}
+}
+
+// deduplicatedRoundTrip sends the request to the round tripper, unless an identical request is already in
+// flight, in which case it waits for the response of that request and returns a copy of it. Errors are not
+// shared: callers waiting for a request which failed send their own request.
+func (f *Handler) deduplicatedRoundTrip(r *http.Request) (*http.Response, error) {
+	key, err := requestFingerprint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	leader := false
+	ch := f.inflightRequests.DoChan(key, func() (interface{}, error) {
+		leader = true
+		resp, err := f.roundTripper.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &sharedResponse{statusCode: resp.StatusCode, header: resp.Header, body: body}, nil
+	})
+	if f.onDeduplicatedWait != nil {
+		f.onDeduplicatedWait()
+	}
+	res := <-ch
+	v, err := res.Val, res.Err
+	if err != nil {
+		if leader {
+			return nil, err
+		}
+		return f.roundTripper.RoundTrip(r)
+	}
+	if !leader {
+		f.deduplicatedRequests.Inc()
+	}
+	return v.(*sharedResponse).response(), nil
+}
+
+// requestFingerprint returns the method, path, sorted parameters and caller of the request. Form parameters
+// of the body are read and the body is replaced, so that the request can still be sent.
+func requestFingerprint(r *http.Request) (string, error) {
+	params, body, err := requestParams(r)
+	if err != nil {
+		return "", err
+	}
+	if body != nil {
+		params["__body__"] = []string{string(body)}
+	}
+
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte('\n')
+	b.WriteString(r.URL.Path)
+	b.WriteByte('\n')
+	// Encode sorts the parameters by name.
+	b.WriteString(params.Encode())
+	for _, h := range fingerprintHeaders {
+		b.WriteByte('\n')
+		b.WriteString(strings.Join(r.Header.Values(h), ","))
+	}
+	return b.String(), nil
+}
+
+// requestParams returns the URL parameters of the request, merged with the form parameters of POST bodies.
+// Other POST bodies are returned as is. The body is read and replaced, so that the request can still be sent.
+func requestParams(r *http.Request) (url.Values, []byte, error) {
+	params := r.URL.Query()
+	if r.Body == nil || r.Method != http.MethodPost {
+		return params, nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/x-www-form-urlencoded" {
+		return params, body, nil
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range form {
+		params[k] = append(params[k], v...)
+	}
+	return params, nil, nil
+}
@@ -0,0 +1,108 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// RedactedLabelValue replaces scrubbed label values in logged queries.
+const RedactedLabelValue = "[REDACTED]"
+
+type contextKey int
+
+const scrubbedQueryContextKey contextKey = 0
+
+// NewPIIScrubberMiddleware returns a middleware which redacts the values of equality and regexp matchers
+// on the given label names in the query expression. The scrubbed query is only used for logging, the query
+// evaluated downstream is left untouched.
+func NewPIIScrubberMiddleware(scrubLabelNames []string) func(http.Handler) http.Handler {
+	scrubbed := make(map[string]struct{}, len(scrubLabelNames))
+	for _, name := range scrubLabelNames {
+		scrubbed[name] = struct{}{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query, err := requestQuery(r)
+			if err != nil || query == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if scrubbedQuery, ok := scrubQuery(query, scrubbed); ok {
+				r = r.WithContext(context.WithValue(r.Context(), scrubbedQueryContextKey, scrubbedQuery))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestQuery returns the query parameter of the request without consuming its body.
+func requestQuery(r *http.Request) (string, error) {
+	if r.Body == nil || r.Method != http.MethodPost {
+		return r.URL.Query().Get("query"), nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	clone := r.Clone(r.Context())
+	clone.Body = io.NopCloser(bytes.NewReader(body))
+	if err := clone.ParseForm(); err != nil {
+		return "", err
+	}
+	return clone.Form.Get("query"), nil
+}
+
+// scrubQuery redacts the values of equality and regexp matchers on the given label names.
+// It returns false if the query cannot be parsed.
+func scrubQuery(query string, scrubLabelNames map[string]struct{}) (string, bool) {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return "", false
+	}
+
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		vs, ok := node.(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+		for i, m := range vs.LabelMatchers {
+			if _, ok := scrubLabelNames[m.Name]; !ok {
+				continue
+			}
+			if m.Type != labels.MatchEqual && m.Type != labels.MatchRegexp {
+				continue
+			}
+			vs.LabelMatchers[i] = labels.MustNewMatcher(m.Type, m.Name, RedactedLabelValue)
+		}
+		return nil
+	})
+	return expr.String(), true
+}
+
+// scrubbedQueryString replaces the query in the given parameters with its scrubbed version, if there is one.
+func scrubbedQueryString(ctx context.Context, queryString url.Values) url.Values {
+	scrubbedQuery, ok := ctx.Value(scrubbedQueryContextKey).(string)
+	if !ok || queryString == nil {
+		return queryString
+	}
+
+	scrubbed := make(url.Values, len(queryString))
+	for k, v := range queryString {
+		scrubbed[k] = v
+	}
+	scrubbed.Set("query", scrubbedQuery)
+	return scrubbed
+}
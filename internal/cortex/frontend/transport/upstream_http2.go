@@ -0,0 +1,85 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// NewUpstreamHTTP2Middleware returns a middleware which sends upstream requests over HTTP/2 using the given
+// TLS config. Requests to upstreams that do not negotiate HTTP/2 via ALPN fall back to the wrapped round tripper.
+func NewUpstreamHTTP2Middleware(tlsConfig *tls.Config) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		cfg := &tls.Config{}
+		if tlsConfig != nil {
+			cfg = tlsConfig.Clone()
+		}
+		// Offer HTTP/1.1 too, otherwise upstreams without HTTP/2 support abort the handshake.
+		cfg.NextProtos = []string{http2.NextProtoTLS, "http/1.1"}
+
+		return &upstreamHTTP2RoundTripper{
+			h2: &http2.Transport{
+				TLSClientConfig: cfg,
+				DialTLSContext:  dialHTTP2TLS,
+			},
+			next: next,
+		}
+	}
+}
+
+var errHTTP2NotNegotiated = errors.New("upstream did not negotiate HTTP/2")
+
+func dialHTTP2TLS(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+	dialer := &tls.Dialer{Config: cfg}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if conn.(*tls.Conn).ConnectionState().NegotiatedProtocol != http2.NextProtoTLS {
+		_ = conn.Close()
+		return nil, errHTTP2NotNegotiated
+	}
+	return conn, nil
+}
+
+type upstreamHTTP2RoundTripper struct {
+	h2   *http2.Transport
+	next http.RoundTripper
+
+	// http1Hosts holds the upstream hosts which do not support HTTP/2.
+	http1Hosts sync.Map
+}
+
+func (u *upstreamHTTP2RoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	// HTTP/2 without TLS (h2c) is not negotiated, so plain text requests always use HTTP/1.1.
+	if r.URL.Scheme != "https" {
+		return u.next.RoundTrip(r)
+	}
+	if _, ok := u.http1Hosts.Load(r.URL.Host); ok {
+		return u.next.RoundTrip(r)
+	}
+
+	resp, err := u.h2.RoundTrip(r)
+	if err == nil || !errors.Is(err, errHTTP2NotNegotiated) {
+		return resp, err
+	}
+
+	u.http1Hosts.Store(r.URL.Host, struct{}{})
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r = r.Clone(r.Context())
+		r.Body = body
+	}
+	return u.next.RoundTrip(r)
+}
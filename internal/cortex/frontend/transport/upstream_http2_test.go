@@ -0,0 +1,53 @@
+// Copyright (c) The Cortex Authors.
+// Licensed under the Apache License 2.0.
+
+package transport
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamHTTP2Middleware(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		enableHTTP2   bool
+		expectedProto string
+	}{
+		{name: "upstream supports HTTP/2", enableHTTP2: true, expectedProto: "HTTP/2.0"},
+		{name: "upstream falls back to HTTP/1.1", enableHTTP2: false, expectedProto: "HTTP/1.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, r.Proto)
+			}))
+			upstream.EnableHTTP2 = tc.enableHTTP2
+			upstream.StartTLS()
+			defer upstream.Close()
+
+			tlsConfig := &tls.Config{RootCAs: upstream.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+			http1 := &http.Transport{TLSClientConfig: tlsConfig.Clone()}
+			rt := NewUpstreamHTTP2Middleware(tlsConfig)(http1)
+
+			for i := 0; i < 2; i++ {
+				req, err := http.NewRequest(http.MethodGet, upstream.URL, nil)
+				require.NoError(t, err)
+
+				resp, err := rt.RoundTrip(req)
+				require.NoError(t, err)
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+				require.Equal(t, tc.expectedProto, string(body))
+			}
+			http1.CloseIdleConnections()
+		})
+	}
+}
@@ -36,6 +36,8 @@ type Stats struct {
 	FetchedSeriesCount uint64 `protobuf:"varint,2,opt,name=fetched_series_count,json=fetchedSeriesCount,proto3" json:"fetched_series_count,omitempty"`
 	// The number of bytes of the chunks fetched for the query
 	FetchedChunkBytes uint64 `protobuf:"varint,3,opt,name=fetched_chunk_bytes,json=fetchedChunkBytes,proto3" json:"fetched_chunk_bytes,omitempty"`
+	// The number of samples fetched for the query
+	FetchedSamplesCount uint64 `protobuf:"varint,4,opt,name=fetched_samples_count,json=fetchedSamplesCount,proto3" json:"fetched_samples_count,omitempty"`
 }
 
 func (m *Stats) Reset()      { *m = Stats{} }
@@ -91,6 +93,13 @@ func (m *Stats) GetFetchedChunkBytes() uint64 {
 	return 0
 }
 
+func (m *Stats) GetFetchedSamplesCount() uint64 {
+	if m != nil {
+		return m.FetchedSamplesCount
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Stats)(nil), "stats.Stats")
 }
@@ -98,25 +107,26 @@ func init() {
 func init() { proto.RegisterFile("stats.proto", fileDescriptor_b4756a0aec8b9d44) }
 
 var fileDescriptor_b4756a0aec8b9d44 = []byte{
-	// 281 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x44, 0xd0, 0xb1, 0x4e, 0x83, 0x40,
-	0x1c, 0xc7, 0xf1, 0xfb, 0xab, 0x35, 0x95, 0x4e, 0xa2, 0x03, 0x76, 0xf8, 0xb7, 0x71, 0xea, 0xe2,
-	0xd5, 0xe8, 0xe8, 0x62, 0xa8, 0x4f, 0xd0, 0x3a, 0xb9, 0x10, 0xa0, 0x57, 0x20, 0x02, 0x67, 0xe0,
-	0x2e, 0xc6, 0xcd, 0x47, 0x70, 0xf4, 0x11, 0x4c, 0x7c, 0x91, 0x8e, 0x8c, 0x9d, 0x54, 0x8e, 0xc5,
-	0xb1, 0x8f, 0x60, 0xee, 0xa0, 0x71, 0xe3, 0x97, 0x0f, 0xdf, 0x4b, 0xee, 0xac, 0x41, 0x29, 0x7c,
-	0x51, 0xd2, 0xa7, 0x82, 0x0b, 0x6e, 0xf7, 0xcc, 0x18, 0x5e, 0x44, 0x89, 0x88, 0x65, 0x40, 0x43,
-	0x9e, 0x4d, 0x23, 0x1e, 0xf1, 0xa9, 0xd1, 0x40, 0xae, 0xcc, 0x32, 0xc3, 0x7c, 0xb5, 0xd5, 0x10,
-	0x23, 0xce, 0xa3, 0x94, 0xfd, 0xff, 0xb5, 0x94, 0x85, 0x2f, 0x12, 0x9e, 0xb7, 0x7e, 0xfe, 0x09,
-	0x56, 0x6f, 0xa1, 0x0f, 0xb6, 0x6f, 0xad, 0xa3, 0x67, 0x3f, 0x4d, 0x3d, 0x91, 0x64, 0xcc, 0x81,
-	0x31, 0x4c, 0x06, 0x57, 0x67, 0xb4, 0xad, 0xe9, 0xae, 0xa6, 0x77, 0x5d, 0xed, 0xf6, 0xd7, 0x5f,
-	0x23, 0xf2, 0xfe, 0x3d, 0x82, 0x79, 0x5f, 0x57, 0xf7, 0x49, 0xc6, 0xec, 0x4b, 0xeb, 0x74, 0xc5,
-	0x44, 0x18, 0xb3, 0xa5, 0x57, 0xb2, 0x22, 0x61, 0xa5, 0x17, 0x72, 0x99, 0x0b, 0x67, 0x6f, 0x0c,
-	0x93, 0x83, 0xb9, 0xdd, 0xd9, 0xc2, 0xd0, 0x4c, 0x8b, 0x4d, 0xad, 0x93, 0x5d, 0x11, 0xc6, 0x32,
-	0x7f, 0xf4, 0x82, 0x17, 0xc1, 0x4a, 0x67, 0xdf, 0x04, 0xc7, 0x1d, 0xcd, 0xb4, 0xb8, 0x1a, 0xdc,
-	0x9b, 0xaa, 0x46, 0xb2, 0xa9, 0x91, 0x6c, 0x6b, 0x84, 0x57, 0x85, 0xf0, 0xa1, 0x10, 0xd6, 0x0a,
-	0xa1, 0x52, 0x08, 0x3f, 0x0a, 0xe1, 0x57, 0x21, 0xd9, 0x2a, 0x84, 0xb7, 0x06, 0x49, 0xd5, 0x20,
-	0xd9, 0x34, 0x48, 0x1e, 0xda, 0x97, 0x0b, 0x0e, 0xcd, 0x2d, 0xae, 0xff, 0x02, 0x00, 0x00, 0xff,
-	0xff, 0x9d, 0xf1, 0x86, 0xb8, 0x56, 0x01, 0x00, 0x00,
+	// 296 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x44, 0x90, 0xb1, 0x4e, 0xc2, 0x40,
+	0x1c, 0xc6, 0xef, 0xaf, 0x60, 0xf0, 0x98, 0x3c, 0x35, 0x41, 0x86, 0x3f, 0xc4, 0x89, 0xc5, 0xc3,
+	0xe0, 0xe8, 0x62, 0xc0, 0x27, 0x00, 0x27, 0x97, 0xa6, 0x2d, 0x47, 0x69, 0x6c, 0x39, 0xd2, 0x5e,
+	0x63, 0xdc, 0x7c, 0x04, 0x47, 0x1f, 0xc1, 0x47, 0x61, 0xec, 0xc8, 0x24, 0xf6, 0xba, 0x38, 0xf2,
+	0x08, 0xa6, 0xd7, 0x16, 0xb7, 0xfb, 0xf2, 0xbb, 0xdf, 0xf7, 0xe5, 0x8e, 0xb6, 0x63, 0x65, 0xab,
+	0x98, 0xaf, 0x23, 0xa9, 0x24, 0x6b, 0x9a, 0xd0, 0xbd, 0xf1, 0x7c, 0xb5, 0x4c, 0x1c, 0xee, 0xca,
+	0x70, 0xe8, 0x49, 0x4f, 0x0e, 0x0d, 0x75, 0x92, 0x85, 0x49, 0x26, 0x98, 0x53, 0x69, 0x75, 0xd1,
+	0x93, 0xd2, 0x0b, 0xc4, 0xff, 0xad, 0x79, 0x12, 0xd9, 0xca, 0x97, 0xab, 0x92, 0x5f, 0xef, 0x80,
+	0x36, 0x67, 0x45, 0x31, 0x7b, 0xa0, 0xa7, 0xaf, 0x76, 0x10, 0x58, 0xca, 0x0f, 0x45, 0x07, 0xfa,
+	0x30, 0x68, 0x8f, 0xae, 0x78, 0x69, 0xf3, 0xda, 0xe6, 0x8f, 0x95, 0x3d, 0x6e, 0x6d, 0xbe, 0x7b,
+	0xe4, 0x73, 0xd7, 0x83, 0x69, 0xab, 0xb0, 0x9e, 0xfc, 0x50, 0xb0, 0x5b, 0x7a, 0xb1, 0x10, 0xca,
+	0x5d, 0x8a, 0xb9, 0x15, 0x8b, 0xc8, 0x17, 0xb1, 0xe5, 0xca, 0x64, 0xa5, 0x3a, 0x47, 0x7d, 0x18,
+	0x34, 0xa6, 0xac, 0x62, 0x33, 0x83, 0x26, 0x05, 0x61, 0x9c, 0x9e, 0xd7, 0x86, 0xbb, 0x4c, 0x56,
+	0x2f, 0x96, 0xf3, 0xa6, 0x44, 0xdc, 0x39, 0x36, 0xc2, 0x59, 0x85, 0x26, 0x05, 0x19, 0x17, 0x80,
+	0x8d, 0xe8, 0xe5, 0x61, 0xc1, 0x0e, 0xd7, 0xc1, 0x61, 0xa2, 0x61, 0x8c, 0xba, 0x6c, 0x56, 0x32,
+	0xb3, 0x31, 0xbe, 0x4f, 0x33, 0x24, 0xdb, 0x0c, 0xc9, 0x3e, 0x43, 0x78, 0xd7, 0x08, 0x5f, 0x1a,
+	0x61, 0xa3, 0x11, 0x52, 0x8d, 0xf0, 0xa3, 0x11, 0x7e, 0x35, 0x92, 0xbd, 0x46, 0xf8, 0xc8, 0x91,
+	0xa4, 0x39, 0x92, 0x6d, 0x8e, 0xe4, 0xb9, 0xfc, 0x6d, 0xe7, 0xc4, 0xbc, 0xfc, 0xee, 0x6f, 0x00,
+	0x13, 0x62, 0xd7, 0x64, 0x8a, 0x01, 0x00, 0x00,
 }
 
 func (this *Stats) Equal(that interface{}) bool {
@@ -147,17 +157,21 @@ func (this *Stats) Equal(that interface{}) bool {
 	if this.FetchedChunkBytes != that1.FetchedChunkBytes {
 		return false
 	}
+	if this.FetchedSamplesCount != that1.FetchedSamplesCount {
+		return false
+	}
 	return true
 }
 func (this *Stats) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 7)
+	s := make([]string, 0, 8)
 	s = append(s, "&stats.Stats{")
 	s = append(s, "WallTime: "+fmt.Sprintf("%#v", this.WallTime)+",\n")
 	s = append(s, "FetchedSeriesCount: "+fmt.Sprintf("%#v", this.FetchedSeriesCount)+",\n")
 	s = append(s, "FetchedChunkBytes: "+fmt.Sprintf("%#v", this.FetchedChunkBytes)+",\n")
+	s = append(s, "FetchedSamplesCount: "+fmt.Sprintf("%#v", this.FetchedSamplesCount)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -189,6 +203,11 @@ func (m *Stats) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if m.FetchedSamplesCount != 0 {
+		i = encodeVarintStats(dAtA, i, uint64(m.FetchedSamplesCount))
+		i--
+		dAtA[i] = 0x20
+	}
 	if m.FetchedChunkBytes != 0 {
 		i = encodeVarintStats(dAtA, i, uint64(m.FetchedChunkBytes))
 		i--
@@ -235,6 +254,9 @@ func (m *Stats) Size() (n int) {
 	if m.FetchedChunkBytes != 0 {
 		n += 1 + sovStats(uint64(m.FetchedChunkBytes))
 	}
+	if m.FetchedSamplesCount != 0 {
+		n += 1 + sovStats(uint64(m.FetchedSamplesCount))
+	}
 	return n
 }
 
@@ -252,6 +274,7 @@ func (this *Stats) String() string {
 		`WallTime:` + strings.Replace(strings.Replace(fmt.Sprintf("%v", this.WallTime), "Duration", "duration.Duration", 1), `&`, ``, 1) + `,`,
 		`FetchedSeriesCount:` + fmt.Sprintf("%v", this.FetchedSeriesCount) + `,`,
 		`FetchedChunkBytes:` + fmt.Sprintf("%v", this.FetchedChunkBytes) + `,`,
+		`FetchedSamplesCount:` + fmt.Sprintf("%v", this.FetchedSamplesCount) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -364,6 +387,25 @@ func (m *Stats) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FetchedSamplesCount", wireType)
+			}
+			m.FetchedSamplesCount = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowStats
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.FetchedSamplesCount |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipStats(dAtA[iNdEx:])
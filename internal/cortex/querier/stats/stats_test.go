@@ -62,23 +62,43 @@ func TestStats_AddFetchedChunkBytes(t *testing.T) {
 	})
 }
 
+func TestStats_AddFetchedSamples(t *testing.T) {
+	t.Run("add and load samples", func(t *testing.T) {
+		stats, _ := ContextWithEmptyStats(context.Background())
+		stats.AddFetchedSamples(1000)
+		stats.AddFetchedSamples(500)
+
+		assert.Equal(t, uint64(1500), stats.LoadFetchedSamples())
+	})
+
+	t.Run("add and load samples nil receiver", func(t *testing.T) {
+		var stats *Stats
+		stats.AddFetchedSamples(500)
+
+		assert.Equal(t, uint64(0), stats.LoadFetchedSamples())
+	})
+}
+
 func TestStats_Merge(t *testing.T) {
 	t.Run("merge two stats objects", func(t *testing.T) {
 		stats1 := &Stats{}
 		stats1.AddWallTime(time.Millisecond)
 		stats1.AddFetchedSeries(50)
 		stats1.AddFetchedChunkBytes(42)
+		stats1.AddFetchedSamples(1000)
 
 		stats2 := &Stats{}
 		stats2.AddWallTime(time.Second)
 		stats2.AddFetchedSeries(60)
 		stats2.AddFetchedChunkBytes(100)
+		stats2.AddFetchedSamples(2000)
 
 		stats1.Merge(stats2)
 
 		assert.Equal(t, 1001*time.Millisecond, stats1.LoadWallTime())
 		assert.Equal(t, uint64(110), stats1.LoadFetchedSeries())
 		assert.Equal(t, uint64(142), stats1.LoadFetchedChunkBytes())
+		assert.Equal(t, uint64(3000), stats1.LoadFetchedSamples())
 	})
 
 	t.Run("merge two nil stats objects", func(t *testing.T) {
@@ -90,5 +110,6 @@ func TestStats_Merge(t *testing.T) {
 		assert.Equal(t, time.Duration(0), stats1.LoadWallTime())
 		assert.Equal(t, uint64(0), stats1.LoadFetchedSeries())
 		assert.Equal(t, uint64(0), stats1.LoadFetchedChunkBytes())
+		assert.Equal(t, uint64(0), stats1.LoadFetchedSamples())
 	})
 }
@@ -103,29 +103,47 @@ func dedupExemplarsResponse(exemplarsData []*exemplarspb.ExemplarData, replicaLa
 		return exemplarsData
 	}
 
-	// Deduplicate series labels.
-	hashToExemplar := make(map[uint64]*exemplarspb.ExemplarData)
+	d := NewExemplarDeduplicator()
 	for _, e := range exemplarsData {
-		if len(e.Exemplars) == 0 {
-			continue
-		}
 		e.SeriesLabels.Labels = removeReplicaLabels(e.SeriesLabels.Labels, replicaLabels)
-		h := labelpb.ZLabelsToPromLabels(e.SeriesLabels.Labels).Hash()
-		if ref, ok := hashToExemplar[h]; ok {
-			ref.Exemplars = append(ref.Exemplars, e.Exemplars...)
-		} else {
-			hashToExemplar[h] = e
-		}
+		d.Add(e)
 	}
+	return d.Deduplicated()
+}
+
+// ExemplarDeduplicator merges the exemplars of series with the same labels, e.g. received from several
+// stores, and drops exemplars with the same labels, value and timestamp.
+type ExemplarDeduplicator struct {
+	series map[uint64]*exemplarspb.ExemplarData
+}
+
+// NewExemplarDeduplicator returns a new ExemplarDeduplicator.
+func NewExemplarDeduplicator() *ExemplarDeduplicator {
+	return &ExemplarDeduplicator{series: make(map[uint64]*exemplarspb.ExemplarData)}
+}
+
+// Add adds the exemplars of a series. Series without exemplars are ignored.
+func (d *ExemplarDeduplicator) Add(e *exemplarspb.ExemplarData) {
+	if len(e.Exemplars) == 0 {
+		return
+	}
+	h := labelpb.ZLabelsToPromLabels(e.SeriesLabels.Labels).Hash()
+	if ref, ok := d.series[h]; ok {
+		ref.Exemplars = append(ref.Exemplars, e.Exemplars...)
+		return
+	}
+	d.series[h] = e
+}
 
-	res := make([]*exemplarspb.ExemplarData, 0, len(hashToExemplar))
-	for _, e := range hashToExemplar {
-		// Dedup exemplars with the same series labels.
+// Deduplicated returns the added series sorted by labels, each with its exemplars deduplicated and
+// sorted by timestamp.
+func (d *ExemplarDeduplicator) Deduplicated() []*exemplarspb.ExemplarData {
+	res := make([]*exemplarspb.ExemplarData, 0, len(d.series))
+	for _, e := range d.series {
 		e.Exemplars = dedupExemplars(e.Exemplars)
 		res = append(res, e)
 	}
 
-	// Sort by series labels.
 	sort.Slice(res, func(i, j int) bool {
 		return res[i].Compare(res[j]) < 0
 	})
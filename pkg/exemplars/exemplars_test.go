@@ -228,3 +228,30 @@ func TestDedupExemplarsResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestExemplarDeduplicator(t *testing.T) {
+	series := func(name string, exemplars ...*exemplarspb.Exemplar) *exemplarspb.ExemplarData {
+		return &exemplarspb.ExemplarData{
+			SeriesLabels: labelpb.ZLabelSet{Labels: []labelpb.ZLabel{{Name: "__name__", Value: name}}},
+			Exemplars:    exemplars,
+		}
+	}
+	exemplar := func(traceID string, ts int64) *exemplarspb.Exemplar {
+		return &exemplarspb.Exemplar{Labels: labelpb.ZLabelSet{Labels: []labelpb.ZLabel{{Name: "traceID", Value: traceID}}}, Value: 1, Ts: ts}
+	}
+
+	d := NewExemplarDeduplicator()
+	// Replicas return the same exemplars, in different orders.
+	d.Add(series("b_total", exemplar("b", 20), exemplar("a", 10)))
+	d.Add(series("a_total", exemplar("c", 30)))
+	d.Add(series("b_total", exemplar("a", 10), exemplar("b", 20), exemplar("d", 40)))
+	d.Add(series("a_total", exemplar("c", 30)))
+	d.Add(series("c_total"))
+
+	testutil.Equals(t, []*exemplarspb.ExemplarData{
+		series("a_total", exemplar("c", 30)),
+		series("b_total", exemplar("a", 10), exemplar("b", 20), exemplar("d", 40)),
+	}, d.Deduplicated())
+
+	testutil.Equals(t, []*exemplarspb.ExemplarData{}, NewExemplarDeduplicator().Deduplicated())
+}
@@ -75,9 +75,9 @@ func (s *Proxy) Exemplars(req *exemplarspb.ExemplarsRequest, srv exemplarspb.Exe
 	}
 
 	var (
-		g, gctx   = errgroup.WithContext(ctx)
-		respChan  = make(chan *exemplarspb.ExemplarData, 10)
-		exemplars []*exemplarspb.ExemplarData
+		g, gctx  = errgroup.WithContext(ctx)
+		respChan = make(chan *exemplarspb.ExemplarData, 10)
+		dedup    = NewExemplarDeduplicator()
 	)
 
 	queryParts := make([]string, 0)
@@ -143,8 +143,9 @@ func (s *Proxy) Exemplars(req *exemplarspb.ExemplarsRequest, srv exemplarspb.Exe
 		close(respChan)
 	}()
 
+	// The same exemplars can be received from several replicas.
 	for resp := range respChan {
-		exemplars = append(exemplars, resp)
+		dedup.Add(resp)
 	}
 
 	if err := g.Wait(); err != nil {
@@ -152,7 +153,7 @@ func (s *Proxy) Exemplars(req *exemplarspb.ExemplarsRequest, srv exemplarspb.Exe
 		return err
 	}
 
-	for _, e := range exemplars {
+	for _, e := range dedup.Deduplicated() {
 		tracing.DoInSpan(srv.Context(), "send_exemplars_response", func(_ context.Context) {
 			err = srv.Send(exemplarspb.NewExemplarsResponse(e))
 		})
@@ -315,6 +315,40 @@ func TestProxy(t *testing.T) {
 				}),
 			},
 		},
+		{
+			name: "duplicate exemplars from replicas",
+			request: &exemplarspb.ExemplarsRequest{
+				Query:                   `http_request_duration_bucket`,
+				PartialResponseStrategy: storepb.PartialResponseStrategy_WARN,
+			},
+			clients: []*exemplarspb.ExemplarStore{
+				{
+					ExemplarsClient: &testExemplarClient{
+						response: exemplarspb.NewExemplarsResponse(&exemplarspb.ExemplarData{
+							SeriesLabels: labelpb.ZLabelSet{Labels: labelpb.ZLabelsFromPromLabels(labels.FromMap(map[string]string{"foo": "bar"}))},
+							Exemplars:    []*exemplarspb.Exemplar{{Value: 1, Ts: 10}},
+						}),
+					},
+					LabelSets: []labels.Labels{labels.FromMap(map[string]string{"replica": "0"})},
+				},
+				{
+					ExemplarsClient: &testExemplarClient{
+						response: exemplarspb.NewExemplarsResponse(&exemplarspb.ExemplarData{
+							SeriesLabels: labelpb.ZLabelSet{Labels: labelpb.ZLabelsFromPromLabels(labels.FromMap(map[string]string{"foo": "bar"}))},
+							Exemplars:    []*exemplarspb.Exemplar{{Value: 1, Ts: 10}},
+						}),
+					},
+					LabelSets: []labels.Labels{labels.FromMap(map[string]string{"replica": "1"})},
+				},
+			},
+			server: &testExemplarServer{},
+			wantResponses: []*exemplarspb.ExemplarsResponse{
+				exemplarspb.NewExemplarsResponse(&exemplarspb.ExemplarData{
+					SeriesLabels: labelpb.ZLabelSet{Labels: labelpb.ZLabelsFromPromLabels(labels.FromMap(map[string]string{"foo": "bar"}))},
+					Exemplars:    []*exemplarspb.Exemplar{{Value: 1, Ts: 10}},
+				}),
+			},
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			p := NewProxy(logger, func() []*exemplarspb.ExemplarStore {
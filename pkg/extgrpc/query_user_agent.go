@@ -0,0 +1,44 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package extgrpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// QueryUserAgentHeader is the gRPC metadata key carrying the query user agent.
+// The gRPC user-agent is set per channel, so the per-query user agent is sent as metadata instead.
+const QueryUserAgentHeader = "thanos-query-user-agent"
+
+type queryUserAgentKey struct{}
+
+// ContextWithQueryUserAgent returns a context with the given query user agent which is sent
+// to the servers by the query user agent interceptors.
+func ContextWithQueryUserAgent(ctx context.Context, ua string) context.Context {
+	return context.WithValue(ctx, queryUserAgentKey{}, ua)
+}
+
+func appendQueryUserAgent(ctx context.Context) context.Context {
+	if ua, ok := ctx.Value(queryUserAgentKey{}).(string); ok && ua != "" {
+		return metadata.AppendToOutgoingContext(ctx, QueryUserAgentHeader, ua)
+	}
+	return ctx
+}
+
+// QueryUserAgentUnaryClientInterceptor sends the query user agent from the context as metadata.
+func QueryUserAgentUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(appendQueryUserAgent(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// QueryUserAgentStreamClientInterceptor sends the query user agent from the context as metadata.
+func QueryUserAgentStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(appendQueryUserAgent(ctx), desc, cc, method, opts...)
+	}
+}
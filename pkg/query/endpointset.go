@@ -963,6 +963,14 @@ func (er *endpointRef) SupportsWithoutReplicaLabels() bool {
 	return er.metadata.Store.SupportsWithoutReplicaLabels
 }
 
+func (er *endpointRef) SupportsCompression(string) bool {
+	return false
+}
+
+func (er *endpointRef) ResponseTimeout() time.Duration {
+	return 0
+}
+
 func (er *endpointRef) String() string {
 	mint, maxt := er.TimeRange()
 	return fmt.Sprintf(
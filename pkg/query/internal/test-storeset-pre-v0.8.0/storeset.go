@@ -223,6 +223,14 @@ func (s *storeRef) SupportsWithoutReplicaLabels() bool {
 	return false
 }
 
+func (s *storeRef) SupportsCompression(string) bool {
+	return false
+}
+
+func (s *storeRef) ResponseTimeout() time.Duration {
+	return 0
+}
+
 func (s *storeRef) String() string {
 	mint, maxt := s.TimeRange()
 	return fmt.Sprintf(
@@ -157,6 +157,14 @@ func (l *localClient) SupportsWithoutReplicaLabels() bool {
 	return true
 }
 
+func (l *localClient) SupportsCompression(string) bool {
+	return false
+}
+
+func (l *localClient) ResponseTimeout() time.Duration {
+	return 0
+}
+
 type tenant struct {
 	readyS        *ReadyStorage
 	storeTSDB     *store.TSDBStore
@@ -6,7 +6,10 @@ package store
 import (
 	"context"
 	"fmt"
+	"io"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"testing"
@@ -14,6 +17,8 @@ import (
 
 	"github.com/cespare/xxhash"
 	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/prometheus/model/labels"
@@ -26,6 +31,7 @@ import (
 	"github.com/thanos-io/thanos/pkg/promclient"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
 	"github.com/thanos-io/thanos/pkg/testutil/custom"
 	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
 )
@@ -486,3 +492,109 @@ func TestPrometheusStore_Info(t *testing.T) {
 	testutil.Equals(t, int64(123), resp.MinTime)
 	testutil.Equals(t, int64(456), resp.MaxTime)
 }
+
+func TestRemoteReadClient_Series(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Equals(t, "/prom/api/v1/read", r.URL.Path)
+
+		compressed, err := io.ReadAll(r.Body)
+		testutil.Ok(t, err)
+		b, err := snappy.Decode(nil, compressed)
+		testutil.Ok(t, err)
+		var req prompb.ReadRequest
+		testutil.Ok(t, proto.Unmarshal(b, &req))
+		testutil.Equals(t, 1, len(req.Queries))
+		testutil.Equals(t, int64(10), req.Queries[0].StartTimestampMs)
+		testutil.Equals(t, int64(20), req.Queries[0].EndTimestampMs)
+		testutil.Equals(t, []*prompb.LabelMatcher{{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"}}, req.Queries[0].Matchers)
+
+		b, err = proto.Marshal(&prompb.ReadResponse{Results: []*prompb.QueryResult{{Timeseries: []*prompb.TimeSeries{
+			{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up", "job", "a")), Samples: []prompb.Sample{{Timestamp: 10, Value: 1}, {Timestamp: 15, Value: 2}}},
+			{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up", "job", "b")), Samples: []prompb.Sample{{Timestamp: 20, Value: 3}}},
+		}}}})
+		testutil.Ok(t, err)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, err = w.Write(snappy.Encode(nil, b))
+		testutil.Ok(t, err)
+	}))
+	defer srv.Close()
+
+	client, err := NewRemoteReadClient(srv.URL+"/prom", srv.Client())
+	testutil.Ok(t, err)
+	addr, isLocal := client.Addr()
+	testutil.Equals(t, srv.URL+"/prom", addr)
+	testutil.Assert(t, !isLocal)
+	mint, maxt := client.TimeRange()
+	testutil.Equals(t, int64(math.MinInt64), mint)
+	testutil.Equals(t, int64(math.MaxInt64), maxt)
+
+	seriesClient, err := client.Series(context.Background(), &storepb.SeriesRequest{
+		MinTime:  10,
+		MaxTime:  20,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"}},
+	})
+	testutil.Ok(t, err)
+
+	var (
+		lsets   []labels.Labels
+		samples [][]sample
+	)
+	for {
+		resp, err := seriesClient.Recv()
+		if err == io.EOF {
+			break
+		}
+		testutil.Ok(t, err)
+		lsets = append(lsets, resp.GetSeries().PromLabels())
+
+		var ss []sample
+		for _, c := range resp.GetSeries().Chunks {
+			chk, err := chunkenc.FromData(chunkenc.EncXOR, c.Raw.Data)
+			testutil.Ok(t, err)
+			ss = append(ss, expandChunk(chk.Iterator(nil))...)
+		}
+		samples = append(samples, ss)
+	}
+	testutil.Equals(t, []labels.Labels{labels.FromStrings("__name__", "up", "job", "a"), labels.FromStrings("__name__", "up", "job", "b")}, lsets)
+	testutil.Equals(t, [][]sample{{{10, 1}, {15, 2}}, {{20, 3}}}, samples)
+}
+
+func TestRemoteReadClient_Series_WithoutReplicaLabels(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := proto.Marshal(&prompb.ReadResponse{Results: []*prompb.QueryResult{{Timeseries: []*prompb.TimeSeries{
+			{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up", "job", "a", "replica", "1")), Samples: []prompb.Sample{{Timestamp: 10, Value: 1}}},
+			{Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up", "job", "b", "replica", "0")), Samples: []prompb.Sample{{Timestamp: 10, Value: 2}}},
+		}}}})
+		testutil.Ok(t, err)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, err = w.Write(snappy.Encode(nil, b))
+		testutil.Ok(t, err)
+	}))
+	defer srv.Close()
+
+	client, err := NewRemoteReadClient(srv.URL, srv.Client())
+	testutil.Ok(t, err)
+	testutil.Assert(t, !client.SupportsWithoutReplicaLabels())
+
+	q := NewProxyStore(nil, nil,
+		func() []Client { return []Client{client} },
+		component.Query,
+		labels.EmptyLabels(),
+		0, EagerRetrieval,
+	)
+	s := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:              10,
+		MaxTime:              20,
+		Matchers:             []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"}},
+		WithoutReplicaLabels: []string{"replica"},
+	}, s))
+
+	var lsets []labels.Labels
+	for _, series := range s.SeriesSet {
+		lsets = append(lsets, series.PromLabels())
+	}
+	testutil.Equals(t, []labels.Labels{labels.FromStrings("__name__", "up", "job", "a"), labels.FromStrings("__name__", "up", "job", "b")}, lsets)
+}
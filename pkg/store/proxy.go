@@ -7,6 +7,8 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,14 +19,18 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/prometheus/model/labels"
+	"go.uber.org/atomic"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/extgrpc"
 	"github.com/thanos-io/thanos/pkg/info/infopb"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/thanos-io/thanos/pkg/strutil"
@@ -40,6 +46,15 @@ const UninitializedTSDBTime = math.MaxInt64
 // StoreMatcherKey is the context key for the store's allow list.
 const StoreMatcherKey = ctxKey(0)
 
+// defaultSelectivityWarnThreshold is the default number of stores above which queries selecting all stores are logged.
+const defaultSelectivityWarnThreshold = 10
+
+// defaultShardingRequiredFraction is the default fraction of stores supporting sharding above which sharding is required.
+const defaultShardingRequiredFraction = 0.5
+
+// DeadlineHeader is the gRPC metadata key carrying the request deadline as a unix timestamp in milliseconds.
+const DeadlineHeader = "x-thanos-deadline-ms"
+
 // ErrorNoStoresMatched is returned if the query does not match any data.
 // This can happen with Query servers trees and external labels.
 var ErrorNoStoresMatched = errors.New("No StoreAPIs matched for this query")
@@ -65,6 +80,14 @@ type Client interface {
 	// and sorted response is supported by the underlying store.
 	SupportsWithoutReplicaLabels() bool
 
+	// SupportsCompression returns true if the underlying store accepts gRPC calls
+	// compressed with the given algorithm.
+	SupportsCompression(algo string) bool
+
+	// ResponseTimeout returns the timeout for receiving each response from the store, or zero
+	// to use the response timeout of the proxy.
+	ResponseTimeout() time.Duration
+
 	// String returns the string representation of the store client.
 	String() string
 
@@ -81,6 +104,16 @@ type Client interface {
 	GroupKey() string
 }
 
+// ResolutionAwareClient is a Client which knows the downsampling resolutions of the data it holds, e.g. a store
+// holding only raw or only downsampled data. Stores not implementing it are queried for all resolutions.
+type ResolutionAwareClient interface {
+	Client
+
+	// SupportsResolution returns true if the store has data usable by queries with the given max resolution
+	// window, in milliseconds.
+	SupportsResolution(window int64) bool
+}
+
 // ProxyStore implements the store API that proxies request to all given underlying stores.
 type ProxyStore struct {
 	logger         log.Logger
@@ -89,15 +122,126 @@ type ProxyStore struct {
 	selectorLabels labels.Labels
 	buffers        sync.Pool
 
+	bufferInitialSize int
+	bufferMaxSize     int
+
 	responseTimeout   time.Duration
 	metrics           *proxyStoreMetrics
 	retrievalStrategy RetrievalStrategy
 	debugLogging      bool
-	tsdbSelector      *TSDBSelector
+	tsdbSelector      atomic.Pointer[TSDBSelector]
+
+	dedupBypassMetricNames map[string]struct{}
+
+	activeStreams atomic.Int64
+
+	grpcCompression string
+	maxRecvMsgSize  int
+	maxSendMsgSize  int
+
+	timeRangeAlignmentMs int64
+
+	selectionLatencyTracking bool
+	queryHintsPushDown       bool
+	tenantPropagation        bool
+	queryMetadataUserAgent   bool
+	fanoutAnalysis           bool
+	connectionWarmupInterval time.Duration
+	healthProbeInterval      time.Duration
+	lastStoreContact         atomic.Time
+	overlapAnalysis          bool
+	speculativeThreshold     float64
+	storeCapabilities        *StoreCapabilityCache
+	chunkOverlapMetrics      bool
+	querySummaryLog          bool
+	deadlineHeader           bool
+	metadataQueryDiagnostics bool
+	maxLabelSetMatchers      int
+	selectivityLogging       bool
+	selectivityWarnThreshold int
+	storeMatchingCache       *storeMatchingCache
+	queueDepthMonitoring     bool
+	tenantUsageAccounting    bool
+	requireGroupReplicaKeys  bool
+	maxMatchersPerStore      int
+	baggageToMetadataKeys    []string
+	metadataToBaggageKeys    []string
+	groupTopology            *groupTopology
+	metadataSizeTracking     bool
+	maxLabelCardinality      int
+	strictLabelCardinality   bool
+	dedupDebugMessages       bool
+	tenantSeriesAccounting   bool
+	labelSetCoverageAnalysis bool
+	shardingRequiredStores   int
+	shardingRequiredFraction float64
+	requestReplay            *requestRing
+	skipNonShardingStores    bool
+	latencyAttribution       bool
+	minTimeRangeOverlapMs    int64
+	circuitBreaker           *circuitBreaker
+	hedgeAfter               time.Duration
+	storeConcurrency         *storeConcurrencyLimiter
+	retryMaxAttempts         int
+	retryBackoff             time.Duration
+	maxSeriesPerQuery        uint64
+	blacklist                *storeBlacklist
+	labelSetFilters          *labelSetFilters
+	tenantStoreRouter        func(tenant string) []Client
+	storeGraph               *StoreGraph
+	fanoutConcurrency        int
+	infoCacheTTL             time.Duration
+	infoCache                atomic.Pointer[cachedInfoResponse]
+	labelSetCacheTTL         time.Duration
+	labelSetCache            atomic.Pointer[cachedLabelSets]
+	timeRangeCacheTTL        time.Duration
+	timeRangeCache           atomic.Pointer[cachedTimeRange]
+	cacheGeneration          atomic.Uint64
+
+	healthReportInterval time.Duration
+	healthReportLogger   log.Logger
+	storeErrorsMtx       sync.Mutex
+	storeErrors          map[string]int
+
+	// done is closed when the proxy is closed to stop background goroutines.
+	done chan struct{}
+	wg   sync.WaitGroup
 }
 
 type proxyStoreMetrics struct {
-	emptyStreamResponses prometheus.Counter
+	emptyStreamResponses   prometheus.Counter
+	storeSelectionDuration prometheus.Histogram
+	warmupFailures         *prometheus.CounterVec
+	seriesOverlapRatio     *prometheus.HistogramVec
+	chunkOverlapMs         prometheus.Counter
+	chunksDeduplicated     prometheus.Counter
+	querySelectivity       prometheus.Histogram
+	seriesQueueDepth       prometheus.Gauge
+	seriesFanoutDepth      prometheus.Gauge
+	tenantStoreQueries     *prometheus.CounterVec
+	invalidGroupReplicaKey prometheus.Counter
+	incomingMetadataBytes  prometheus.Histogram
+	outgoingMetadataBytes  prometheus.Histogram
+	tenantSeriesReturned   *prometheus.CounterVec
+	tenantWarnings         *prometheus.CounterVec
+	labelSetCoverage       *prometheus.CounterVec
+	nonShardingSkipped     prometheus.Counter
+	seriesMatchingDuration prometheus.Histogram
+	seriesSetupDuration    prometheus.Histogram
+	seriesMergeDuration    prometheus.Histogram
+	circuitBreakerState    *prometheus.GaugeVec
+	fanoutBlocked          prometheus.Counter
+	storeSeriesDuration    *prometheus.HistogramVec
+	fanoutRetries          *prometheus.CounterVec
+	queryHintFiltered      prometheus.Counter
+	maxSeriesExceeded      prometheus.Counter
+	blacklisted            prometheus.Counter
+	blacklistActive        prometheus.Gauge
+	fanoutActive           prometheus.Gauge
+	infoCacheHits          prometheus.Counter
+	storeFilteredTotal     *prometheus.CounterVec
+	storeGroupFanoutTotal  *prometheus.CounterVec
+	storeGroupErrorTotal   *prometheus.CounterVec
 }
 
 func newProxyStoreMetrics(reg prometheus.Registerer) *proxyStoreMetrics {
@@ -107,6 +251,146 @@ func newProxyStoreMetrics(reg prometheus.Registerer) *proxyStoreMetrics {
 		Name: "thanos_proxy_store_empty_stream_responses_total",
 		Help: "Total number of empty responses received.",
 	})
+	m.storeSelectionDuration = promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+		Name:    "thanos_proxy_store_selection_duration_seconds",
+		Help:    "Time spent selecting the stores to fan out a Series request to.",
+		Buckets: []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1},
+	})
+	m.warmupFailures = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_warmup_failures_total",
+		Help: "Total number of failed connection warmup calls to stores.",
+	}, []string{"store_addr"})
+	m.seriesOverlapRatio = promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "thanos_proxy_store_series_overlap_ratio",
+		Help:    "Ratio of series returned by more than one store of the same group, 1 meaning all stores returned all series.",
+		Buckets: prometheus.LinearBuckets(0, 0.1, 11),
+	}, []string{"group_key"})
+	m.querySelectivity = promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+		Name:    "thanos_proxy_store_query_selectivity",
+		Help:    "Fraction of all stores selected to fan out a Series request to.",
+		Buckets: prometheus.LinearBuckets(0, 0.1, 11),
+	})
+	m.seriesQueueDepth = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_proxy_store_series_queue_depth",
+		Help: "Number of Series requests currently selecting the stores to fan out to.",
+	})
+	m.seriesFanoutDepth = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_proxy_store_series_fanout_depth",
+		Help: "Number of Series requests currently fanned out to stores.",
+	})
+	m.tenantStoreQueries = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_tenant_store_queries_total",
+		Help: "Total number of Series requests sent to each store per tenant.",
+	}, []string{"tenant", "store_addr"})
+	m.invalidGroupReplicaKey = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_invalid_group_replica_key_total",
+		Help: "Total number of times a store was skipped because of an empty group or replica key.",
+	})
+	m.incomingMetadataBytes = promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+		Name:    "thanos_proxy_store_incoming_metadata_bytes",
+		Help:    "Size in bytes of the gRPC metadata of incoming Series requests.",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+	})
+	m.outgoingMetadataBytes = promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+		Name:    "thanos_proxy_store_outgoing_metadata_bytes",
+		Help:    "Size in bytes of the gRPC metadata added by the proxy to Series requests sent to stores.",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+	})
+	m.tenantSeriesReturned = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_tenant_series_returned_total",
+		Help: "Total number of series returned by Series requests per tenant.",
+	}, []string{"tenant"})
+	m.tenantWarnings = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_tenant_warnings_total",
+		Help: "Total number of warnings returned by Series requests per tenant.",
+	}, []string{"tenant"})
+	m.labelSetCoverage = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_label_set_coverage_total",
+		Help: "Total number of Series requests by whether the selected stores share label sets (replicated) or not (disjoint).",
+	}, []string{"coverage"})
+	m.nonShardingSkipped = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_non_sharding_stores_skipped_total",
+		Help: "Total number of times a store not supporting sharding was skipped for a sharded Series request.",
+	})
+	m.seriesMatchingDuration = promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+		Name:    "thanos_proxy_store_series_matching_duration_seconds",
+		Help:    "Time spent in Series requests from their start until the stores to query are selected.",
+		Buckets: []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1},
+	})
+	m.seriesSetupDuration = promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+		Name:    "thanos_proxy_store_series_setup_duration_seconds",
+		Help:    "Time spent in Series requests starting the streams of the selected stores.",
+		Buckets: []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+	})
+	m.seriesMergeDuration = promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+		Name:    "thanos_proxy_store_series_merge_duration_seconds",
+		Help:    "Time spent in Series requests merging and sending the responses of the selected stores.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+	})
+	m.circuitBreakerState = promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thanos_proxy_store_circuit_breaker_state",
+		Help: "State of the circuit breaker of each store: 0 closed, 1 open, 2 half-open.",
+	}, []string{"store_addr"})
+	m.fanoutBlocked = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_fanout_blocked_total",
+		Help: "Total number of store calls not sent because the concurrency limit of the store or of the fanout was reached for longer than the response timeout.",
+	})
+	m.storeSeriesDuration = promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "thanos_proxy_store_series_request_duration_seconds",
+		Help:    "Duration of Series calls to each store until their responses are consumed, by status: success, error or timeout.",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"store_addr", "status"})
+	m.fanoutRetries = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_fanout_retries_total",
+		Help: "Total number of retried Series calls to stores, by the gRPC code of the failed call.",
+	}, []string{"store_addr", "grpc_code"})
+	m.queryHintFiltered = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_query_hint_filtered_total",
+		Help: "Total number of stores skipped in Series requests because their label sets do not match the matchers of the query hints.",
+	})
+	m.maxSeriesExceeded = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_max_series_exceeded_total",
+		Help: "Total number of Series requests aborted because the stores returned more series than the maximum per query.",
+	})
+	m.blacklisted = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_blacklisted_total",
+		Help: "Total number of times a store was blacklisted because of repeated errors.",
+	})
+	m.blacklistActive = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_proxy_store_blacklist_active",
+		Help: "Number of stores currently blacklisted.",
+	})
+	m.fanoutActive = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_proxy_store_fanout_active",
+		Help: "Number of Series streams from stores currently holding a fanout slot.",
+	})
+	m.infoCacheHits = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_info_cache_hits_total",
+		Help: "Total number of Info calls served from the cached response.",
+	})
+	m.storeFilteredTotal = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_filtered_total",
+		Help: "Total number of stores not queried because they cannot have data matching the request, by reason.",
+	}, []string{"reason"})
+	for _, cause := range []string{filterCauseTimeRange, filterCauseLabelSet, filterCauseTSDBSelector, filterCauseDebugMetadata, filterCauseResolution} {
+		m.storeFilteredTotal.WithLabelValues(cause)
+	}
+	m.storeGroupFanoutTotal = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_group_fanout_total",
+		Help: "Total number of Series requests sent to stores, by group and replica key.",
+	}, []string{"group_key", "replica_key"})
+	m.storeGroupErrorTotal = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_group_errors_total",
+		Help: "Total number of failed Series requests to stores, by group and replica key.",
+	}, []string{"group_key", "replica_key"})
+	m.chunkOverlapMs = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_chunk_overlap_milliseconds_total",
+		Help: "Total time range in milliseconds covered by chunks of more than one copy of a series when merging them.",
+	})
+	m.chunksDeduplicated = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_chunks_deduplicated_total",
+		Help: "Total number of chunks discarded when merging copies of a series.",
+	})
 
 	return &m
 }
@@ -130,7 +414,384 @@ func WithProxyStoreDebugLogging(enable bool) ProxyStoreOption {
 // WithTSDBSelector sets the TSDB selector for the proxy.
 func WithTSDBSelector(selector *TSDBSelector) ProxyStoreOption {
 	return func(s *ProxyStore) {
-		s.tsdbSelector = selector
+		s.tsdbSelector.Store(selectorOrDefault(selector))
+	}
+}
+
+// SetTSDBSelector replaces the TSDB selector of the proxy at runtime, e.g. on configuration reload. A nil
+// selector selects all TSDBs, like DefaultSelector. Requests already in flight keep using the selector they
+// started with. The cached Info response, if any, is dropped.
+func (s *ProxyStore) SetTSDBSelector(selector *TSDBSelector) {
+	s.tsdbSelector.Store(selectorOrDefault(selector))
+	s.infoCache.Store(nil)
+	s.labelSetCache.Store(nil)
+}
+
+func selectorOrDefault(selector *TSDBSelector) *TSDBSelector {
+	if selector == nil {
+		return DefaultSelector
+	}
+	return selector
+}
+
+// WithDeduplicationBypass disables deduplication of series with the given metric names.
+// All copies of such series are forwarded from all stores, e.g. for summaries' _sum and _count
+// series that have to be summed rather than deduplicated.
+func WithDeduplicationBypass(metricNames ...string) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.dedupBypassMetricNames = make(map[string]struct{}, len(metricNames))
+		for _, name := range metricNames {
+			s.dedupBypassMetricNames[name] = struct{}{}
+		}
+	}
+}
+
+// WithStoreGRPCCompression compresses outgoing calls to the stores which support the given algorithm,
+// e.g. "gzip", "snappy" or "zstd". The compressor has to be registered in gRPC.
+func WithStoreGRPCCompression(algo string) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.grpcCompression = algo
+	}
+}
+
+// WithMaxGRPCMessageSize sets the maximum size in bytes of messages received from the stores.
+func WithMaxGRPCMessageSize(maxBytes int) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.maxRecvMsgSize = maxBytes
+	}
+}
+
+// WithMaxGRPCSendMsgSize sets the maximum size in bytes of messages sent to the stores.
+func WithMaxGRPCSendMsgSize(maxBytes int) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.maxSendMsgSize = maxBytes
+	}
+}
+
+// WithPeriodicHealthReport logs a health report of all stores every interval.
+func WithPeriodicHealthReport(interval time.Duration, logger log.Logger) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.healthReportInterval = interval
+		s.healthReportLogger = logger
+	}
+}
+
+// WithConnectionWarmup calls Info on every store each interval, so that connections to the stores
+// are kept warm before queries arrive.
+func WithConnectionWarmup(interval time.Duration) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.connectionWarmupInterval = interval
+	}
+}
+
+// WithHealthProbe calls Info on every store each interval, to track whether the proxy can reach any store
+// for IsReady.
+func WithHealthProbe(interval time.Duration) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.healthProbeInterval = interval
+	}
+}
+
+// WithTimeRangeAlignment aligns the time range of Series requests to the given step before fanning out,
+// so that all stores return chunks from the same time window.
+func WithTimeRangeAlignment(stepMs int64) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.timeRangeAlignmentMs = stepMs
+	}
+}
+
+// WithSelectionLatencyTracking toggles tracking of the time spent selecting stores in Series.
+func WithSelectionLatencyTracking(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.selectionLatencyTracking = enabled
+	}
+}
+
+// pushDownAggregations are the aggregations for which chunks are not requested from stores
+// when query hints push-down is enabled.
+var pushDownAggregations = map[string]struct{}{
+	"count": {},
+	"sum":   {},
+}
+
+// WithQueryHintsPushDown toggles skipping chunks in requests to stores when the query hints
+// indicate that the selected series are only used by an aggregation.
+func WithQueryHintsPushDown(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.queryHintsPushDown = enabled
+	}
+}
+
+// WithTenantPropagation toggles forwarding of the request tenant to the stores in Series, LabelNames
+// and LabelValues calls. It is enabled by default.
+func WithTenantPropagation(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.tenantPropagation = enabled
+	}
+}
+
+// WithQueryMetadataUserAgent toggles sending a user agent identifying the query, tenant and partial
+// response strategy with each store call, so that stores can attribute the calls to queries.
+// The user agent is sent as metadata by the extgrpc query user agent interceptors.
+func WithQueryMetadataUserAgent(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.queryMetadataUserAgent = enabled
+	}
+}
+
+// WithFanoutAnalysis toggles logging of the share of the selected stores that support sharding for
+// Series calls that are not sharded. It is a diagnostic to find queries which could benefit from sharding.
+func WithFanoutAnalysis(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.fanoutAnalysis = enabled
+	}
+}
+
+// WithOverlapAnalysis toggles logging and recording of how much the series returned by the stores of
+// each group overlap in Series calls. It is a diagnostic to spot unexpected replication or sharding.
+func WithOverlapAnalysis(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.overlapAnalysis = enabled
+	}
+}
+
+// WithSpeculativeExecution queries a single replica of each store group in Series calls and starts a
+// request to the next replica of the group whenever threshold * response timeout passes without a response.
+// The first replica to respond is used and the other requests are canceled. It requires a response timeout.
+func WithSpeculativeExecution(threshold float64) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.speculativeThreshold = threshold
+	}
+}
+
+// WithHedgedReplication queries a single replica of each store group in Series calls using the GROUP_REPLICA
+// partial response strategy, and sends a second request to another replica of the group if the first one
+// has not responded after the given duration. The first replica to respond is used and the other request
+// is canceled. Unlike WithSpeculativeExecution, at most one hedged request is sent per group.
+func WithHedgedReplication(after time.Duration) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.hedgeAfter = after
+	}
+}
+
+// WithMaxSeriesPerQuery aborts Series requests once the stores returned more than n series in total, counting
+// the series of all stores before deduplication. The limit is a safety guard against running out of memory,
+// so it applies regardless of the partial response strategy. 0 disables the limit.
+func WithMaxSeriesPerQuery(n uint64) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.maxSeriesPerQuery = n
+	}
+}
+
+// WithRespectStoreSeriesLimit caps the total limit of Series requests to the maximum number of series
+// per query advertised by each store in its Info response. Info responses are cached per store.
+func WithRespectStoreSeriesLimit(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		if !enabled {
+			s.storeCapabilities = nil
+			return
+		}
+		s.storeCapabilities = NewStoreCapabilityCache(defaultStoreCapabilityTTL, s.logger)
+	}
+}
+
+// WithStoreMatchingCache caches, for up to ttl, which stores match the external labels of a set of request
+// matchers, so that repeated queries do not check every store again. At most maxEntries matcher sets are kept.
+// Store time ranges are still checked for every request.
+func WithStoreMatchingCache(ttl time.Duration, maxEntries int) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		if ttl <= 0 || maxEntries <= 0 {
+			s.storeMatchingCache = nil
+			return
+		}
+		s.storeMatchingCache = newStoreMatchingCache(ttl, maxEntries)
+	}
+}
+
+// WithQueueDepthMonitoring toggles tracking of how many Series requests are selecting stores and how many
+// are fanned out to stores at any time.
+func WithQueueDepthMonitoring(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.queueDepthMonitoring = enabled
+	}
+}
+
+// WithTenantUsageAccounting toggles counting the Series requests sent to each store per tenant.
+// The counter has one series per tenant and store, so enabling it multiplies the number of tenants by the
+// number of stores in the metric cardinality.
+func WithTenantUsageAccounting(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.tenantUsageAccounting = enabled
+	}
+}
+
+// WithRequireGroupReplicaKeys skips stores with an empty group or replica key when selecting the stores
+// for a Series request. Such stores would otherwise be tracked as one group, masking their failures.
+func WithRequireGroupReplicaKeys(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.requireGroupReplicaKeys = enabled
+	}
+}
+
+// WithChunkOverlapMetrics toggles recording of how much the chunks of series returned by more than one
+// store overlap, and how many chunks are discarded when merging them.
+func WithChunkOverlapMetrics(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.chunkOverlapMetrics = enabled
+	}
+}
+
+// WithQuerySummaryLog toggles logging of a single line summarizing each Series call once it completes.
+func WithQuerySummaryLog(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.querySummaryLog = enabled
+	}
+}
+
+// WithDeadlineHeader toggles sending the deadline of the request to the stores as a unix timestamp in
+// milliseconds in the DeadlineHeader metadata, so that stores can compute the remaining time without
+// relying on the deadline propagated by gRPC.
+func WithDeadlineHeader(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.deadlineHeader = enabled
+	}
+}
+
+// WithMetadataQueryDiagnostics toggles adding a warning with the number of queried and filtered stores
+// to LabelNames and LabelValues responses. Note that clients may treat this warning as an error.
+func WithMetadataQueryDiagnostics(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.metadataQueryDiagnostics = enabled
+	}
+}
+
+// WithMatcherPruning limits the number of matchers generated from store label sets to maxMatchers.
+// Generated matchers implied by an equality matcher of the request are removed first. If there are
+// still more than maxMatchers, no label set matchers are sent and stores are selected by time range only.
+func WithMatcherPruning(maxMatchers int) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.maxLabelSetMatchers = maxMatchers
+	}
+}
+
+// WithMaxMatchersPerStore limits the number of label sets a single store can contribute to the matchers
+// generated from store label sets. If a store exceeds the limit, no label set matchers are sent for the
+// request, since leaving out some of its label sets would filter out the series of those label sets.
+func WithMaxMatchersPerStore(limit int) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.maxMatchersPerStore = limit
+	}
+}
+
+// WithMetadataSizeTracking toggles recording of the size of the gRPC metadata of incoming Series requests
+// and of the metadata the proxy sends to stores. Metadata added by client interceptors, like trace
+// context, is not included in the outgoing size.
+func WithMetadataSizeTracking(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.metadataSizeTracking = enabled
+	}
+}
+
+// WithDeduplicatedDebugMessages collapses the debug messages of stores filtered out for the same reason, or
+// queried, into a single message with the number of stores, to keep debug logs readable with many stores.
+func WithDeduplicatedDebugMessages(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.dedupDebugMessages = enabled
+	}
+}
+
+// WithTenantSeriesAccounting toggles counting the series and warnings returned by Series requests per tenant.
+func WithTenantSeriesAccounting(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.tenantSeriesAccounting = enabled
+	}
+}
+
+// WithTenantStoreRouter restricts the stores queried by Series, LabelNames and LabelValues requests to the
+// ones returned by fn for the tenant of the request. All stores are queried if fn returns nil.
+func WithTenantStoreRouter(fn func(tenant string) []Client) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.tenantStoreRouter = fn
+	}
+}
+
+// WithLabelSetCoverageAnalysis toggles checking whether the stores selected for a Series request share any
+// label set, which indicates replicated data, or cover disjoint label sets, which indicates perfect sharding.
+func WithLabelSetCoverageAnalysis(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.labelSetCoverageAnalysis = enabled
+	}
+}
+
+// WithRequireShardingAboveStoreCount rejects Series requests without shard info which select more than n stores,
+// if more than the fraction of those stores set with WithShardingRequiredFraction support sharding.
+func WithRequireShardingAboveStoreCount(n int) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.shardingRequiredStores = n
+	}
+}
+
+// WithShardingRequiredFraction sets the fraction of selected stores which have to support sharding for
+// WithRequireShardingAboveStoreCount to reject a request. Defaults to 0.5.
+func WithShardingRequiredFraction(fraction float64) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.shardingRequiredFraction = fraction
+	}
+}
+
+// WithSkipNonShardingStores skips stores which do not support sharding for Series requests with shard info.
+func WithSkipNonShardingStores(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.skipNonShardingStores = enabled
+	}
+}
+
+// WithLatencyAttribution toggles logging and recording how long successful Series requests spend selecting
+// stores, starting the store streams and merging their responses.
+func WithLatencyAttribution(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.latencyAttribution = enabled
+	}
+}
+
+// WithMinTimeRangeOverlap filters out stores whose time range overlaps the time range of the request by
+// less than minOverlapMs milliseconds.
+func WithMinTimeRangeOverlap(minOverlapMs int64) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.minTimeRangeOverlapMs = minOverlapMs
+	}
+}
+
+// WithSelectivityLogging toggles logging and recording of the fraction of all stores selected for each
+// Series call. Queries selecting every store are logged as warnings when there are more stores than
+// the threshold set with WithSelectivityWarnThreshold.
+func WithSelectivityLogging(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.selectivityLogging = enabled
+	}
+}
+
+// WithSelectivityWarnThreshold sets the number of stores above which Series calls selecting every store
+// are logged as warnings by the selectivity logging. Defaults to 10.
+func WithSelectivityWarnThreshold(stores int) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.selectivityWarnThreshold = stores
+	}
+}
+
+// WithBufferInitialSize sets the initial size, in bytes, of the pooled buffers used to match series against
+// the shard of sharded requests. Defaults to 32KiB.
+func WithBufferInitialSize(n int) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.bufferInitialSize = n
+	}
+}
+
+// WithBufferMaxSize drops pooled buffers which grew beyond n bytes instead of returning them to the pool,
+// so that a few series with large labels do not bloat the pool. 0 means no limit.
+func WithBufferMaxSize(n int) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.bufferMaxSize = n
 	}
 }
 
@@ -152,29 +813,123 @@ func NewProxyStore(
 
 	metrics := newProxyStoreMetrics(reg)
 	s := &ProxyStore{
-		logger:         logger,
-		stores:         stores,
-		component:      component,
-		selectorLabels: selectorLabels,
-		buffers: sync.Pool{New: func() interface{} {
-			b := make([]byte, 0, initialBufSize)
-			return &b
-		}},
-		responseTimeout:   responseTimeout,
-		metrics:           metrics,
-		retrievalStrategy: retrievalStrategy,
-		tsdbSelector:      DefaultSelector,
+		logger:                   logger,
+		stores:                   stores,
+		component:                component,
+		selectorLabels:           selectorLabels,
+		bufferInitialSize:        initialBufSize,
+		responseTimeout:          responseTimeout,
+		metrics:                  metrics,
+		retrievalStrategy:        retrievalStrategy,
+		tenantPropagation:        true,
+		selectivityWarnThreshold: defaultSelectivityWarnThreshold,
+		shardingRequiredFraction: defaultShardingRequiredFraction,
+		timeRangeCacheTTL:        defaultTimeRangeCacheTTL,
+		storeErrors:              make(map[string]int),
+		done:                     make(chan struct{}),
 	}
+	s.tsdbSelector.Store(DefaultSelector)
 
 	for _, option := range options {
 		option(s)
 	}
+	if s.fanoutConcurrency > 0 && s.retrievalStrategy == LazyRetrieval {
+		level.Warn(logger).Log("msg", "fanout concurrency is not supported with lazy retrieval, ignoring it", "fanout_concurrency", s.fanoutConcurrency)
+		s.fanoutConcurrency = 0
+	}
+	s.buffers.New = func() interface{} {
+		b := make([]byte, 0, s.bufferInitialSize)
+		return &b
+	}
+
+	if s.healthReportInterval > 0 {
+		if s.healthReportLogger == nil {
+			s.healthReportLogger = logger
+		}
+		s.runPeriodically(s.healthReportInterval, s.reportHealth)
+	}
+	if s.connectionWarmupInterval > 0 {
+		s.runPeriodically(s.connectionWarmupInterval, s.warmupConnections)
+	}
+	if s.healthProbeInterval > 0 {
+		s.runPeriodically(s.healthProbeInterval, s.probeHealth)
+	}
+
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "thanos_proxy_store_active_streams",
+		Help: "Number of currently open Series streams to underlying stores.",
+	}, func() float64 {
+		return float64(s.ActiveStreamCount())
+	})
 
 	return s
 }
 
+// Close stops all background goroutines of the proxy.
+func (s *ProxyStore) Close() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+// runPeriodically calls f every interval until the proxy is closed.
+func (s *ProxyStore) runPeriodically(interval time.Duration, f func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				f()
+			}
+		}
+	}()
+}
+
+// ActiveStreamCount returns the number of currently open Series streams to underlying stores.
+func (s *ProxyStore) ActiveStreamCount() int {
+	return int(s.activeStreams.Load())
+}
+
+// storeCallContext returns the context of a label call to a store, bounded by the response timeout if set, so
+// that a slow store is turned into a warning with partial response instead of delaying the whole call.
+func (s *ProxyStore) storeCallContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.responseTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, s.responseTimeout)
+}
+
+// storeCallOptions returns the gRPC call options to use for calls to the given store.
+func (s *ProxyStore) storeCallOptions(st Client) []grpc.CallOption {
+	var opts []grpc.CallOption
+	if s.grpcCompression != "" && encoding.GetCompressor(s.grpcCompression) != nil && st.SupportsCompression(s.grpcCompression) {
+		opts = append(opts, grpc.UseCompressor(s.grpcCompression))
+	}
+	if s.maxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxCallRecvMsgSize(s.maxRecvMsgSize))
+	}
+	if s.maxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxCallSendMsgSize(s.maxSendMsgSize))
+	}
+	return opts
+}
+
 // Info returns store information about the external labels this store have.
 func (s *ProxyStore) Info(_ context.Context, _ *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	if res, ok := s.cachedInfo(); ok {
+		return res, nil
+	}
+	res := s.info()
+	s.cacheInfo(res)
+	return res, nil
+}
+
+func (s *ProxyStore) info() *storepb.InfoResponse {
 	res := &storepb.InfoResponse{
 		StoreType: s.component.ToProto(),
 		Labels:    labelpb.ZLabelsFromPromLabels(s.selectorLabels),
@@ -189,7 +944,7 @@ func (s *ProxyStore) Info(_ context.Context, _ *storepb.InfoRequest) (*storepb.I
 		res.MaxTime = 0
 		res.MinTime = 0
 
-		return res, nil
+		return res
 	}
 
 	for _, s := range stores {
@@ -226,10 +981,19 @@ func (s *ProxyStore) Info(_ context.Context, _ *storepb.InfoRequest) (*storepb.I
 		res.LabelSets = append(res.LabelSets, labelpb.ZLabelSet{Labels: res.Labels})
 	}
 
-	return res, nil
+	return res
 }
 
 func (s *ProxyStore) LabelSet() []labelpb.ZLabelSet {
+	if labelSets, ok := s.cachedLabelSets(); ok {
+		return labelSets
+	}
+	labelSets := s.labelSet()
+	s.cacheLabelSets(labelSets)
+	return labelSets
+}
+
+func (s *ProxyStore) labelSet() []labelpb.ZLabelSet {
 	stores := s.stores()
 	if len(stores) == 0 {
 		return []labelpb.ZLabelSet{}
@@ -261,7 +1025,19 @@ func (s *ProxyStore) LabelSet() []labelpb.ZLabelSet {
 }
 
 func (s *ProxyStore) TimeRange() (int64, int64) {
+	// The generation is loaded before the stores, so that a time range computed from stores which were replaced
+	// meanwhile is never used after InvalidateCaches.
+	generation := s.cacheGeneration.Load()
 	stores := s.stores()
+	if mint, maxt, ok := s.cachedTimeRange(stores, generation); ok {
+		return mint, maxt
+	}
+	mint, maxt := timeRange(stores)
+	s.cacheTimeRange(stores, generation, mint, maxt)
+	return mint, maxt
+}
+
+func timeRange(stores []Client) (int64, int64) {
 	if len(stores) == 0 {
 		return math.MinInt64, math.MaxInt64
 	}
@@ -281,9 +1057,10 @@ func (s *ProxyStore) TimeRange() (int64, int64) {
 }
 
 func (s *ProxyStore) TSDBInfos() []infopb.TSDBInfo {
+	tsdbSelector := s.tsdbSelector.Load()
 	infos := make([]infopb.TSDBInfo, 0)
 	for _, st := range s.stores() {
-		matches, _ := s.tsdbSelector.MatchLabelSets(st.LabelSets()...)
+		matches, _ := tsdbSelector.MatchLabelSets(st.LabelSets()...)
 		if !matches {
 			continue
 		}
@@ -299,6 +1076,27 @@ func (s *ProxyStore) Series(originalRequest *storepb.SeriesRequest, srv storepb.
 	if s.debugLogging {
 		reqLogger = log.With(reqLogger, "request", originalRequest.String())
 	}
+	// Load the selector once, so that the whole request uses the same one even if it is reloaded meanwhile.
+	tsdbSelector := s.tsdbSelector.Load()
+
+	if s.requestReplay != nil && srv.Context().Value(replayKey) == nil {
+		if err := s.requestReplay.add(originalRequest); err != nil {
+			level.Warn(reqLogger).Log("msg", "failed to capture request for replay", "err", err)
+		}
+	}
+
+	summary := &seriesSummary{start: time.Now()}
+	if s.querySummaryLog {
+		summary.tenant = getTenant(srv.Context(), reqLogger)
+		defer logSeriesSummary(reqLogger, originalRequest, summary)
+	}
+	if s.tenantSeriesAccounting {
+		tenant := getTenant(srv.Context(), reqLogger)
+		defer func() {
+			s.metrics.tenantSeriesReturned.WithLabelValues(tenant).Add(float64(summary.seriesReturned))
+			s.metrics.tenantWarnings.WithLabelValues(tenant).Add(float64(summary.warnings))
+		}()
+	}
 
 	match, matchers, err := matchesExternalLabels(originalRequest.Matchers, s.selectorLabels)
 	if err != nil {
@@ -326,23 +1124,43 @@ func (s *ProxyStore) Series(originalRequest *storepb.SeriesRequest, srv storepb.
 		ShardInfo:               originalRequest.ShardInfo,
 		WithoutReplicaLabels:    originalRequest.WithoutReplicaLabels,
 	}
-
-	// We may arrive here either via the promql engine
-	// or as a result of a grpc call in layered queries
-	ctx := srv.Context()
-	tenant, foundTenant := tenancy.GetTenantFromGRPCMetadata(ctx)
-	if !foundTenant {
-		if ctx.Value(tenancy.TenantKey) != nil {
-			tenant = ctx.Value(tenancy.TenantKey).(string)
+	if s.queryHintsPushDown && !r.SkipChunks && r.QueryHints != nil && r.QueryHints.Func != nil {
+		if _, ok := pushDownAggregations[r.QueryHints.Func.Name]; ok {
+			r.SkipChunks = true
 		}
 	}
+	if s.timeRangeAlignmentMs > 0 {
+		r.MinTime, r.MaxTime = alignTimeRange(r.MinTime, r.MaxTime, s.timeRangeAlignmentMs)
+		level.Debug(reqLogger).Log("msg", "aligned time range",
+			"original_min_time", originalRequest.MinTime, "original_max_time", originalRequest.MaxTime,
+			"min_time", r.MinTime, "max_time", r.MaxTime)
+	}
 
-	ctx = metadata.AppendToOutgoingContext(ctx, tenancy.DefaultTenantHeader, tenant)
-	level.Debug(s.logger).Log("msg", "Tenant info in Series()", "tenant", tenant)
+	ctx := srv.Context()
+	if s.metadataSizeTracking {
+		md, _ := metadata.FromIncomingContext(ctx)
+		s.metrics.incomingMetadataBytes.Observe(float64(metadataSize(md)))
+	}
+	if s.tenantPropagation {
+		ctx = propagateTenant(ctx, log.With(s.logger, "method", "Series"))
+	}
+	if s.queryMetadataUserAgent {
+		ctx = extgrpc.ContextWithQueryUserAgent(ctx, queryUserAgent(ctx, getTenant(ctx, s.logger), r.PartialResponseStrategy))
+	}
+	if s.deadlineHeader {
+		ctx = propagateDeadline(ctx)
+	}
+	ctx = s.propagateBaggage(ctx, reqLogger)
+	if s.metadataSizeTracking {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		s.metrics.outgoingMetadataBytes.Observe(float64(metadataSize(md)))
+	}
 
 	var (
 		stores         []Client
 		storeLabelSets []labels.Labels
+		// skipLabelSetMatchers is set when a store has too many label sets to generate matchers from.
+		skipLabelSetMatchers bool
 	)
 	// groupReplicaStores[groupKey][replicaKey] = number of stores with the groupKey and replicaKey
 	groupReplicaStores := make(map[string]map[string]int)
@@ -353,38 +1171,176 @@ func (s *ProxyStore) Series(originalRequest *storepb.SeriesRequest, srv storepb.
 		if _, ok := mp[key1]; !ok {
 			mp[key1] = make(map[string]int)
 		}
-		mp[key1][key2]++
-	}
-
-	for _, st := range s.stores() {
-		// We might be able to skip the store if its meta information indicates it cannot have series matching our query.
-		if ok, reason := storeMatches(ctx, st, s.debugLogging, originalRequest.MinTime, originalRequest.MaxTime, matchers...); !ok {
+		mp[key1][key2]++
+	}
+
+	if s.queueDepthMonitoring {
+		s.metrics.seriesQueueDepth.Inc()
+	}
+	selectionStart := time.Now()
+	allStores := s.tenantStores(ctx)
+	candidates, cacheFiltered, remainingMatchers := s.candidateStores(ctx, allStores, matchers)
+	// The topology is of all stores, so it is only updated when the request is not restricted to some of them.
+	if s.groupTopology != nil && s.tenantStoreRouter == nil && s.storeGraph == nil {
+		s.groupTopology.update(allStores)
+	}
+	summary.storesFiltered += cacheFiltered
+	if s.debugLogging && cacheFiltered > 0 {
+		storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("%d stores filtered out due to: cached store matching", cacheFiltered))
+	}
+	for _, st := range candidates {
+		if s.requireGroupReplicaKeys && (st.GroupKey() == "" || st.ReplicaKey() == "") {
+			addr, _ := st.Addr()
+			level.Error(reqLogger).Log("msg", "skipping store with empty group or replica key", "store_addr", addr, "group", st.GroupKey(), "replica", st.ReplicaKey())
+			s.metrics.invalidGroupReplicaKey.Inc()
+			summary.storesFiltered++
+			continue
+		}
+		if s.labelSetFilters != nil && s.labelSetFilters.rejects(st, remainingMatchers) {
+			if s.debugLogging {
+				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, "label sets bloom filter"))
+			}
+			summary.storesFiltered++
+			continue
+		}
+		// We might be able to skip the store if its meta information indicates it cannot have series matching our query.
+		if cause, reason := storeMismatch(ctx, st, s.debugLogging, originalRequest.MinTime, originalRequest.MaxTime, s.minTimeRangeOverlapMs, remainingMatchers...); cause != "" {
+			s.metrics.storeFilteredTotal.WithLabelValues(cause).Inc()
+			if s.debugLogging {
+				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, reason))
+			}
+			summary.storesFiltered++
+			continue
+		}
+		if !storeMatchesQueryHints(st, originalRequest.QueryHints) {
+			if s.debugLogging {
+				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, "query hints"))
+			}
+			s.metrics.queryHintFiltered.Inc()
+			summary.storesFiltered++
+			continue
+		}
+		if !storeSupportsResolution(st, r.MaxResolutionWindow) {
+			s.metrics.storeFilteredTotal.WithLabelValues(filterCauseResolution).Inc()
+			if s.debugLogging {
+				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, "resolution"))
+			}
+			summary.storesFiltered++
+			continue
+		}
+		matches, extraMatchers := tsdbSelector.MatchLabelSets(st.LabelSets()...)
+		if !matches {
+			s.metrics.storeFilteredTotal.WithLabelValues(filterCauseTSDBSelector).Inc()
+			if s.debugLogging {
+				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, "tsdb selector"))
+			}
+			summary.storesFiltered++
+			continue
+		}
+		if s.skipNonShardingStores && r.ShardInfo != nil && !st.SupportsSharding() {
+			if s.debugLogging {
+				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, "store does not support sharding"))
+			}
+			s.metrics.nonShardingSkipped.Inc()
+			summary.storesFiltered++
+			continue
+		}
+		if !s.circuitAllows(st) {
 			if s.debugLogging {
-				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, reason))
+				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, "circuit breaker open"))
 			}
+			summary.storesFiltered++
 			continue
 		}
-		matches, extraMatchers := s.tsdbSelector.MatchLabelSets(st.LabelSets()...)
-		if !matches {
+		if !s.blacklistAllows(st) {
 			if s.debugLogging {
-				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, "tsdb selector"))
+				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, "blacklisted"))
 			}
+			summary.storesFiltered++
 			continue
 		}
+		if s.maxMatchersPerStore > 0 && len(extraMatchers) > s.maxMatchersPerStore {
+			addr, _ := st.Addr()
+			level.Warn(reqLogger).Log("msg", "store has too many label sets, querying stores without label set matchers",
+				"store_addr", addr, "label_sets", len(extraMatchers), "max_matchers_per_store", s.maxMatchersPerStore)
+			skipLabelSetMatchers = true
+		}
 		storeLabelSets = append(storeLabelSets, extraMatchers...)
 
 		stores = append(stores, st)
 		bumpCounter(st.GroupKey(), st.ReplicaKey(), groupReplicaStores)
+		s.metrics.storeGroupFanoutTotal.WithLabelValues(st.GroupKey(), st.ReplicaKey()).Inc()
+	}
+	if s.queueDepthMonitoring {
+		s.metrics.seriesQueueDepth.Dec()
+	}
+	summary.matchingEnd = time.Now()
+	if s.selectionLatencyTracking {
+		s.metrics.storeSelectionDuration.Observe(time.Since(selectionStart).Seconds())
+	}
+	if s.selectivityLogging {
+		s.logSelectivity(reqLogger, len(stores), len(stores)+summary.storesFiltered)
 	}
 	if len(stores) == 0 {
-		level.Debug(reqLogger).Log("err", ErrorNoStoresMatched, "stores", strings.Join(storeDebugMsgs, ";"))
+		level.Debug(reqLogger).Log("err", ErrorNoStoresMatched, "stores", s.joinStoreDebugMsgs(storeDebugMsgs))
 		return nil
 	}
-	r.Matchers = append(r.Matchers, MatchersForLabelSets(storeLabelSets)...)
+	if !skipLabelSetMatchers {
+		r.Matchers = append(r.Matchers, s.labelSetMatchers(reqLogger, r.Matchers, storeLabelSets)...)
+	}
+	if s.fanoutAnalysis && r.ShardInfo == nil {
+		logFanoutAnalysis(reqLogger, stores)
+	}
+	if s.labelSetCoverageAnalysis {
+		s.analyzeLabelSetCoverage(reqLogger, stores)
+	}
+	if s.shardingRequiredStores > 0 && len(stores) > s.shardingRequiredStores && r.ShardInfo == nil &&
+		potentialShardFactor(stores) > s.shardingRequiredFraction {
+		return status.Error(codes.FailedPrecondition, "sharding required for this query")
+	}
+	if s.hedgeAfter > 0 && r.PartialResponseStrategy == storepb.PartialResponseStrategy_GROUP_REPLICA {
+		stores = speculativeClients(stores, s.hedgeAfter, 1)
+	} else if s.speculativeThreshold > 0 && s.responseTimeout > 0 {
+		stores = speculativeClients(stores, time.Duration(s.speculativeThreshold*float64(s.responseTimeout)), 0)
+	}
 
+	if s.queueDepthMonitoring {
+		s.metrics.seriesFanoutDepth.Inc()
+		defer s.metrics.seriesFanoutDepth.Dec()
+	}
 	storeResponses := make([]respSet, 0, len(stores))
+	var seriesLimit *seriesLimiter
+	if s.maxSeriesPerQuery > 0 {
+		var cancelStores context.CancelFunc
+		ctx, cancelStores = context.WithCancel(ctx)
+		defer cancelStores()
+		seriesLimit = &seriesLimiter{limit: s.maxSeriesPerQuery, cancel: cancelStores}
+	}
+
+	// quorumLost returns whether less than a majority of the stores of the group can still respond successfully.
+	quorumLost := func(group string) bool {
+		var total, failed int
+		for _, n := range groupReplicaStores[group] {
+			total += n
+		}
+		for _, n := range failedStores[group] {
+			failed += n
+		}
+		return total-failed < total/2+1
+	}
 
 	checkGroupReplicaErrors := func(st Client, err error) error {
+		if r.PartialResponseStrategy == storepb.PartialResponseStrategy_QUORUM {
+			if quorumLost(st.GroupKey()) {
+				level.Error(reqLogger).Log(
+					"msg", "Quorum lost for group",
+					"group", st.GroupKey(),
+					"replicas", failedStores[st.GroupKey()],
+				)
+				return status.Error(codes.Aborted, err.Error())
+			}
+			return nil
+		}
 		if len(failedStores[st.GroupKey()]) > 1 {
 			level.Error(reqLogger).Log(
 				"msg", "Multipel replicas have failures for the same group",
@@ -414,45 +1370,166 @@ func (s *ProxyStore) Series(originalRequest *storepb.SeriesRequest, srv storepb.
 	}
 	defer logGroupReplicaErrors()
 
-	for _, st := range stores {
+	var overlap *seriesOverlap
+	if s.overlapAnalysis {
+		overlap = newSeriesOverlap()
+	}
+
+	var tenant string
+	if s.tenantUsageAccounting {
+		tenant = getTenant(ctx, reqLogger)
+	}
+	fanoutSlots := s.newFanoutSlots()
+	var storeSeriesLimits []int64
+	if s.storeCapabilities != nil {
+		storeSeriesLimits = s.storeCapabilities.seriesLimits(ctx, stores)
+	}
+
+	// Calls are started concurrently, so that waiting for slots or retrying a store does not delay the calls
+	// to the other stores. Their results are then handled in order.
+	calls := make([]seriesCall, len(stores))
+	var callsWg sync.WaitGroup
+	for i, st := range stores {
+		storeReq := r
+		if storeSeriesLimits != nil {
+			storeReq = limitSeriesRequest(r, storeSeriesLimits[i])
+		}
+		callsWg.Add(1)
+		go func(call *seriesCall, st Client, storeReq *storepb.SeriesRequest) {
+			defer callsWg.Done()
+			*call = s.startSeriesCall(ctx, st, storeReq, r.ShardInfo, fanoutSlots, seriesLimit, reqLogger)
+		}(&calls[i], st, storeReq)
+	}
+	callsWg.Wait()
+	defer func() {
+		// Close the calls which were not handled because of an early return.
+		for _, call := range calls {
+			if call.set != nil {
+				call.set.Close()
+				call.release()
+			}
+		}
+	}()
+
+	for i, st := range stores {
 		st := st
 		if s.debugLogging {
 			storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s queried", st))
 		}
+		summary.storesQueried++
+		if s.tenantUsageAccounting {
+			addr, _ := st.Addr()
+			s.metrics.tenantStoreQueries.WithLabelValues(tenant, addr).Inc()
+		}
 
-		respSet, err := newAsyncRespSet(ctx, st, r, s.responseTimeout, s.retrievalStrategy, &s.buffers, r.ShardInfo, reqLogger, s.metrics.emptyStreamResponses)
+		respSet, release, err := calls[i].set, calls[i].release, calls[i].err
+		calls[i] = seriesCall{}
 		if err != nil {
 			level.Error(reqLogger).Log("err", err)
 			level.Warn(s.logger).Log("msg", "Store failure", "group", st.GroupKey(), "replica", st.ReplicaKey())
+			s.recordStoreError(ctx, st, err)
+			s.recordCircuitResult(ctx, st, err)
 			bumpCounter(st.GroupKey(), st.ReplicaKey(), failedStores)
+			s.metrics.storeGroupErrorTotal.WithLabelValues(st.GroupKey(), st.ReplicaKey()).Inc()
 			totalFailedStores++
+			if r.PartialResponseStrategy == storepb.PartialResponseStrategy_QUORUM {
+				if err := checkGroupReplicaErrors(st, err); err != nil {
+					return err
+				}
+				continue
+			}
 			if r.PartialResponseStrategy == storepb.PartialResponseStrategy_GROUP_REPLICA {
 				if checkGroupReplicaErrors(st, err) != nil {
 					return err
 				}
+				continue
 			} else if !r.PartialResponseDisabled || r.PartialResponseStrategy == storepb.PartialResponseStrategy_WARN {
 				if err := srv.Send(storepb.NewWarnSeriesResponse(err)); err != nil {
 					return err
 				}
+				summary.warnings++
 				continue
 			} else {
 				return err
 			}
 		}
 
+		if respSet == nil {
+			// Failed stores are skipped above, but never track a missing stream, which would release its slots twice.
+			continue
+		}
+		s.activeStreams.Inc()
+		respSet = newCloseCallbackRespSet(respSet, func() {
+			s.activeStreams.Dec()
+			release()
+		})
+		if overlap != nil {
+			respSet = overlap.track(st.GroupKey(), respSet)
+		}
+		if s.circuitBreaker != nil {
+			addr, _ := st.Addr()
+			respSet = s.circuitBreaker.track(addr, respSet)
+		}
+		if r.PartialResponseStrategy == storepb.PartialResponseStrategy_QUORUM {
+			respSet = &warningCallbackRespSet{respSet: respSet, onWarning: func() {
+				bumpCounter(st.GroupKey(), st.ReplicaKey(), failedStores)
+				s.metrics.storeGroupErrorTotal.WithLabelValues(st.GroupKey(), st.ReplicaKey()).Inc()
+			}}
+		}
+
 		storeResponses = append(storeResponses, respSet)
 		defer respSet.Close()
 	}
 
-	level.Debug(reqLogger).Log("msg", "Series: started fanout streams", "status", strings.Join(storeDebugMsgs, ";"))
+	level.Debug(reqLogger).Log("msg", "Series: started fanout streams", "status", s.joinStoreDebugMsgs(storeDebugMsgs))
+	summary.setupEnd = time.Now()
 
 	respHeap := NewResponseDeduplicator(NewProxyResponseLoserTree(storeResponses...))
+	respHeap.bypassMetricNames = s.dedupBypassMetricNames
+	if s.chunkOverlapMetrics {
+		respHeap.chunkOverlapMs = s.metrics.chunkOverlapMs
+		respHeap.chunksDeduplicated = s.metrics.chunksDeduplicated
+	}
+	var cardinality *labelCardinality
+	if s.maxLabelCardinality > 0 {
+		cardinality = newLabelCardinality(s.maxLabelCardinality)
+	}
 	for respHeap.Next() {
+		if err := s.checkMaxSeriesPerQuery(reqLogger, seriesLimit); err != nil {
+			return err
+		}
 		resp := respHeap.At()
 
+		if cardinality != nil && resp.GetSeries() != nil {
+			if err := cardinality.observe(resp.GetSeries().Labels); err != nil {
+				level.Warn(reqLogger).Log("msg", "Series: label cardinality limit exceeded", "err", err)
+				// Truncated results are not returned when partial responses are not allowed.
+				if s.strictLabelCardinality && (r.PartialResponseDisabled || r.PartialResponseStrategy == storepb.PartialResponseStrategy_ABORT) {
+					return status.Error(codes.Aborted, err.Error())
+				}
+				if err := srv.Send(storepb.NewWarnSeriesResponse(err)); err != nil {
+					return status.Error(codes.Unknown, errors.Wrap(err, "send series response").Error())
+				}
+				summary.warnings++
+				if s.strictLabelCardinality {
+					break
+				}
+			}
+		}
+
 		if resp.GetWarning() != "" {
 			totalFailedStores++
 			level.Error(s.logger).Log("msg", "Series: warning from store", "warning", resp.GetWarning())
+			if r.PartialResponseStrategy == storepb.PartialResponseStrategy_QUORUM {
+				for group := range failedStores {
+					if quorumLost(group) {
+						level.Error(reqLogger).Log("msg", "Quorum lost for group", "group", group, "replicas", failedStores[group])
+						return status.Error(codes.Aborted, resp.GetWarning())
+					}
+				}
+				// Warnings of stores in the minority of their group are not returned.
+				continue
+			}
 			if r.PartialResponseStrategy == storepb.PartialResponseStrategy_GROUP_REPLICA {
 				// TODO: attribute the warning to the store(group key and replica key) that produced it.
 				// Each client streams a sequence of time series, so it's not trivial to attribute the warning to a specific client.
@@ -469,13 +1546,330 @@ func (s *ProxyStore) Series(originalRequest *storepb.SeriesRequest, srv storepb.
 		if err := srv.Send(resp); err != nil {
 			return status.Error(codes.Unknown, errors.Wrap(err, "send series response").Error())
 		}
+		if resp.GetSeries() != nil {
+			summary.seriesReturned++
+		} else if resp.GetWarning() != "" {
+			summary.warnings++
+		}
+	}
+	if err := s.checkMaxSeriesPerQuery(reqLogger, seriesLimit); err != nil {
+		return err
+	}
+	if overlap != nil {
+		s.reportSeriesOverlap(reqLogger, overlap)
+	}
+	if s.latencyAttribution {
+		s.reportLatencyAttribution(reqLogger, summary, time.Now())
 	}
 
 	return nil
 }
 
+// seriesCall is a started Series call to a store, with the function releasing its concurrency slots.
+type seriesCall struct {
+	set     respSet
+	release func()
+	err     error
+}
+
+// startSeriesCall acquires the concurrency slots of the given store and starts its Series call, retrying it if
+// enabled with WithRetry.
+func (s *ProxyStore) startSeriesCall(ctx context.Context, st Client, req *storepb.SeriesRequest, shardInfo *storepb.ShardInfo, fanoutSlots chan struct{}, seriesLimit *seriesLimiter, logger log.Logger) seriesCall {
+	seriesClient, release, err := s.acquireSeriesSlots(ctx, st, fanoutSlots)
+	if err != nil {
+		return seriesCall{err: err}
+	}
+
+	storeAddr, _ := st.Addr()
+	start := time.Now()
+	set, err := s.newAsyncRespSetWithRetry(ctx, seriesClient, req, shardInfo, seriesLimit, logger)
+	if err != nil {
+		s.metrics.storeSeriesDuration.WithLabelValues(storeAddr, storeStatusError).Observe(time.Since(start).Seconds())
+		release()
+		return seriesCall{err: err}
+	}
+	return seriesCall{set: newDurationRespSet(set, start, s.metrics.storeSeriesDuration, storeAddr), release: release}
+}
+
+// isContextError returns whether the error is caused by a canceled call or an expired deadline, rather than by
+// the store.
+func isContextError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	code := status.Code(errors.Cause(err))
+	return code == codes.Canceled || code == codes.DeadlineExceeded
+}
+
+// checkMaxSeriesPerQuery returns an Aborted error if more series than allowed by WithMaxSeriesPerQuery were received.
+// The calls of all stores are already canceled by the limiter at that point.
+func (s *ProxyStore) checkMaxSeriesPerQuery(logger log.Logger, seriesLimit *seriesLimiter) error {
+	if !seriesLimit.exceeded() {
+		return nil
+	}
+	s.metrics.maxSeriesExceeded.Inc()
+	level.Warn(logger).Log("msg", "Series: maximum number of series per query exceeded", "limit", s.maxSeriesPerQuery)
+	return status.Errorf(codes.Aborted, "the stores returned more than the maximum of %d series per query, use more selective matchers or a shorter time range", s.maxSeriesPerQuery)
+}
+
+// labelSetMatchers returns the matchers for the given label sets, pruned if matcher pruning is enabled.
+func (s *ProxyStore) labelSetMatchers(logger log.Logger, requestMatchers []storepb.LabelMatcher, labelSets []labels.Labels) []storepb.LabelMatcher {
+	matchers := MatchersForLabelSets(labelSets)
+	if s.maxLabelSetMatchers <= 0 || len(matchers) <= s.maxLabelSetMatchers {
+		return matchers
+	}
+
+	matchers = PruneLabelSetMatchers(requestMatchers, matchers)
+	if len(matchers) > s.maxLabelSetMatchers {
+		level.Warn(logger).Log("msg", "too many label set matchers, querying stores without label set matchers",
+			"matchers", len(matchers), "max_matchers", s.maxLabelSetMatchers)
+		return nil
+	}
+	return matchers
+}
+
+// alignTimeRange aligns mint down and maxt up to the nearest multiple of step. Bounds without a multiple of
+// step beyond them, like those of open-ended requests, saturate at the int64 limits.
+func alignTimeRange(mint, maxt, step int64) (int64, int64) {
+	if rem := mint % step; rem != 0 {
+		mint -= rem
+		if rem < 0 {
+			if mint < math.MinInt64+step {
+				mint = math.MinInt64
+			} else {
+				mint -= step
+			}
+		}
+	}
+	if rem := maxt % step; rem != 0 {
+		maxt -= rem
+		if rem > 0 {
+			if maxt > math.MaxInt64-step {
+				maxt = math.MaxInt64
+			} else {
+				maxt += step
+			}
+		}
+	}
+	return mint, maxt
+}
+
+// getTenant returns the tenant of the request. We may arrive here either via the promql engine
+// or as a result of a grpc call in layered queries, so the tenant is taken from the incoming
+// gRPC metadata first and from the context value otherwise.
+func getTenant(ctx context.Context, logger log.Logger) string {
+	tenant, foundTenant := tenancy.GetTenantFromGRPCMetadata(ctx)
+	if !foundTenant {
+		level.Debug(logger).Log("msg", "using tenant from context instead of metadata")
+		if ctx.Value(tenancy.TenantKey) != nil {
+			tenant = ctx.Value(tenancy.TenantKey).(string)
+		}
+	}
+	return tenant
+}
+
+// tenantStores returns the stores to query for the tenant of the request, restricted to the ones reachable
+// in the store graph if there is one.
+func (s *ProxyStore) tenantStores(ctx context.Context) []Client {
+	var stores []Client
+	if s.tenantStoreRouter != nil {
+		stores = s.tenantStoreRouter(getTenant(ctx, s.logger))
+	}
+	if stores == nil {
+		stores = s.stores()
+	}
+	if s.storeGraph != nil {
+		stores = s.storeGraph.filter(stores)
+	}
+	return stores
+}
+
+// propagateTenant appends the tenant of the request to the outgoing gRPC metadata.
+func propagateTenant(ctx context.Context, logger log.Logger) context.Context {
+	tenant := getTenant(ctx, logger)
+	level.Debug(logger).Log("msg", "Tenant info", "tenant", tenant)
+	return metadata.AppendToOutgoingContext(ctx, tenancy.DefaultTenantHeader, tenant)
+}
+
+// propagateDeadline appends the deadline of the request, if any, to the outgoing gRPC metadata.
+func propagateDeadline(ctx context.Context) context.Context {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, DeadlineHeader, strconv.FormatInt(deadline.UnixMilli(), 10))
+}
+
+// joinStoreDebugMsgs joins the store debug messages of a request, collapsing them if enabled.
+func (s *ProxyStore) joinStoreDebugMsgs(msgs []string) string {
+	if !s.dedupDebugMessages {
+		return strings.Join(msgs, ";")
+	}
+	return strings.Join(dedupStoreDebugMsgs(msgs), ";")
+}
+
+// dedupStoreDebugMsgs collapses the messages of stores filtered out for the same reason, or queried, into one
+// message with the number of stores. Reasons are compared up to their first store specific detail, like
+// time ranges or label sets. Other messages are kept as they are.
+func dedupStoreDebugMsgs(msgs []string) []string {
+	const filteredSep = " filtered out due to: "
+
+	var (
+		deduped []string
+		// counts holds the number of stores of each collapsed message, by its index in deduped.
+		counts  = make(map[int]int)
+		indices = make(map[string]int)
+	)
+	for _, msg := range msgs {
+		key, collapse := msg, false
+		if strings.HasPrefix(msg, "Store ") {
+			if i := strings.Index(msg, filteredSep); i >= 0 {
+				reason := msg[i+len(filteredSep):]
+				if j := strings.IndexAny(reason, ":[{"); j >= 0 {
+					reason = reason[:j]
+				}
+				key, collapse = "Store filtered out due to: "+strings.TrimSpace(reason), true
+			} else if strings.HasSuffix(msg, " queried") {
+				key, collapse = "Store queried", true
+			}
+		}
+		if !collapse {
+			deduped = append(deduped, msg)
+			continue
+		}
+		i, ok := indices[key]
+		if !ok {
+			i = len(deduped)
+			indices[key] = i
+			deduped = append(deduped, key)
+		}
+		counts[i]++
+	}
+
+	for i, count := range counts {
+		deduped[i] = fmt.Sprintf("%s [%d stores]", deduped[i], count)
+	}
+	return deduped
+}
+
+// metadataSize returns the total size in bytes of the keys and values of the given gRPC metadata.
+func metadataSize(md metadata.MD) int {
+	size := 0
+	for k, vs := range md {
+		for _, v := range vs {
+			size += len(k) + len(v)
+		}
+	}
+	return size
+}
+
+// metadataQueryDiagnostics returns the diagnostic warning added to LabelNames and LabelValues responses.
+func metadataQueryDiagnostics(queried, filtered int) string {
+	return fmt.Sprintf("queried %d stores, %d filtered", queried, filtered)
+}
+
+// logSelectivity logs and records the fraction of all stores selected for a Series call.
+func (s *ProxyStore) logSelectivity(logger log.Logger, selected, total int) {
+	if total == 0 {
+		return
+	}
+	selectivity := float64(selected) / float64(total)
+	s.metrics.querySelectivity.Observe(selectivity)
+	if selected == total && total > s.selectivityWarnThreshold {
+		level.Warn(logger).Log("msg", "Series: query selects all stores, it may be missing external label matchers",
+			"stores", total, "selectivity", selectivity)
+		return
+	}
+	level.Debug(logger).Log("msg", "Series: query selectivity", "stores_selected", selected, "stores", total, "selectivity", selectivity)
+}
+
+// potentialShardFactor returns the fraction of the given stores that support sharding.
+func potentialShardFactor(stores []Client) float64 {
+	if len(stores) == 0 {
+		return 0
+	}
+	var sharding int
+	for _, st := range stores {
+		if st.SupportsSharding() {
+			sharding++
+		}
+	}
+	return float64(sharding) / float64(len(stores))
+}
+
+func logFanoutAnalysis(logger log.Logger, stores []Client) {
+	factor := potentialShardFactor(stores)
+	level.Debug(logger).Log("msg", "Series: fanout analysis", "stores", len(stores), "potential_shard_factor", factor)
+	if factor > 0.5 {
+		level.Info(logger).Log("msg", "most selected stores support sharding, consider enabling query sharding to reduce fanout",
+			"stores", len(stores), "potential_shard_factor", factor)
+	}
+}
+
+// analyzeLabelSetCoverage logs and records whether any of the given stores share a label set.
+// Stores without label sets are not taken into account.
+func (s *ProxyStore) analyzeLabelSetCoverage(logger log.Logger, stores []Client) {
+	var (
+		owners   = make(map[string]string)
+		analyzed int
+		shared   int
+	)
+	for _, st := range stores {
+		lsets := st.LabelSets()
+		if len(lsets) == 0 {
+			continue
+		}
+		analyzed++
+		for _, lset := range lsets {
+			key := lset.String()
+			owner, ok := owners[key]
+			if !ok {
+				owners[key] = st.String()
+				continue
+			}
+			shared++
+			level.Debug(logger).Log("msg", "stores share a label set", "label_set", key, "store", st.String(), "other_store", owner)
+		}
+	}
+	if analyzed < 2 {
+		return
+	}
+	if shared > 0 {
+		s.metrics.labelSetCoverage.WithLabelValues("replicated").Inc()
+		return
+	}
+	level.Debug(logger).Log("msg", "perfect sharding detected", "stores", analyzed)
+	s.metrics.labelSetCoverage.WithLabelValues("disjoint").Inc()
+}
+
+// queryUserAgent returns the user agent identifying the query sent to the stores.
+func queryUserAgent(ctx context.Context, tenant string, strategy storepb.PartialResponseStrategy) string {
+	queryID, ok := middleware.RequestIDFromContext(ctx)
+	if !ok {
+		queryID = "-"
+	}
+	return fmt.Sprintf("thanos-proxy/query-id=%s/tenant=%s/strategy=%s", queryID, tenant, strategy)
+}
+
 // storeMatches returns boolean if the given store may hold data for the given label matchers, time ranges and debug store matches gathered from context.
-func storeMatches(ctx context.Context, s Client, debugLogging bool, mint, maxt int64, matchers ...*labels.Matcher) (ok bool, reason string) {
+// Stores whose time range overlaps [mint, maxt] by less than minOverlapMs are filtered out too.
+func storeMatches(ctx context.Context, s Client, debugLogging bool, mint, maxt, minOverlapMs int64, matchers ...*labels.Matcher) (ok bool, reason string) {
+	cause, reason := storeMismatch(ctx, s, debugLogging, mint, maxt, minOverlapMs, matchers...)
+	return cause == "", reason
+}
+
+// Causes of stores not matching a request, as exposed by the thanos_proxy_store_filtered_total metric.
+const (
+	filterCauseTimeRange     = "time_range"
+	filterCauseLabelSet      = "label_set"
+	filterCauseTSDBSelector  = "tsdb_selector"
+	filterCauseDebugMetadata = "debug_metadata"
+	filterCauseResolution    = "resolution"
+)
+
+// storeMismatch returns the cause for which the store cannot have series matching the request, or an empty
+// cause if it matches. The reason describes the mismatch in detail if debug logging is enabled.
+func storeMismatch(ctx context.Context, s Client, debugLogging bool, mint, maxt, minOverlapMs int64, matchers ...*labels.Matcher) (cause, reason string) {
 	var storeDebugMatcher [][]*labels.Matcher
 	if ctxVal := ctx.Value(StoreMatcherKey); ctxVal != nil {
 		if value, ok := ctxVal.([][]*labels.Matcher); ok {
@@ -488,11 +1882,21 @@ func storeMatches(ctx context.Context, s Client, debugLogging bool, mint, maxt i
 		if debugLogging {
 			reason = fmt.Sprintf("does not have data within this time period: [%v,%v]. Store time ranges: [%v,%v]", mint, maxt, storeMinTime, storeMaxTime)
 		}
-		return false, reason
+		return filterCauseTimeRange, reason
+	}
+	if minOverlapMs > 0 {
+		// Compare as unsigned to not overflow on unbounded time ranges.
+		overlapStart, overlapEnd := max(mint, storeMinTime), min(maxt, storeMaxTime)
+		if uint64(overlapEnd-overlapStart) < uint64(minOverlapMs) {
+			if debugLogging {
+				reason = fmt.Sprintf("time range overlap of %vms with [%v,%v] is less than %vms. Store time ranges: [%v,%v]", overlapEnd-overlapStart, mint, maxt, minOverlapMs, storeMinTime, storeMaxTime)
+			}
+			return filterCauseTimeRange, reason
+		}
 	}
 
 	if ok, reason := storeMatchDebugMetadata(s, storeDebugMatcher); !ok {
-		return false, reason
+		return filterCauseDebugMetadata, reason
 	}
 
 	extLset := s.LabelSets()
@@ -500,9 +1904,9 @@ func storeMatches(ctx context.Context, s Client, debugLogging bool, mint, maxt i
 		if debugLogging {
 			reason = fmt.Sprintf("external labels %v does not match request label matchers: %v", extLset, matchers)
 		}
-		return false, reason
+		return filterCauseLabelSet, reason
 	}
-	return true, ""
+	return "", ""
 }
 
 // storeMatchDebugMetadata return true if the store's address match the storeDebugMatchers.
@@ -526,6 +1930,32 @@ func storeMatchDebugMetadata(s Client, storeDebugMatchers [][]*labels.Matcher) (
 	return true, ""
 }
 
+// storeMatchesQueryHints returns false if the label sets of the store do not match the matchers of the query hints.
+// As hints are best effort, invalid matchers do not filter out any store.
+func storeMatchesQueryHints(st Client, hints *storepb.QueryHints) bool {
+	if hints == nil || len(hints.Matchers) == 0 {
+		return true
+	}
+	matchers, err := storepb.MatchersToPromMatchers(hints.Matchers...)
+	if err != nil {
+		return true
+	}
+	return labelSetsMatch(matchers, st.LabelSets()...)
+}
+
+// storeSupportsResolution returns false if the store is resolution aware and has no data usable by queries
+// with the given max resolution window. Raw data queries, with a window of 0, are sent to all stores.
+func storeSupportsResolution(st Client, window int64) bool {
+	if window <= 0 {
+		return true
+	}
+	rst, ok := st.(ResolutionAwareClient)
+	if !ok {
+		return true
+	}
+	return rst.SupportsResolution(window)
+}
+
 // labelSetsMatch returns false if all label-set do not match the matchers (aka: OR is between all label-sets).
 func labelSetsMatch(matchers []*labels.Matcher, lset ...labels.Labels) bool {
 	if len(lset) == 0 {
@@ -553,56 +1983,99 @@ func (s *ProxyStore) LabelNames(ctx context.Context, r *storepb.LabelNamesReques
 ) {
 	var (
 		warnings       []string
-		names          [][]string
+		storeNames     [][]string
 		mtx            sync.Mutex
 		g, gctx        = errgroup.WithContext(ctx)
 		storeDebugMsgs []string
+
+		storesQueried, storesFiltered int
+
+		tsdbSelector = s.tsdbSelector.Load()
 	)
 
-	// We may arrive here either via the promql engine
-	// or as a result of a grpc call in layered queries
-	tenant, foundTenant := tenancy.GetTenantFromGRPCMetadata(gctx)
-	if !foundTenant {
-		level.Debug(s.logger).Log("msg", "using tenant from context instead of metadata")
-		if gctx.Value(tenancy.TenantKey) != nil {
-			tenant = gctx.Value(tenancy.TenantKey).(string)
-		}
+	if s.tenantPropagation {
+		gctx = propagateTenant(gctx, log.With(s.logger, "method", "LabelNames"))
 	}
+	if s.queryMetadataUserAgent {
+		gctx = extgrpc.ContextWithQueryUserAgent(gctx, queryUserAgent(gctx, getTenant(gctx, s.logger), r.PartialResponseStrategy))
+	}
+	if s.deadlineHeader {
+		gctx = propagateDeadline(gctx)
+	}
+	gctx = s.propagateBaggage(gctx, s.logger)
 
-	gctx = metadata.AppendToOutgoingContext(gctx, tenancy.DefaultTenantHeader, tenant)
-	level.Debug(s.logger).Log("msg", "Tenant info in LabelNames()", "tenant", tenant)
-
-	for _, st := range s.stores() {
+	candidates, cacheFiltered, _ := s.candidateStores(gctx, s.tenantStores(gctx), nil)
+	storesFiltered += cacheFiltered
+	if s.debugLogging && cacheFiltered > 0 {
+		storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("%d stores filtered out due to: cached store matching", cacheFiltered))
+	}
+	for _, st := range candidates {
 		st := st
 
 		// We might be able to skip the store if its meta information indicates it cannot have series matching our query.
-		if ok, reason := storeMatches(gctx, st, s.debugLogging, r.Start, r.End); !ok {
+		if cause, reason := storeMismatch(gctx, st, s.debugLogging, r.Start, r.End, s.minTimeRangeOverlapMs); cause != "" {
+			s.metrics.storeFilteredTotal.WithLabelValues(cause).Inc()
 			if s.debugLogging {
 				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, reason))
 			}
+			storesFiltered++
 			continue
 		}
-		matches, extraMatchers := s.tsdbSelector.MatchLabelSets(st.LabelSets()...)
+		matches, extraMatchers := tsdbSelector.MatchLabelSets(st.LabelSets()...)
 		if !matches {
+			s.metrics.storeFilteredTotal.WithLabelValues(filterCauseTSDBSelector).Inc()
 			if s.debugLogging {
 				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, "tsdb selector"))
 			}
+			storesFiltered++
+			continue
+		}
+		if !s.circuitAllows(st) {
+			if s.debugLogging {
+				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, "circuit breaker open"))
+			}
+			storesFiltered++
+			continue
+		}
+		if !s.blacklistAllows(st) {
+			if s.debugLogging {
+				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, "blacklisted"))
+			}
+			storesFiltered++
 			continue
 		}
 
 		if s.debugLogging {
 			storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s queried", st))
 		}
+		storesQueried++
 
 		g.Go(func() error {
-			resp, err := st.LabelNames(gctx, &storepb.LabelNamesRequest{
+			release, err := s.acquireStore(gctx, st)
+			if err != nil {
+				if r.PartialResponseDisabled {
+					return err
+				}
+				mtx.Lock()
+				warnings = append(warnings, err.Error())
+				mtx.Unlock()
+				return nil
+			}
+			defer release()
+
+			storeCtx, cancel := s.storeCallContext(gctx)
+			defer cancel()
+
+			resp, err := st.LabelNames(storeCtx, &storepb.LabelNamesRequest{
 				PartialResponseDisabled: r.PartialResponseDisabled,
 				Start:                   r.Start,
 				End:                     r.End,
-				Matchers:                append(r.Matchers, MatchersForLabelSets(extraMatchers)...),
+				Matchers:                append(r.Matchers, s.labelSetMatchers(s.logger, r.Matchers, extraMatchers)...),
 				WithoutReplicaLabels:    r.WithoutReplicaLabels,
-			})
+			}, s.storeCallOptions(st)...)
+			s.recordCircuitResult(gctx, st, err)
 			if err != nil {
+				s.recordStoreError(gctx, st, err)
 				err = errors.Wrapf(err, "fetch label names from store %s", st)
 				if r.PartialResponseDisabled {
 					return err
@@ -614,9 +2087,14 @@ func (s *ProxyStore) LabelNames(ctx context.Context, r *storepb.LabelNamesReques
 				return nil
 			}
 
+			// Sort outside of the lock, so that the responses only need to be merged.
+			if !sort.StringsAreSorted(resp.Names) {
+				sort.Strings(resp.Names)
+			}
+
 			mtx.Lock()
 			warnings = append(warnings, resp.Warnings...)
-			names = append(names, resp.Names)
+			storeNames = append(storeNames, resp.Names)
 			mtx.Unlock()
 
 			return nil
@@ -626,15 +2104,20 @@ func (s *ProxyStore) LabelNames(ctx context.Context, r *storepb.LabelNamesReques
 	if err := g.Wait(); err != nil {
 		return nil, err
 	}
+	names := strutil.HeapMergeSlices(storeNames...)
+	if s.metadataQueryDiagnostics {
+		warnings = append(warnings, metadataQueryDiagnostics(storesQueried, storesFiltered))
+	}
 
-	level.Debug(s.logger).Log("msg", strings.Join(storeDebugMsgs, ";"))
+	level.Debug(s.logger).Log("msg", s.joinStoreDebugMsgs(storeDebugMsgs))
 	return &storepb.LabelNamesResponse{
-		Names:    strutil.MergeUnsortedSlices(names...),
+		Names:    names,
 		Warnings: warnings,
 	}, nil
 }
 
-// LabelValues returns all known label values for a given label name.
+// LabelValues returns all known label values for a given label name. Values are deduplicated and sorted,
+// whatever the order in which stores return them.
 func (s *ProxyStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRequest) (
 	*storepb.LabelValuesResponse, error,
 ) {
@@ -644,25 +2127,32 @@ func (s *ProxyStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRequ
 		mtx            sync.Mutex
 		g, gctx        = errgroup.WithContext(ctx)
 		storeDebugMsgs []string
+
+		storesQueried, storesFiltered int
+
+		tsdbSelector = s.tsdbSelector.Load()
 	)
 	if r.Label == "" {
 		return nil, status.Error(codes.InvalidArgument, "label name parameter cannot be empty")
 	}
 
-	// We may arrive here either via the promql engine
-	// or as a result of a grpc call in layered queries
-	tenant, foundTenant := tenancy.GetTenantFromGRPCMetadata(gctx)
-	if !foundTenant {
-		level.Debug(s.logger).Log("msg", "using tenant from context instead of metadata")
-		if gctx.Value(tenancy.TenantKey) != nil {
-			tenant = gctx.Value(tenancy.TenantKey).(string)
-		}
+	if s.tenantPropagation {
+		gctx = propagateTenant(gctx, log.With(s.logger, "method", "LabelValues"))
 	}
+	if s.queryMetadataUserAgent {
+		gctx = extgrpc.ContextWithQueryUserAgent(gctx, queryUserAgent(gctx, getTenant(gctx, s.logger), r.PartialResponseStrategy))
+	}
+	if s.deadlineHeader {
+		gctx = propagateDeadline(gctx)
+	}
+	gctx = s.propagateBaggage(gctx, s.logger)
 
-	gctx = metadata.AppendToOutgoingContext(gctx, tenancy.DefaultTenantHeader, tenant)
-	level.Debug(s.logger).Log("msg", "Tenant info in LabelValues()", "tenant", tenant)
-
-	for _, st := range s.stores() {
+	candidates, cacheFiltered, _ := s.candidateStores(gctx, s.tenantStores(gctx), nil)
+	storesFiltered += cacheFiltered
+	if s.debugLogging && cacheFiltered > 0 {
+		storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("%d stores filtered out due to: cached store matching", cacheFiltered))
+	}
+	for _, st := range candidates {
 		st := st
 
 		storeAddr, isLocalStore := st.Addr()
@@ -672,22 +2162,41 @@ func (s *ProxyStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRequ
 		}
 
 		// We might be able to skip the store if its meta information indicates it cannot have series matching our query.
-		if ok, reason := storeMatches(gctx, st, s.debugLogging, r.Start, r.End); !ok {
+		if cause, reason := storeMismatch(gctx, st, s.debugLogging, r.Start, r.End, s.minTimeRangeOverlapMs); cause != "" {
+			s.metrics.storeFilteredTotal.WithLabelValues(cause).Inc()
 			if s.debugLogging {
 				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, reason))
 			}
+			storesFiltered++
 			continue
 		}
-		matches, extraMatchers := s.tsdbSelector.MatchLabelSets(st.LabelSets()...)
+		matches, extraMatchers := tsdbSelector.MatchLabelSets(st.LabelSets()...)
 		if !matches {
+			s.metrics.storeFilteredTotal.WithLabelValues(filterCauseTSDBSelector).Inc()
 			if s.debugLogging {
 				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, "tsdb selector"))
 			}
+			storesFiltered++
+			continue
+		}
+		if !s.circuitAllows(st) {
+			if s.debugLogging {
+				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, "circuit breaker open"))
+			}
+			storesFiltered++
+			continue
+		}
+		if !s.blacklistAllows(st) {
+			if s.debugLogging {
+				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s filtered out due to: %v", st, "blacklisted"))
+			}
+			storesFiltered++
 			continue
 		}
 		if s.debugLogging {
 			storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("Store %s queried", st))
 		}
+		storesQueried++
 
 		g.Go(func() error {
 			span, spanCtx := tracing.StartSpan(gctx, "proxy.label_values", tracing.Tags{
@@ -697,15 +2206,32 @@ func (s *ProxyStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRequ
 			})
 			defer span.Finish()
 
-			resp, err := st.LabelValues(spanCtx, &storepb.LabelValuesRequest{
+			release, err := s.acquireStore(spanCtx, st)
+			if err != nil {
+				if r.PartialResponseDisabled {
+					return err
+				}
+				mtx.Lock()
+				warnings = append(warnings, err.Error())
+				mtx.Unlock()
+				return nil
+			}
+			defer release()
+
+			storeCtx, cancel := s.storeCallContext(spanCtx)
+			defer cancel()
+
+			resp, err := st.LabelValues(storeCtx, &storepb.LabelValuesRequest{
 				Label:                   r.Label,
 				PartialResponseDisabled: r.PartialResponseDisabled,
 				Start:                   r.Start,
 				End:                     r.End,
-				Matchers:                append(r.Matchers, MatchersForLabelSets(extraMatchers)...),
+				Matchers:                append(r.Matchers, s.labelSetMatchers(s.logger, r.Matchers, extraMatchers)...),
 				WithoutReplicaLabels:    r.WithoutReplicaLabels,
-			})
+			}, s.storeCallOptions(st)...)
+			s.recordCircuitResult(spanCtx, st, err)
 			if err != nil {
+				s.recordStoreError(spanCtx, st, err)
 				msg := "fetch label values from store %s"
 				err = errors.Wrapf(err, msg, st)
 				if r.PartialResponseDisabled {
@@ -730,10 +2256,16 @@ func (s *ProxyStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRequ
 	if err := g.Wait(); err != nil {
 		return nil, err
 	}
+	if s.metadataQueryDiagnostics {
+		warnings = append(warnings, metadataQueryDiagnostics(storesQueried, storesFiltered))
+	}
 
-	level.Debug(s.logger).Log("msg", strings.Join(storeDebugMsgs, ";"))
+	level.Debug(s.logger).Log("msg", s.joinStoreDebugMsgs(storeDebugMsgs))
+	values := strutil.MergeUnsortedSlices(all...)
+	// Clients rely on sorted values, make it explicit instead of depending on how values are merged.
+	sort.Strings(values)
 	return &storepb.LabelValuesResponse{
-		Values:   strutil.MergeUnsortedSlices(all...),
+		Values:   values,
 		Warnings: warnings,
 	}, nil
 }
@@ -0,0 +1,82 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"go.opentelemetry.io/otel/baggage"
+	"google.golang.org/grpc/metadata"
+)
+
+// BaggageHeaderPrefix is the prefix of the gRPC metadata keys carrying OpenTelemetry baggage members.
+const BaggageHeaderPrefix = "x-baggage-"
+
+// WithOTelBaggageToGRPCMetadata sends the values of the given OpenTelemetry baggage members of the request
+// to stores as x-baggage-<key> gRPC metadata.
+func WithOTelBaggageToGRPCMetadata(keys ...string) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.baggageToMetadataKeys = keys
+	}
+}
+
+// WithGRPCMetadataToOTelBaggage adds the values of the given x-baggage-<key> gRPC metadata of incoming
+// requests to their OpenTelemetry baggage.
+func WithGRPCMetadataToOTelBaggage(keys ...string) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.metadataToBaggageKeys = keys
+	}
+}
+
+// propagateBaggage moves the configured baggage members from the incoming gRPC metadata to the baggage
+// of the request, and from the baggage of the request to the outgoing gRPC metadata.
+func (s *ProxyStore) propagateBaggage(ctx context.Context, logger log.Logger) context.Context {
+	if len(s.metadataToBaggageKeys) > 0 {
+		ctx = grpcMetadataToBaggage(ctx, logger, s.metadataToBaggageKeys)
+	}
+	if len(s.baggageToMetadataKeys) > 0 {
+		ctx = baggageToGRPCMetadata(ctx, s.baggageToMetadataKeys)
+	}
+	return ctx
+}
+
+func grpcMetadataToBaggage(ctx context.Context, logger log.Logger, keys []string) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	b := baggage.FromContext(ctx)
+	for _, key := range keys {
+		values := md.Get(BaggageHeaderPrefix + key)
+		if len(values) == 0 {
+			continue
+		}
+		member, err := baggage.NewMemberRaw(key, values[0])
+		if err == nil {
+			b, err = b.SetMember(member)
+		}
+		if err != nil {
+			level.Debug(logger).Log("msg", "failed to add gRPC metadata to baggage", "key", key, "err", err)
+		}
+	}
+	return baggage.ContextWithBaggage(ctx, b)
+}
+
+func baggageToGRPCMetadata(ctx context.Context, keys []string) context.Context {
+	b := baggage.FromContext(ctx)
+
+	var kv []string
+	for _, key := range keys {
+		if value := b.Member(key).Value(); value != "" {
+			kv = append(kv, BaggageHeaderPrefix+key, value)
+		}
+	}
+	if len(kv) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
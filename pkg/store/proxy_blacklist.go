@@ -0,0 +1,141 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultBlacklistBanDuration = time.Minute
+
+// WithBlacklistThreshold excludes stores from Series, LabelNames and LabelValues calls once their calls failed
+// n times within window. Stores are excluded for one minute, see WithBlacklistBanDuration.
+func WithBlacklistThreshold(n int, window time.Duration) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		if n <= 0 {
+			s.blacklist = nil
+			return
+		}
+		banDuration := defaultBlacklistBanDuration
+		if s.blacklist != nil {
+			banDuration = s.blacklist.banDuration
+		}
+		s.blacklist = newStoreBlacklist(n, window, banDuration, s.metrics.blacklisted, s.metrics.blacklistActive)
+	}
+}
+
+// WithBlacklistBanDuration sets how long stores are excluded once blacklisted. It has to be passed after
+// WithBlacklistThreshold.
+func WithBlacklistBanDuration(d time.Duration) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		if s.blacklist != nil {
+			s.blacklist.banDuration = d
+		}
+	}
+}
+
+// storeBlacklist tracks the recent errors of each store address and the stores banned because of them.
+type storeBlacklist struct {
+	threshold   int
+	window      time.Duration
+	banDuration time.Duration
+	now         func() time.Time
+
+	blacklisted prometheus.Counter
+	active      prometheus.Gauge
+
+	mtx         sync.Mutex
+	errors      map[string][]time.Time
+	bannedUntil map[string]time.Time
+	lastPrune   time.Time
+}
+
+func newStoreBlacklist(threshold int, window, banDuration time.Duration, blacklisted prometheus.Counter, active prometheus.Gauge) *storeBlacklist {
+	return &storeBlacklist{
+		threshold:   threshold,
+		window:      window,
+		banDuration: banDuration,
+		now:         time.Now,
+		blacklisted: blacklisted,
+		active:      active,
+		errors:      map[string][]time.Time{},
+		bannedUntil: map[string]time.Time{},
+	}
+}
+
+// IsAllowed returns whether the store with the given address is not banned.
+func (b *storeBlacklist) IsAllowed(addr string) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.expire()
+	_, banned := b.bannedUntil[addr]
+	return !banned
+}
+
+// recordError records a failed call to the store with the given address, banning it once it failed threshold
+// times within the window.
+func (b *storeBlacklist) recordError(addr string) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.expire()
+	if _, banned := b.bannedUntil[addr]; banned {
+		return
+	}
+
+	now := b.now()
+	errs := b.errors[addr][:0]
+	for _, t := range b.errors[addr] {
+		if now.Sub(t) < b.window {
+			errs = append(errs, t)
+		}
+	}
+	errs = append(errs, now)
+	if len(errs) < b.threshold {
+		b.errors[addr] = errs
+		return
+	}
+
+	delete(b.errors, addr)
+	b.bannedUntil[addr] = now.Add(b.banDuration)
+	b.blacklisted.Inc()
+	b.active.Inc()
+}
+
+// expire lifts the bans which are over and, at most once per window, drops the errors out of the window.
+// Stores which were removed stop failing, so they are dropped from both maps once their errors are out of the
+// window and their ban is over. It has to be called with the lock held.
+func (b *storeBlacklist) expire() {
+	now := b.now()
+	for addr, until := range b.bannedUntil {
+		if !now.Before(until) {
+			delete(b.bannedUntil, addr)
+			b.active.Dec()
+		}
+	}
+
+	if now.Sub(b.lastPrune) < b.window {
+		return
+	}
+	b.lastPrune = now
+	for addr, errs := range b.errors {
+		// Errors are recorded in order, so the last one is the most recent.
+		if len(errs) == 0 || now.Sub(errs[len(errs)-1]) >= b.window {
+			delete(b.errors, addr)
+		}
+	}
+}
+
+// blacklistAllows returns whether the given store is not blacklisted, if blacklisting is enabled.
+func (s *ProxyStore) blacklistAllows(st Client) bool {
+	if s.blacklist == nil {
+		return true
+	}
+	addr, _ := st.Addr()
+	return s.blacklist.IsAllowed(addr)
+}
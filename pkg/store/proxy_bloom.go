@@ -0,0 +1,150 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"math/bits"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+const (
+	labelSetFilterBitsPerPair = 10
+	labelSetFilterHashes      = 7
+)
+
+// WithBloomFilterLabelSets pre-screens stores with a bloom filter over the label name and value pairs of their
+// label sets. Stores whose label sets all carry a different value for the label of an equality matcher are
+// rejected without matching the request matchers against each of their label sets. The filter of a store is
+// rebuilt when its label sets change.
+func WithBloomFilterLabelSets() ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.labelSetFilters = &labelSetFilters{filters: map[string]*labelSetFilter{}}
+	}
+}
+
+// labelSetFilters keeps the label set filter of each store, keyed by store address.
+type labelSetFilters struct {
+	mtx     sync.Mutex
+	filters map[string]*labelSetFilter
+}
+
+// rejects returns true if no label set of the store can match the given matchers. False positives of the
+// bloom filter only make it return false, the store then still has to be matched against its label sets.
+func (f *labelSetFilters) rejects(st Client, matchers []*labels.Matcher) bool {
+	if len(matchers) == 0 {
+		return false
+	}
+	lsets := st.LabelSets()
+	if len(lsets) == 0 {
+		return false
+	}
+	addr, _ := st.Addr()
+
+	f.mtx.Lock()
+	filter, ok := f.filters[addr]
+	f.mtx.Unlock()
+	if !ok || !filter.builtFrom(lsets) {
+		filter = newLabelSetFilter(lsets)
+		f.mtx.Lock()
+		f.filters[addr] = filter
+		f.mtx.Unlock()
+	}
+
+	return filter.rejects(matchers)
+}
+
+// labelSetFilter is a bloom filter over the label name and value pairs of the label sets of a store.
+type labelSetFilter struct {
+	// lsets are the label sets the filter was built from.
+	lsets []labels.Labels
+	bits  []uint64
+	// common holds the label names present in all label sets. A label set without the label of a matcher
+	// always matches it, so only those names can be used to reject a store.
+	common map[string]struct{}
+}
+
+func newLabelSetFilter(lsets []labels.Labels) *labelSetFilter {
+	pairs := 0
+	counts := map[string]int{}
+	for _, ls := range lsets {
+		pairs += len(ls)
+		for _, l := range ls {
+			counts[l.Name]++
+		}
+	}
+
+	f := &labelSetFilter{
+		lsets:  make([]labels.Labels, 0, len(lsets)),
+		bits:   make([]uint64, (pairs*labelSetFilterBitsPerPair+63)/64+1),
+		common: map[string]struct{}{},
+	}
+	for name, c := range counts {
+		if c == len(lsets) {
+			f.common[name] = struct{}{}
+		}
+	}
+	for _, ls := range lsets {
+		f.lsets = append(f.lsets, ls.Copy())
+		for _, l := range ls {
+			f.add(l.Name, l.Value)
+		}
+	}
+	return f
+}
+
+// builtFrom returns whether the filter was built from the given label sets. Comparing them is cheaper than
+// hashing, as the strings of unchanged label sets usually share their memory with the ones of the filter.
+func (f *labelSetFilter) builtFrom(lsets []labels.Labels) bool {
+	if len(f.lsets) != len(lsets) {
+		return false
+	}
+	for i := range lsets {
+		if !labels.Equal(f.lsets[i], lsets[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *labelSetFilter) add(name, value string) {
+	h1, h2 := labelPairHashes(name, value)
+	n := uint32(len(f.bits) * 64)
+	for i := uint32(0); i < labelSetFilterHashes; i++ {
+		b := (h1 + i*h2) % n
+		f.bits[b/64] |= 1 << (b % 64)
+	}
+}
+
+func (f *labelSetFilter) mayContain(name, value string) bool {
+	h1, h2 := labelPairHashes(name, value)
+	n := uint32(len(f.bits) * 64)
+	for i := uint32(0); i < labelSetFilterHashes; i++ {
+		b := (h1 + i*h2) % n
+		if f.bits[b/64]&(1<<(b%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *labelSetFilter) rejects(matchers []*labels.Matcher) bool {
+	for _, m := range matchers {
+		if m.Type != labels.MatchEqual {
+			continue
+		}
+		if _, ok := f.common[m.Name]; ok && !f.mayContain(m.Name, m.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// labelPairHashes returns the two hashes used for double hashing a label name and value pair.
+func labelPairHashes(name, value string) (uint32, uint32) {
+	h := xxhash.Sum64String(name) ^ bits.RotateLeft64(xxhash.Sum64String(value), 31)
+	return uint32(h), uint32(h>>32) | 1
+}
@@ -0,0 +1,137 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// defaultStoreCapabilityTTL is how long store capabilities are cached by the ProxyStore.
+const defaultStoreCapabilityTTL = 5 * time.Minute
+
+// storeCapabilityInfoTimeout bounds the Info calls fetching the capabilities of a store.
+const storeCapabilityInfoTimeout = 5 * time.Second
+
+// StoreCapabilityCache caches the Info responses of stores for a fixed time. Expired entries are evicted, so
+// that stores which are gone do not stay in the cache.
+type StoreCapabilityCache struct {
+	ttl    time.Duration
+	logger log.Logger
+	now    func() time.Time
+
+	mtx       sync.Mutex
+	entries   map[string]storeCapabilityEntry
+	lastPrune time.Time
+}
+
+type storeCapabilityEntry struct {
+	info    *storepb.InfoResponse
+	expires time.Time
+}
+
+// NewStoreCapabilityCache returns a StoreCapabilityCache which keeps Info responses for the given TTL.
+func NewStoreCapabilityCache(ttl time.Duration, logger log.Logger) *StoreCapabilityCache {
+	return &StoreCapabilityCache{
+		ttl:     ttl,
+		logger:  logger,
+		now:     time.Now,
+		entries: map[string]storeCapabilityEntry{},
+	}
+}
+
+// Info returns the cached Info response of the given store, calling Info on the store if there is
+// no cached response or it expired. Failed calls are cached as empty responses, so that stores not
+// implementing Info are not called for every request. Calls which were canceled or timed out are not
+// cached, as they say nothing about the store.
+func (c *StoreCapabilityCache) Info(ctx context.Context, st Client) *storepb.InfoResponse {
+	key := st.String()
+	if info, ok := c.cached(key); ok {
+		return info
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, storeCapabilityInfoTimeout)
+	defer cancel()
+	info, err := st.Info(ctx, &storepb.InfoRequest{})
+	if err != nil {
+		level.Debug(c.logger).Log("msg", "failed to get store capabilities", "store", key, "err", err)
+		if ctx.Err() != nil || isContextError(err) {
+			return &storepb.InfoResponse{}
+		}
+		info = &storepb.InfoResponse{}
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := c.now()
+	if now.Sub(c.lastPrune) > c.ttl {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastPrune = now
+	}
+	c.entries[key] = storeCapabilityEntry{info: info, expires: now.Add(c.ttl)}
+	return info
+}
+
+func (c *StoreCapabilityCache) cached(key string) (*storepb.InfoResponse, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expires) {
+		return nil, false
+	}
+	return entry.info, true
+}
+
+// seriesLimits returns the maximum number of series per query advertised by each of the given stores. Info is
+// called concurrently on the stores without cached response.
+func (c *StoreCapabilityCache) seriesLimits(ctx context.Context, stores []Client) []int64 {
+	limits := make([]int64, len(stores))
+	var wg sync.WaitGroup
+	for i, st := range stores {
+		if info, ok := c.cached(st.String()); ok {
+			limits[i] = info.MaxSeriesPerQuery
+			continue
+		}
+		wg.Add(1)
+		go func(i int, st Client) {
+			defer wg.Done()
+			limits[i] = c.Info(ctx, st).MaxSeriesPerQuery
+		}(i, st)
+	}
+	wg.Wait()
+	return limits
+}
+
+// limitSeriesRequest returns the request to send to a store, with its total limit capped to the maximum
+// number of series per query storeCap advertised by the store.
+func limitSeriesRequest(r *storepb.SeriesRequest, storeCap int64) *storepb.SeriesRequest {
+	if storeCap <= 0 {
+		return r
+	}
+
+	var hints storepb.QueryHints
+	if r.QueryHints != nil {
+		hints = *r.QueryHints
+	}
+	if hints.TotalLimit > 0 && hints.TotalLimit <= storeCap {
+		return r
+	}
+	hints.TotalLimit = storeCap
+
+	storeReq := *r
+	storeReq.QueryHints = &hints
+	return &storeReq
+}
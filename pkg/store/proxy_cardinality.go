@@ -0,0 +1,61 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"fmt"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+)
+
+// WithMaxLabelCardinality adds a warning to Series responses once any label name has more than maxUniqueValues
+// unique values across the returned series. See WithStrictLabelCardinality to stop returning series instead.
+func WithMaxLabelCardinality(maxUniqueValues int) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.maxLabelCardinality = maxUniqueValues
+	}
+}
+
+// WithStrictLabelCardinality stops returning series once the limit set with WithMaxLabelCardinality is exceeded.
+// Requests which do not allow partial responses fail with an Aborted error instead.
+func WithStrictLabelCardinality(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.strictLabelCardinality = enabled
+	}
+}
+
+// labelCardinality tracks the unique values of each label name across the series of a response.
+type labelCardinality struct {
+	maxUniqueValues int
+	values          map[string]map[string]struct{}
+	exceeded        bool
+}
+
+func newLabelCardinality(maxUniqueValues int) *labelCardinality {
+	return &labelCardinality{
+		maxUniqueValues: maxUniqueValues,
+		values:          make(map[string]map[string]struct{}),
+	}
+}
+
+// observe tracks the label values of a series. It returns an error the first time a label name exceeds the
+// limit of unique values.
+func (c *labelCardinality) observe(lset []labelpb.ZLabel) error {
+	if c.exceeded {
+		return nil
+	}
+	for _, l := range lset {
+		vals, ok := c.values[l.Name]
+		if !ok {
+			vals = make(map[string]struct{})
+			c.values[l.Name] = vals
+		}
+		vals[l.Value] = struct{}{}
+		if len(vals) > c.maxUniqueValues {
+			c.exceeded = true
+			return fmt.Errorf("label %q has more than %d unique values in the response", l.Name, c.maxUniqueValues)
+		}
+	}
+	return nil
+}
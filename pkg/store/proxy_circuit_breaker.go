@@ -0,0 +1,171 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Circuit breaker states, as exposed by the thanos_proxy_store_circuit_breaker_state metric.
+const (
+	circuitClosed   = 0
+	circuitOpen     = 1
+	circuitHalfOpen = 2
+)
+
+// WithCircuitBreaker skips stores for cooldown once their calls failed threshold times in a row. After the
+// cooldown, a single request is sent to the store as a probe: the circuit closes again if it succeeds and
+// stays open for another cooldown if it fails. Series calls count as failed if the store returned a warning.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		if threshold <= 0 {
+			s.circuitBreaker = nil
+			return
+		}
+		s.circuitBreaker = newCircuitBreaker(threshold, cooldown, s.metrics.circuitBreakerState)
+	}
+}
+
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+	state     *prometheus.GaugeVec
+
+	mtx    sync.Mutex
+	stores map[string]*storeCircuit
+}
+
+type storeCircuit struct {
+	failures  int
+	openUntil time.Time
+	// probeStarted is set while a probe is sent to a store whose cooldown is over.
+	probeStarted time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, state *prometheus.GaugeVec) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     state,
+		stores:    map[string]*storeCircuit{},
+	}
+}
+
+// allow returns whether the store with the given address can be queried. Once the cooldown of an open
+// circuit is over, only one request at a time is allowed until it reports its result.
+func (c *circuitBreaker) allow(addr string) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	sc, ok := c.stores[addr]
+	if !ok || sc.openUntil.IsZero() {
+		return true
+	}
+	now := c.now()
+	if now.Before(sc.openUntil) {
+		return false
+	}
+	// Allow a new probe if the previous one never reported its result.
+	if !sc.probeStarted.IsZero() && now.Sub(sc.probeStarted) < c.cooldown {
+		return false
+	}
+	sc.probeStarted = now
+	c.state.WithLabelValues(addr).Set(circuitHalfOpen)
+	return true
+}
+
+// success closes the circuit of the store with the given address.
+func (c *circuitBreaker) success(addr string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, ok := c.stores[addr]; !ok {
+		return
+	}
+	delete(c.stores, addr)
+	c.state.WithLabelValues(addr).Set(circuitClosed)
+}
+
+// failure records a failed call to the store with the given address, opening its circuit once the
+// threshold of consecutive failures is reached or if the call was a probe.
+func (c *circuitBreaker) failure(addr string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	sc, ok := c.stores[addr]
+	if !ok {
+		sc = &storeCircuit{}
+		c.stores[addr] = sc
+	}
+	sc.failures++
+	if sc.failures < c.threshold && sc.openUntil.IsZero() {
+		return
+	}
+	sc.openUntil = c.now().Add(c.cooldown)
+	sc.probeStarted = time.Time{}
+	c.state.WithLabelValues(addr).Set(circuitOpen)
+}
+
+// track returns a respSet which reports the result of the Series call of the store with the given address
+// once its responses are consumed.
+func (c *circuitBreaker) track(addr string, set respSet) respSet {
+	return &circuitBreakerRespSet{respSet: set, breaker: c, addr: addr}
+}
+
+type circuitBreakerRespSet struct {
+	respSet
+
+	breaker *circuitBreaker
+	addr    string
+	failed  bool
+	done    bool
+}
+
+func (c *circuitBreakerRespSet) Next() bool {
+	if !c.respSet.Next() {
+		if !c.done {
+			c.done = true
+			if c.failed {
+				c.breaker.failure(c.addr)
+			} else {
+				c.breaker.success(c.addr)
+			}
+		}
+		return false
+	}
+	if c.respSet.At().GetWarning() != "" {
+		c.failed = true
+	}
+	return true
+}
+
+// circuitAllows returns whether the circuit breaker, if enabled, allows querying the given store.
+func (s *ProxyStore) circuitAllows(st Client) bool {
+	if s.circuitBreaker == nil {
+		return true
+	}
+	addr, _ := st.Addr()
+	return s.circuitBreaker.allow(addr)
+}
+
+// recordCircuitResult reports the result of a call to the given store to the circuit breaker, if enabled.
+// Calls canceled by the caller or by the failure of another store do not say anything about the store, so
+// they are not reported.
+func (s *ProxyStore) recordCircuitResult(ctx context.Context, st Client, err error) {
+	if s.circuitBreaker == nil || ctx.Err() != nil || isContextError(err) {
+		return
+	}
+	addr, _ := st.Addr()
+	if err != nil {
+		s.circuitBreaker.failure(addr)
+		return
+	}
+	s.circuitBreaker.success(addr)
+}
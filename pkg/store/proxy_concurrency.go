@@ -0,0 +1,171 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// WithPerStoreMaxConcurrency limits the number of concurrent Series, LabelNames and LabelValues calls sent to
+// each store to n. Calls waiting for longer than the response timeout are treated as failed store calls.
+func WithPerStoreMaxConcurrency(n int) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		if n <= 0 {
+			s.storeConcurrency = nil
+			return
+		}
+		s.storeConcurrency = &storeConcurrencyLimiter{limit: n, semaphores: map[string]chan struct{}{}}
+	}
+}
+
+// WithFanoutConcurrency limits the number of stores each Series request streams from at the same time to n.
+// A slot is released once the stream of a store ends, whether or not its responses were merged yet. Stores
+// waiting for longer than the response timeout are treated as failed store calls. The option is ignored with
+// lazy retrieval, as streams are then only consumed once all stores have been called.
+func WithFanoutConcurrency(n int) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.fanoutConcurrency = max(n, 0)
+	}
+}
+
+// storeConcurrencyLimiter keeps a semaphore per store address.
+type storeConcurrencyLimiter struct {
+	limit int
+
+	mtx        sync.Mutex
+	semaphores map[string]chan struct{}
+}
+
+func (l *storeConcurrencyLimiter) semaphore(addr string) chan struct{} {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	sem, ok := l.semaphores[addr]
+	if !ok {
+		sem = make(chan struct{}, l.limit)
+		l.semaphores[addr] = sem
+	}
+	return sem
+}
+
+// acquire waits for a free slot of the store with the given address for at most timeout, if positive. It
+// returns the function releasing the slot.
+func (l *storeConcurrencyLimiter) acquire(ctx context.Context, addr string, timeout time.Duration) (func(), error) {
+	return acquireSlot(ctx, l.semaphore(addr), timeout)
+}
+
+// acquireSlot waits for a free slot of the semaphore for at most timeout, if positive. It returns the function
+// releasing the slot.
+func acquireSlot(ctx context.Context, sem chan struct{}, timeout time.Duration) (func(), error) {
+	release := func() { <-sem }
+
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	var timeoutC <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timeoutC = t.C
+	}
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	case <-timeoutC:
+		return nil, errors.Errorf("concurrency limit of %d reached for %s", cap(sem), timeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// acquireStore acquires a concurrency slot of the given store, if limited. It returns the function releasing
+// the slot.
+func (s *ProxyStore) acquireStore(ctx context.Context, st Client) (func(), error) {
+	if s.storeConcurrency == nil {
+		return func() {}, nil
+	}
+	addr, _ := st.Addr()
+	release, err := s.storeConcurrency.acquire(ctx, addr, s.responseTimeout)
+	if err != nil {
+		s.metrics.fanoutBlocked.Inc()
+		return nil, errors.Wrapf(err, "wait for store %s", st)
+	}
+	return release, nil
+}
+
+// newFanoutSlots returns the fanout slots shared by the stores of a single Series request, or nil if the fanout
+// is not limited.
+func (s *ProxyStore) newFanoutSlots() chan struct{} {
+	if s.fanoutConcurrency == 0 {
+		return nil
+	}
+	return make(chan struct{}, s.fanoutConcurrency)
+}
+
+// acquireSeriesSlots acquires the concurrency slots of a Series call to the given store, if limited. fanoutSlots
+// are the slots of the request returned by newFanoutSlots. It returns the client to send the call to and the
+// function releasing the slots.
+func (s *ProxyStore) acquireSeriesSlots(ctx context.Context, st Client, fanoutSlots chan struct{}) (Client, func(), error) {
+	release, err := s.acquireStore(ctx, st)
+	if err != nil || fanoutSlots == nil {
+		return st, release, err
+	}
+	releaseFanout, err := acquireSlot(ctx, fanoutSlots, s.responseTimeout)
+	if err != nil {
+		release()
+		s.metrics.fanoutBlocked.Inc()
+		return nil, nil, errors.Wrapf(err, "wait for fanout slot for store %s", st)
+	}
+	s.metrics.fanoutActive.Inc()
+
+	var once sync.Once
+	streamDone := func() {
+		once.Do(func() {
+			s.metrics.fanoutActive.Dec()
+			releaseFanout()
+		})
+	}
+	return &fanoutClient{Client: st, streamDone: streamDone}, func() {
+		streamDone()
+		release()
+	}, nil
+}
+
+// fanoutClient calls streamDone once the Series stream of the store ends.
+type fanoutClient struct {
+	Client
+	streamDone func()
+}
+
+func (c *fanoutClient) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	// The slot is kept if the call fails, as it might be retried. It is released once the call is given up.
+	cl, err := c.Client.Series(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &fanoutSeriesClient{Store_SeriesClient: cl, streamDone: c.streamDone}, nil
+}
+
+type fanoutSeriesClient struct {
+	storepb.Store_SeriesClient
+	streamDone func()
+}
+
+func (c *fanoutSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	resp, err := c.Store_SeriesClient.Recv()
+	if err != nil {
+		c.streamDone()
+	}
+	return resp, err
+}
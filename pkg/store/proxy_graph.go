@@ -0,0 +1,124 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// StoreGraph is a directed acyclic graph of stores, e.g. a local store pointing to the remote store it
+// forwards to, which in turn points to an archive store. Stores are identified by their address, or by
+// their string representation for local stores. It is safe for concurrent use.
+type StoreGraph struct {
+	mtx   sync.RWMutex
+	root  string
+	nodes map[string]Client
+	edges map[string][]string
+}
+
+// NewStoreGraph returns a new StoreGraph whose fanout starts at root.
+func NewStoreGraph(root Client) *StoreGraph {
+	key := storeGraphKey(root)
+	return &StoreGraph{
+		root:  key,
+		nodes: map[string]Client{key: root},
+		edges: map[string][]string{},
+	}
+}
+
+func storeGraphKey(st Client) string {
+	if addr, isLocal := st.Addr(); !isLocal && addr != "" {
+		return addr
+	}
+	return st.String()
+}
+
+// AddEdge adds an edge from one store to another. It returns an error if the edge would create a cycle.
+func (g *StoreGraph) AddEdge(from, to Client) error {
+	fromKey, toKey := storeGraphKey(from), storeGraphKey(to)
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if _, ok := g.walk(toKey)[fromKey]; ok {
+		return errors.Errorf("edge from %s to %s creates a cycle", fromKey, toKey)
+	}
+	for _, k := range g.edges[fromKey] {
+		if k == toKey {
+			return nil
+		}
+	}
+	g.nodes[fromKey] = from
+	g.nodes[toKey] = to
+	g.edges[fromKey] = append(g.edges[fromKey], toKey)
+	return nil
+}
+
+// Reachable returns the stores reachable from root, including root, in depth-first order.
+func (g *StoreGraph) Reachable(root Client) []Client {
+	g.mtx.RLock()
+	defer g.mtx.RUnlock()
+
+	var (
+		res     []Client
+		visited = map[string]struct{}{}
+		visit   func(key string)
+	)
+	visit = func(key string) {
+		if _, ok := visited[key]; ok {
+			return
+		}
+		visited[key] = struct{}{}
+		if st, ok := g.nodes[key]; ok {
+			res = append(res, st)
+		}
+		for _, k := range g.edges[key] {
+			visit(k)
+		}
+	}
+	visit(storeGraphKey(root))
+	return res
+}
+
+// walk returns the keys of the stores reachable from the given key, including it. It must be called with
+// the lock held.
+func (g *StoreGraph) walk(key string) map[string]struct{} {
+	visited := map[string]struct{}{}
+	stack := []string{key}
+	for len(stack) > 0 {
+		k := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := visited[k]; ok {
+			continue
+		}
+		visited[k] = struct{}{}
+		stack = append(stack, g.edges[k]...)
+	}
+	return visited
+}
+
+// filter returns the given stores which are reachable from the root of the graph.
+func (g *StoreGraph) filter(stores []Client) []Client {
+	g.mtx.RLock()
+	reachable := g.walk(g.root)
+	g.mtx.RUnlock()
+
+	res := make([]Client, 0, len(stores))
+	for _, st := range stores {
+		if _, ok := reachable[storeGraphKey(st)]; ok {
+			res = append(res, st)
+		}
+	}
+	return res
+}
+
+// WithStoreGraph restricts the stores queried by Series, LabelNames and LabelValues requests to the ones
+// reachable from the root of g, instead of all stores.
+func WithStoreGraph(g *StoreGraph) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.storeGraph = g
+	}
+}
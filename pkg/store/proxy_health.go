@@ -0,0 +1,138 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// recordStoreError records a failed call to the given store for the periodic health report and the blacklist.
+// As for the circuit breaker, calls canceled by the caller or by the failure of another store are not recorded.
+func (s *ProxyStore) recordStoreError(ctx context.Context, st Client, err error) {
+	if ctx.Err() != nil || isContextError(err) {
+		return
+	}
+	addr, _ := st.Addr()
+	if s.blacklist != nil {
+		s.blacklist.recordError(addr)
+	}
+	if s.healthReportInterval <= 0 {
+		return
+	}
+
+	s.storeErrorsMtx.Lock()
+	defer s.storeErrorsMtx.Unlock()
+	s.storeErrors[addr]++
+}
+
+// reportHealth logs the state of each store and whether it had errors since the last report.
+func (s *ProxyStore) reportHealth() {
+	s.storeErrorsMtx.Lock()
+	storeErrors := s.storeErrors
+	s.storeErrors = make(map[string]int, len(storeErrors))
+	s.storeErrorsMtx.Unlock()
+
+	stores := s.stores()
+	level.Info(s.healthReportLogger).Log("msg", "store health report", "stores", len(stores))
+	for _, st := range stores {
+		addr, _ := st.Addr()
+		mint, maxt := st.TimeRange()
+		level.Info(s.healthReportLogger).Log(
+			"msg", "store health",
+			"store_addr", addr,
+			"group_key", st.GroupKey(),
+			"replica_key", st.ReplicaKey(),
+			"min_time", formatStoreTime(mint),
+			"max_time", formatStoreTime(maxt),
+			"label_sets", labelpb.PromLabelSetsToString(st.LabelSets()),
+			"errors", storeErrors[addr] > 0,
+		)
+	}
+}
+
+func formatStoreTime(t int64) string {
+	return time.UnixMilli(t).UTC().Format(time.RFC3339)
+}
+
+// warmupConnections calls Info on all stores concurrently to keep the connections to them open.
+func (s *ProxyStore) warmupConnections() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.connectionWarmupInterval)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, st := range s.stores() {
+		st := st
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := st.Info(ctx, &storepb.InfoRequest{}); err != nil {
+				addr, _ := st.Addr()
+				level.Warn(s.logger).Log("msg", "store connection warmup failed", "store_addr", addr, "err", err)
+				s.metrics.warmupFailures.WithLabelValues(addr).Inc()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// probeHealth calls Info on all stores concurrently and records the time if any of them responded.
+func (s *ProxyStore) probeHealth() {
+	timeout := s.responseTimeout
+	if timeout <= 0 || timeout > s.healthProbeInterval {
+		timeout = s.healthProbeInterval
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, st := range s.stores() {
+		st := st
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := st.Info(ctx, &storepb.InfoRequest{}); err != nil {
+				addr, _ := st.Addr()
+				level.Debug(s.logger).Log("msg", "store health probe failed", "store_addr", addr, "err", err)
+				return
+			}
+			s.lastStoreContact.Store(time.Now())
+		}()
+	}
+	wg.Wait()
+}
+
+// IsReady returns true if a store responded to the health probe within the last response timeout, or within
+// the last two probe intervals if that is longer, so that a single slow probe does not flip the state. The
+// health probe has to be enabled with WithHealthProbe, otherwise the proxy is never ready.
+func (s *ProxyStore) IsReady() bool {
+	last := s.lastStoreContact.Load()
+	if last.IsZero() {
+		return false
+	}
+	window := max(s.responseTimeout, 2*s.healthProbeInterval)
+	return time.Since(last) <= window
+}
+
+// ReadyHandler returns a handler for readiness checks, e.g. by Kubernetes, responding with 200 if the proxy
+// is ready and with 503 otherwise. The querier mounts it at /ready when --store.health-probe-interval is set.
+func (s *ProxyStore) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if !s.IsReady() {
+			http.Error(w, "no store reachable", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ready\n"))
+	})
+}
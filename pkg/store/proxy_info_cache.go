@@ -0,0 +1,162 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"time"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// WithInfoCacheTTL serves Info calls from the last computed response for ttl, instead of iterating over all
+// stores on each call, e.g. for frequent health checks. The response is computed again once it is older than
+// ttl, so changes of the stores are reflected with a delay of up to ttl.
+func WithInfoCacheTTL(ttl time.Duration) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.infoCacheTTL = ttl
+	}
+}
+
+type cachedInfoResponse struct {
+	res       *storepb.InfoResponse
+	createdAt time.Time
+}
+
+// cachedInfo returns a copy of the cached Info response, if the cache is enabled and the response is fresh.
+func (s *ProxyStore) cachedInfo() (*storepb.InfoResponse, bool) {
+	if s.infoCacheTTL <= 0 {
+		return nil, false
+	}
+	cached := s.infoCache.Load()
+	if cached == nil || time.Since(cached.createdAt) >= s.infoCacheTTL {
+		return nil, false
+	}
+	s.metrics.infoCacheHits.Inc()
+	return copyInfoResponse(cached.res), true
+}
+
+func (s *ProxyStore) cacheInfo(res *storepb.InfoResponse) {
+	if s.infoCacheTTL <= 0 {
+		return
+	}
+	s.infoCache.Store(&cachedInfoResponse{res: copyInfoResponse(res), createdAt: time.Now()})
+}
+
+// copyInfoResponse returns a copy of the response which shares no labels with it, so that callers modifying
+// the returned response do not modify the cached one.
+func copyInfoResponse(res *storepb.InfoResponse) *storepb.InfoResponse {
+	cp := *res
+	cp.Labels = copyLabels(res.Labels)
+	cp.LabelSets = copyLabelSets(res.LabelSets)
+	return &cp
+}
+
+// WithLabelSetCacheTTL serves LabelSet calls from the last computed label sets for ttl, instead of iterating
+// over all stores on each call. The label sets are computed lazily on the first call and again once they are
+// older than ttl.
+func WithLabelSetCacheTTL(ttl time.Duration) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.labelSetCacheTTL = ttl
+	}
+}
+
+type cachedLabelSets struct {
+	labelSets []labelpb.ZLabelSet
+	createdAt time.Time
+}
+
+// cachedLabelSets returns a copy of the cached label sets, if the cache is enabled and the label sets are fresh.
+func (s *ProxyStore) cachedLabelSets() ([]labelpb.ZLabelSet, bool) {
+	if s.labelSetCacheTTL <= 0 {
+		return nil, false
+	}
+	cached := s.labelSetCache.Load()
+	if cached == nil || time.Since(cached.createdAt) >= s.labelSetCacheTTL {
+		return nil, false
+	}
+	return copyLabelSets(cached.labelSets), true
+}
+
+func (s *ProxyStore) cacheLabelSets(labelSets []labelpb.ZLabelSet) {
+	if s.labelSetCacheTTL <= 0 {
+		return
+	}
+	s.labelSetCache.Store(&cachedLabelSets{labelSets: copyLabelSets(labelSets), createdAt: time.Now()})
+}
+
+// copyLabelSets returns a deep copy of the label sets.
+func copyLabelSets(labelSets []labelpb.ZLabelSet) []labelpb.ZLabelSet {
+	if labelSets == nil {
+		return nil
+	}
+	res := make([]labelpb.ZLabelSet, len(labelSets))
+	for i, ls := range labelSets {
+		res[i] = labelpb.ZLabelSet{Labels: copyLabels(ls.Labels)}
+	}
+	return res
+}
+
+func copyLabels(lbls []labelpb.ZLabel) []labelpb.ZLabel {
+	if lbls == nil {
+		return nil
+	}
+	res := make([]labelpb.ZLabel, len(lbls))
+	copy(res, lbls)
+	return res
+}
+
+// defaultTimeRangeCacheTTL is the default TTL of the cached time range, matching typical store scrape intervals.
+const defaultTimeRangeCacheTTL = 30 * time.Second
+
+// WithTimeRangeCacheTTL serves TimeRange calls from the last computed time range for ttl, instead of iterating
+// over all stores on each call. The time range is computed again as soon as the store list changes, so only
+// changes of the time ranges of the stores themselves are reflected with a delay. Defaults to 30s. 0 disables
+// the cache.
+func WithTimeRangeCacheTTL(ttl time.Duration) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.timeRangeCacheTTL = ttl
+	}
+}
+
+type cachedTimeRange struct {
+	mint, maxt int64
+	computedAt time.Time
+	// stores and generation are the store list and cache generation the time range was computed for.
+	stores     []Client
+	generation uint64
+}
+
+// cachedTimeRange returns the cached time range, if the cache is enabled and the time range is fresh and was
+// computed for the given stores and cache generation.
+func (s *ProxyStore) cachedTimeRange(stores []Client, generation uint64) (int64, int64, bool) {
+	if s.timeRangeCacheTTL <= 0 {
+		return 0, 0, false
+	}
+	cached := s.timeRangeCache.Load()
+	if cached == nil || time.Since(cached.computedAt) >= s.timeRangeCacheTTL ||
+		cached.generation != generation || !sameStores(cached.stores, stores) {
+		return 0, 0, false
+	}
+	return cached.mint, cached.maxt, true
+}
+
+func (s *ProxyStore) cacheTimeRange(stores []Client, generation uint64, mint, maxt int64) {
+	if s.timeRangeCacheTTL <= 0 || s.cacheGeneration.Load() != generation {
+		return
+	}
+	s.timeRangeCache.Store(&cachedTimeRange{mint: mint, maxt: maxt, computedAt: time.Now(), stores: stores, generation: generation})
+}
+
+// InvalidateCaches drops the cached Info response, label sets, time range and store matching, e.g. when
+// stores are added or removed. Pass it to DynamicClientPool.OnChange to invalidate them on updates of the pool.
+func (s *ProxyStore) InvalidateCaches() {
+	s.cacheGeneration.Inc()
+	s.infoCache.Store(nil)
+	s.labelSetCache.Store(nil)
+	s.timeRangeCache.Store(nil)
+	if s.storeMatchingCache != nil {
+		s.storeMatchingCache.invalidate()
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2/simplelru"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// storeMatchingCache caches the positions, in the store list, of the stores whose external labels and
+// debug metadata match a set of request matchers. Time ranges are not part of the cached selection, they are still checked for every request.
+// Entries are keyed by the version of the store list they were computed for and the full matchers, so that
+// they are never used for another store list or other matchers.
+type storeMatchingCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mtx sync.Mutex
+	lru *lru.LRU[string, storeMatchingEntry]
+	// storeSets holds the last store list seen for each tenant, as tenants can be routed to different stores.
+	// version is incremented whenever the list of any tenant changes, so that versions are never shared by
+	// different lists.
+	storeSets map[string]storeSet
+	version   uint64
+}
+
+type storeSet struct {
+	stores  []Client
+	version uint64
+}
+
+type storeMatchingEntry struct {
+	stores []int
+	// filtered is the number of stores not matching, by cause.
+	filtered map[string]int
+	expires  time.Time
+}
+
+func newStoreMatchingCache(ttl time.Duration, maxEntries int) *storeMatchingCache {
+	// Error is only returned for a non-positive size, which the option does not allow.
+	l, _ := lru.NewLRU[string, storeMatchingEntry](maxEntries, nil)
+	return &storeMatchingCache{ttl: ttl, now: time.Now, lru: l, storeSets: map[string]storeSet{}}
+}
+
+// storeSetVersion returns the version of the given store list of the tenant, assigning it a new version if
+// the list differs from the last one seen for the tenant. Stores are compared by identity, which does not
+// require formatting them.
+func (c *storeMatchingCache) storeSetVersion(tenant string, stores []Client) uint64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	set, ok := c.storeSets[tenant]
+	if !ok || !sameStores(set.stores, stores) {
+		c.version++
+		set = storeSet{stores: stores, version: c.version}
+		c.storeSets[tenant] = set
+	}
+	return set.version
+}
+
+func (c *storeMatchingCache) get(key string) (storeMatchingEntry, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entry, ok := c.lru.Get(key)
+	if !ok {
+		return storeMatchingEntry{}, false
+	}
+	if !c.now().Before(entry.expires) {
+		c.lru.Remove(key)
+		return storeMatchingEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *storeMatchingCache) add(key string, entry storeMatchingEntry) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entry.expires = c.now().Add(c.ttl)
+	c.lru.Add(key, entry)
+}
+
+// invalidate drops all cached selections.
+func (c *storeMatchingCache) invalidate() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.lru.Purge()
+	clear(c.storeSets)
+	c.version++
+}
+
+// sameStores returns true if both lists hold the same clients in the same order. Clients which cannot be
+// compared, like structs holding slices, are never considered the same.
+func sameStores(a, b []Client) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		// Comparing interfaces holding the same non-comparable type panics, so the type is checked first.
+		if t := reflect.TypeOf(a[i]); t != reflect.TypeOf(b[i]) || !t.Comparable() || a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// candidateStores returns the stores among all, the stores of the tenant returned by tenantStores, to check
+// against the request, the number of stores skipped because they are known not to match and the matchers the
+// returned stores still have to be checked against. Stores returned from the cache already match the given
+// matchers, so no matchers are left to check.
+func (s *ProxyStore) candidateStores(ctx context.Context, all []Client, matchers []*labels.Matcher) ([]Client, int, []*labels.Matcher) {
+	if s.storeMatchingCache == nil {
+		return all, 0, matchers
+	}
+
+	// Without tenant routing, all tenants share the same stores.
+	var tenant string
+	if s.tenantStoreRouter != nil {
+		tenant = getTenant(ctx, s.logger)
+	}
+	key := storeMatchingCacheKey(ctx, s.storeMatchingCache.storeSetVersion(tenant, all), matchers)
+	entry, ok := s.storeMatchingCache.get(key)
+	if !ok {
+		entry.filtered = map[string]int{}
+		for i, st := range all {
+			if cause, _ := storeMismatch(ctx, st, false, math.MinInt64, math.MaxInt64, 0, matchers...); cause != "" {
+				entry.filtered[cause]++
+				continue
+			}
+			entry.stores = append(entry.stores, i)
+		}
+		s.storeMatchingCache.add(key, entry)
+	}
+	for cause, n := range entry.filtered {
+		s.metrics.storeFilteredTotal.WithLabelValues(cause).Add(float64(n))
+	}
+
+	stores := make([]Client, 0, len(entry.stores))
+	for _, i := range entry.stores {
+		stores = append(stores, all[i])
+	}
+	return stores, len(all) - len(stores), nil
+}
+
+func storeMatchingCacheKey(ctx context.Context, version uint64, matchers []*labels.Matcher) string {
+	var b strings.Builder
+	b.WriteString(strconv.FormatUint(version, 10))
+	b.WriteByte(0xfe)
+	for _, m := range matchers {
+		b.WriteString(m.String())
+		b.WriteByte(0xff)
+	}
+	if storeDebugMatchers, ok := ctx.Value(StoreMatcherKey).([][]*labels.Matcher); ok {
+		for _, sm := range storeDebugMatchers {
+			b.WriteByte(0xfe)
+			for _, m := range sm {
+				b.WriteString(m.String())
+				b.WriteByte(0xff)
+			}
+		}
+	}
+	return b.String()
+}
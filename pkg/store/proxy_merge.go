@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,6 +20,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/model/labels"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
 
 	"github.com/thanos-io/thanos/pkg/losertree"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
@@ -36,6 +39,14 @@ type responseDeduplicator struct {
 
 	prev *storepb.SeriesResponse
 	ok   bool
+
+	// bypassMetricNames holds metric names whose series are forwarded as-is from every store
+	// instead of being merged into one series.
+	bypassMetricNames map[string]struct{}
+
+	// chunkOverlapMs and chunksDeduplicated record chunk overlap statistics of merged series, if set.
+	chunkOverlapMs     prometheus.Counter
+	chunksDeduplicated prometheus.Counter
 }
 
 // NewResponseDeduplicator returns a wrapper around a loser tree that merges duplicated series messages into one.
@@ -73,7 +84,7 @@ func (d *responseDeduplicator) Next() bool {
 			d.ok = d.h.Next()
 			if !d.ok {
 				if len(d.bufferedSameSeries) > 0 {
-					d.bufferedResp = append(d.bufferedResp, chainSeriesAndRemIdenticalChunks(d.bufferedSameSeries))
+					d.flushSameSeries()
 				}
 				return len(d.bufferedResp) > 0
 			}
@@ -101,15 +112,86 @@ func (d *responseDeduplicator) Next() bool {
 			continue
 		}
 
-		d.bufferedResp = append(d.bufferedResp, chainSeriesAndRemIdenticalChunks(d.bufferedSameSeries))
+		d.flushSameSeries()
 		d.prev = s
 
 		return true
 	}
 }
 
+// flushSameSeries moves the buffered copies of the same series into the response buffer. Copies are merged
+// into a single series unless the metric name is in the bypass set, in which case every copy is forwarded.
+func (d *responseDeduplicator) flushSameSeries() {
+	if len(d.bypassMetricNames) > 0 {
+		name := labelpb.ZLabelsToPromLabels(d.bufferedSameSeries[0].GetSeries().Labels).Get(labels.MetricName)
+		if _, ok := d.bypassMetricNames[name]; ok {
+			d.bufferedResp = append(d.bufferedResp, d.bufferedSameSeries...)
+			return
+		}
+	}
+	merged := chainSeriesAndRemIdenticalChunks(d.bufferedSameSeries)
+	if d.chunkOverlapMs != nil && len(d.bufferedSameSeries) > 1 {
+		d.recordChunkOverlap(d.bufferedSameSeries, merged)
+	}
+	d.bufferedResp = append(d.bufferedResp, merged)
+}
+
+// recordChunkOverlap records the time range covered by chunks of more than one copy of the series, counted
+// once per additional copy, and the number of chunks dropped when merging the copies.
+func (d *responseDeduplicator) recordChunkOverlap(series []*storepb.SeriesResponse, merged *storepb.SeriesResponse) {
+	var (
+		totalChunks int
+		covered     int64
+		all         []storepb.AggrChunk
+	)
+	for _, s := range series {
+		chunks := s.GetSeries().Chunks
+		totalChunks += len(chunks)
+		covered += chunksTimeRangeUnion(chunks)
+		all = append(all, chunks...)
+	}
+	if totalChunks == 0 {
+		return
+	}
+	d.chunkOverlapMs.Add(float64(covered - chunksTimeRangeUnion(all)))
+	d.chunksDeduplicated.Add(float64(totalChunks - len(merged.GetSeries().Chunks)))
+}
+
+// chunksTimeRangeUnion returns the length in milliseconds of the union of the time ranges of the given chunks.
+func chunksTimeRangeUnion(chunks []storepb.AggrChunk) int64 {
+	if len(chunks) == 0 {
+		return 0
+	}
+	ranges := make([][2]int64, 0, len(chunks))
+	for _, c := range chunks {
+		ranges = append(ranges, [2]int64{c.MinTime, c.MaxTime})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+
+	var total int64
+	cur := ranges[0]
+	for _, r := range ranges[1:] {
+		if r[0] > cur[1] {
+			total += cur[1] - cur[0]
+			cur = r
+			continue
+		}
+		if r[1] > cur[1] {
+			cur[1] = r[1]
+		}
+	}
+	return total + cur[1] - cur[0]
+}
+
+// chunkDedupKey identifies identical chunks. The encoding is part of the key, as chunks of native histograms
+// and of floats covering the same time range are different chunks even if their checksums collide.
+type chunkDedupKey struct {
+	hash     uint64
+	encoding storepb.Chunk_Encoding
+}
+
 func chainSeriesAndRemIdenticalChunks(series []*storepb.SeriesResponse) *storepb.SeriesResponse {
-	chunkDedupMap := map[uint64]*storepb.AggrChunk{}
+	chunkDedupMap := map[chunkDedupKey]*storepb.AggrChunk{}
 
 	for _, s := range series {
 		for _, chk := range s.GetSeries().Chunks {
@@ -119,14 +201,14 @@ func chainSeriesAndRemIdenticalChunks(series []*storepb.SeriesResponse) *storepb
 				if field == nil {
 					continue
 				}
-				hash := field.Hash
-				if hash == 0 {
-					hash = xxhash.Sum64(field.Data)
+				key := chunkDedupKey{hash: field.Hash, encoding: field.Type}
+				if key.hash == 0 {
+					key.hash = xxhash.Sum64(field.Data)
 				}
 
-				if _, ok := chunkDedupMap[hash]; !ok {
+				if _, ok := chunkDedupMap[key]; !ok {
 					chk := chk
-					chunkDedupMap[hash] = &chk
+					chunkDedupMap[key] = &chk
 					break
 				}
 			}
@@ -301,6 +383,7 @@ func newLazyRespSet(
 	shardMatcher *storepb.ShardMatcher,
 	applySharding bool,
 	emptyStreamResponses prometheus.Counter,
+	seriesLimit *seriesLimiter,
 ) respSet {
 	bufferedResponses := []*storepb.SeriesResponse{}
 	bufferedResponsesMtx := &sync.Mutex{}
@@ -364,7 +447,7 @@ func newLazyRespSet(
 				if t != nil && !t.Stop() {
 					if errors.Is(err, context.Canceled) {
 						// The per-Recv timeout has been reached.
-						rerr = errors.Wrapf(err, "failed to receive any data in %s from %s", l.frameTimeout, st)
+						rerr = errors.Wrapf(err, frameTimeoutMsg+" in %s from %s", l.frameTimeout, st)
 					}
 				} else {
 					rerr = errors.Wrapf(err, "receive series from %s", st)
@@ -389,6 +472,7 @@ func newLazyRespSet(
 
 			if resp.GetSeries() != nil {
 				seriesStats.Count(resp)
+				seriesLimit.add()
 			}
 
 			l.bufferedResponsesMtx.Lock()
@@ -434,9 +518,12 @@ func newAsyncRespSet(
 	frameTimeout time.Duration,
 	retrievalStrategy RetrievalStrategy,
 	buffers *sync.Pool,
+	maxBufferSize int,
 	shardInfo *storepb.ShardInfo,
 	logger log.Logger,
 	emptyStreamResponses prometheus.Counter,
+	seriesLimit *seriesLimiter,
+	callOpts ...grpc.CallOption,
 ) (respSet, error) {
 
 	var span opentracing.Span
@@ -460,14 +547,18 @@ func newAsyncRespSet(
 
 	seriesCtx, closeSeries = context.WithCancel(seriesCtx)
 
-	shardMatcher := shardInfo.Matcher(buffers)
+	if t := st.ResponseTimeout(); t > 0 {
+		frameTimeout = t
+	}
+
+	shardMatcher := shardInfo.MatcherWithMaxBufferSize(buffers, maxBufferSize)
 
 	applySharding := shardInfo != nil && !st.SupportsSharding()
 	if applySharding {
 		level.Debug(logger).Log("msg", "Applying series sharding in the proxy since there is not support in the underlying store", "store", st.String())
 	}
 
-	cl, err := st.Series(seriesCtx, req)
+	cl, err := st.Series(seriesCtx, req, callOpts...)
 	if err != nil {
 		err = errors.Wrapf(err, "fetch series for %s %s", storeID, st)
 
@@ -501,6 +592,7 @@ func newAsyncRespSet(
 			shardMatcher,
 			applySharding,
 			emptyStreamResponses,
+			seriesLimit,
 		), nil
 	case EagerRetrieval:
 		return newEagerRespSet(
@@ -514,6 +606,7 @@ func newAsyncRespSet(
 			applySharding,
 			emptyStreamResponses,
 			labelsToRemove,
+			seriesLimit,
 		), nil
 	default:
 		panic(fmt.Sprintf("unsupported retrieval strategy %s", retrievalStrategy))
@@ -565,6 +658,7 @@ func newEagerRespSet(
 	applySharding bool,
 	emptyStreamResponses prometheus.Counter,
 	removeLabels map[string]struct{},
+	seriesLimit *seriesLimiter,
 ) respSet {
 	ret := &eagerRespSet{
 		span:              span,
@@ -627,7 +721,7 @@ func newEagerRespSet(
 					<-t.C // Drain the channel if it was already stopped.
 					if errors.Is(err, context.Canceled) {
 						// The per-Recv timeout has been reached.
-						rerr = errors.Wrapf(err, "failed to receive any data in %s from %s", l.frameTimeout, storeName)
+						rerr = errors.Wrapf(err, frameTimeoutMsg+" in %s from %s", l.frameTimeout, storeName)
 					}
 				} else {
 					rerr = errors.Wrapf(err, "receive series from %s", storeName)
@@ -647,6 +741,7 @@ func newEagerRespSet(
 
 			if resp.GetSeries() != nil {
 				seriesStats.Count(resp)
+				seriesLimit.add()
 			}
 
 			l.bufferedResponses = append(l.bufferedResponses, resp)
@@ -756,6 +851,120 @@ func (l *eagerRespSet) StoreLabels() map[string]struct{} {
 	return l.storeLabels
 }
 
+// closeCallbackRespSet calls the given callback once the wrapped respSet is closed for the first time.
+type closeCallbackRespSet struct {
+	respSet
+
+	once    sync.Once
+	onClose func()
+}
+
+func newCloseCallbackRespSet(set respSet, onClose func()) respSet {
+	return &closeCallbackRespSet{respSet: set, onClose: onClose}
+}
+
+func (c *closeCallbackRespSet) Close() {
+	c.once.Do(func() {
+		c.respSet.Close()
+		c.onClose()
+	})
+}
+
+// warningCallbackRespSet calls onWarning on the first warning received from a store.
+type warningCallbackRespSet struct {
+	respSet
+
+	warned    bool
+	onWarning func()
+}
+
+func (w *warningCallbackRespSet) Next() bool {
+	if !w.respSet.Next() {
+		return false
+	}
+	if !w.warned && w.respSet.At().GetWarning() != "" {
+		w.warned = true
+		w.onWarning()
+	}
+	return true
+}
+
+// Statuses of the Series calls of the stores.
+const (
+	storeStatusSuccess = "success"
+	storeStatusError   = "error"
+	storeStatusTimeout = "timeout"
+)
+
+// frameTimeoutMsg starts the warning sent when a store did not respond within the frame timeout.
+const frameTimeoutMsg = "failed to receive any data"
+
+// durationRespSet observes the duration of a Series call of a store, from the creation of the respSet until
+// its responses are consumed or it is closed.
+type durationRespSet struct {
+	respSet
+
+	start    time.Time
+	duration *prometheus.HistogramVec
+	addr     string
+	status   string
+	once     sync.Once
+}
+
+func newDurationRespSet(set respSet, start time.Time, duration *prometheus.HistogramVec, addr string) respSet {
+	return &durationRespSet{respSet: set, start: start, duration: duration, addr: addr, status: storeStatusSuccess}
+}
+
+func (d *durationRespSet) Next() bool {
+	if !d.respSet.Next() {
+		d.observe()
+		return false
+	}
+	if w := d.respSet.At().GetWarning(); w != "" {
+		if strings.Contains(w, frameTimeoutMsg) {
+			d.status = storeStatusTimeout
+		} else if d.status == storeStatusSuccess {
+			d.status = storeStatusError
+		}
+	}
+	return true
+}
+
+func (d *durationRespSet) Close() {
+	d.observe()
+	d.respSet.Close()
+}
+
+func (d *durationRespSet) observe() {
+	d.once.Do(func() {
+		d.duration.WithLabelValues(d.addr, d.status).Observe(time.Since(d.start).Seconds())
+	})
+}
+
+// seriesLimiter counts the series received from the stores of a Series request. Series are counted by the
+// goroutines receiving the streams of the stores, so that the limit also applies while responses are buffered.
+// Once more than limit series were received, the calls of all stores are canceled.
+type seriesLimiter struct {
+	limit    uint64
+	received atomic.Uint64
+	cancel   context.CancelFunc
+}
+
+// add counts a received series. It is a no-op on a nil limiter.
+func (l *seriesLimiter) add() {
+	if l == nil {
+		return
+	}
+	if l.received.Inc() > l.limit {
+		l.cancel()
+	}
+}
+
+// exceeded returns whether more series than the limit were received.
+func (l *seriesLimiter) exceeded() bool {
+	return l != nil && l.received.Load() > l.limit
+}
+
 type respSet interface {
 	Close()
 	At() *storepb.SeriesResponse
@@ -0,0 +1,77 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+)
+
+// seriesOverlap tracks the series streamed by the stores of each group to compute how much they overlap.
+// It is not safe for concurrent use, the tracked respSets have to be consumed from a single goroutine.
+type seriesOverlap struct {
+	groups map[string]*groupSeriesOverlap
+}
+
+type groupSeriesOverlap struct {
+	stores int
+	total  int
+	unique map[uint64]struct{}
+}
+
+func newSeriesOverlap() *seriesOverlap {
+	return &seriesOverlap{groups: map[string]*groupSeriesOverlap{}}
+}
+
+// track returns a respSet which records the series of the given respSet for the given group.
+func (o *seriesOverlap) track(groupKey string, set respSet) respSet {
+	g, ok := o.groups[groupKey]
+	if !ok {
+		g = &groupSeriesOverlap{unique: map[uint64]struct{}{}}
+		o.groups[groupKey] = g
+	}
+	g.stores++
+	return &overlapRespSet{respSet: set, group: g}
+}
+
+// ratios returns the overlap ratio of each group with at least two stores that returned series.
+// The ratio is 1 if every store of the group returned all series of the group, and 0 if the stores
+// returned disjoint series.
+func (o *seriesOverlap) ratios() map[string]float64 {
+	ratios := make(map[string]float64, len(o.groups))
+	for groupKey, g := range o.groups {
+		if g.stores < 2 || len(g.unique) == 0 {
+			continue
+		}
+		ratios[groupKey] = float64(g.total-len(g.unique)) / float64(len(g.unique)*(g.stores-1))
+	}
+	return ratios
+}
+
+type overlapRespSet struct {
+	respSet
+
+	group *groupSeriesOverlap
+}
+
+func (o *overlapRespSet) Next() bool {
+	if !o.respSet.Next() {
+		return false
+	}
+	if series := o.respSet.At().GetSeries(); series != nil {
+		o.group.total++
+		o.group.unique[labelpb.ZLabelsToPromLabels(series.Labels).Hash()] = struct{}{}
+	}
+	return true
+}
+
+// reportSeriesOverlap logs and records the series overlap ratio of each store group.
+func (s *ProxyStore) reportSeriesOverlap(logger log.Logger, overlap *seriesOverlap) {
+	for groupKey, ratio := range overlap.ratios() {
+		level.Debug(logger).Log("msg", "Series: store group overlap", "group_key", groupKey, "overlap_ratio", ratio)
+		s.metrics.seriesOverlapRatio.WithLabelValues(groupKey).Observe(ratio)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"sync"
+)
+
+// DynamicClientPool is a set of store clients which can be updated while the ProxyStore using it serves
+// requests. Pass its Snapshot method as the stores function of NewProxyStore.
+type DynamicClientPool struct {
+	mtx      sync.RWMutex
+	clients  []Client
+	onChange []func()
+}
+
+// NewDynamicClientPool returns a pool with the given clients.
+func NewDynamicClientPool(initial []Client) *DynamicClientPool {
+	return &DynamicClientPool{clients: append([]Client(nil), initial...)}
+}
+
+// OnChange registers fn to be called after each update of the pool, e.g. ProxyStore.InvalidateCaches.
+func (p *DynamicClientPool) OnChange(fn func()) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.onChange = append(p.onChange, fn)
+}
+
+// Add adds the client to the pool, replacing the client with the same address if any.
+func (p *DynamicClientPool) Add(c Client) {
+	defer p.changed()
+
+	addr, _ := c.Addr()
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for i, existing := range p.clients {
+		if existingAddr, _ := existing.Addr(); existingAddr == addr {
+			p.clients[i] = c
+			return
+		}
+	}
+	p.clients = append(p.clients, c)
+}
+
+// Remove removes the client with the given address from the pool.
+func (p *DynamicClientPool) Remove(addr string) {
+	defer p.changed()
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	clients := make([]Client, 0, len(p.clients))
+	for _, c := range p.clients {
+		if cAddr, _ := c.Addr(); cAddr != addr {
+			clients = append(clients, c)
+		}
+	}
+	p.clients = clients
+}
+
+// Snapshot returns the clients currently in the pool. The returned slice is not modified by later updates.
+func (p *DynamicClientPool) Snapshot() []Client {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	return append([]Client(nil), p.clients...)
+}
+
+// changed calls the functions registered with OnChange. It must be called without the lock held.
+func (p *DynamicClientPool) changed() {
+	p.mtx.RLock()
+	onChange := p.onChange
+	p.mtx.RUnlock()
+
+	for _, fn := range onChange {
+		fn()
+	}
+}
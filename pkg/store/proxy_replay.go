@@ -0,0 +1,168 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// replayKey marks the context of replayed Series requests, so that they are not captured again.
+const replayKey = ctxKey(1)
+
+// WithRequestReplay keeps the last n Series requests, so that they can be executed again with ReplayRequest
+// or the handler returned by ReplayHandler for debugging.
+func WithRequestReplay(n int) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		if n <= 0 {
+			s.requestReplay = nil
+			return
+		}
+		s.requestReplay = &requestRing{requests: make([][]byte, n)}
+	}
+}
+
+// requestRing is a ring buffer of marshaled Series requests.
+type requestRing struct {
+	mtx      sync.Mutex
+	requests [][]byte
+	next     int
+	count    int
+}
+
+func (r *requestRing) add(req *storepb.SeriesRequest) error {
+	b, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.requests[r.next] = b
+	r.next = (r.next + 1) % len(r.requests)
+	if r.count < len(r.requests) {
+		r.count++
+	}
+	return nil
+}
+
+// get returns the request at the given index, 0 being the most recent one.
+func (r *requestRing) get(index int) (*storepb.SeriesRequest, error) {
+	r.mtx.Lock()
+	if index < 0 || index >= r.count {
+		r.mtx.Unlock()
+		return nil, errors.Errorf("no request at index %d, %d requests captured", index, r.count)
+	}
+	b := r.requests[(r.next-1-index+len(r.requests))%len(r.requests)]
+	r.mtx.Unlock()
+
+	req := &storepb.SeriesRequest{}
+	if err := req.Unmarshal(b); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// ReplayRequest executes again the captured Series request at the given index, 0 being the most recent one,
+// sending its responses to srv. Request replay has to be enabled with WithRequestReplay.
+func (s *ProxyStore) ReplayRequest(ctx context.Context, index int, srv storepb.Store_SeriesServer) error {
+	if s.requestReplay == nil {
+		return errors.New("request replay is not enabled")
+	}
+	req, err := s.requestReplay.get(index)
+	if err != nil {
+		return err
+	}
+	return s.Series(req, &replaySeriesServer{Store_SeriesServer: srv, ctx: context.WithValue(ctx, replayKey, true)})
+}
+
+type replaySeriesServer struct {
+	storepb.Store_SeriesServer
+	ctx context.Context
+}
+
+func (r *replaySeriesServer) Context() context.Context {
+	return r.ctx
+}
+
+// ReplayHandler returns a handler for POST /debug/replay/{index} requests, replaying the captured Series
+// request at the given index and streaming its responses as newline-delimited JSON. The querier mounts it
+// when --store.request-replay-size is set.
+func (s *ProxyStore) ReplayHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/debug/replay/"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid request index: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		srv := &jsonSeriesServer{ctx: r.Context(), w: w, enc: json.NewEncoder(w)}
+		if err := s.ReplayRequest(r.Context(), index, srv); err != nil {
+			if !srv.written {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			level.Warn(s.logger).Log("msg", "replaying request failed", "index", index, "err", err)
+		}
+	})
+}
+
+// jsonSeriesServer writes Series responses as newline-delimited JSON. Headers and trailers are not sent over
+// HTTP, so setting them is a no-op.
+type jsonSeriesServer struct {
+	ctx     context.Context
+	w       http.ResponseWriter
+	enc     *json.Encoder
+	written bool
+}
+
+func (j *jsonSeriesServer) Context() context.Context {
+	return j.ctx
+}
+
+func (j *jsonSeriesServer) SetHeader(metadata.MD) error { return nil }
+
+func (j *jsonSeriesServer) SendHeader(metadata.MD) error { return nil }
+
+func (j *jsonSeriesServer) SetTrailer(metadata.MD) {}
+
+func (j *jsonSeriesServer) SendMsg(m interface{}) error {
+	resp, ok := m.(*storepb.SeriesResponse)
+	if !ok {
+		return errors.Errorf("unexpected message type %T", m)
+	}
+	return j.Send(resp)
+}
+
+func (j *jsonSeriesServer) RecvMsg(interface{}) error {
+	return errors.New("receiving messages is not supported")
+}
+
+func (j *jsonSeriesServer) Send(resp *storepb.SeriesResponse) error {
+	j.written = true
+	// Encode writes a newline after each response.
+	if err := j.enc.Encode(resp); err != nil {
+		return err
+	}
+	if f, ok := j.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
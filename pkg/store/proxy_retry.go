@@ -0,0 +1,61 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// WithRetry retries Series calls to stores failing with the Unavailable or ResourceExhausted gRPC codes, up to
+// maxAttempts calls in total. The backoff between attempts starts at backoff and doubles after each attempt.
+// No retry is made if its backoff would exceed the response timeout measured from the first attempt.
+func WithRetry(maxAttempts int, backoff time.Duration) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		s.retryMaxAttempts = maxAttempts
+		s.retryBackoff = backoff
+	}
+}
+
+// newAsyncRespSetWithRetry calls newAsyncRespSet, retrying transient errors if enabled with WithRetry.
+func (s *ProxyStore) newAsyncRespSetWithRetry(ctx context.Context, st Client, req *storepb.SeriesRequest, shardInfo *storepb.ShardInfo, seriesLimit *seriesLimiter, logger log.Logger) (respSet, error) {
+	var (
+		deadline = time.Now().Add(s.responseTimeout)
+		backoff  = s.retryBackoff
+	)
+	for attempt := 1; ; attempt++ {
+		set, err := newAsyncRespSet(ctx, st, req, s.responseTimeout, s.retrievalStrategy, &s.buffers, s.bufferMaxSize, shardInfo, logger, s.metrics.emptyStreamResponses, seriesLimit, s.storeCallOptions(st)...)
+		if err == nil || attempt >= s.retryMaxAttempts {
+			return set, err
+		}
+		code := status.Code(errors.Cause(err))
+		if code != codes.Unavailable && code != codes.ResourceExhausted {
+			return nil, err
+		}
+		if s.responseTimeout > 0 && time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+
+		addr, _ := st.Addr()
+		s.metrics.fanoutRetries.WithLabelValues(addr, code.String()).Inc()
+		level.Debug(logger).Log("msg", "retrying store Series call", "store", st, "attempt", attempt, "backoff", backoff, "err", err)
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// speculativeClient queries the first of the given replicas of a store group and starts a
+// request to the next replica each time the delay passes without any replica sending its first
+// response. The first replica to respond is used and the requests to the other replicas are canceled.
+type speculativeClient struct {
+	Client
+
+	replicas []Client
+	delay    time.Duration
+	// maxHedges limits the number of requests started because of the delay, if positive. Requests
+	// replacing failed replicas are not limited.
+	maxHedges int
+}
+
+// speculativeClients replaces the stores of each group with more than one replica by a single
+// speculativeClient. Stores without a group key are returned as is.
+func speculativeClients(stores []Client, delay time.Duration, maxHedges int) []Client {
+	groups := make(map[string][]Client)
+	for _, st := range stores {
+		if st.GroupKey() != "" {
+			groups[st.GroupKey()] = append(groups[st.GroupKey()], st)
+		}
+	}
+
+	clients := make([]Client, 0, len(stores))
+	for _, st := range stores {
+		replicas := groups[st.GroupKey()]
+		if len(replicas) < 2 {
+			clients = append(clients, st)
+			continue
+		}
+		if replicas[0] == st {
+			clients = append(clients, &speculativeClient{Client: st, replicas: replicas, delay: delay, maxHedges: maxHedges})
+		}
+	}
+	return clients
+}
+
+type speculativeAttempt struct {
+	replica int
+	cl      storepb.Store_SeriesClient
+	first   *storepb.SeriesResponse
+	err     error
+}
+
+// Series returns immediately and races the replicas in the background, so that the fan-out to other
+// stores is not delayed. The returned stream blocks on Recv until a replica responded.
+func (c *speculativeClient) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	cl := &speculativeSeriesClient{ctx: ctx, done: make(chan struct{})}
+	go func() {
+		defer close(cl.done)
+		cl.winner, cl.cancel, cl.err = c.race(ctx, req, opts...)
+	}()
+	return cl, nil
+}
+
+// race queries the replicas and returns the attempt of the first one to respond, and the function
+// canceling its request.
+func (c *speculativeClient) race(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (speculativeAttempt, context.CancelFunc, error) {
+	results := make(chan speculativeAttempt, len(c.replicas))
+	cancels := make([]context.CancelFunc, 0, len(c.replicas))
+	startNext := func() {
+		replica := len(cancels)
+		attemptCtx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		go func() {
+			cl, err := c.replicas[replica].Series(attemptCtx, req, opts...)
+			if err != nil {
+				results <- speculativeAttempt{replica: replica, err: err}
+				return
+			}
+			first, err := cl.Recv()
+			results <- speculativeAttempt{replica: replica, cl: cl, first: first, err: err}
+		}()
+	}
+	cancelAllBut := func(replica int) {
+		for i, cancel := range cancels {
+			if i != replica {
+				cancel()
+			}
+		}
+	}
+
+	ticker := time.NewTicker(c.delay)
+	defer ticker.Stop()
+
+	startNext()
+	pending := 1
+	hedges := 0
+	for {
+		select {
+		case <-ctx.Done():
+			cancelAllBut(-1)
+			return speculativeAttempt{}, nil, ctx.Err()
+		case <-ticker.C:
+			if len(cancels) < len(c.replicas) && (c.maxHedges <= 0 || hedges < c.maxHedges) {
+				startNext()
+				pending++
+				hedges++
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil || res.err == io.EOF {
+				cancelAllBut(res.replica)
+				return res, cancels[res.replica], nil
+			}
+			cancels[res.replica]()
+
+			// The replica failed, so query the next one without waiting for the delay.
+			if len(cancels) < len(c.replicas) {
+				startNext()
+				pending++
+				continue
+			}
+			if pending == 0 {
+				return speculativeAttempt{}, nil, res.err
+			}
+		}
+	}
+}
+
+// speculativeSeriesClient streams the responses of the replica which won the race.
+type speculativeSeriesClient struct {
+	ctx  context.Context
+	done chan struct{}
+
+	winner   speculativeAttempt
+	cancel   context.CancelFunc
+	err      error
+	replayed bool
+}
+
+func (c *speculativeSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	<-c.done
+	if c.err != nil {
+		return nil, c.err
+	}
+	if !c.replayed {
+		c.replayed = true
+		if c.winner.err != nil {
+			c.cancel()
+		}
+		return c.winner.first, c.winner.err
+	}
+	resp, err := c.winner.cl.Recv()
+	if err != nil {
+		c.cancel()
+	}
+	return resp, err
+}
+
+func (c *speculativeSeriesClient) Header() (metadata.MD, error) {
+	<-c.done
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.winner.cl.Header()
+}
+
+func (c *speculativeSeriesClient) Trailer() metadata.MD {
+	<-c.done
+	if c.err != nil {
+		return nil
+	}
+	return c.winner.cl.Trailer()
+}
+
+func (c *speculativeSeriesClient) CloseSend() error {
+	<-c.done
+	if c.err != nil {
+		return nil
+	}
+	return c.winner.cl.CloseSend()
+}
+
+func (c *speculativeSeriesClient) Context() context.Context { return c.ctx }
+
+func (c *speculativeSeriesClient) SendMsg(m interface{}) error {
+	<-c.done
+	if c.err != nil {
+		return c.err
+	}
+	return c.winner.cl.SendMsg(m)
+}
+
+func (c *speculativeSeriesClient) RecvMsg(m interface{}) error {
+	<-c.done
+	if c.err != nil {
+		return c.err
+	}
+	return c.winner.cl.RecvMsg(m)
+}
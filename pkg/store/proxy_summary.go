@@ -0,0 +1,61 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// seriesSummary holds the statistics of a single Series call logged by the query summary log.
+type seriesSummary struct {
+	start          time.Time
+	tenant         string
+	storesQueried  int
+	storesFiltered int
+	seriesReturned int
+	warnings       int
+
+	// matchingEnd and setupEnd mark the end of the store selection and of the start of the store streams.
+	matchingEnd time.Time
+	setupEnd    time.Time
+}
+
+// logSeriesSummary logs a single line summarizing the given Series call.
+func logSeriesSummary(logger log.Logger, r *storepb.SeriesRequest, summary *seriesSummary) {
+	lvl := level.Info(logger)
+	if summary.warnings > 0 {
+		lvl = level.Warn(logger)
+	}
+	lvl.Log(
+		"msg", "Series: query summary",
+		"duration", time.Since(summary.start),
+		"stores_queried", summary.storesQueried,
+		"stores_filtered", summary.storesFiltered,
+		"series_returned", summary.seriesReturned,
+		"warnings_count", summary.warnings,
+		"partial_response_strategy", r.PartialResponseStrategy,
+		"shard_info_set", r.ShardInfo != nil,
+		"tenant", summary.tenant,
+		"matcher_count", len(r.Matchers),
+	)
+}
+
+// reportLatencyAttribution logs and records the time a Series call spent in each of its phases.
+func (s *ProxyStore) reportLatencyAttribution(logger log.Logger, summary *seriesSummary, mergeEnd time.Time) {
+	var (
+		matchingDuration = summary.matchingEnd.Sub(summary.start)
+		setupDuration    = summary.setupEnd.Sub(summary.matchingEnd)
+		mergeDuration    = mergeEnd.Sub(summary.setupEnd)
+	)
+	s.metrics.seriesMatchingDuration.Observe(matchingDuration.Seconds())
+	s.metrics.seriesSetupDuration.Observe(setupDuration.Seconds())
+	s.metrics.seriesMergeDuration.Observe(mergeDuration.Seconds())
+	level.Debug(logger).Log("msg", "Series: latency attribution",
+		"matching_duration", matchingDuration, "setup_duration", setupDuration, "merge_duration", mergeDuration)
+}
@@ -4,13 +4,20 @@
 package store
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 
 	"math"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -21,23 +28,41 @@ import (
 	"github.com/gogo/protobuf/types"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"go.opentelemetry.io/otel/baggage"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/atomic"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 
 	"github.com/efficientgo/core/testutil"
 
 	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/info/infopb"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/server/http/middleware"
 	"github.com/thanos-io/thanos/pkg/store/labelpb"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	storetestutil "github.com/thanos-io/thanos/pkg/store/storepb/testutil"
+	"github.com/thanos-io/thanos/pkg/tenancy"
 	"github.com/thanos-io/thanos/pkg/testutil/custom"
+	"github.com/thanos-io/thanos/pkg/tracing"
+	"github.com/thanos-io/thanos/pkg/tracing/migration"
 )
 
 type mockedSeriesServer struct {
@@ -1842,7 +1867,7 @@ func TestStoreMatches(t *testing.T) {
 		},
 	} {
 		t.Run("", func(t *testing.T) {
-			ok, reason := storeMatches(context.TODO(), c.s, true, c.mint, c.maxt, c.ms...)
+			ok, reason := storeMatches(context.TODO(), c.s, true, c.mint, c.maxt, 0, c.ms...)
 			testutil.Equals(t, c.expectedMatch, ok)
 			testutil.Equals(t, c.expectedReason, reason)
 
@@ -1896,6 +1921,7 @@ func (s *storeSeriesServer) Context() context.Context {
 
 // mockedStoreAPI is test gRPC store API client.
 type mockedStoreAPI struct {
+	RespInfo        *storepb.InfoResponse
 	RespSeries      []*storepb.SeriesResponse
 	RespLabelValues *storepb.LabelValuesResponse
 	RespLabelNames  *storepb.LabelNamesResponse
@@ -1907,6 +1933,7 @@ type mockedStoreAPI struct {
 	LastSeriesReq      *storepb.SeriesRequest
 	LastLabelValuesReq *storepb.LabelValuesRequest
 	LastLabelNamesReq  *storepb.LabelNamesRequest
+	InfoCalls          int
 
 	// injectedError will be injected into Recv() if not nil.
 	injectedError      error
@@ -1914,6 +1941,10 @@ type mockedStoreAPI struct {
 }
 
 func (s *mockedStoreAPI) Info(context.Context, *storepb.InfoRequest, ...grpc.CallOption) (*storepb.InfoResponse, error) {
+	s.InfoCalls++
+	if s.RespInfo != nil {
+		return s.RespInfo, nil
+	}
 	return nil, status.Error(codes.Unimplemented, "not implemented")
 }
 
@@ -2023,8 +2054,8 @@ func benchProxySeries(t testutil.TB, totalSamples, totalSeries int) {
 		metrics:           newProxyStoreMetrics(nil),
 		responseTimeout:   5 * time.Second,
 		retrievalStrategy: EagerRetrieval,
-		tsdbSelector:      DefaultSelector,
 	}
+	store.tsdbSelector.Store(DefaultSelector)
 
 	var allResps []*storepb.SeriesResponse
 	var expected []*storepb.Series
@@ -2152,8 +2183,8 @@ func TestProxyStore_NotLeakingOnPrematureFinish(t *testing.T) {
 		metrics:           newProxyStoreMetrics(nil),
 		responseTimeout:   0,
 		retrievalStrategy: EagerRetrieval,
-		tsdbSelector:      DefaultSelector,
 	}
+	p.tsdbSelector.Store(DefaultSelector)
 
 	t.Run("failling send", func(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
@@ -2281,6 +2312,30 @@ func TestDedupRespHeap_Deduplication(t *testing.T) {
 				testutil.Equals(t, false, h.Next())
 			},
 		},
+		{
+			tname: "keeps chunks with the same data but different encodings",
+			responses: []*storepb.SeriesResponse{
+				storepb.NewSeriesResponse(&storepb.Series{
+					Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("foo", "bar")),
+					Chunks: []storepb.AggrChunk{{Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: []byte(`abcdefgh`)}}},
+				}),
+				storepb.NewSeriesResponse(&storepb.Series{
+					Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("foo", "bar")),
+					Chunks: []storepb.AggrChunk{{Raw: &storepb.Chunk{Type: storepb.Chunk_HISTOGRAM, Data: []byte(`abcdefgh`)}}},
+				}),
+			},
+			testFn: func(responses []*storepb.SeriesResponse, h *responseDeduplicator) {
+				testutil.Equals(t, true, h.Next())
+				testutil.Equals(t, storepb.NewSeriesResponse(&storepb.Series{
+					Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("foo", "bar")),
+					Chunks: []storepb.AggrChunk{
+						{Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: []byte(`abcdefgh`)}},
+						{Raw: &storepb.Chunk{Type: storepb.Chunk_HISTOGRAM, Data: []byte(`abcdefgh`)}},
+					},
+				}), h.At())
+				testutil.Equals(t, false, h.Next())
+			},
+		},
 	} {
 		t.Run(tcase.tname, func(t *testing.T) {
 			h := NewResponseDeduplicator(NewProxyResponseLoserTree(
@@ -2295,3 +2350,4159 @@ func TestDedupRespHeap_Deduplication(t *testing.T) {
 	}
 
 }
+
+func TestDedupRespHeap_DeduplicationBypass(t *testing.T) {
+	t.Parallel()
+
+	seriesResp := func(lset labels.Labels, data string) *storepb.SeriesResponse {
+		return storepb.NewSeriesResponse(&storepb.Series{
+			Labels: labelpb.ZLabelsFromPromLabels(lset),
+			Chunks: []storepb.AggrChunk{{Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: []byte(data)}}},
+		})
+	}
+	responses := []*storepb.SeriesResponse{
+		seriesResp(labels.FromStrings("__name__", "rpc_duration_seconds_count", "foo", "bar"), `abcdefgh`),
+		seriesResp(labels.FromStrings("__name__", "rpc_duration_seconds_count", "foo", "bar"), `abcdefgh`),
+		seriesResp(labels.FromStrings("__name__", "up", "foo", "bar"), `abcdefgh`),
+		seriesResp(labels.FromStrings("__name__", "up", "foo", "bar"), `abcdefgh`),
+	}
+
+	h := NewResponseDeduplicator(NewProxyResponseLoserTree(
+		&eagerRespSet{
+			closeSeries:       func() {},
+			wg:                &sync.WaitGroup{},
+			bufferedResponses: responses,
+		},
+	))
+	h.bypassMetricNames = map[string]struct{}{"rpc_duration_seconds_count": {}}
+
+	var got []*storepb.SeriesResponse
+	for h.Next() {
+		got = append(got, h.At())
+	}
+	testutil.Equals(t, 3, len(got))
+	testutil.Equals(t, responses[0], got[0])
+	testutil.Equals(t, responses[1], got[1])
+	testutil.Equals(t, "up", labelpb.ZLabelsToPromLabels(got[2].GetSeries().Labels).Get("__name__"))
+}
+
+func TestProxyStore_ActiveStreamCount(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{0, 0}, {2, 1}, {3, 2}}),
+					storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{0, 0}, {2, 1}, {3, 2}}),
+				},
+			},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, LazyRetrieval,
+	)
+	testutil.Equals(t, 0, q.ActiveStreamCount())
+
+	var activeWhileStreaming []int
+	srv := &mockedSeriesServer{
+		ctx: context.Background(),
+		send: func(*storepb.SeriesResponse) error {
+			activeWhileStreaming = append(activeWhileStreaming, q.ActiveStreamCount())
+			return nil
+		},
+	}
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".*", Type: storepb.LabelMatcher_RE}},
+	}, srv))
+
+	testutil.Equals(t, 2, len(activeWhileStreaming))
+	testutil.Equals(t, 1, activeWhileStreaming[0])
+	testutil.Equals(t, 0, q.ActiveStreamCount())
+}
+
+func TestProxyStore_StoreGRPCCompression(t *testing.T) {
+	q := NewProxyStore(nil, nil,
+		func() []Client { return nil },
+		component.Query,
+		labels.EmptyLabels(),
+		0*time.Second, EagerRetrieval,
+		WithStoreGRPCCompression("snappy"),
+	)
+
+	testutil.Equals(t, []grpc.CallOption{grpc.UseCompressor("snappy")}, q.storeCallOptions(&storetestutil.TestClient{CompressionAlgos: []string{"snappy"}}))
+	testutil.Equals(t, 0, len(q.storeCallOptions(&storetestutil.TestClient{CompressionAlgos: []string{"gzip"}})))
+
+	// Compressors which are not registered are never used.
+	q = NewProxyStore(nil, nil,
+		func() []Client { return nil },
+		component.Query,
+		labels.EmptyLabels(),
+		0*time.Second, EagerRetrieval,
+		WithStoreGRPCCompression("unknown"),
+	)
+	testutil.Equals(t, 0, len(q.storeCallOptions(&storetestutil.TestClient{CompressionAlgos: []string{"unknown"}})))
+}
+
+func TestProxyStore_PeriodicHealthReport(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{
+			Name:        "failing",
+			StoreClient: &mockedStoreAPI{RespError: errors.New("error")},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		},
+		&storetestutil.TestClient{
+			Name:        "healthy",
+			StoreClient: &mockedStoreAPI{RespLabelNames: &storepb.LabelNamesResponse{Names: []string{"a"}}},
+			ExtLset:     []labels.Labels{labels.FromStrings("ext", "1")},
+			MinTime:     0,
+			MaxTime:     1000,
+			GroupKeyStr: "group",
+		},
+	}
+
+	var logs bytes.Buffer
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		0*time.Second, EagerRetrieval,
+		WithPeriodicHealthReport(time.Hour, log.NewLogfmtLogger(&logs)),
+	)
+	defer q.Close()
+
+	_, err := q.LabelNames(context.Background(), &storepb.LabelNamesRequest{Start: 0, End: 100})
+	testutil.Ok(t, err)
+
+	q.reportHealth()
+	testutil.Assert(t, strings.Contains(logs.String(), `store_addr=failing group_key= replica_key=`), logs.String())
+	testutil.Assert(t, strings.Contains(logs.String(), `store_addr=healthy group_key=group replica_key= min_time=1970-01-01T00:00:00Z max_time=1970-01-01T00:00:01Z label_sets="{ext=\"1\"}" errors=false`), logs.String())
+	testutil.Assert(t, strings.Contains(logs.String(), `errors=true`), logs.String())
+
+	// Errors are reported only for the interval they happened in.
+	logs.Reset()
+	q.reportHealth()
+	testutil.Assert(t, !strings.Contains(logs.String(), `errors=true`), logs.String())
+}
+
+func TestAlignTimeRange(t *testing.T) {
+	for _, tc := range []struct {
+		mint, maxt, step           int64
+		expectedMint, expectedMaxt int64
+	}{
+		{mint: 0, maxt: 100, step: 10, expectedMint: 0, expectedMaxt: 100},
+		{mint: 20, maxt: 40, step: 10, expectedMint: 20, expectedMaxt: 40},
+		{mint: 15, maxt: 45, step: 10, expectedMint: 10, expectedMaxt: 50},
+		{mint: 3, maxt: 7, step: 2, expectedMint: 2, expectedMaxt: 8},
+		{mint: 1001, maxt: 2999, step: 1000, expectedMint: 1000, expectedMaxt: 3000},
+		{mint: -15, maxt: -5, step: 10, expectedMint: -20, expectedMaxt: 0},
+		{mint: math.MinInt64, maxt: math.MaxInt64, step: 1000, expectedMint: math.MinInt64, expectedMaxt: math.MaxInt64},
+		{mint: math.MinInt64 + 1, maxt: math.MaxInt64 - 1, step: 1000, expectedMint: math.MinInt64, expectedMaxt: math.MaxInt64},
+		{mint: math.MinInt64, maxt: math.MaxInt64, step: 1 << 62, expectedMint: math.MinInt64, expectedMaxt: math.MaxInt64},
+	} {
+		t.Run(fmt.Sprintf("%d-%d/%d", tc.mint, tc.maxt, tc.step), func(t *testing.T) {
+			mint, maxt := alignTimeRange(tc.mint, tc.maxt, tc.step)
+			testutil.Equals(t, tc.expectedMint, mint)
+			testutil.Equals(t, tc.expectedMaxt, maxt)
+		})
+	}
+}
+
+func TestProxyStore_Series_TimeRangeAlignment(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	m := &mockedStoreAPI{}
+	cls := []Client{
+		&storetestutil.TestClient{StoreClient: m, MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		1*time.Second, EagerRetrieval,
+		WithTimeRangeAlignment(10),
+	)
+
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  15,
+		MaxTime:  41,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: "a", Type: storepb.LabelMatcher_EQ}},
+	}, newStoreSeriesServer(context.Background())))
+	testutil.Equals(t, int64(10), m.LastSeriesReq.MinTime)
+	testutil.Equals(t, int64(50), m.LastSeriesReq.MaxTime)
+}
+
+func histogramSampleCount(t *testing.T, h prometheus.Histogram) uint64 {
+	t.Helper()
+
+	m := &dto.Metric{}
+	testutil.Ok(t, h.Write(m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestProxyStore_SelectionLatencyTracking(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	var cls []Client
+	for i := 0; i < 1000; i++ {
+		cls = append(cls, &storetestutil.TestClient{
+			Name:        fmt.Sprintf("store-%d", i),
+			StoreClient: &mockedStoreAPI{},
+			ExtLset:     []labels.Labels{labels.FromStrings("store", fmt.Sprintf("%d", i))},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		})
+	}
+	q := NewProxyStore(nil, prometheus.NewRegistry(),
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		1*time.Second, EagerRetrieval,
+		WithSelectionLatencyTracking(true),
+	)
+
+	for i := 1; i <= 3; i++ {
+		testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+			MinTime:  1,
+			MaxTime:  300,
+			Matchers: []storepb.LabelMatcher{{Name: "store", Value: "1", Type: storepb.LabelMatcher_EQ}},
+		}, newStoreSeriesServer(context.Background())))
+		testutil.Equals(t, uint64(i), histogramSampleCount(t, q.metrics.storeSelectionDuration))
+	}
+}
+
+func TestProxyStore_Series_QueryHintsPushDown(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	for _, tc := range []struct {
+		name               string
+		hints              *storepb.QueryHints
+		expectedSkipChunks bool
+	}{
+		{name: "no hints"},
+		{name: "count aggregation", hints: &storepb.QueryHints{Func: &storepb.Func{Name: "count"}}, expectedSkipChunks: true},
+		{name: "sum aggregation", hints: &storepb.QueryHints{Func: &storepb.Func{Name: "sum"}}, expectedSkipChunks: true},
+		{name: "rate function", hints: &storepb.QueryHints{Func: &storepb.Func{Name: "rate"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &mockedStoreAPI{}
+			cls := []Client{
+				&storetestutil.TestClient{StoreClient: m, MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+			}
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				1*time.Second, EagerRetrieval,
+				WithQueryHintsPushDown(true),
+			)
+
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+				MinTime:    1,
+				MaxTime:    300,
+				Matchers:   []storepb.LabelMatcher{{Name: "a", Value: "a", Type: storepb.LabelMatcher_EQ}},
+				QueryHints: tc.hints,
+			}, newStoreSeriesServer(context.Background())))
+			testutil.Equals(t, tc.expectedSkipChunks, m.LastSeriesReq.SkipChunks)
+		})
+	}
+}
+
+func TestPropagateTenant(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		ctx            context.Context
+		expectedTenant string
+	}{
+		{
+			name:           "no tenant",
+			ctx:            context.Background(),
+			expectedTenant: tenancy.DefaultTenant,
+		},
+		{
+			name:           "tenant from gRPC metadata",
+			ctx:            metadata.NewIncomingContext(context.Background(), metadata.Pairs(tenancy.DefaultTenantHeader, "tenant-a")),
+			expectedTenant: "tenant-a",
+		},
+		{
+			name:           "tenant from context value",
+			ctx:            context.WithValue(context.Background(), tenancy.TenantKey, "tenant-b"),
+			expectedTenant: "tenant-b",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			md, ok := metadata.FromOutgoingContext(propagateTenant(tc.ctx, log.NewNopLogger()))
+			testutil.Assert(t, ok)
+			testutil.Equals(t, []string{tc.expectedTenant}, md.Get(tenancy.DefaultTenantHeader))
+		})
+	}
+}
+
+func TestQueryUserAgent(t *testing.T) {
+	var ctx context.Context
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+	req.Header.Set("X-Request-ID", "query-1")
+	middleware.RequestID(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	})).ServeHTTP(httptest.NewRecorder(), req)
+
+	testutil.Equals(t, "thanos-proxy/query-id=query-1/tenant=tenant-a/strategy=ABORT", queryUserAgent(ctx, "tenant-a", storepb.PartialResponseStrategy_ABORT))
+	testutil.Equals(t, "thanos-proxy/query-id=-/tenant=default-tenant/strategy=WARN", queryUserAgent(context.Background(), tenancy.DefaultTenant, storepb.PartialResponseStrategy_WARN))
+}
+
+func TestLogFanoutAnalysis(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		shardable          []bool
+		expectedFactor     float64
+		expectedSuggestion bool
+	}{
+		{name: "no stores", expectedFactor: 0},
+		{name: "no store supports sharding", shardable: []bool{false, false}, expectedFactor: 0},
+		{name: "half of the stores support sharding", shardable: []bool{true, false}, expectedFactor: 0.5},
+		{name: "most stores support sharding", shardable: []bool{true, true, true, false}, expectedFactor: 0.75, expectedSuggestion: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var stores []Client
+			for _, shardable := range tc.shardable {
+				stores = append(stores, storetestutil.TestClient{Shardable: shardable})
+			}
+			testutil.Equals(t, tc.expectedFactor, potentialShardFactor(stores))
+
+			var logs bytes.Buffer
+			logFanoutAnalysis(log.NewLogfmtLogger(&logs), stores)
+			testutil.Equals(t, tc.expectedSuggestion, strings.Contains(logs.String(), "consider enabling query sharding"))
+		})
+	}
+}
+
+func TestProxyStore_Series_SelectorLabelsMismatchSkipsStores(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	var storesCalls int
+	q := NewProxyStore(nil, nil,
+		func() []Client {
+			storesCalls++
+			return []Client{&storetestutil.TestClient{StoreClient: &mockedStoreAPI{}, MinTime: math.MinInt64, MaxTime: math.MaxInt64}}
+		},
+		component.Query,
+		labels.FromStrings("ext", "1"),
+		1*time.Second, EagerRetrieval,
+	)
+
+	s := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: "a", Type: storepb.LabelMatcher_EQ}, {Name: "ext", Value: "2", Type: storepb.LabelMatcher_EQ}},
+	}, s))
+	testutil.Equals(t, 0, len(s.SeriesSet))
+	testutil.Equals(t, 0, storesCalls)
+
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: "a", Type: storepb.LabelMatcher_EQ}, {Name: "ext", Value: "1", Type: storepb.LabelMatcher_EQ}},
+	}, s))
+	testutil.Equals(t, 1, storesCalls)
+}
+
+func TestProxyStore_ConnectionWarmup(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{Name: "store-1", StoreClient: &mockedStoreAPI{}},
+		&storetestutil.TestClient{Name: "store-2", StoreClient: &mockedStoreAPI{}},
+	}
+	q := NewProxyStore(nil, prometheus.NewRegistry(),
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		0*time.Second, EagerRetrieval,
+		WithConnectionWarmup(time.Hour),
+	)
+	defer q.Close()
+
+	// The mocked store does not implement Info, so every warmup call fails.
+	q.warmupConnections()
+	q.warmupConnections()
+	testutil.Equals(t, float64(2), promtest.ToFloat64(q.metrics.warmupFailures.WithLabelValues("store-1")))
+	testutil.Equals(t, float64(2), promtest.ToFloat64(q.metrics.warmupFailures.WithLabelValues("store-2")))
+}
+
+type labelNamesStoreServer struct {
+	storepb.UnimplementedStoreServer
+
+	names []string
+}
+
+func (s *labelNamesStoreServer) LabelNames(context.Context, *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	return &storepb.LabelNamesResponse{Names: s.names}, nil
+}
+
+func TestProxyStore_MaxGRPCMessageSize(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+	srv := grpc.NewServer()
+	storepb.RegisterStoreServer(srv, &labelNamesStoreServer{names: []string{strings.Repeat("a", 1024)}})
+	go func() { _ = srv.Serve(listener) }()
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	testutil.Ok(t, err)
+	defer conn.Close()
+
+	cls := []Client{&storetestutil.TestClient{
+		Name:        listener.Addr().String(),
+		StoreClient: storepb.NewStoreClient(conn),
+		MinTime:     math.MinInt64,
+		MaxTime:     math.MaxInt64,
+	}}
+	req := &storepb.LabelNamesRequest{Start: 0, End: 100, PartialResponseDisabled: true}
+
+	for _, tc := range []struct {
+		name        string
+		opts        []ProxyStoreOption
+		expectedErr codes.Code
+	}{
+		{name: "no limit"},
+		{name: "response within receive limit", opts: []ProxyStoreOption{WithMaxGRPCMessageSize(2048)}},
+		{name: "response exceeds receive limit", opts: []ProxyStoreOption{WithMaxGRPCMessageSize(512)}, expectedErr: codes.ResourceExhausted},
+		{name: "request exceeds send limit", opts: []ProxyStoreOption{WithMaxGRPCSendMsgSize(1)}, expectedErr: codes.ResourceExhausted},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				0*time.Second, EagerRetrieval,
+				tc.opts...,
+			)
+
+			resp, err := q.LabelNames(context.Background(), req)
+			if tc.expectedErr != codes.OK {
+				testutil.NotOk(t, err)
+				testutil.Assert(t, strings.Contains(err.Error(), tc.expectedErr.String()), err.Error())
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equals(t, 1, len(resp.Names))
+		})
+	}
+}
+
+func TestProxyStore_Series_OverlapAnalysis(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	seriesA := storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{0, 0}})
+	seriesB := storeSeriesResponse(t, labels.FromStrings("a", "2"), []sample{{0, 0}})
+	seriesC := storeSeriesResponse(t, labels.FromStrings("a", "3"), []sample{{0, 0}})
+	seriesD := storeSeriesResponse(t, labels.FromStrings("a", "4"), []sample{{0, 0}})
+
+	newClient := func(group, replica string, resps ...*storepb.SeriesResponse) Client {
+		return &storetestutil.TestClient{
+			Name:          group + "/" + replica,
+			StoreClient:   &mockedStoreAPI{RespSeries: resps},
+			MinTime:       math.MinInt64,
+			MaxTime:       math.MaxInt64,
+			GroupKeyStr:   group,
+			ReplicaKeyStr: replica,
+		}
+	}
+	cls := []Client{
+		// Replicated group, both stores have all series.
+		newClient("replicated", "0", seriesA, seriesB),
+		newClient("replicated", "1", seriesA, seriesB),
+		// Sharded group, stores have disjoint series.
+		newClient("sharded", "0", seriesC),
+		newClient("sharded", "1", seriesD),
+		// Partially replicated group.
+		newClient("partial", "0", seriesA, seriesB),
+		newClient("partial", "1", seriesB, seriesC),
+		// Single store group, overlap is not reported.
+		newClient("single", "0", seriesD),
+	}
+	q := NewProxyStore(nil, prometheus.NewRegistry(),
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		0*time.Second, EagerRetrieval,
+		WithOverlapAnalysis(true),
+	)
+
+	s := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  10,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+	}, s))
+	testutil.Equals(t, 4, len(s.SeriesSet))
+
+	for group, expected := range map[string]float64{"replicated": 1, "sharded": 0, "partial": 1.0 / 3} {
+		m := &dto.Metric{}
+		testutil.Ok(t, q.metrics.seriesOverlapRatio.WithLabelValues(group).(prometheus.Histogram).Write(m))
+		testutil.Equals(t, uint64(1), m.GetHistogram().GetSampleCount(), group)
+		testutil.Equals(t, expected, m.GetHistogram().GetSampleSum(), group)
+	}
+	m := &dto.Metric{}
+	testutil.Ok(t, q.metrics.seriesOverlapRatio.WithLabelValues("single").(prometheus.Histogram).Write(m))
+	testutil.Equals(t, uint64(0), m.GetHistogram().GetSampleCount())
+}
+
+// blockingStoreAPI blocks the Series streams until their request is canceled, and then closes canceled.
+type blockingStoreAPI struct {
+	storepb.StoreClient
+
+	canceled chan struct{}
+}
+
+func (s *blockingStoreAPI) Series(ctx context.Context, _ *storepb.SeriesRequest, _ ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	return &blockingSeriesClient{StoreSeriesClient: &storetestutil.StoreSeriesClient{Ctx: ctx}, canceled: s.canceled}, nil
+}
+
+type blockingSeriesClient struct {
+	*storetestutil.StoreSeriesClient
+	canceled chan struct{}
+}
+
+func (c *blockingSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	<-c.Ctx.Done()
+	close(c.canceled)
+	return nil, c.Ctx.Err()
+}
+
+func TestProxyStore_Series_SpeculativeExecution(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	newReplica := func(replica string, m storepb.StoreClient) Client {
+		return &storetestutil.TestClient{
+			Name:          replica,
+			StoreClient:   m,
+			MinTime:       math.MinInt64,
+			MaxTime:       math.MaxInt64,
+			GroupKeyStr:   "group",
+			ReplicaKeyStr: replica,
+		}
+	}
+	for _, tc := range []struct {
+		name           string
+		primary        storepb.StoreClient
+		expectedSeries string
+	}{
+		{
+			// The primary never responds, so the request only succeeds if the backup is queried.
+			name:           "slow primary",
+			primary:        &blockingStoreAPI{canceled: make(chan struct{})},
+			expectedSeries: "backup",
+		},
+		{
+			name:           "failing primary",
+			primary:        &mockedStoreAPI{RespError: errors.New("error")},
+			expectedSeries: "backup",
+		},
+		{
+			name: "fast primary",
+			primary: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "primary"), []sample{{0, 0}})},
+			},
+			expectedSeries: "primary",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			backup := &mockedStoreAPI{
+				RespSeries:   []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "backup"), []sample{{0, 0}})},
+				RespDuration: 100 * time.Millisecond,
+			}
+			cls := []Client{newReplica("primary", tc.primary), newReplica("backup", backup)}
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				2*time.Second, EagerRetrieval,
+				WithSpeculativeExecution(0.1),
+			)
+
+			s := newStoreSeriesServer(context.Background())
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+				MinTime:                 0,
+				MaxTime:                 10,
+				Matchers:                []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+				PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT,
+			}, s))
+			testutil.Equals(t, 0, len(s.Warnings))
+			testutil.Equals(t, 1, len(s.SeriesSet))
+			testutil.Equals(t, tc.expectedSeries, labelpb.ZLabelsToPromLabels(s.SeriesSet[0].Labels).Get("a"))
+
+			// The request to the slow replica is canceled once the backup responded.
+			if blocking, ok := tc.primary.(*blockingStoreAPI); ok {
+				<-blocking.canceled
+			}
+		})
+	}
+}
+
+func TestProxyStore_Series_RespectStoreSeriesLimit(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	for _, tc := range []struct {
+		name               string
+		info               *storepb.InfoResponse
+		queryHints         *storepb.QueryHints
+		expectedTotalLimit int64
+	}{
+		{
+			name: "store without limit",
+			info: &storepb.InfoResponse{},
+		},
+		{
+			name: "store without Info",
+		},
+		{
+			name:               "store limit applied to request without limit",
+			info:               &storepb.InfoResponse{MaxSeriesPerQuery: 100},
+			queryHints:         &storepb.QueryHints{StepMillis: 30},
+			expectedTotalLimit: 100,
+		},
+		{
+			name:               "store limit lower than request limit",
+			info:               &storepb.InfoResponse{MaxSeriesPerQuery: 100},
+			queryHints:         &storepb.QueryHints{TotalLimit: 1000},
+			expectedTotalLimit: 100,
+		},
+		{
+			name:               "request limit lower than store limit",
+			info:               &storepb.InfoResponse{MaxSeriesPerQuery: 100},
+			queryHints:         &storepb.QueryHints{TotalLimit: 10},
+			expectedTotalLimit: 10,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &mockedStoreAPI{RespInfo: tc.info}
+			cls := []Client{&storetestutil.TestClient{Name: "store", StoreClient: m, MinTime: math.MinInt64, MaxTime: math.MaxInt64}}
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				1*time.Second, EagerRetrieval,
+				WithRespectStoreSeriesLimit(true),
+			)
+
+			for i := 0; i < 2; i++ {
+				req := &storepb.SeriesRequest{
+					MinTime:    1,
+					MaxTime:    300,
+					Matchers:   []storepb.LabelMatcher{{Name: "a", Value: "a", Type: storepb.LabelMatcher_EQ}},
+					QueryHints: tc.queryHints,
+				}
+				testutil.Ok(t, q.Series(req, newStoreSeriesServer(context.Background())))
+				var totalLimit int64
+				if m.LastSeriesReq.QueryHints != nil {
+					totalLimit = m.LastSeriesReq.QueryHints.TotalLimit
+				}
+				testutil.Equals(t, tc.expectedTotalLimit, totalLimit)
+			}
+			// Info responses are cached.
+			testutil.Equals(t, 1, m.InfoCalls)
+			if tc.queryHints != nil {
+				testutil.Equals(t, tc.queryHints.StepMillis, m.LastSeriesReq.QueryHints.StepMillis)
+			}
+		})
+	}
+}
+
+// infoFuncStoreAPI answers Info calls with the given function.
+type infoFuncStoreAPI struct {
+	storepb.StoreClient
+
+	info  func(ctx context.Context) (*storepb.InfoResponse, error)
+	calls atomic.Int64
+}
+
+func (s *infoFuncStoreAPI) Info(ctx context.Context, _ *storepb.InfoRequest, _ ...grpc.CallOption) (*storepb.InfoResponse, error) {
+	s.calls.Inc()
+	return s.info(ctx)
+}
+
+func TestStoreCapabilityCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := NewStoreCapabilityCache(time.Minute, log.NewNopLogger())
+	c.now = func() time.Time { return now }
+
+	newStore := func(name string, info func(ctx context.Context) (*storepb.InfoResponse, error)) (Client, *infoFuncStoreAPI) {
+		api := &infoFuncStoreAPI{info: info}
+		return &storetestutil.TestClient{Name: name, StoreClient: api}, api
+	}
+	limited, limitedAPI := newStore("limited", func(context.Context) (*storepb.InfoResponse, error) {
+		return &storepb.InfoResponse{MaxSeriesPerQuery: 100}, nil
+	})
+	unimplemented, unimplementedAPI := newStore("unimplemented", func(context.Context) (*storepb.InfoResponse, error) {
+		return nil, status.Error(codes.Unimplemented, "not implemented")
+	})
+	blocking, blockingAPI := newStore("blocking", func(ctx context.Context) (*storepb.InfoResponse, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 2; i++ {
+		testutil.Equals(t, []int64{100, 0}, c.seriesLimits(context.Background(), []Client{limited, unimplemented}))
+		testutil.Equals(t, []int64{0}, c.seriesLimits(ctx, []Client{blocking}))
+	}
+	// Failed calls are cached, but not canceled ones.
+	testutil.Equals(t, int64(1), limitedAPI.calls.Load())
+	testutil.Equals(t, int64(1), unimplementedAPI.calls.Load())
+	testutil.Equals(t, int64(2), blockingAPI.calls.Load())
+
+	// Entries of stores which are not queried anymore are evicted once expired.
+	now = now.Add(2 * time.Minute)
+	testutil.Equals(t, int64(100), c.Info(context.Background(), limited).MaxSeriesPerQuery)
+	testutil.Equals(t, 1, len(c.entries))
+}
+
+func TestDedupRespHeap_ChunkOverlapMetrics(t *testing.T) {
+	t.Parallel()
+
+	chunk := func(mint, maxt int64, data string) storepb.AggrChunk {
+		return storepb.AggrChunk{MinTime: mint, MaxTime: maxt, Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: []byte(data)}}
+	}
+	lset := labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up"))
+	responses := []*storepb.SeriesResponse{
+		storepb.NewSeriesResponse(&storepb.Series{Labels: lset, Chunks: []storepb.AggrChunk{chunk(0, 100, "a"), chunk(101, 200, "b")}}),
+		storepb.NewSeriesResponse(&storepb.Series{Labels: lset, Chunks: []storepb.AggrChunk{chunk(0, 100, "a"), chunk(150, 250, "c")}}),
+	}
+
+	h := NewResponseDeduplicator(NewProxyResponseLoserTree(
+		&eagerRespSet{
+			closeSeries:       func() {},
+			wg:                &sync.WaitGroup{},
+			bufferedResponses: responses,
+		},
+	))
+	metrics := newProxyStoreMetrics(nil)
+	h.chunkOverlapMs = metrics.chunkOverlapMs
+	h.chunksDeduplicated = metrics.chunksDeduplicated
+
+	var got []*storepb.SeriesResponse
+	for h.Next() {
+		got = append(got, h.At())
+	}
+	testutil.Equals(t, 1, len(got))
+	testutil.Equals(t, 3, len(got[0].GetSeries().Chunks))
+	// Both copies cover [0, 100] and [150, 200].
+	testutil.Equals(t, float64(150), promtest.ToFloat64(metrics.chunkOverlapMs))
+	testutil.Equals(t, float64(1), promtest.ToFloat64(metrics.chunksDeduplicated))
+}
+
+func TestProxyStore_Series_QuerySummaryLog(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{
+			Name: "queried",
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{0, 0}}),
+					storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{0, 0}}),
+				},
+			},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		},
+		&storetestutil.TestClient{
+			Name:        "failing",
+			StoreClient: &mockedStoreAPI{RespError: errors.New("error")},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		},
+		&storetestutil.TestClient{
+			Name:        "filtered",
+			StoreClient: &mockedStoreAPI{},
+			MinTime:     1000,
+			MaxTime:     2000,
+		},
+	}
+
+	var logs bytes.Buffer
+	q := NewProxyStore(log.NewLogfmtLogger(&logs), nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		1*time.Second, EagerRetrieval,
+		WithQuerySummaryLog(true),
+	)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(tenancy.DefaultTenantHeader, "tenant-a"))
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+	}, newStoreSeriesServer(ctx)))
+
+	testutil.Assert(t, strings.Contains(logs.String(), `level=warn component=proxy msg="Series: query summary" duration=`), logs.String())
+	testutil.Assert(t, strings.Contains(logs.String(), `stores_queried=2 stores_filtered=1 series_returned=2 warnings_count=1 partial_response_strategy=WARN shard_info_set=false tenant=tenant-a matcher_count=1`), logs.String())
+}
+
+func TestPropagateDeadline(t *testing.T) {
+	_, ok := metadata.FromOutgoingContext(propagateDeadline(context.Background()))
+	testutil.Assert(t, !ok, "no deadline metadata expected without deadline")
+
+	deadline := time.UnixMilli(1700000000123)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	md, ok := metadata.FromOutgoingContext(propagateDeadline(ctx))
+	testutil.Assert(t, ok)
+	testutil.Equals(t, []string{"1700000000123"}, md.Get(DeadlineHeader))
+}
+
+func TestProxyStore_PropagateBaggage(t *testing.T) {
+	member, err := baggage.NewMember("flag", "on")
+	testutil.Ok(t, err)
+	b, err := baggage.New(member)
+	testutil.Ok(t, err)
+
+	ctx := baggage.ContextWithBaggage(context.Background(), b)
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(
+		BaggageHeaderPrefix+"ab_group", "blue",
+		BaggageHeaderPrefix+"other", "1",
+	))
+
+	q := NewProxyStore(nil, nil, func() []Client { return nil }, component.Query, labels.EmptyLabels(), 0, EagerRetrieval,
+		WithGRPCMetadataToOTelBaggage("ab_group"),
+		WithOTelBaggageToGRPCMetadata("ab_group", "flag", "missing"),
+	)
+	ctx = q.propagateBaggage(ctx, log.NewNopLogger())
+
+	testutil.Equals(t, "blue", baggage.FromContext(ctx).Member("ab_group").Value())
+	testutil.Equals(t, "", baggage.FromContext(ctx).Member("other").Value())
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	testutil.Assert(t, ok)
+	testutil.Equals(t, metadata.Pairs(
+		BaggageHeaderPrefix+"ab_group", "blue",
+		BaggageHeaderPrefix+"flag", "on",
+	), md)
+}
+
+func TestProxyStore_MetadataQueryDiagnostics(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{
+			StoreClient: &mockedStoreAPI{
+				RespLabelNames:  &storepb.LabelNamesResponse{Names: []string{"a"}},
+				RespLabelValues: &storepb.LabelValuesResponse{Values: []string{"1"}},
+			},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		},
+		&storetestutil.TestClient{
+			StoreClient: &mockedStoreAPI{},
+			MinTime:     1000,
+			MaxTime:     2000,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		0*time.Second, EagerRetrieval,
+		WithMetadataQueryDiagnostics(true),
+	)
+
+	names, err := q.LabelNames(context.Background(), &storepb.LabelNamesRequest{Start: 0, End: 100})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"a"}, names.Names)
+	testutil.Equals(t, []string{"queried 1 stores, 1 filtered"}, names.Warnings)
+
+	values, err := q.LabelValues(context.Background(), &storepb.LabelValuesRequest{Label: "a", Start: 0, End: 100})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"1"}, values.Values)
+	testutil.Equals(t, []string{"queried 1 stores, 1 filtered"}, values.Warnings)
+}
+
+// partitionedStoreServer streams its series and, if partitioned, drops all connections to it
+// after the first series, as if the network between the proxy and the store was partitioned.
+type partitionedStoreServer struct {
+	storepb.UnimplementedStoreServer
+
+	series    []*storepb.SeriesResponse
+	partition func()
+}
+
+func (s *partitionedStoreServer) Series(_ *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	for i, resp := range s.series {
+		if err := srv.Send(resp); err != nil {
+			return err
+		}
+		if i == 0 && s.partition != nil {
+			s.partition()
+			<-srv.Context().Done()
+			return srv.Context().Err()
+		}
+	}
+	return nil
+}
+
+// connTrackingListener tracks accepted connections, so that they can be closed.
+type connTrackingListener struct {
+	*bufconn.Listener
+
+	mtx   sync.Mutex
+	conns []net.Conn
+}
+
+func (l *connTrackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.mtx.Lock()
+	l.conns = append(l.conns, conn)
+	l.mtx.Unlock()
+	return conn, nil
+}
+
+func (l *connTrackingListener) closeConns() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	for _, conn := range l.conns {
+		_ = conn.Close()
+	}
+}
+
+func TestProxyStoreWithNetworkPartition(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	type store struct {
+		group, replica string
+		partitioned    bool
+	}
+	for _, tc := range []struct {
+		name     string
+		strategy storepb.PartialResponseStrategy
+		stores   []store
+
+		expectedErr      bool
+		expectedWarnings int
+	}{
+		{
+			name:     "warn strategy continues with remaining stores",
+			strategy: storepb.PartialResponseStrategy_WARN,
+			stores: []store{
+				{group: "g1", replica: "r1"},
+				{group: "g2", replica: "r1", partitioned: true},
+			},
+			expectedWarnings: 1,
+		},
+		{
+			name:     "abort strategy fails",
+			strategy: storepb.PartialResponseStrategy_ABORT,
+			stores: []store{
+				{group: "g1", replica: "r1"},
+				{group: "g2", replica: "r1", partitioned: true},
+			},
+			expectedErr: true,
+		},
+		{
+			name:     "group replica strategy tolerates one failed replica",
+			strategy: storepb.PartialResponseStrategy_GROUP_REPLICA,
+			stores: []store{
+				{group: "g1", replica: "r1"},
+				{group: "g1", replica: "r2", partitioned: true},
+			},
+			expectedWarnings: 1,
+		},
+		{
+			name:     "group replica strategy fails on more than one failed replica",
+			strategy: storepb.PartialResponseStrategy_GROUP_REPLICA,
+			stores: []store{
+				{group: "g1", replica: "r1"},
+				{group: "g1", replica: "r2", partitioned: true},
+				{group: "g2", replica: "r1"},
+				{group: "g2", replica: "r2", partitioned: true},
+			},
+			expectedErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cls []Client
+			for _, st := range tc.stores {
+				lis := &connTrackingListener{Listener: bufconn.Listen(1024 * 1024)}
+				srv := grpc.NewServer()
+				storeSrv := &partitionedStoreServer{series: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "1", "group", st.group), []sample{{0, 0}}),
+					storeSeriesResponse(t, labels.FromStrings("a", "2", "group", st.group), []sample{{0, 0}}),
+				}}
+				if st.partitioned {
+					storeSrv.partition = lis.closeConns
+				}
+				storepb.RegisterStoreServer(srv, storeSrv)
+				go func() { _ = srv.Serve(lis) }()
+				t.Cleanup(srv.Stop)
+
+				conn, err := grpc.Dial("bufnet",
+					grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+					grpc.WithTransportCredentials(insecure.NewCredentials()),
+				)
+				testutil.Ok(t, err)
+				t.Cleanup(func() { _ = conn.Close() })
+
+				cls = append(cls, &storetestutil.TestClient{
+					Name:          st.group + "/" + st.replica,
+					StoreClient:   storepb.NewStoreClient(conn),
+					MinTime:       math.MinInt64,
+					MaxTime:       math.MaxInt64,
+					GroupKeyStr:   st.group,
+					ReplicaKeyStr: st.replica,
+				})
+			}
+
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				5*time.Second, EagerRetrieval,
+			)
+
+			s := newStoreSeriesServer(context.Background())
+			err := q.Series(&storepb.SeriesRequest{
+				MinTime:                 0,
+				MaxTime:                 10,
+				Matchers:                []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+				PartialResponseStrategy: tc.strategy,
+			}, s)
+			if tc.expectedErr {
+				testutil.NotOk(t, err)
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equals(t, tc.expectedWarnings, len(s.Warnings))
+
+			// All series of the groups with a healthy replica are returned.
+			var returned int
+			for _, series := range s.SeriesSet {
+				if labelpb.ZLabelsToPromLabels(series.Labels).Get("group") == "g1" {
+					returned++
+				}
+			}
+			testutil.Equals(t, 2, returned)
+		})
+	}
+}
+
+func TestProxyStore_Series_GroupReplicaStoreFailure(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{
+			Name: "g1/r1",
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{0, 0}})},
+			},
+			MinTime:       math.MinInt64,
+			MaxTime:       math.MaxInt64,
+			GroupKeyStr:   "g1",
+			ReplicaKeyStr: "r1",
+		},
+		&storetestutil.TestClient{
+			Name:          "g1/r2",
+			StoreClient:   &mockedStoreAPI{RespError: status.Error(codes.Internal, "store failure")},
+			MinTime:       math.MinInt64,
+			MaxTime:       math.MaxInt64,
+			GroupKeyStr:   "g1",
+			ReplicaKeyStr: "r2",
+		},
+	}
+	for _, opts := range [][]ProxyStoreOption{
+		nil,
+		{WithPerStoreMaxConcurrency(1)},
+		{WithPerStoreMaxConcurrency(1), WithFanoutConcurrency(1)},
+	} {
+		q := NewProxyStore(nil, nil,
+			func() []Client { return cls },
+			component.Query,
+			labels.EmptyLabels(),
+			time.Second, EagerRetrieval,
+			opts...,
+		)
+
+		// Query repeatedly, so that slots released twice or never would block later queries.
+		for i := 0; i < 3; i++ {
+			srv := newStoreSeriesServer(context.Background())
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+				MinTime:                 0,
+				MaxTime:                 10,
+				Matchers:                []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+				PartialResponseStrategy: storepb.PartialResponseStrategy_GROUP_REPLICA,
+			}, srv))
+			testutil.Equals(t, 1, len(srv.SeriesSet))
+			testutil.Equals(t, 0, q.ActiveStreamCount())
+		}
+	}
+}
+
+func TestProxyStore_MatcherPruning(t *testing.T) {
+	labelSets := []labels.Labels{
+		labels.FromStrings("ext", "1", "region", "eu"),
+		labels.FromStrings("ext", "2", "region", "us"),
+	}
+	extMatcher := storepb.LabelMatcher{Type: storepb.LabelMatcher_RE, Name: "ext", Value: "1|2"}
+	regionMatcher := storepb.LabelMatcher{Type: storepb.LabelMatcher_RE, Name: "region", Value: "eu|us"}
+
+	for _, tc := range []struct {
+		name            string
+		opts            []ProxyStoreOption
+		requestMatchers []storepb.LabelMatcher
+		expected        []storepb.LabelMatcher
+	}{
+		{
+			name:     "pruning disabled",
+			expected: []storepb.LabelMatcher{extMatcher, regionMatcher},
+		},
+		{
+			name:     "within limit",
+			opts:     []ProxyStoreOption{WithMatcherPruning(2)},
+			expected: []storepb.LabelMatcher{extMatcher, regionMatcher},
+		},
+		{
+			name:            "implied matchers pruned",
+			opts:            []ProxyStoreOption{WithMatcherPruning(1)},
+			requestMatchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "ext", Value: "1"}},
+			expected:        []storepb.LabelMatcher{regionMatcher},
+		},
+		{
+			name: "all matchers dropped above limit after pruning",
+			opts: []ProxyStoreOption{WithMatcherPruning(1)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := NewProxyStore(nil, nil, func() []Client { return nil }, component.Query, labels.EmptyLabels(), 0, EagerRetrieval, tc.opts...)
+
+			matchers := q.labelSetMatchers(log.NewNopLogger(), tc.requestMatchers, labelSets)
+			sort.Slice(matchers, func(i, j int) bool { return matchers[i].Name < matchers[j].Name })
+			if len(tc.expected) == 0 {
+				testutil.Equals(t, 0, len(matchers))
+				return
+			}
+			testutil.Equals(t, tc.expected, matchers)
+		})
+	}
+}
+
+func TestProxyStore_Series_SelectivityLogging(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	newStores := func(matching, filtered int) []Client {
+		var cls []Client
+		for i := 0; i < matching; i++ {
+			cls = append(cls, &storetestutil.TestClient{StoreClient: &mockedStoreAPI{}, MinTime: math.MinInt64, MaxTime: math.MaxInt64})
+		}
+		for i := 0; i < filtered; i++ {
+			cls = append(cls, &storetestutil.TestClient{StoreClient: &mockedStoreAPI{}, MinTime: 1000, MaxTime: 2000})
+		}
+		return cls
+	}
+	for _, tc := range []struct {
+		name                string
+		stores              []Client
+		opts                []ProxyStoreOption
+		expectedSelectivity float64
+		expectedWarning     bool
+	}{
+		{name: "half of the stores selected", stores: newStores(6, 6), expectedSelectivity: 0.5},
+		{name: "all stores selected", stores: newStores(12, 0), expectedSelectivity: 1, expectedWarning: true},
+		{name: "all stores selected below threshold", stores: newStores(12, 0), opts: []ProxyStoreOption{WithSelectivityWarnThreshold(20)}, expectedSelectivity: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			q := NewProxyStore(log.NewLogfmtLogger(&logs), nil,
+				func() []Client { return tc.stores },
+				component.Query,
+				labels.EmptyLabels(),
+				1*time.Second, EagerRetrieval,
+				append(tc.opts, WithSelectivityLogging(true))...,
+			)
+
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+				MinTime:  0,
+				MaxTime:  100,
+				Matchers: []storepb.LabelMatcher{{Name: "a", Value: "a", Type: storepb.LabelMatcher_EQ}},
+			}, newStoreSeriesServer(context.Background())))
+
+			m := &dto.Metric{}
+			testutil.Ok(t, q.metrics.querySelectivity.Write(m))
+			testutil.Equals(t, uint64(1), m.GetHistogram().GetSampleCount())
+			testutil.Equals(t, tc.expectedSelectivity, m.GetHistogram().GetSampleSum())
+			testutil.Equals(t, tc.expectedWarning, strings.Contains(logs.String(), "query selects all stores"), logs.String())
+		})
+	}
+}
+
+func TestProxyStore_Series_StoreMatchingCache(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	a := &mockedStoreAPI{}
+	b := &mockedStoreAPI{}
+	storeB := &storetestutil.TestClient{Name: "b", StoreClient: b, ExtLset: []labels.Labels{labels.FromStrings("ext", "2")}, MinTime: 0, MaxTime: 100}
+	clients := []Client{
+		&storetestutil.TestClient{Name: "a", StoreClient: a, ExtLset: []labels.Labels{labels.FromStrings("ext", "1")}, MinTime: 0, MaxTime: 100},
+		storeB,
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return clients },
+		component.Query,
+		labels.EmptyLabels(),
+		1*time.Second, EagerRetrieval,
+		WithStoreMatchingCache(time.Minute, 10),
+	)
+
+	queried := func(mint, maxt int64) []bool {
+		a.LastSeriesReq, b.LastSeriesReq = nil, nil
+		testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+			MinTime: mint,
+			MaxTime: maxt,
+			Matchers: []storepb.LabelMatcher{
+				{Name: "ext", Value: "1", Type: storepb.LabelMatcher_EQ},
+				{Name: "a", Value: "a", Type: storepb.LabelMatcher_EQ},
+			},
+		}, newStoreSeriesServer(context.Background())))
+		return []bool{a.LastSeriesReq != nil, b.LastSeriesReq != nil}
+	}
+
+	testutil.Equals(t, []bool{true, false}, queried(0, 50))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(q.metrics.storeFilteredTotal.WithLabelValues(filterCauseLabelSet)))
+
+	// The selection is cached, so the changed external labels of the store are not seen yet. The store skipped
+	// because of the cache is still counted as filtered.
+	storeB.ExtLset = []labels.Labels{labels.FromStrings("ext", "1")}
+	testutil.Equals(t, []bool{true, false}, queried(0, 50))
+	testutil.Equals(t, 2.0, promtest.ToFloat64(q.metrics.storeFilteredTotal.WithLabelValues(filterCauseLabelSet)))
+
+	// Time ranges are checked for every request.
+	testutil.Equals(t, []bool{false, false}, queried(200, 300))
+
+	// Invalidating the caches drops the cached selection.
+	q.InvalidateCaches()
+	testutil.Equals(t, []bool{true, true}, queried(0, 50))
+	storeB.ExtLset = []labels.Labels{labels.FromStrings("ext", "2")}
+
+	// A different store list invalidates the cached selection.
+	clients = append(clients, &storetestutil.TestClient{Name: "c", StoreClient: &mockedStoreAPI{}, MinTime: 0, MaxTime: 100})
+	testutil.Equals(t, []bool{true, false}, queried(0, 50))
+
+	// So does a list of other stores with the same string representations.
+	clients = []Client{
+		&storetestutil.TestClient{Name: "a", StoreClient: a, ExtLset: []labels.Labels{labels.FromStrings("ext", "1")}, MinTime: 0, MaxTime: 100},
+		&storetestutil.TestClient{Name: "b", StoreClient: b, ExtLset: []labels.Labels{labels.FromStrings("ext", "1")}, MinTime: 0, MaxTime: 100},
+		clients[2],
+	}
+	testutil.Equals(t, []bool{true, true}, queried(0, 50))
+}
+
+func TestProxyStore_Series_StoreMatchingCache_TenantRouting(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	stores := map[string]Client{}
+	var cls []Client
+	for _, name := range []string{"a", "b"} {
+		st := &storetestutil.TestClient{
+			Name:        name,
+			StoreClient: &mockedStoreAPI{},
+			ExtLset:     []labels.Labels{labels.FromStrings("ext", name)},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		}
+		stores[name] = st
+		cls = append(cls, st)
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+		WithStoreMatchingCache(time.Minute, 10),
+		WithTenantStoreRouter(func(tenant string) []Client {
+			if tenant == "tenant-a" {
+				return []Client{stores["a"]}
+			}
+			return nil
+		}),
+	)
+
+	versions := map[string]uint64{}
+	for i := 0; i < 3; i++ {
+		for _, tenant := range []string{"tenant-a", "tenant-b"} {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(tenancy.DefaultTenantHeader, tenant))
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+				MinTime:  0,
+				MaxTime:  100,
+				Matchers: []storepb.LabelMatcher{{Name: "ext", Value: ".+", Type: storepb.LabelMatcher_RE}},
+			}, newStoreSeriesServer(ctx)))
+
+			// Alternating tenants with different stores keep the version of their store lists.
+			version := q.storeMatchingCache.storeSetVersion(tenant, q.tenantStores(ctx))
+			if i == 0 {
+				versions[tenant] = version
+				continue
+			}
+			testutil.Equals(t, versions[tenant], version)
+		}
+	}
+	testutil.Assert(t, versions["tenant-a"] != versions["tenant-b"])
+	testutil.Equals(t, 2, q.storeMatchingCache.lru.Len())
+}
+
+func TestSameStores(t *testing.T) {
+	a, b := &storetestutil.TestClient{Name: "a"}, &storetestutil.TestClient{Name: "a"}
+	testutil.Assert(t, sameStores([]Client{a, b}, []Client{a, b}))
+	testutil.Assert(t, !sameStores([]Client{a, b}, []Client{b, a}))
+	testutil.Assert(t, !sameStores([]Client{a}, []Client{a, b}))
+	testutil.Assert(t, !sameStores([]Client{a}, []Client{b}))
+
+	// Clients which cannot be compared are never the same, instead of panicking.
+	c := storetestutil.TestClient{Name: "c", ExtLset: []labels.Labels{labels.FromStrings("ext", "1")}}
+	testutil.Assert(t, !sameStores([]Client{c}, []Client{c}))
+}
+
+func TestProxyStore_Series_QueueDepthMonitoring(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{
+			StoreClient: &mockedStoreAPI{
+				RespSeries:   []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{0, 0}})},
+				RespDuration: 500 * time.Millisecond,
+			},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+		WithQueueDepthMonitoring(true),
+	)
+
+	done := make(chan error)
+	go func() {
+		done <- q.Series(&storepb.SeriesRequest{
+			MinTime:  0,
+			MaxTime:  100,
+			Matchers: []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+		}, newStoreSeriesServer(context.Background()))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, ctx.Done(), func() error {
+		if v := promtest.ToFloat64(q.metrics.seriesFanoutDepth); v != 1 {
+			return errors.Errorf("expected one request fanned out, got %v", v)
+		}
+		return nil
+	}))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(q.metrics.seriesQueueDepth))
+
+	testutil.Ok(t, <-done)
+	testutil.Equals(t, 0.0, promtest.ToFloat64(q.metrics.seriesQueueDepth))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(q.metrics.seriesFanoutDepth))
+}
+
+func TestProxyStore_Series_TenantUsageAccounting(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{Name: "store-1", StoreClient: &mockedStoreAPI{}, MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+		&storetestutil.TestClient{Name: "store-2", StoreClient: &mockedStoreAPI{}, MinTime: math.MinInt64, MaxTime: 10},
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		1*time.Second, EagerRetrieval,
+		WithTenantUsageAccounting(true),
+	)
+
+	ctx := context.WithValue(context.Background(), tenancy.TenantKey, "team-a")
+	for i := 0; i < 2; i++ {
+		testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+			MinTime:  0,
+			MaxTime:  100,
+			Matchers: []storepb.LabelMatcher{{Name: "a", Value: "a", Type: storepb.LabelMatcher_EQ}},
+		}, newStoreSeriesServer(ctx)))
+	}
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  50,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: "a", Type: storepb.LabelMatcher_EQ}},
+	}, newStoreSeriesServer(context.WithValue(context.Background(), tenancy.TenantKey, "team-b"))))
+
+	testutil.Equals(t, 2.0, promtest.ToFloat64(q.metrics.tenantStoreQueries.WithLabelValues("team-a", "store-1")))
+	testutil.Equals(t, 2.0, promtest.ToFloat64(q.metrics.tenantStoreQueries.WithLabelValues("team-a", "store-2")))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(q.metrics.tenantStoreQueries.WithLabelValues("team-b", "store-1")))
+	testutil.Equals(t, 3, promtest.CollectAndCount(q.metrics.tenantStoreQueries))
+}
+
+func TestProxyStore_Series_RequireGroupReplicaKeys(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	valid := &mockedStoreAPI{}
+	emptyGroup := &mockedStoreAPI{}
+	emptyReplica := &mockedStoreAPI{}
+	cls := []Client{
+		&storetestutil.TestClient{Name: "valid", StoreClient: valid, GroupKeyStr: "g1", ReplicaKeyStr: "r1", MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+		&storetestutil.TestClient{Name: "empty-group", StoreClient: emptyGroup, ReplicaKeyStr: "r1", MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+		&storetestutil.TestClient{Name: "empty-replica", StoreClient: emptyReplica, GroupKeyStr: "g1", MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+	}
+
+	for _, tc := range []struct {
+		name            string
+		enabled         bool
+		expectedQueried []bool
+		expectedInvalid float64
+	}{
+		{name: "disabled", expectedQueried: []bool{true, true, true}},
+		{name: "enabled", enabled: true, expectedQueried: []bool{true, false, false}, expectedInvalid: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			valid.LastSeriesReq, emptyGroup.LastSeriesReq, emptyReplica.LastSeriesReq = nil, nil, nil
+
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				1*time.Second, EagerRetrieval,
+				WithRequireGroupReplicaKeys(tc.enabled),
+			)
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+				MinTime:  0,
+				MaxTime:  100,
+				Matchers: []storepb.LabelMatcher{{Name: "a", Value: "a", Type: storepb.LabelMatcher_EQ}},
+			}, newStoreSeriesServer(context.Background())))
+
+			testutil.Equals(t, tc.expectedQueried, []bool{valid.LastSeriesReq != nil, emptyGroup.LastSeriesReq != nil, emptyReplica.LastSeriesReq != nil})
+			testutil.Equals(t, tc.expectedInvalid, promtest.ToFloat64(q.metrics.invalidGroupReplicaKey))
+		})
+	}
+}
+
+func TestProxyStore_Series_MaxMatchersPerStore(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	relabelConfig, err := block.ParseRelabelConfig([]byte(`
+- source_labels: [ext]
+  action: keep
+  regex: .+
+`), block.SelectorSupportedRelabelActions)
+	testutil.Ok(t, err)
+
+	small := &mockedStoreAPI{}
+	large := &mockedStoreAPI{}
+	cls := []Client{
+		&storetestutil.TestClient{Name: "small", StoreClient: small, MinTime: math.MinInt64, MaxTime: math.MaxInt64,
+			ExtLset: []labels.Labels{labels.FromStrings("ext", "1")}},
+		&storetestutil.TestClient{Name: "large", StoreClient: large, MinTime: math.MinInt64, MaxTime: math.MaxInt64,
+			ExtLset: []labels.Labels{labels.FromStrings("ext", "2"), labels.FromStrings("ext", "3"), labels.FromStrings("ext", "4")}},
+	}
+	req := &storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: "a", Type: storepb.LabelMatcher_EQ}},
+	}
+
+	for _, tc := range []struct {
+		name             string
+		limit            int
+		expectedMatchers int
+	}{
+		{name: "within limit", limit: 3, expectedMatchers: 2},
+		{name: "store above limit", limit: 2, expectedMatchers: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				1*time.Second, EagerRetrieval,
+				WithTSDBSelector(NewTSDBSelector(relabelConfig)),
+				WithMaxMatchersPerStore(tc.limit),
+			)
+			testutil.Ok(t, q.Series(req, newStoreSeriesServer(context.Background())))
+
+			testutil.Equals(t, tc.expectedMatchers, len(small.LastSeriesReq.Matchers))
+			testutil.Equals(t, tc.expectedMatchers, len(large.LastSeriesReq.Matchers))
+		})
+	}
+}
+
+func TestProxyStore_GroupTopology(t *testing.T) {
+	cls := []Client{
+		&storetestutil.TestClient{Name: "a", GroupKeyStr: "g1", ReplicaKeyStr: "r1"},
+		&storetestutil.TestClient{Name: "b", GroupKeyStr: "g1", ReplicaKeyStr: "r2"},
+		&storetestutil.TestClient{Name: "c", GroupKeyStr: "g2", ReplicaKeyStr: "r1"},
+		&storetestutil.TestClient{Name: "d", GroupKeyStr: "g2", ReplicaKeyStr: "r1"},
+	}
+
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("caching=%v", enabled), func(t *testing.T) {
+			stores := cls[:3]
+			q := NewProxyStore(nil, nil, func() []Client { return stores }, component.Query, labels.EmptyLabels(), 0, EagerRetrieval,
+				WithGroupTopologyCaching(enabled),
+			)
+
+			topology := q.GroupTopology()
+			testutil.Equals(t, map[string]map[string][]string{
+				"g1": {"r1": {"a"}, "r2": {"b"}},
+				"g2": {"r1": {"c"}},
+			}, topology)
+
+			// The returned topology is a copy.
+			topology["g1"]["r1"] = nil
+			testutil.Equals(t, []string{"a"}, q.GroupTopology()["g1"]["r1"])
+
+			stores = cls
+			testutil.Equals(t, map[string]map[string][]string{
+				"g1": {"r1": {"a"}, "r2": {"b"}},
+				"g2": {"r1": {"c", "d"}},
+			}, q.GroupTopology())
+		})
+	}
+
+	t.Run("series lists the stores once", func(t *testing.T) {
+		calls := 0
+		q := NewProxyStore(nil, nil,
+			func() []Client {
+				calls++
+				return []Client{&storetestutil.TestClient{Name: "a", StoreClient: &mockedStoreAPI{}, MinTime: math.MinInt64, MaxTime: math.MaxInt64}}
+			},
+			component.Query, labels.EmptyLabels(), 0, EagerRetrieval,
+			WithGroupTopologyCaching(true),
+		)
+		testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+			MinTime:  0,
+			MaxTime:  100,
+			Matchers: []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+		}, newStoreSeriesServer(context.Background())))
+		testutil.Equals(t, 1, calls)
+	})
+}
+
+func TestProxyStore_Series_MetadataSizeTracking(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{StoreClient: &mockedStoreAPI{}, MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		1*time.Second, EagerRetrieval,
+		WithMetadataSizeTracking(true),
+		WithTenantPropagation(true),
+	)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("thanos-tenant", "team-a", "key", "value"))
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: "a", Type: storepb.LabelMatcher_EQ}},
+	}, newStoreSeriesServer(ctx)))
+
+	m := &dto.Metric{}
+	testutil.Ok(t, q.metrics.incomingMetadataBytes.Write(m))
+	testutil.Equals(t, uint64(1), m.GetHistogram().GetSampleCount())
+	testutil.Equals(t, float64(len("thanos-tenant")+len("team-a")+len("key")+len("value")), m.GetHistogram().GetSampleSum())
+
+	m = &dto.Metric{}
+	testutil.Ok(t, q.metrics.outgoingMetadataBytes.Write(m))
+	testutil.Equals(t, uint64(1), m.GetHistogram().GetSampleCount())
+	testutil.Equals(t, float64(len("thanos-tenant")+len("team-a")), m.GetHistogram().GetSampleSum())
+}
+
+func TestProxyStore_Series_MaxLabelCardinality(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	var series []*storepb.SeriesResponse
+	for i := 0; i < 5; i++ {
+		series = append(series, storeSeriesResponse(t, labels.FromStrings("a", "b", "pod", fmt.Sprintf("pod-%d", i)), []sample{{0, 0}}))
+	}
+
+	for _, tc := range []struct {
+		name           string
+		opts           []ProxyStoreOption
+		strategy       storepb.PartialResponseStrategy
+		expectedSeries int
+		expectedWarns  []string
+		expectedErr    bool
+	}{
+		{name: "disabled", expectedSeries: 5},
+		{name: "within limit", opts: []ProxyStoreOption{WithMaxLabelCardinality(5)}, expectedSeries: 5},
+		{
+			name:           "limit exceeded",
+			opts:           []ProxyStoreOption{WithMaxLabelCardinality(3)},
+			expectedSeries: 5,
+			expectedWarns:  []string{`label "pod" has more than 3 unique values in the response`},
+		},
+		{
+			name:           "limit exceeded in strict mode",
+			opts:           []ProxyStoreOption{WithMaxLabelCardinality(3), WithStrictLabelCardinality(true)},
+			expectedSeries: 3,
+			expectedWarns:  []string{`label "pod" has more than 3 unique values in the response`},
+		},
+		{
+			name:        "limit exceeded in strict mode with abort strategy",
+			opts:        []ProxyStoreOption{WithMaxLabelCardinality(3), WithStrictLabelCardinality(true)},
+			strategy:    storepb.PartialResponseStrategy_ABORT,
+			expectedErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cls := []Client{
+				&storetestutil.TestClient{StoreClient: &mockedStoreAPI{RespSeries: series}, MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+			}
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				1*time.Second, EagerRetrieval,
+				tc.opts...,
+			)
+
+			srv := newStoreSeriesServer(context.Background())
+			err := q.Series(&storepb.SeriesRequest{
+				MinTime:                 0,
+				MaxTime:                 100,
+				Matchers:                []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+				PartialResponseStrategy: tc.strategy,
+			}, srv)
+			if tc.expectedErr {
+				testutil.Equals(t, codes.Aborted, status.Code(err))
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equals(t, tc.expectedSeries, len(srv.SeriesSet))
+			testutil.Equals(t, tc.expectedWarns, srv.Warnings)
+		})
+	}
+}
+
+func TestDedupStoreDebugMsgs(t *testing.T) {
+	msgs := []string{
+		"2 stores filtered out due to: cached store matching",
+		"Store a filtered out due to: does not have data within this time period: [0,10]. Store time ranges: [20,30]",
+		"Store b filtered out due to: external labels [{ext=1}] does not match request label matchers: [ext=\"2\"]",
+		"Store c filtered out due to: does not have data within this time period: [0,10]. Store time ranges: [40,50]",
+		"Store d queried",
+		"Store e filtered out due to: tsdb selector",
+		"Store f queried",
+	}
+	testutil.Equals(t, []string{
+		"2 stores filtered out due to: cached store matching",
+		"Store filtered out due to: does not have data within this time period [2 stores]",
+		"Store filtered out due to: external labels [1 stores]",
+		"Store queried [2 stores]",
+		"Store filtered out due to: tsdb selector [1 stores]",
+	}, dedupStoreDebugMsgs(msgs))
+}
+
+func TestProxyStore_Series_TenantSeriesAccounting(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{
+			StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "b", "c", "1"), []sample{{0, 0}}),
+				storeSeriesResponse(t, labels.FromStrings("a", "b", "c", "2"), []sample{{0, 0}}),
+				storepb.NewWarnSeriesResponse(errors.New("partial data")),
+			}},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		1*time.Second, EagerRetrieval,
+		WithTenantSeriesAccounting(true),
+	)
+
+	for i := 0; i < 2; i++ {
+		testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+			MinTime:                 0,
+			MaxTime:                 100,
+			Matchers:                []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+			PartialResponseStrategy: storepb.PartialResponseStrategy_WARN,
+		}, newStoreSeriesServer(context.WithValue(context.Background(), tenancy.TenantKey, "team-a"))))
+	}
+
+	testutil.Equals(t, 4.0, promtest.ToFloat64(q.metrics.tenantSeriesReturned.WithLabelValues("team-a")))
+	testutil.Equals(t, 2.0, promtest.ToFloat64(q.metrics.tenantWarnings.WithLabelValues("team-a")))
+}
+
+func TestProxyStore_AnalyzeLabelSetCoverage(t *testing.T) {
+	newStore := func(name string, lsets ...labels.Labels) Client {
+		return &storetestutil.TestClient{Name: name, ExtLset: lsets}
+	}
+
+	for _, tc := range []struct {
+		name             string
+		stores           []Client
+		expectedCoverage string
+		expectedLog      string
+	}{
+		{
+			name: "replicated",
+			stores: []Client{
+				newStore("a", labels.FromStrings("cluster", "eu")),
+				newStore("b", labels.FromStrings("cluster", "us"), labels.FromStrings("cluster", "eu")),
+			},
+			expectedCoverage: "replicated",
+			expectedLog:      "stores share a label set",
+		},
+		{
+			name: "disjoint",
+			stores: []Client{
+				newStore("a", labels.FromStrings("cluster", "eu")),
+				newStore("b", labels.FromStrings("cluster", "us")),
+				newStore("c"),
+			},
+			expectedCoverage: "disjoint",
+			expectedLog:      "perfect sharding detected",
+		},
+		{
+			name:   "single store with label sets",
+			stores: []Client{newStore("a", labels.FromStrings("cluster", "eu")), newStore("b")},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			q := NewProxyStore(nil, nil, func() []Client { return nil }, component.Query, labels.EmptyLabels(), 0, EagerRetrieval)
+			q.analyzeLabelSetCoverage(log.NewLogfmtLogger(&logs), tc.stores)
+
+			if tc.expectedCoverage == "" {
+				testutil.Equals(t, 0, promtest.CollectAndCount(q.metrics.labelSetCoverage))
+				return
+			}
+			testutil.Equals(t, 1.0, promtest.ToFloat64(q.metrics.labelSetCoverage.WithLabelValues(tc.expectedCoverage)))
+			testutil.Assert(t, strings.Contains(logs.String(), tc.expectedLog), logs.String())
+		})
+	}
+}
+
+func TestProxyStore_Series_RequireShardingAboveStoreCount(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	newStores := func(shardable, other int) []Client {
+		var cls []Client
+		for i := 0; i < shardable+other; i++ {
+			cls = append(cls, &storetestutil.TestClient{StoreClient: &mockedStoreAPI{}, MinTime: math.MinInt64, MaxTime: math.MaxInt64, Shardable: i < shardable})
+		}
+		return cls
+	}
+
+	for _, tc := range []struct {
+		name        string
+		stores      []Client
+		opts        []ProxyStoreOption
+		shardInfo   *storepb.ShardInfo
+		expectedErr bool
+	}{
+		{name: "disabled", stores: newStores(3, 0)},
+		{name: "store count within limit", stores: newStores(3, 0), opts: []ProxyStoreOption{WithRequireShardingAboveStoreCount(3)}},
+		{name: "sharding required", stores: newStores(3, 1), opts: []ProxyStoreOption{WithRequireShardingAboveStoreCount(3)}, expectedErr: true},
+		{
+			name:      "sharded request",
+			stores:    newStores(3, 1),
+			opts:      []ProxyStoreOption{WithRequireShardingAboveStoreCount(3)},
+			shardInfo: &storepb.ShardInfo{ShardIndex: 0, TotalShards: 2, By: true, Labels: []string{"a"}},
+		},
+		{
+			name:   "too few stores support sharding",
+			stores: newStores(3, 1),
+			opts:   []ProxyStoreOption{WithRequireShardingAboveStoreCount(3), WithShardingRequiredFraction(0.8)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := NewProxyStore(nil, nil,
+				func() []Client { return tc.stores },
+				component.Query,
+				labels.EmptyLabels(),
+				1*time.Second, EagerRetrieval,
+				tc.opts...,
+			)
+			err := q.Series(&storepb.SeriesRequest{
+				MinTime:   0,
+				MaxTime:   100,
+				Matchers:  []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+				ShardInfo: tc.shardInfo,
+			}, newStoreSeriesServer(context.Background()))
+			if !tc.expectedErr {
+				testutil.Ok(t, err)
+				return
+			}
+			testutil.NotOk(t, err)
+			testutil.Equals(t, codes.FailedPrecondition, status.Code(err))
+		})
+	}
+}
+
+func TestProxyStore_RequestReplay(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	store := &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{0, 0}}),
+	}}
+	cls := []Client{&storetestutil.TestClient{StoreClient: store, MinTime: math.MinInt64, MaxTime: math.MaxInt64}}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		1*time.Second, EagerRetrieval,
+		WithRequestReplay(2),
+	)
+
+	for _, v := range []string{"1", "2", "3"} {
+		testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+			MinTime:  0,
+			MaxTime:  100,
+			Matchers: []storepb.LabelMatcher{{Name: "a", Value: v, Type: storepb.LabelMatcher_EQ}},
+		}, newStoreSeriesServer(context.Background())))
+	}
+
+	for _, tc := range []struct {
+		index    int
+		expected string
+	}{{index: 0, expected: "3"}, {index: 1, expected: "2"}} {
+		srv := newStoreSeriesServer(context.Background())
+		testutil.Ok(t, q.ReplayRequest(context.Background(), tc.index, srv))
+		testutil.Equals(t, tc.expected, store.LastSeriesReq.Matchers[0].Value)
+		testutil.Equals(t, 1, len(srv.SeriesSet))
+	}
+	testutil.NotOk(t, q.ReplayRequest(context.Background(), 2, newStoreSeriesServer(context.Background())))
+
+	t.Run("http", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		q.ReplayHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/replay/1", nil))
+		testutil.Equals(t, http.StatusOK, rec.Code)
+		testutil.Equals(t, "2", store.LastSeriesReq.Matchers[0].Value)
+
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		testutil.Equals(t, 1, len(lines))
+		var resp struct {
+			Result struct {
+				Series storepb.Series `json:"series"`
+			}
+		}
+		testutil.Ok(t, json.Unmarshal([]byte(lines[0]), &resp))
+		testutil.Equals(t, labels.FromStrings("a", "b"), resp.Result.Series.PromLabels())
+
+		rec = httptest.NewRecorder()
+		q.ReplayHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/replay/5", nil))
+		testutil.Equals(t, http.StatusBadRequest, rec.Code)
+
+		rec = httptest.NewRecorder()
+		q.ReplayHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/replay/0", nil))
+		testutil.Equals(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+
+	t.Run("headers and trailers", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		srv := &jsonSeriesServer{ctx: context.Background(), w: rec, enc: json.NewEncoder(rec)}
+		testutil.Ok(t, srv.SetHeader(metadata.Pairs("a", "b")))
+		testutil.Ok(t, srv.SendHeader(metadata.Pairs("a", "b")))
+		srv.SetTrailer(metadata.Pairs("a", "b"))
+		testutil.Ok(t, srv.SendMsg(storepb.NewWarnSeriesResponse(errors.New("warning"))))
+		testutil.NotOk(t, srv.RecvMsg(&storepb.SeriesRequest{}))
+		testutil.Equals(t, 1, len(strings.Split(strings.TrimSpace(rec.Body.String()), "\n")))
+	})
+}
+
+func TestProxyStore_Series_SkipNonShardingStores(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	sharding := &mockedStoreAPI{}
+	nonSharding := &mockedStoreAPI{}
+	cls := []Client{
+		&storetestutil.TestClient{StoreClient: sharding, Shardable: true, MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+		&storetestutil.TestClient{StoreClient: nonSharding, MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+	}
+
+	for _, tc := range []struct {
+		name            string
+		enabled         bool
+		shardInfo       *storepb.ShardInfo
+		expectedQueried []bool
+		expectedSkipped float64
+	}{
+		{name: "disabled", shardInfo: &storepb.ShardInfo{TotalShards: 2, By: true, Labels: []string{"a"}}, expectedQueried: []bool{true, true}},
+		{name: "not sharded", enabled: true, expectedQueried: []bool{true, true}},
+		{name: "sharded", enabled: true, shardInfo: &storepb.ShardInfo{TotalShards: 2, By: true, Labels: []string{"a"}}, expectedQueried: []bool{true, false}, expectedSkipped: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sharding.LastSeriesReq, nonSharding.LastSeriesReq = nil, nil
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				1*time.Second, EagerRetrieval,
+				WithSkipNonShardingStores(tc.enabled),
+			)
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+				MinTime:   0,
+				MaxTime:   100,
+				Matchers:  []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+				ShardInfo: tc.shardInfo,
+			}, newStoreSeriesServer(context.Background())))
+
+			testutil.Equals(t, tc.expectedQueried, []bool{sharding.LastSeriesReq != nil, nonSharding.LastSeriesReq != nil})
+			testutil.Equals(t, tc.expectedSkipped, promtest.ToFloat64(q.metrics.nonShardingSkipped))
+		})
+	}
+}
+
+func TestProxyStore_Series_MixedShardingStores(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	series := func(src string, values ...string) []*storepb.SeriesResponse {
+		var resps []*storepb.SeriesResponse
+		for _, v := range values {
+			resps = append(resps, storeSeriesResponse(t, labels.FromStrings("a", v, "src", src), []sample{{0, 0}}))
+		}
+		return resps
+	}
+	cls := []Client{
+		// Stores supporting sharding only return the series of the requested shard.
+		&storetestutil.TestClient{
+			StoreClient: &mockedStoreAPI{RespSeries: series("sharding", "a", "b")},
+			Shardable:   true,
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		},
+		&storetestutil.TestClient{
+			StoreClient: &mockedStoreAPI{RespSeries: series("plain", "a", "b", "c")},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		},
+	}
+
+	for _, strategy := range []RetrievalStrategy{EagerRetrieval, LazyRetrieval} {
+		t.Run(string(strategy), func(t *testing.T) {
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				1*time.Second, strategy,
+			)
+			srv := newStoreSeriesServer(context.Background())
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+				MinTime:   0,
+				MaxTime:   100,
+				Matchers:  []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+				ShardInfo: &storepb.ShardInfo{ShardIndex: 0, TotalShards: 2, By: true, Labels: []string{"a"}},
+			}, srv))
+
+			// The series of the store without sharding support are filtered by the proxy.
+			var got []labels.Labels
+			for _, s := range srv.SeriesSet {
+				got = append(got, s.PromLabels())
+			}
+			testutil.Equals(t, []labels.Labels{
+				labels.FromStrings("a", "a", "src", "plain"),
+				labels.FromStrings("a", "a", "src", "sharding"),
+				labels.FromStrings("a", "b", "src", "plain"),
+				labels.FromStrings("a", "b", "src", "sharding"),
+			}, got)
+		})
+	}
+}
+
+func TestProxyStore_Series_WithoutReplicaLabelsFallback(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	for _, strategy := range []RetrievalStrategy{EagerRetrieval, LazyRetrieval} {
+		t.Run(string(strategy), func(t *testing.T) {
+			// The store does not support WithoutReplicaLabels, so the proxy removes the labels itself.
+			cl := &storetestutil.TestClient{
+				StoreClient: &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "y"), []sample{{0, 0}}),
+					storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "x"), []sample{{0, 0}}),
+					storeSeriesResponse(t, labels.FromStrings("a", "3", "replica", "x"), []sample{{0, 0}}),
+				}},
+				MinTime: math.MinInt64,
+				MaxTime: math.MaxInt64,
+			}
+			q := NewProxyStore(nil, nil,
+				func() []Client { return []Client{cl} },
+				component.Query,
+				labels.EmptyLabels(),
+				1*time.Second, strategy,
+			)
+			srv := newStoreSeriesServer(context.Background())
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+				MinTime:              0,
+				MaxTime:              100,
+				Matchers:             []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+				WithoutReplicaLabels: []string{"replica"},
+			}, srv))
+
+			var got []labels.Labels
+			for _, s := range srv.SeriesSet {
+				got = append(got, s.PromLabels())
+			}
+			testutil.Equals(t, []labels.Labels{
+				labels.FromStrings("a", "1"),
+				labels.FromStrings("a", "2"),
+				labels.FromStrings("a", "3"),
+			}, got)
+		})
+	}
+}
+
+func TestProxyStore_Series_LatencyAttribution(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{
+			StoreClient: &mockedStoreAPI{
+				RespSeries:   []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{0, 0}})},
+				RespDuration: 100 * time.Millisecond,
+			},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		},
+	}
+	var logs bytes.Buffer
+	q := NewProxyStore(log.NewLogfmtLogger(&logs), nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+		WithLatencyAttribution(true),
+	)
+
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+	}, newStoreSeriesServer(context.Background())))
+
+	for _, h := range []prometheus.Histogram{q.metrics.seriesMatchingDuration, q.metrics.seriesSetupDuration, q.metrics.seriesMergeDuration} {
+		m := &dto.Metric{}
+		testutil.Ok(t, h.Write(m))
+		testutil.Equals(t, uint64(1), m.GetHistogram().GetSampleCount())
+	}
+	m := &dto.Metric{}
+	testutil.Ok(t, q.metrics.seriesMergeDuration.Write(m))
+	testutil.Assert(t, m.GetHistogram().GetSampleSum() >= (100*time.Millisecond).Seconds(), "merge should include waiting for the store")
+	testutil.Assert(t, strings.Contains(logs.String(), "Series: latency attribution"), logs.String())
+}
+
+func TestStoreMatches_MinTimeRangeOverlap(t *testing.T) {
+	st := &storetestutil.TestClient{MinTime: 100, MaxTime: 200}
+	unbounded := &storetestutil.TestClient{MinTime: math.MinInt64, MaxTime: math.MaxInt64}
+
+	for _, tc := range []struct {
+		name          string
+		s             Client
+		mint, maxt    int64
+		minOverlapMs  int64
+		expectedMatch bool
+	}{
+		{name: "disabled", s: st, mint: 200, maxt: 300, expectedMatch: true},
+		{name: "overlap equals minimum at start", s: st, mint: 0, maxt: 110, minOverlapMs: 10, expectedMatch: true},
+		{name: "overlap equals minimum at end", s: st, mint: 190, maxt: 300, minOverlapMs: 10, expectedMatch: true},
+		{name: "overlap below minimum", s: st, mint: 0, maxt: 110, minOverlapMs: 11},
+		{name: "request ends at store start", s: st, mint: 0, maxt: 100, minOverlapMs: 1},
+		{name: "request within store", s: st, mint: 120, maxt: 180, minOverlapMs: 60, expectedMatch: true},
+		{name: "unbounded ranges", s: unbounded, mint: math.MinInt64, maxt: math.MaxInt64, minOverlapMs: 1000, expectedMatch: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ok, _ := storeMatches(context.Background(), tc.s, false, tc.mint, tc.maxt, tc.minOverlapMs)
+			testutil.Equals(t, tc.expectedMatch, ok)
+		})
+	}
+}
+
+func TestProxyStore_CircuitBreaker(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	failing := &mockedStoreAPI{RespError: errors.New("error"), RespLabelNames: &storepb.LabelNamesResponse{Names: []string{"b"}}}
+	cls := []Client{
+		&storetestutil.TestClient{
+			Name:        "failing",
+			StoreClient: failing,
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		},
+		&storetestutil.TestClient{
+			Name:        "healthy",
+			StoreClient: &mockedStoreAPI{RespLabelNames: &storepb.LabelNamesResponse{Names: []string{"a"}}},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+		WithCircuitBreaker(2, time.Minute),
+	)
+	now := time.Now()
+	q.circuitBreaker.now = func() time.Time { return now }
+
+	labelNames := func() *storepb.LabelNamesResponse {
+		resp, err := q.LabelNames(context.Background(), &storepb.LabelNamesRequest{Start: 0, End: 100})
+		testutil.Ok(t, err)
+		return resp
+	}
+	state := func() float64 {
+		return promtest.ToFloat64(q.metrics.circuitBreakerState.WithLabelValues("failing"))
+	}
+
+	// The circuit opens after two consecutive failures.
+	testutil.Equals(t, 1, len(labelNames().Warnings))
+	testutil.Equals(t, 1, len(labelNames().Warnings))
+	testutil.Equals(t, float64(circuitOpen), state())
+
+	// The failing store is skipped while the circuit is open.
+	resp := labelNames()
+	testutil.Equals(t, 0, len(resp.Warnings))
+	testutil.Equals(t, []string{"a"}, resp.Names)
+
+	// A failed probe after the cooldown keeps the circuit open.
+	now = now.Add(time.Minute)
+	testutil.Equals(t, 1, len(labelNames().Warnings))
+	testutil.Equals(t, float64(circuitOpen), state())
+	testutil.Equals(t, 0, len(labelNames().Warnings))
+
+	// A successful probe closes the circuit.
+	now = now.Add(time.Minute)
+	failing.RespError = nil
+	resp = labelNames()
+	testutil.Equals(t, []string{"a", "b"}, resp.Names)
+	testutil.Equals(t, float64(circuitClosed), state())
+}
+
+func TestProxyStore_CircuitBreaker_ContextErrors(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{
+			Name:        "canceled",
+			StoreClient: &mockedStoreAPI{RespError: status.Error(codes.Canceled, "context canceled")},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		},
+		&storetestutil.TestClient{
+			Name:        "deadline",
+			StoreClient: &mockedStoreAPI{RespError: errors.Wrap(context.DeadlineExceeded, "fetch")},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+		WithCircuitBreaker(1, time.Minute),
+	)
+
+	for i := 0; i < 2; i++ {
+		resp, err := q.LabelNames(context.Background(), &storepb.LabelNamesRequest{Start: 0, End: 100})
+		testutil.Ok(t, err)
+		testutil.Equals(t, 2, len(resp.Warnings))
+		_, err = q.LabelValues(context.Background(), &storepb.LabelValuesRequest{Label: "a", Start: 0, End: 100})
+		testutil.Ok(t, err)
+	}
+
+	// Context errors do not count as store failures, so the circuits stay closed.
+	for _, st := range cls {
+		testutil.Assert(t, q.circuitAllows(st), "circuit of store %s opened", st)
+	}
+
+	// Calls canceled by the caller are not reported either.
+	cls[0].(*storetestutil.TestClient).StoreClient = &mockedStoreAPI{RespError: errors.New("error")}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _ = q.LabelNames(ctx, &storepb.LabelNamesRequest{Start: 0, End: 100})
+	testutil.Assert(t, q.circuitAllows(cls[0]), "circuit opened for a canceled request")
+}
+
+func TestProxyStore_Series_StoreResponseTimeout(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	for _, tc := range []struct {
+		name             string
+		storeTimeout     time.Duration
+		expectedSeries   int
+		expectedWarnings int
+	}{
+		{name: "proxy timeout", expectedWarnings: 1},
+		{name: "store timeout", storeTimeout: 5 * time.Second, expectedSeries: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cls := []Client{
+				&storetestutil.TestClient{
+					Name: "slow",
+					StoreClient: &mockedStoreAPI{
+						RespSeries:   []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}})},
+						RespDuration: 200 * time.Millisecond,
+					},
+					MinTime:              math.MinInt64,
+					MaxTime:              math.MaxInt64,
+					StoreResponseTimeout: tc.storeTimeout,
+				},
+			}
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				50*time.Millisecond, LazyRetrieval,
+			)
+
+			s := newStoreSeriesServer(context.Background())
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+				MinTime:  0,
+				MaxTime:  100,
+				Matchers: []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+			}, s))
+			testutil.Equals(t, tc.expectedSeries, len(s.SeriesSet))
+			testutil.Equals(t, tc.expectedWarnings, len(s.Warnings))
+		})
+	}
+}
+
+func TestProxyStore_Series_HedgedReplication(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	newReplica := func(replica string, d time.Duration) Client {
+		return &storetestutil.TestClient{
+			Name: replica,
+			StoreClient: &mockedStoreAPI{
+				RespSeries:   []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", replica), []sample{{0, 0}})},
+				RespDuration: d,
+			},
+			MinTime:       math.MinInt64,
+			MaxTime:       math.MaxInt64,
+			GroupKeyStr:   "group",
+			ReplicaKeyStr: replica,
+		}
+	}
+	for _, tc := range []struct {
+		name           string
+		strategy       storepb.PartialResponseStrategy
+		hedgeDelay     time.Duration
+		expectedSeries []string
+	}{
+		{
+			// Only the first hedged request is sent, so the fast third replica is never queried.
+			name:           "group replica",
+			strategy:       storepb.PartialResponseStrategy_GROUP_REPLICA,
+			hedgeDelay:     5 * time.Second,
+			expectedSeries: []string{"primary"},
+		},
+		{
+			name:           "other strategies query all replicas",
+			strategy:       storepb.PartialResponseStrategy_WARN,
+			expectedSeries: []string{"fast", "hedge", "primary"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cls := []Client{
+				newReplica("primary", 200*time.Millisecond),
+				newReplica("hedge", tc.hedgeDelay),
+				newReplica("fast", 0),
+			}
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				10*time.Second, LazyRetrieval,
+				WithHedgedReplication(10*time.Millisecond),
+			)
+
+			s := newStoreSeriesServer(context.Background())
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+				MinTime:                 0,
+				MaxTime:                 10,
+				Matchers:                []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+				PartialResponseStrategy: tc.strategy,
+			}, s))
+			testutil.Equals(t, 0, len(s.Warnings))
+			var series []string
+			for _, sr := range s.SeriesSet {
+				series = append(series, labelpb.ZLabelsToPromLabels(sr.Labels).Get("a"))
+			}
+			testutil.Equals(t, tc.expectedSeries, series)
+		})
+	}
+}
+
+func TestProxyStore_PerStoreMaxConcurrency(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{
+			Name: "store",
+			StoreClient: &mockedStoreAPI{
+				RespSeries:     []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{0, 0}})},
+				RespLabelNames: &storepb.LabelNamesResponse{Names: []string{"a"}},
+			},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		50*time.Millisecond, EagerRetrieval,
+		WithPerStoreMaxConcurrency(1),
+	)
+	req := &storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+	}
+
+	// Occupy the only slot of the store.
+	release, err := q.storeConcurrency.acquire(context.Background(), "store", 0)
+	testutil.Ok(t, err)
+
+	s := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(req, s))
+	testutil.Equals(t, 0, len(s.SeriesSet))
+	testutil.Equals(t, 1, len(s.Warnings))
+	resp, err := q.LabelNames(context.Background(), &storepb.LabelNamesRequest{Start: 0, End: 100})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(resp.Warnings))
+	testutil.Equals(t, 2.0, promtest.ToFloat64(q.metrics.fanoutBlocked))
+
+	release()
+	s = newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(req, s))
+	testutil.Equals(t, 1, len(s.SeriesSet))
+	testutil.Equals(t, 0, len(s.Warnings))
+
+	// The slot is released once the responses are consumed.
+	release, err = q.storeConcurrency.acquire(context.Background(), "store", time.Second)
+	testutil.Ok(t, err)
+	release()
+}
+
+func TestProxyStore_Series_StoreRequestDuration(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	newStore := func(name string, m *mockedStoreAPI) Client {
+		return &storetestutil.TestClient{Name: name, StoreClient: m, MinTime: math.MinInt64, MaxTime: math.MaxInt64}
+	}
+	cls := []Client{
+		newStore("ok", &mockedStoreAPI{
+			RespSeries: []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{0, 0}})},
+		}),
+		newStore("failing", &mockedStoreAPI{RespError: errors.New("error")}),
+		newStore("slow", &mockedStoreAPI{
+			RespSeries:   []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{0, 0}})},
+			RespDuration: time.Second,
+		}),
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		50*time.Millisecond, LazyRetrieval,
+	)
+
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+	}, newStoreSeriesServer(context.Background())))
+
+	testutil.Equals(t, 3, promtest.CollectAndCount(q.metrics.storeSeriesDuration))
+	for _, lbls := range [][]string{{"ok", storeStatusSuccess}, {"failing", storeStatusError}, {"slow", storeStatusTimeout}} {
+		m := &dto.Metric{}
+		testutil.Ok(t, q.metrics.storeSeriesDuration.WithLabelValues(lbls...).(prometheus.Histogram).Write(m))
+		testutil.Equals(t, uint64(1), m.GetHistogram().GetSampleCount())
+	}
+}
+
+// flakyStoreAPI fails the first Series calls with the given error.
+type flakyStoreAPI struct {
+	*mockedStoreAPI
+
+	failures int
+	err      error
+	calls    int
+}
+
+func (s *flakyStoreAPI) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return nil, s.err
+	}
+	return s.mockedStoreAPI.Series(ctx, req, opts...)
+}
+
+func TestProxyStore_Series_Retry(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	for _, tc := range []struct {
+		name             string
+		failures         int
+		err              error
+		expectedCalls    int
+		expectedRetries  float64
+		expectedWarnings int
+	}{
+		{name: "recovered", failures: 2, err: status.Error(codes.Unavailable, "unavailable"), expectedCalls: 3, expectedRetries: 2},
+		{name: "attempts exhausted", failures: 5, err: status.Error(codes.ResourceExhausted, "exhausted"), expectedCalls: 3, expectedRetries: 2, expectedWarnings: 1},
+		{name: "not retried", failures: 1, err: status.Error(codes.Internal, "internal"), expectedCalls: 1, expectedWarnings: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			flaky := &flakyStoreAPI{
+				mockedStoreAPI: &mockedStoreAPI{
+					RespSeries: []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{0, 0}})},
+				},
+				failures: tc.failures,
+				err:      tc.err,
+			}
+			cls := []Client{
+				&storetestutil.TestClient{Name: "flaky", StoreClient: flaky, MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+			}
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				5*time.Second, EagerRetrieval,
+				WithRetry(3, time.Millisecond),
+			)
+
+			s := newStoreSeriesServer(context.Background())
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+				MinTime:  0,
+				MaxTime:  100,
+				Matchers: []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+			}, s))
+			testutil.Equals(t, tc.expectedCalls, flaky.calls)
+			testutil.Equals(t, tc.expectedWarnings, len(s.Warnings))
+			testutil.Equals(t, tc.expectedRetries, promtest.ToFloat64(q.metrics.fanoutRetries.WithLabelValues("flaky", status.Code(tc.err).String())))
+		})
+	}
+}
+
+// signalingStoreAPI closes called on its first Series call.
+type signalingStoreAPI struct {
+	*mockedStoreAPI
+	called chan struct{}
+}
+
+func (s *signalingStoreAPI) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	close(s.called)
+	return s.mockedStoreAPI.Series(ctx, req, opts...)
+}
+
+func TestProxyStore_Series_RetryDoesNotDelayOtherStores(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	flaky := &flakyStoreAPI{mockedStoreAPI: &mockedStoreAPI{}, failures: 1, err: status.Error(codes.Unavailable, "unavailable")}
+	healthy := &signalingStoreAPI{mockedStoreAPI: &mockedStoreAPI{}, called: make(chan struct{})}
+	cls := []Client{
+		&storetestutil.TestClient{Name: "flaky", StoreClient: flaky, MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+		&storetestutil.TestClient{Name: "healthy", StoreClient: healthy, MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		0, EagerRetrieval,
+		// The backoff of the flaky store only ends once the request is canceled.
+		WithRetry(2, time.Hour),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- q.Series(&storepb.SeriesRequest{
+			MinTime:  0,
+			MaxTime:  100,
+			Matchers: []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+		}, newStoreSeriesServer(ctx))
+	}()
+
+	select {
+	case <-healthy.called:
+	case <-time.After(10 * time.Second):
+		t.Fatal("healthy store was not called while the flaky store was waiting to be retried")
+	}
+	cancel()
+	<-done
+}
+
+func BenchmarkProxyStore_LabelNames(b *testing.B) {
+	const (
+		stores         = 100
+		namesPerStores = 10000
+	)
+	cls := make([]Client, 0, stores)
+	for i := 0; i < stores; i++ {
+		storeNames := make([]string, 0, namesPerStores)
+		for j := 0; j < namesPerStores; j++ {
+			// Consecutive stores share most of their label names.
+			storeNames = append(storeNames, fmt.Sprintf("label_%06d", i*100+j))
+		}
+		sort.Strings(storeNames)
+		cls = append(cls, &storetestutil.TestClient{
+			Name:        fmt.Sprintf("store-%d", i),
+			StoreClient: &mockedStoreAPI{RespLabelNames: &storepb.LabelNamesResponse{Names: storeNames}},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		})
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		0, EagerRetrieval,
+	)
+	req := &storepb.LabelNamesRequest{Start: 0, End: 100}
+
+	resp, err := q.LabelNames(context.Background(), req)
+	testutil.Ok(b, err)
+	testutil.Equals(b, (stores-1)*100+namesPerStores, len(resp.Names))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = q.LabelNames(context.Background(), req)
+	}
+}
+
+func TestStoreMatchesQueryHints(t *testing.T) {
+	st := &storetestutil.TestClient{ExtLset: []labels.Labels{labels.FromStrings("region", "eu")}}
+
+	for _, tc := range []struct {
+		name     string
+		s        Client
+		hints    *storepb.QueryHints
+		expected bool
+	}{
+		{name: "no hints", s: st, expected: true},
+		{name: "no matchers", s: st, hints: &storepb.QueryHints{StepMillis: 1000}, expected: true},
+		{name: "matching", s: st, hints: &storepb.QueryHints{Matchers: []storepb.LabelMatcher{{Name: "region", Value: "eu|us", Type: storepb.LabelMatcher_RE}}}, expected: true},
+		{name: "not matching", s: st, hints: &storepb.QueryHints{Matchers: []storepb.LabelMatcher{{Name: "region", Value: "us", Type: storepb.LabelMatcher_EQ}}}},
+		{name: "store without label sets", s: &storetestutil.TestClient{}, hints: &storepb.QueryHints{Matchers: []storepb.LabelMatcher{{Name: "region", Value: "us", Type: storepb.LabelMatcher_EQ}}}, expected: true},
+		{name: "invalid matcher", s: st, hints: &storepb.QueryHints{Matchers: []storepb.LabelMatcher{{Name: "region", Value: "(", Type: storepb.LabelMatcher_RE}}}, expected: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.Equals(t, tc.expected, storeMatchesQueryHints(tc.s, tc.hints))
+		})
+	}
+}
+
+func TestProxyStore_Series_QueryHintsFiltering(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	newStore := func(region string) (*mockedStoreAPI, Client) {
+		m := &mockedStoreAPI{
+			RespSeries: []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "b", "region", region), []sample{{0, 0}})},
+		}
+		return m, &storetestutil.TestClient{
+			Name:        region,
+			StoreClient: m,
+			ExtLset:     []labels.Labels{labels.FromStrings("region", region)},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		}
+	}
+	eu, euClient := newStore("eu")
+	us, usClient := newStore("us")
+	cls := []Client{euClient, usClient}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+	)
+
+	s := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:    0,
+		MaxTime:    100,
+		Matchers:   []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+		QueryHints: &storepb.QueryHints{Matchers: []storepb.LabelMatcher{{Name: "region", Value: "eu", Type: storepb.LabelMatcher_EQ}}},
+	}, s))
+	testutil.Equals(t, 1, len(s.SeriesSet))
+	testutil.Assert(t, eu.LastSeriesReq != nil, "store matching the query hints should be queried")
+	testutil.Assert(t, us.LastSeriesReq == nil, "store not matching the query hints should not be queried")
+	testutil.Equals(t, 1.0, promtest.ToFloat64(q.metrics.queryHintFiltered))
+}
+
+func TestProxyStore_Series_MaxSeriesPerQuery(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	newStore := func(name string) Client {
+		var resps []*storepb.SeriesResponse
+		for i := 0; i < 3; i++ {
+			resps = append(resps, storeSeriesResponse(t, labels.FromStrings("a", "b", "i", fmt.Sprintf("%s-%d", name, i)), []sample{{0, 0}}))
+		}
+		return &storetestutil.TestClient{
+			Name:        name,
+			StoreClient: &mockedStoreAPI{RespSeries: resps},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		}
+	}
+	cls := []Client{newStore("store-1"), newStore("store-2")}
+
+	for _, tc := range []struct {
+		name        string
+		limit       uint64
+		expectedErr bool
+	}{
+		{name: "no limit"},
+		{name: "limit not exceeded", limit: 6},
+		{name: "limit exceeded", limit: 5, expectedErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				5*time.Second, EagerRetrieval,
+				WithMaxSeriesPerQuery(tc.limit),
+			)
+
+			s := newStoreSeriesServer(context.Background())
+			err := q.Series(&storepb.SeriesRequest{
+				MinTime:                 0,
+				MaxTime:                 100,
+				Matchers:                []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+				PartialResponseStrategy: storepb.PartialResponseStrategy_WARN,
+			}, s)
+			if tc.expectedErr {
+				testutil.NotOk(t, err)
+				testutil.Equals(t, codes.Aborted, status.Code(err))
+				testutil.Equals(t, 1.0, promtest.ToFloat64(q.metrics.maxSeriesExceeded))
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equals(t, 6, len(s.SeriesSet))
+			testutil.Equals(t, 0.0, promtest.ToFloat64(q.metrics.maxSeriesExceeded))
+		})
+	}
+}
+
+// endlessStoreAPI streams series until its Series call is canceled.
+type endlessStoreAPI struct {
+	storepb.StoreClient
+
+	sent atomic.Int64
+}
+
+func (s *endlessStoreAPI) Series(ctx context.Context, _ *storepb.SeriesRequest, _ ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	return &endlessSeriesClient{ctx: ctx, store: s}, nil
+}
+
+type endlessSeriesClient struct {
+	storepb.Store_SeriesClient
+
+	ctx   context.Context
+	store *endlessStoreAPI
+}
+
+func (c *endlessSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	i := c.store.sent.Inc()
+	return storepb.NewSeriesResponse(&storepb.Series{
+		Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("a", "b", "i", fmt.Sprintf("%09d", i))),
+	}), nil
+}
+
+func TestProxyStore_Series_MaxSeriesPerQueryCancelsStores(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	for _, strategy := range []RetrievalStrategy{EagerRetrieval, LazyRetrieval} {
+		t.Run(string(strategy), func(t *testing.T) {
+			endless := &endlessStoreAPI{}
+			cls := []Client{
+				&storetestutil.TestClient{Name: "endless", StoreClient: endless, MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+			}
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				0, strategy,
+				WithMaxSeriesPerQuery(5),
+			)
+
+			err := q.Series(&storepb.SeriesRequest{
+				MinTime:  0,
+				MaxTime:  100,
+				Matchers: []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+			}, newStoreSeriesServer(context.Background()))
+			testutil.NotOk(t, err)
+			testutil.Equals(t, codes.Aborted, status.Code(err))
+			// The store is canceled as soon as the limit is exceeded, not once all of its series are buffered.
+			testutil.Equals(t, int64(6), endless.sent.Load())
+		})
+	}
+}
+
+func TestProxyStore_Series_QuorumStrategy(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	healthy := func(replica string) *mockedStoreAPI {
+		return &mockedStoreAPI{
+			RespSeries: []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "b", "replica", replica), []sample{{0, 0}})},
+		}
+	}
+	// Stores are called concurrently, so each of them needs its own mock.
+	failing := func() *mockedStoreAPI { return &mockedStoreAPI{RespError: errors.New("error")} }
+	warning := &mockedStoreAPI{RespSeries: []*storepb.SeriesResponse{storepb.NewWarnSeriesResponse(errors.New("warning"))}}
+
+	for _, tc := range []struct {
+		name           string
+		stores         []*mockedStoreAPI
+		expectedSeries int
+		expectedErr    bool
+	}{
+		{name: "all stores respond", stores: []*mockedStoreAPI{healthy("0"), healthy("1"), healthy("2")}, expectedSeries: 3},
+		{name: "minority failed", stores: []*mockedStoreAPI{healthy("0"), failing(), healthy("2")}, expectedSeries: 2},
+		{name: "minority warned", stores: []*mockedStoreAPI{healthy("0"), healthy("1"), warning}, expectedSeries: 2},
+		{name: "majority failed", stores: []*mockedStoreAPI{healthy("0"), failing(), failing()}, expectedErr: true},
+		{name: "majority failed or warned", stores: []*mockedStoreAPI{warning, failing(), healthy("2")}, expectedErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cls []Client
+			for i, m := range tc.stores {
+				cls = append(cls, &storetestutil.TestClient{
+					Name:          fmt.Sprintf("store-%d", i),
+					StoreClient:   m,
+					MinTime:       math.MinInt64,
+					MaxTime:       math.MaxInt64,
+					GroupKeyStr:   "group",
+					ReplicaKeyStr: fmt.Sprintf("replica-%d", i),
+				})
+			}
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				5*time.Second, EagerRetrieval,
+			)
+
+			s := newStoreSeriesServer(context.Background())
+			err := q.Series(&storepb.SeriesRequest{
+				MinTime:                 0,
+				MaxTime:                 100,
+				Matchers:                []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+				PartialResponseStrategy: storepb.PartialResponseStrategy_QUORUM,
+			}, s)
+			if tc.expectedErr {
+				testutil.NotOk(t, err)
+				testutil.Equals(t, codes.Aborted, status.Code(err))
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equals(t, tc.expectedSeries, len(s.SeriesSet))
+			testutil.Equals(t, 0, len(s.Warnings))
+		})
+	}
+}
+
+func TestDynamicClientPool(t *testing.T) {
+	newClient := func(name string) Client {
+		return &storetestutil.TestClient{Name: name}
+	}
+	names := func(clients []Client) []string {
+		var res []string
+		for _, c := range clients {
+			res = append(res, c.String())
+		}
+		return res
+	}
+
+	p := NewDynamicClientPool([]Client{newClient("a"), newClient("b")})
+	snapshot := p.Snapshot()
+
+	p.Add(newClient("c"))
+	p.Remove("a")
+	testutil.Equals(t, []string{"b", "c"}, names(p.Snapshot()))
+	testutil.Equals(t, []string{"a", "b"}, names(snapshot))
+
+	replacement := &storetestutil.TestClient{Name: "b", MinTime: 10}
+	p.Add(replacement)
+	testutil.Equals(t, []string{"b", "c"}, names(p.Snapshot()))
+	mint, _ := p.Snapshot()[0].TimeRange()
+	testutil.Equals(t, int64(10), mint)
+
+	p.Remove("unknown")
+	testutil.Equals(t, []string{"b", "c"}, names(p.Snapshot()))
+}
+
+func TestProxyStore_Series_DynamicClientPool(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	newClient := func(name string) Client {
+		return &storetestutil.TestClient{
+			Name: name,
+			StoreClient: &mockedStoreAPI{
+				RespSeries:   []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "b", "store", name), []sample{{0, 0}})},
+				RespDuration: time.Millisecond,
+			},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		}
+	}
+	pool := NewDynamicClientPool([]Client{newClient("static")})
+	q := NewProxyStore(nil, nil,
+		pool.Snapshot,
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("dynamic-%d", i)
+			for ctx.Err() == nil {
+				pool.Add(newClient(name))
+				pool.Remove(name)
+			}
+		}(i)
+	}
+
+	for i := 0; i < 20; i++ {
+		s := newStoreSeriesServer(context.Background())
+		testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+			MinTime:  0,
+			MaxTime:  100,
+			Matchers: []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+		}, s))
+		testutil.Equals(t, 0, len(s.Warnings))
+		// The static client is always queried, dynamic ones only if they were in the pool when the request started.
+		testutil.Assert(t, len(s.SeriesSet) >= 1 && len(s.SeriesSet) <= 3, "unexpected number of series %d", len(s.SeriesSet))
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestProxyStore_Blacklist(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	failing := &mockedStoreAPI{RespError: errors.New("error")}
+	cls := []Client{
+		&storetestutil.TestClient{Name: "failing", StoreClient: failing, MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+		&storetestutil.TestClient{
+			Name:        "healthy",
+			StoreClient: &mockedStoreAPI{RespLabelNames: &storepb.LabelNamesResponse{Names: []string{"a"}}},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+		WithBlacklistThreshold(2, time.Minute),
+		WithBlacklistBanDuration(5*time.Minute),
+	)
+	now := time.Now()
+	q.blacklist.now = func() time.Time { return now }
+
+	warnings := func() int {
+		resp, err := q.LabelNames(context.Background(), &storepb.LabelNamesRequest{Start: 0, End: 100})
+		testutil.Ok(t, err)
+		return len(resp.Warnings)
+	}
+
+	// Errors outside of the window do not count.
+	testutil.Equals(t, 1, warnings())
+	now = now.Add(2 * time.Minute)
+	testutil.Equals(t, 1, warnings())
+	testutil.Equals(t, 0.0, promtest.ToFloat64(q.metrics.blacklisted))
+
+	testutil.Equals(t, 1, warnings())
+	testutil.Equals(t, 1.0, promtest.ToFloat64(q.metrics.blacklisted))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(q.metrics.blacklistActive))
+
+	// The blacklisted store is skipped until the ban is over.
+	now = now.Add(4 * time.Minute)
+	testutil.Equals(t, 0, warnings())
+	now = now.Add(time.Minute)
+	testutil.Equals(t, 1, warnings())
+	testutil.Equals(t, 0.0, promtest.ToFloat64(q.metrics.blacklistActive))
+
+	// Context errors are not recorded.
+	failing.RespError = status.Error(codes.Canceled, "context canceled")
+	now = now.Add(2 * time.Minute)
+	for i := 0; i < 3; i++ {
+		testutil.Equals(t, 1, warnings())
+	}
+	testutil.Equals(t, 1.0, promtest.ToFloat64(q.metrics.blacklisted))
+
+	// The errors of removed stores are dropped once they are out of the window.
+	cls = cls[1:]
+	now = now.Add(2 * time.Minute)
+	testutil.Equals(t, 0, warnings())
+	testutil.Equals(t, 0, len(q.blacklist.errors))
+}
+
+func TestProxyStore_SetTSDBSelector(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	dropExt := func(value string) *TSDBSelector {
+		return NewTSDBSelector([]*relabel.Config{{
+			SourceLabels: model.LabelNames{"ext"},
+			Regex:        relabel.MustNewRegexp(value),
+			Action:       relabel.Drop,
+		}})
+	}
+
+	var cls []Client
+	for _, ext := range []string{"a", "b"} {
+		cls = append(cls, &storetestutil.TestClient{
+			Name: ext,
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("ext", ext), []sample{{0, 0}})},
+			},
+			ExtLset: []labels.Labels{labels.FromStrings("ext", ext)},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		})
+	}
+
+	var q *ProxyStore
+	reload := true
+	q = NewProxyStore(nil, nil,
+		func() []Client {
+			// Reload the selector while the first request is in flight.
+			if reload {
+				reload = false
+				q.SetTSDBSelector(dropExt("a"))
+			}
+			return cls
+		},
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+		WithTSDBSelector(dropExt("b")),
+	)
+
+	series := func() []storepb.Series {
+		srv := newStoreSeriesServer(context.Background())
+		testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+			MinTime:  0,
+			MaxTime:  100,
+			Matchers: []storepb.LabelMatcher{{Name: "ext", Value: ".+", Type: storepb.LabelMatcher_RE}},
+		}, srv))
+		return srv.SeriesSet
+	}
+
+	// The in-flight request keeps the selector it started with, later requests use the new one.
+	res := series()
+	testutil.Equals(t, 1, len(res))
+	testutil.Equals(t, "a", res[0].PromLabels().Get("ext"))
+
+	res = series()
+	testutil.Equals(t, 1, len(res))
+	testutil.Equals(t, "b", res[0].PromLabels().Get("ext"))
+
+	// A nil selector selects all TSDBs.
+	q.SetTSDBSelector(nil)
+	testutil.Equals(t, 2, len(series()))
+}
+
+func TestLabelSetFilters(t *testing.T) {
+	st := &storetestutil.TestClient{
+		Name: "store",
+		ExtLset: []labels.Labels{
+			labels.FromStrings("cluster", "eu-west", "tenant", "a"),
+			labels.FromStrings("cluster", "eu-east", "tenant", "b", "zone", "1"),
+		},
+	}
+	filters := &labelSetFilters{filters: map[string]*labelSetFilter{}}
+
+	for _, tc := range []struct {
+		name     string
+		matchers []*labels.Matcher
+		rejected bool
+	}{
+		{name: "no matchers"},
+		{name: "matching equal", matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "cluster", "eu-east")}},
+		{name: "not matching equal", matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "cluster", "us-east")}, rejected: true},
+		{name: "empty equal on common label", matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "tenant", "")}, rejected: true},
+		{name: "label missing from a label set", matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "zone", "2")}},
+		{name: "unknown label", matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "region", "eu")}},
+		{name: "regexp", matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "cluster", "us-.*")}},
+		{
+			name: "one of several matchers not matching",
+			matchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchEqual, "cluster", "eu-west"),
+				labels.MustNewMatcher(labels.MatchEqual, "tenant", "c"),
+			},
+			rejected: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.Equals(t, tc.rejected, filters.rejects(st, tc.matchers))
+			if tc.rejected {
+				testutil.Assert(t, !labelSetsMatch(tc.matchers, st.LabelSets()...), "rejected store matches")
+			}
+		})
+	}
+
+	// The filter is rebuilt once the label sets of the store change.
+	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "cluster", "us-east")}
+	st.ExtLset = append(st.ExtLset, labels.FromStrings("cluster", "us-east", "tenant", "c"))
+	testutil.Assert(t, !filters.rejects(st, matchers))
+	testutil.Equals(t, 1, len(filters.filters))
+}
+
+func TestProxyStore_Series_BloomFilterLabelSets(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	var cls []Client
+	for _, cluster := range []string{"eu", "us"} {
+		cls = append(cls, &storetestutil.TestClient{
+			Name: cluster,
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("cluster", cluster), []sample{{0, 0}})},
+			},
+			ExtLset: []labels.Labels{labels.FromStrings("cluster", cluster)},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		})
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+		WithBloomFilterLabelSets(),
+	)
+
+	srv := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Name: "cluster", Value: "us", Type: storepb.LabelMatcher_EQ}},
+	}, srv))
+	testutil.Equals(t, 1, len(srv.SeriesSet))
+	testutil.Equals(t, "us", srv.SeriesSet[0].PromLabels().Get("cluster"))
+	testutil.Equals(t, 2, len(q.labelSetFilters.filters))
+	testutil.Assert(t, q.labelSetFilters.filters["eu"].rejects([]*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "cluster", "us")}))
+}
+
+func BenchmarkStoreLabelSetsMatching(b *testing.B) {
+	const (
+		stores          = 500
+		labelSetsPerSet = 50
+	)
+	cls := make([]Client, 0, stores)
+	for i := 0; i < stores; i++ {
+		lsets := make([]labels.Labels, 0, labelSetsPerSet)
+		for j := 0; j < labelSetsPerSet; j++ {
+			lsets = append(lsets, labels.FromStrings(
+				"cluster", fmt.Sprintf("cluster-%d", i),
+				"replica", "0",
+				"tenant_id", fmt.Sprintf("tenant-%d", j),
+			))
+		}
+		cls = append(cls, &storetestutil.TestClient{Name: fmt.Sprintf("store-%d", i), ExtLset: lsets})
+	}
+	matchers := []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, "__name__", "up"),
+		labels.MustNewMatcher(labels.MatchEqual, "tenant_id", "tenant-10"),
+		labels.MustNewMatcher(labels.MatchEqual, "cluster", "cluster-42"),
+	}
+
+	b.Run("label sets", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			matched := 0
+			for _, st := range cls {
+				if labelSetsMatch(matchers, st.LabelSets()...) {
+					matched++
+				}
+			}
+			testutil.Equals(b, 1, matched)
+		}
+	})
+	b.Run("bloom filter", func(b *testing.B) {
+		filters := &labelSetFilters{filters: map[string]*labelSetFilter{}}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			matched := 0
+			for _, st := range cls {
+				if !filters.rejects(st, matchers) && labelSetsMatch(matchers, st.LabelSets()...) {
+					matched++
+				}
+			}
+			testutil.Equals(b, 1, matched)
+		}
+	})
+}
+
+func TestProxyStore_Series_NativeHistograms(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	var series []*storepb.Series
+	for j, sampleType := range []chunkenc.ValueType{chunkenc.ValHistogram, chunkenc.ValFloatHistogram} {
+		head, s := storetestutil.CreateHeadWithSeries(t, j, storetestutil.HeadGenOptions{
+			TSDBDir:          t.TempDir(),
+			SamplesPerSeries: 300,
+			Series:           3,
+			SampleType:       sampleType,
+		})
+		testutil.Ok(t, head.Close())
+		series = append(series, s...)
+	}
+
+	// Each series is returned by two replicas, in reverse order for the second one.
+	responses := func(reverse bool) []*storepb.SeriesResponse {
+		resps := make([]*storepb.SeriesResponse, 0, len(series))
+		for _, s := range series {
+			resps = append(resps, storepb.NewSeriesResponse(s))
+		}
+		if reverse {
+			for i, j := 0, len(resps)-1; i < j; i, j = i+1, j-1 {
+				resps[i], resps[j] = resps[j], resps[i]
+			}
+		}
+		return resps
+	}
+	cls := []Client{
+		&storetestutil.TestClient{Name: "1", StoreClient: &mockedStoreAPI{RespSeries: responses(false)}, MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+		&storetestutil.TestClient{Name: "2", StoreClient: &mockedStoreAPI{RespSeries: responses(true)}, MinTime: math.MinInt64, MaxTime: math.MaxInt64},
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+	)
+
+	srv := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  math.MinInt64,
+		MaxTime:  math.MaxInt64,
+		Matchers: []storepb.LabelMatcher{{Name: "foo", Value: "bar", Type: storepb.LabelMatcher_EQ}},
+	}, srv))
+
+	testutil.Equals(t, len(series), len(srv.SeriesSet))
+	for i, s := range srv.SeriesSet {
+		testutil.Equals(t, series[i].Labels, s.Labels)
+		testutil.Equals(t, len(series[i].Chunks), len(s.Chunks))
+		for j, c := range s.Chunks {
+			testutil.Equals(t, series[i].Chunks[j].MinTime, c.MinTime)
+			testutil.Equals(t, series[i].Chunks[j].MaxTime, c.MaxTime)
+			testutil.Equals(t, series[i].Chunks[j].Raw.Type, c.Raw.Type)
+			testutil.Equals(t, series[i].Chunks[j].Raw.Data, c.Raw.Data)
+		}
+	}
+	testutil.Equals(t, storepb.Chunk_HISTOGRAM, srv.SeriesSet[0].Chunks[0].Raw.Type)
+	testutil.Equals(t, storepb.Chunk_FLOAT_HISTOGRAM, srv.SeriesSet[len(series)-1].Chunks[0].Raw.Type)
+}
+
+func TestProxyStore_TenantStoreRouter(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	stores := map[string]Client{}
+	var cls []Client
+	for _, name := range []string{"a", "b"} {
+		st := &storetestutil.TestClient{
+			Name: name,
+			StoreClient: &mockedStoreAPI{
+				RespSeries:      []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("store", name), []sample{{0, 0}})},
+				RespLabelNames:  &storepb.LabelNamesResponse{Names: []string{name}},
+				RespLabelValues: &storepb.LabelValuesResponse{Values: []string{name}},
+			},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		}
+		stores[name] = st
+		cls = append(cls, st)
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+		WithTenantStoreRouter(func(tenant string) []Client {
+			if tenant == "tenant-a" {
+				return []Client{stores["a"]}
+			}
+			return nil
+		}),
+	)
+
+	for _, tc := range []struct {
+		tenant   string
+		expected []string
+	}{
+		{tenant: "tenant-a", expected: []string{"a"}},
+		{tenant: "tenant-b", expected: []string{"a", "b"}},
+	} {
+		t.Run(tc.tenant, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(tenancy.DefaultTenantHeader, tc.tenant))
+
+			srv := newStoreSeriesServer(ctx)
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+				MinTime:  0,
+				MaxTime:  100,
+				Matchers: []storepb.LabelMatcher{{Name: "store", Value: ".+", Type: storepb.LabelMatcher_RE}},
+			}, srv))
+			var series []string
+			for _, s := range srv.SeriesSet {
+				series = append(series, s.PromLabels().Get("store"))
+			}
+			testutil.Equals(t, tc.expected, series)
+
+			names, err := q.LabelNames(ctx, &storepb.LabelNamesRequest{Start: 0, End: 100})
+			testutil.Ok(t, err)
+			testutil.Equals(t, tc.expected, names.Names)
+
+			values, err := q.LabelValues(ctx, &storepb.LabelValuesRequest{Label: "store", Start: 0, End: 100})
+			testutil.Ok(t, err)
+			testutil.Equals(t, tc.expected, values.Values)
+		})
+	}
+}
+
+// concurrencyTracker records the maximum number of Series streams open at the same time.
+type concurrencyTracker struct {
+	mtx         sync.Mutex
+	active, max int
+}
+
+type concurrencyTrackingStoreAPI struct {
+	storepb.StoreClient
+	tracker *concurrencyTracker
+}
+
+func (s *concurrencyTrackingStoreAPI) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	cl, err := s.StoreClient.Series(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	s.tracker.mtx.Lock()
+	s.tracker.active++
+	s.tracker.max = max(s.tracker.max, s.tracker.active)
+	s.tracker.mtx.Unlock()
+
+	var once sync.Once
+	return &fanoutSeriesClient{Store_SeriesClient: cl, streamDone: func() {
+		once.Do(func() {
+			s.tracker.mtx.Lock()
+			s.tracker.active--
+			s.tracker.mtx.Unlock()
+		})
+	}}, nil
+}
+
+// gatedStoreAPI blocks the Series streams of requests starting at time 0 until gate is closed.
+type gatedStoreAPI struct {
+	storepb.StoreClient
+
+	resps   []*storepb.SeriesResponse
+	gate    chan struct{}
+	started chan struct{}
+}
+
+func (s *gatedStoreAPI) Series(ctx context.Context, req *storepb.SeriesRequest, _ ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	cl := &storetestutil.StoreSeriesClient{Ctx: ctx, RespSet: s.resps}
+	if req.MinTime != 0 {
+		return cl, nil
+	}
+	s.started <- struct{}{}
+	return &gatedSeriesClient{Store_SeriesClient: cl, gate: s.gate}, nil
+}
+
+type gatedSeriesClient struct {
+	storepb.Store_SeriesClient
+	gate chan struct{}
+}
+
+func (c *gatedSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	<-c.gate
+	return c.Store_SeriesClient.Recv()
+}
+
+func TestProxyStore_Series_FanoutConcurrency(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	const stores = 5
+
+	tracker := &concurrencyTracker{}
+	var cls []Client
+	for i := 0; i < stores; i++ {
+		name := fmt.Sprintf("store-%d", i)
+		cls = append(cls, &storetestutil.TestClient{
+			Name: name,
+			StoreClient: &concurrencyTrackingStoreAPI{
+				StoreClient: &mockedStoreAPI{
+					RespSeries:   []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("store", name), []sample{{0, 0}})},
+					RespDuration: 10 * time.Millisecond,
+				},
+				tracker: tracker,
+			},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		})
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+		WithFanoutConcurrency(2),
+	)
+
+	s := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Name: "store", Value: ".+", Type: storepb.LabelMatcher_RE}},
+	}, s))
+	testutil.Equals(t, stores, len(s.SeriesSet))
+	testutil.Equals(t, 0, len(s.Warnings))
+	testutil.Equals(t, 2, tracker.max)
+	testutil.Equals(t, 0.0, promtest.ToFloat64(q.metrics.fanoutActive))
+
+	t.Run("slots are not shared between requests", func(t *testing.T) {
+		gate := make(chan struct{})
+		started := make(chan struct{}, stores)
+		var cls []Client
+		for i := 0; i < stores; i++ {
+			name := fmt.Sprintf("store-%d", i)
+			cls = append(cls, &storetestutil.TestClient{
+				Name: name,
+				StoreClient: &gatedStoreAPI{
+					resps:   []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("store", name), []sample{{0, 0}})},
+					gate:    gate,
+					started: started,
+				},
+				MinTime: math.MinInt64,
+				MaxTime: math.MaxInt64,
+			})
+		}
+		q := NewProxyStore(nil, nil,
+			func() []Client { return cls },
+			component.Query,
+			labels.EmptyLabels(),
+			5*time.Second, EagerRetrieval,
+			WithFanoutConcurrency(2),
+		)
+		matchers := []storepb.LabelMatcher{{Name: "store", Value: ".+", Type: storepb.LabelMatcher_RE}}
+
+		// The streams of the gated request hold both of its slots until the gate is opened.
+		gated := newStoreSeriesServer(context.Background())
+		gatedErr := make(chan error, 1)
+		go func() {
+			gatedErr <- q.Series(&storepb.SeriesRequest{MinTime: 0, MaxTime: 100, Matchers: matchers}, gated)
+		}()
+		<-started
+		<-started
+
+		// Concurrent requests get slots of their own.
+		var g errgroup.Group
+		srvs := make([]*storeSeriesServer, 3)
+		for i := range srvs {
+			srvs[i] = newStoreSeriesServer(context.Background())
+			srv := srvs[i]
+			g.Go(func() error {
+				return q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 100, Matchers: matchers}, srv)
+			})
+		}
+		testutil.Ok(t, g.Wait())
+		for _, srv := range srvs {
+			testutil.Equals(t, stores, len(srv.SeriesSet))
+			testutil.Equals(t, 0, len(srv.Warnings))
+		}
+		testutil.Equals(t, 0, len(started))
+
+		close(gate)
+		testutil.Ok(t, <-gatedErr)
+		testutil.Equals(t, stores, len(gated.SeriesSet))
+		testutil.Equals(t, 0, len(gated.Warnings))
+		testutil.Equals(t, 0.0, promtest.ToFloat64(q.metrics.fanoutActive))
+	})
+
+	t.Run("ignored with lazy retrieval", func(t *testing.T) {
+		q := NewProxyStore(nil, nil,
+			func() []Client { return cls },
+			component.Query,
+			labels.EmptyLabels(),
+			5*time.Second, LazyRetrieval,
+			WithFanoutConcurrency(2),
+		)
+		testutil.Equals(t, 0, q.fanoutConcurrency)
+	})
+}
+
+func BenchmarkProxySeriesFanout(b *testing.B) {
+	const stores = 100
+
+	var cls []Client
+	for i := 0; i < stores; i++ {
+		name := fmt.Sprintf("store-%d", i)
+		cls = append(cls, &storetestutil.TestClient{
+			Name: name,
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{storeSeriesResponse(b, labels.FromStrings("store", name), []sample{{0, 0}})},
+			},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		})
+	}
+	req := &storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Name: "store", Value: ".+", Type: storepb.LabelMatcher_RE}},
+	}
+
+	for _, concurrency := range []int{0, 10} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				5*time.Second, EagerRetrieval,
+				WithFanoutConcurrency(concurrency),
+			)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s := newStoreSeriesServer(context.Background())
+				testutil.Ok(b, q.Series(req, s))
+				testutil.Equals(b, stores, len(s.SeriesSet))
+			}
+		})
+	}
+}
+
+func BenchmarkProxySeriesBufferSizes(b *testing.B) {
+	const (
+		stores          = 4
+		seriesPerStore  = 10
+		labelValueBytes = 1 << 20
+	)
+
+	// The pooled buffers hold the sharding labels of each series, so the series have 1MiB label values.
+	var cls []Client
+	for i := 0; i < stores; i++ {
+		var resps []*storepb.SeriesResponse
+		for j := 0; j < seriesPerStore; j++ {
+			value := fmt.Sprintf("%d-%d-%s", i, j, strings.Repeat("x", labelValueBytes))
+			resps = append(resps, storeSeriesResponse(b, labels.FromStrings("a", value), []sample{{0, 0}}))
+		}
+		cls = append(cls, &storetestutil.TestClient{
+			Name:        fmt.Sprintf("store-%d", i),
+			StoreClient: &mockedStoreAPI{RespSeries: resps},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		})
+	}
+	req := &storepb.SeriesRequest{
+		MinTime:   0,
+		MaxTime:   100,
+		Matchers:  []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+		ShardInfo: &storepb.ShardInfo{TotalShards: 2, By: true, Labels: []string{"a"}},
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts []ProxyStoreOption
+	}{
+		{name: "default"},
+		{name: "initial=2MiB", opts: []ProxyStoreOption{WithBufferInitialSize(2 << 20)}},
+		{name: "max=64KiB", opts: []ProxyStoreOption{WithBufferMaxSize(64 << 10)}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(),
+				5*time.Second, EagerRetrieval,
+				tc.opts...,
+			)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s := newStoreSeriesServer(context.Background())
+				testutil.Ok(b, q.Series(req, s))
+			}
+		})
+	}
+}
+
+func TestProxyStore_BufferInitialSize(t *testing.T) {
+	q := NewProxyStore(nil, nil,
+		func() []Client { return nil },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+		WithBufferInitialSize(1024),
+	)
+	testutil.Equals(t, 1024, cap(*q.buffers.Get().(*[]byte)))
+}
+
+func TestProxyStore_LabelValues_Sorted(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	var cls []Client
+	for i, values := range [][]string{
+		{"c", "a", "e"},
+		{"d", "b", "a"},
+		{"e", "f", "c", "b"},
+	} {
+		cls = append(cls, &storetestutil.TestClient{
+			Name:        fmt.Sprintf("store-%d", i),
+			StoreClient: &mockedStoreAPI{RespLabelValues: &storepb.LabelValuesResponse{Values: values}},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		})
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+	)
+
+	resp, err := q.LabelValues(context.Background(), &storepb.LabelValuesRequest{Label: "a", Start: 0, End: 100})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"a", "b", "c", "d", "e", "f"}, resp.Values)
+}
+
+// blockingLabelsStore is a store whose label calls block until their context is done.
+type blockingLabelsStore struct {
+	storepb.StoreClient
+}
+
+func (blockingLabelsStore) LabelNames(ctx context.Context, _ *storepb.LabelNamesRequest, _ ...grpc.CallOption) (*storepb.LabelNamesResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockingLabelsStore) LabelValues(ctx context.Context, _ *storepb.LabelValuesRequest, _ ...grpc.CallOption) (*storepb.LabelValuesResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestProxyStore_LabelNames_StoreTimeout(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{
+			Name:        "fast",
+			StoreClient: &mockedStoreAPI{RespLabelNames: &storepb.LabelNamesResponse{Names: []string{"a", "b"}}},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		},
+		&storetestutil.TestClient{
+			Name:        "slow",
+			StoreClient: blockingLabelsStore{},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		100*time.Millisecond, EagerRetrieval,
+	)
+
+	start := time.Now()
+	resp, err := q.LabelNames(context.Background(), &storepb.LabelNamesRequest{Start: 0, End: 100})
+	testutil.Ok(t, err)
+	testutil.Assert(t, time.Since(start) < time.Second, "label names took %v", time.Since(start))
+	testutil.Equals(t, []string{"a", "b"}, resp.Names)
+	testutil.Equals(t, 1, len(resp.Warnings))
+	testutil.Assert(t, strings.Contains(resp.Warnings[0], "slow"), resp.Warnings[0])
+
+	_, err = q.LabelNames(context.Background(), &storepb.LabelNamesRequest{Start: 0, End: 100, PartialResponseDisabled: true})
+	testutil.NotOk(t, err)
+}
+
+func TestProxyStore_LabelValues_StoreTimeout(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{
+			Name:        "fast",
+			StoreClient: &mockedStoreAPI{RespLabelValues: &storepb.LabelValuesResponse{Values: []string{"x", "y"}}},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		},
+		&storetestutil.TestClient{
+			Name:        "slow",
+			StoreClient: blockingLabelsStore{},
+			MinTime:     math.MinInt64,
+			MaxTime:     math.MaxInt64,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		100*time.Millisecond, EagerRetrieval,
+	)
+
+	start := time.Now()
+	resp, err := q.LabelValues(context.Background(), &storepb.LabelValuesRequest{Label: "a", Start: 0, End: 100})
+	testutil.Ok(t, err)
+	testutil.Assert(t, time.Since(start) < 200*time.Millisecond, "label values took %v", time.Since(start))
+	testutil.Equals(t, []string{"x", "y"}, resp.Values)
+	testutil.Equals(t, 1, len(resp.Warnings))
+	testutil.Assert(t, strings.Contains(resp.Warnings[0], "slow"), resp.Warnings[0])
+
+	_, err = q.LabelValues(context.Background(), &storepb.LabelValuesRequest{Label: "a", Start: 0, End: 100, PartialResponseDisabled: true})
+	testutil.NotOk(t, err)
+}
+
+func TestProxyStore_StoreFilteredMetric(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	newStore := func(name, ext string, mint int64) Client {
+		return &storetestutil.TestClient{
+			Name:        name,
+			StoreClient: &mockedStoreAPI{RespLabelNames: &storepb.LabelNamesResponse{}},
+			ExtLset:     []labels.Labels{labels.FromStrings("ext", ext)},
+			MinTime:     mint,
+			MaxTime:     math.MaxInt64,
+		}
+	}
+	cls := []Client{
+		newStore("queried", "a", 0),
+		newStore("old", "a", 1000),
+		newStore("other", "b", 0),
+		newStore("dropped", "c", 0),
+	}
+	q := NewProxyStore(nil, prometheus.NewRegistry(),
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+		WithTSDBSelector(NewTSDBSelector([]*relabel.Config{{
+			SourceLabels: model.LabelNames{"ext"},
+			Regex:        relabel.MustNewRegexp("c"),
+			Action:       relabel.Drop,
+		}})),
+	)
+
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Name: "ext", Value: "a|c", Type: storepb.LabelMatcher_RE}},
+	}, newStoreSeriesServer(context.Background())))
+
+	filtered := q.metrics.storeFilteredTotal
+	testutil.Equals(t, 1.0, promtest.ToFloat64(filtered.WithLabelValues(filterCauseTimeRange)))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(filtered.WithLabelValues(filterCauseLabelSet)))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(filtered.WithLabelValues(filterCauseTSDBSelector)))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(filtered.WithLabelValues(filterCauseDebugMetadata)))
+
+	// Stores not matching the debug store matchers are filtered out in label calls too.
+	ctx := context.WithValue(context.Background(), StoreMatcherKey, [][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchEqual, "__address__", "none")}})
+	_, err := q.LabelNames(ctx, &storepb.LabelNamesRequest{Start: 0, End: 100})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2.0, promtest.ToFloat64(filtered.WithLabelValues(filterCauseTimeRange)))
+	testutil.Equals(t, 3.0, promtest.ToFloat64(filtered.WithLabelValues(filterCauseDebugMetadata)))
+}
+
+func TestProxyStore_Series_GroupMetrics(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	cls := []Client{
+		&storetestutil.TestClient{
+			Name: "ok",
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{0, 0}})},
+			},
+			GroupKeyStr:   "group",
+			ReplicaKeyStr: "replica-1",
+			MinTime:       math.MinInt64,
+			MaxTime:       math.MaxInt64,
+		},
+		&storetestutil.TestClient{
+			Name:          "failing",
+			StoreClient:   &mockedStoreAPI{RespError: errors.New("unavailable")},
+			GroupKeyStr:   "group",
+			ReplicaKeyStr: "replica-2",
+			MinTime:       math.MinInt64,
+			MaxTime:       math.MaxInt64,
+		},
+	}
+	q := NewProxyStore(nil, prometheus.NewRegistry(),
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+	)
+
+	for i := 0; i < 2; i++ {
+		testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+			MinTime:  0,
+			MaxTime:  100,
+			Matchers: []storepb.LabelMatcher{{Name: "a", Value: "b", Type: storepb.LabelMatcher_EQ}},
+		}, newStoreSeriesServer(context.Background())))
+	}
+
+	testutil.Equals(t, 2.0, promtest.ToFloat64(q.metrics.storeGroupFanoutTotal.WithLabelValues("group", "replica-1")))
+	testutil.Equals(t, 2.0, promtest.ToFloat64(q.metrics.storeGroupFanoutTotal.WithLabelValues("group", "replica-2")))
+	testutil.Equals(t, 0.0, promtest.ToFloat64(q.metrics.storeGroupErrorTotal.WithLabelValues("group", "replica-1")))
+	testutil.Equals(t, 2.0, promtest.ToFloat64(q.metrics.storeGroupErrorTotal.WithLabelValues("group", "replica-2")))
+}
+
+func TestProxyStore_InfoCache(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	calls := 0
+	q := NewProxyStore(nil, prometheus.NewRegistry(),
+		func() []Client {
+			calls++
+			return []Client{&storetestutil.TestClient{
+				ExtLset: []labels.Labels{labels.FromStrings("ext", "a")},
+				MinTime: 10,
+				MaxTime: 20,
+			}}
+		},
+		component.Query,
+		labels.EmptyLabels(), 0*time.Second, EagerRetrieval,
+		WithInfoCacheTTL(time.Hour),
+	)
+
+	first, err := q.Info(context.Background(), &storepb.InfoRequest{})
+	testutil.Ok(t, err)
+	second, err := q.Info(context.Background(), &storepb.InfoRequest{})
+	testutil.Ok(t, err)
+	testutil.Equals(t, first, second)
+	testutil.Equals(t, 1, calls)
+	testutil.Equals(t, 1.0, promtest.ToFloat64(q.metrics.infoCacheHits))
+
+	// Modifying a returned response does not modify the cached one.
+	second.LabelSets[0].Labels[0].Value = "modified"
+	second.LabelSets = append(second.LabelSets, labelpb.ZLabelSet{})
+	third, err := q.Info(context.Background(), &storepb.InfoRequest{})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []labelpb.ZLabelSet{{Labels: []labelpb.ZLabel{{Name: "ext", Value: "a"}}}}, third.LabelSets)
+	first.LabelSets[0].Labels[0].Value = "modified"
+	third, err = q.Info(context.Background(), &storepb.InfoRequest{})
+	testutil.Ok(t, err)
+	testutil.Equals(t, "a", third.LabelSets[0].Labels[0].Value)
+
+	// Changing the TSDB selector drops the cached response.
+	q.SetTSDBSelector(DefaultSelector)
+	_, err = q.Info(context.Background(), &storepb.InfoRequest{})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, calls)
+
+	// Responses older than the TTL are computed again.
+	q.infoCache.Store(&cachedInfoResponse{res: first, createdAt: time.Now().Add(-2 * time.Hour)})
+	_, err = q.Info(context.Background(), &storepb.InfoRequest{})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, calls)
+	testutil.Equals(t, 3.0, promtest.ToFloat64(q.metrics.infoCacheHits))
+}
+
+func TestProxyStore_LabelSetCache(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	calls := 0
+	q := NewProxyStore(nil, nil,
+		func() []Client {
+			calls++
+			return []Client{&storetestutil.TestClient{ExtLset: []labels.Labels{labels.FromStrings("ext", "a")}}}
+		},
+		component.Query,
+		labels.EmptyLabels(), 0*time.Second, EagerRetrieval,
+		WithLabelSetCacheTTL(time.Hour),
+	)
+	expected := []labelpb.ZLabelSet{{Labels: []labelpb.ZLabel{{Name: "ext", Value: "a"}}}}
+
+	// The label sets are only computed on the first call.
+	testutil.Equals(t, 0, calls)
+	testutil.Equals(t, expected, q.LabelSet())
+	testutil.Equals(t, expected, q.LabelSet())
+	testutil.Equals(t, 1, calls)
+
+	// Modifying the returned label sets does not modify the cached ones.
+	q.LabelSet()[0].Labels[0].Value = "modified"
+	testutil.Equals(t, expected, q.LabelSet())
+
+	// Changing the TSDB selector drops the cached label sets.
+	q.SetTSDBSelector(DefaultSelector)
+	testutil.Equals(t, expected, q.LabelSet())
+	testutil.Equals(t, 2, calls)
+
+	// Label sets older than the TTL are computed again.
+	q.labelSetCache.Store(&cachedLabelSets{labelSets: expected, createdAt: time.Now().Add(-2 * time.Hour)})
+	testutil.Equals(t, expected, q.LabelSet())
+	testutil.Equals(t, 3, calls)
+}
+
+// timeRangeCountingClient counts the TimeRange calls of a client.
+type timeRangeCountingClient struct {
+	Client
+	calls *int
+}
+
+func (c *timeRangeCountingClient) TimeRange() (int64, int64) {
+	*c.calls++
+	return c.Client.TimeRange()
+}
+
+func TestProxyStore_TimeRangeCache(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	calls := 0
+	newStore := func(name string, mint, maxt int64) Client {
+		return &timeRangeCountingClient{Client: &storetestutil.TestClient{Name: name, MinTime: mint, MaxTime: maxt}, calls: &calls}
+	}
+	stores := []Client{newStore("a", 10, 20)}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return stores },
+		component.Query,
+		labels.EmptyLabels(), 0*time.Second, EagerRetrieval,
+	)
+
+	// The cache is enabled by default.
+	for i := 0; i < 2; i++ {
+		mint, maxt := q.TimeRange()
+		testutil.Equals(t, int64(10), mint)
+		testutil.Equals(t, int64(20), maxt)
+	}
+	testutil.Equals(t, 1, calls)
+
+	// Changes of the store list are reflected immediately, even without invalidating the caches.
+	stores = []Client{stores[0], newStore("b", 0, 30)}
+	mint, maxt := q.TimeRange()
+	testutil.Equals(t, int64(0), mint)
+	testutil.Equals(t, int64(30), maxt)
+	stores = stores[:1]
+	mint, maxt = q.TimeRange()
+	testutil.Equals(t, int64(10), mint)
+	testutil.Equals(t, int64(20), maxt)
+	testutil.Equals(t, 4, calls)
+
+	// A time range computed before the caches are invalidated is not used after.
+	generation := q.cacheGeneration.Load()
+	q.InvalidateCaches()
+	q.cacheTimeRange(stores, generation, 1, 2)
+	mint, _ = q.TimeRange()
+	testutil.Equals(t, int64(10), mint)
+	testutil.Equals(t, 5, calls)
+
+	// Time ranges older than the TTL are computed again.
+	q.timeRangeCache.Store(&cachedTimeRange{mint: 1, maxt: 2, computedAt: time.Now().Add(-time.Minute), stores: stores, generation: q.cacheGeneration.Load()})
+	mint, _ = q.TimeRange()
+	testutil.Equals(t, int64(10), mint)
+	testutil.Equals(t, 6, calls)
+}
+
+func BenchmarkProxyStoreTimeRange(b *testing.B) {
+	var cls []Client
+	for i := 0; i < 500; i++ {
+		cls = append(cls, &storetestutil.TestClient{Name: fmt.Sprintf("store-%d", i), MinTime: int64(i), MaxTime: int64(1000 + i)})
+	}
+
+	for _, ttl := range []time.Duration{0, defaultTimeRangeCacheTTL} {
+		b.Run(fmt.Sprintf("ttl=%s", ttl), func(b *testing.B) {
+			q := NewProxyStore(nil, nil,
+				func() []Client { return cls },
+				component.Query,
+				labels.EmptyLabels(), 0*time.Second, EagerRetrieval,
+				WithTimeRangeCacheTTL(ttl),
+			)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				q.TimeRange()
+			}
+		})
+	}
+}
+
+func TestProxyStore_HealthProbe(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	newProxy := func(info *storepb.InfoResponse) *ProxyStore {
+		st := &storetestutil.TestClient{StoreClient: &mockedStoreAPI{RespInfo: info}, Name: "store"}
+		return NewProxyStore(nil, nil,
+			func() []Client { return []Client{st} },
+			component.Query,
+			labels.EmptyLabels(), time.Second, EagerRetrieval,
+			WithHealthProbe(10*time.Millisecond),
+		)
+	}
+
+	t.Run("no store reachable", func(t *testing.T) {
+		q := newProxy(nil)
+		defer q.Close()
+
+		time.Sleep(50 * time.Millisecond)
+		testutil.Assert(t, !q.IsReady())
+
+		rec := httptest.NewRecorder()
+		q.ReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		testutil.Equals(t, http.StatusServiceUnavailable, rec.Code)
+	})
+
+	t.Run("store reachable", func(t *testing.T) {
+		q := newProxy(&storepb.InfoResponse{})
+
+		testutil.Assert(t, !q.IsReady())
+		for deadline := time.Now().Add(time.Second); !q.IsReady(); time.Sleep(5 * time.Millisecond) {
+			testutil.Assert(t, time.Now().Before(deadline), "proxy did not become ready")
+		}
+
+		rec := httptest.NewRecorder()
+		q.ReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		testutil.Equals(t, http.StatusOK, rec.Code)
+
+		// The proxy is not ready once the last contact is older than the response timeout.
+		q.Close()
+		q.lastStoreContact.Store(time.Now().Add(-2 * time.Second))
+		testutil.Assert(t, !q.IsReady())
+	})
+}
+
+func TestStoreGraph(t *testing.T) {
+	st := map[string]Client{}
+	for _, name := range []string{"local", "remote", "archive", "other", "unrelated"} {
+		st[name] = &storetestutil.TestClient{Name: name}
+	}
+	names := func(stores []Client) []string {
+		var res []string
+		for _, s := range stores {
+			res = append(res, s.String())
+		}
+		return res
+	}
+
+	g := NewStoreGraph(st["local"])
+	testutil.Ok(t, g.AddEdge(st["local"], st["remote"]))
+	testutil.Ok(t, g.AddEdge(st["remote"], st["archive"]))
+	testutil.Ok(t, g.AddEdge(st["local"], st["other"]))
+	testutil.Ok(t, g.AddEdge(st["other"], st["archive"]))
+	// Adding an existing edge is a no-op.
+	testutil.Ok(t, g.AddEdge(st["local"], st["remote"]))
+
+	// Depth-first, visiting shared stores once.
+	testutil.Equals(t, []string{"local", "remote", "archive", "other"}, names(g.Reachable(st["local"])))
+	testutil.Equals(t, []string{"remote", "archive"}, names(g.Reachable(st["remote"])))
+	// Stores which are not in the graph reach nothing.
+	testutil.Equals(t, 0, len(g.Reachable(st["unrelated"])))
+
+	// Edges creating cycles are rejected.
+	testutil.NotOk(t, g.AddEdge(st["archive"], st["local"]))
+	testutil.NotOk(t, g.AddEdge(st["archive"], st["remote"]))
+	testutil.NotOk(t, g.AddEdge(st["local"], st["local"]))
+	testutil.Equals(t, []string{"remote", "archive"}, names(g.Reachable(st["remote"])))
+}
+
+func TestProxyStore_StoreGraph(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	stores := map[string]Client{}
+	var cls []Client
+	for _, name := range []string{"local", "remote", "archive", "unrelated"} {
+		st := &storetestutil.TestClient{
+			Name: name,
+			StoreClient: &mockedStoreAPI{
+				RespSeries:     []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("store", name), []sample{{0, 0}})},
+				RespLabelNames: &storepb.LabelNamesResponse{Names: []string{name}},
+			},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		}
+		stores[name] = st
+		cls = append(cls, st)
+	}
+	g := NewStoreGraph(stores["local"])
+	testutil.Ok(t, g.AddEdge(stores["local"], stores["remote"]))
+	testutil.Ok(t, g.AddEdge(stores["remote"], stores["archive"]))
+
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+		WithStoreGraph(g),
+	)
+
+	srv := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Name: "store", Value: ".+", Type: storepb.LabelMatcher_RE}},
+	}, srv))
+	var series []string
+	for _, s := range srv.SeriesSet {
+		series = append(series, s.PromLabels().Get("store"))
+	}
+	testutil.Equals(t, []string{"archive", "local", "remote"}, series)
+
+	names, err := q.LabelNames(context.Background(), &storepb.LabelNamesRequest{Start: 0, End: 100})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"archive", "local", "remote"}, names.Names)
+}
+
+func TestProxyStore_Series_StoreSpans(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	var cls []Client
+	for _, name := range []string{"a", "b", "c"} {
+		cls = append(cls, &storetestutil.TestClient{
+			Name: name,
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("store", name), []sample{{0, 0}})},
+			},
+			ExtLset: []labels.Labels{labels.FromStrings("ext", name)},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		})
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+	)
+
+	exp := tracetest.NewInMemoryExporter()
+	tracer, closer := migration.Bridge(tracesdk.NewTracerProvider(tracesdk.WithSpanProcessor(tracesdk.NewSimpleSpanProcessor(exp))), log.NewNopLogger())
+	defer closer.Close()
+	root, ctx := tracing.StartSpan(tracing.ContextWithTracer(context.Background(), tracer), "root")
+
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Name: "ext", Value: "a|b", Type: storepb.LabelMatcher_RE}},
+	}, newStoreSeriesServer(ctx)))
+	root.Finish()
+
+	var rootSpan tracetest.SpanStub
+	for _, span := range exp.GetSpans() {
+		if span.Name == "root" {
+			rootSpan = span
+		}
+	}
+
+	// Each queried store has its own span, child of the Series request span.
+	storeSpans := map[string]map[string]string{}
+	for _, span := range exp.GetSpans() {
+		if span.Name != "proxy.series" {
+			continue
+		}
+		testutil.Equals(t, rootSpan.SpanContext.TraceID(), span.SpanContext.TraceID())
+		testutil.Equals(t, rootSpan.SpanContext.SpanID(), span.Parent.SpanID())
+
+		attrs := map[string]string{}
+		for _, attr := range span.Attributes {
+			if strings.HasPrefix(string(attr.Key), "store.") {
+				attrs[string(attr.Key)] = attr.Value.Emit()
+			}
+		}
+		storeSpans[attrs["store.addr"]] = attrs
+	}
+	testutil.Equals(t, map[string]map[string]string{
+		"a": {"store.addr": "a", "store.id": `{ext="a"}`, "store.is_local": "false"},
+		"b": {"store.addr": "b", "store.id": `{ext="b"}`, "store.is_local": "false"},
+	}, storeSpans)
+}
+
+// resolutionAwareTestClient is a test client holding data downsampled to minResolution, usable by queries with
+// larger max resolution windows.
+type resolutionAwareTestClient struct {
+	*storetestutil.TestClient
+
+	minResolution int64
+}
+
+func (c *resolutionAwareTestClient) SupportsResolution(window int64) bool {
+	return window >= c.minResolution
+}
+
+func TestProxyStore_Series_Resolution(t *testing.T) {
+	defer custom.TolerantVerifyLeak(t)
+
+	newClient := func(name string) *storetestutil.TestClient {
+		return &storetestutil.TestClient{
+			Name: name,
+			StoreClient: &mockedStoreAPI{
+				RespSeries: []*storepb.SeriesResponse{storeSeriesResponse(t, labels.FromStrings("store", name), []sample{{0, 0}})},
+			},
+			MinTime: math.MinInt64,
+			MaxTime: math.MaxInt64,
+		}
+	}
+	cls := []Client{
+		&resolutionAwareTestClient{TestClient: newClient("raw"), minResolution: 0},
+		&resolutionAwareTestClient{TestClient: newClient("5m"), minResolution: downsample.ResLevel1},
+		&resolutionAwareTestClient{TestClient: newClient("1h"), minResolution: downsample.ResLevel2},
+		newClient("unaware"),
+	}
+	q := NewProxyStore(nil, nil,
+		func() []Client { return cls },
+		component.Query,
+		labels.EmptyLabels(),
+		5*time.Second, EagerRetrieval,
+	)
+
+	for _, tc := range []struct {
+		window   int64
+		expected []string
+	}{
+		{window: 0, expected: []string{"1h", "5m", "raw", "unaware"}},
+		{window: downsample.ResLevel1, expected: []string{"5m", "raw", "unaware"}},
+		{window: downsample.ResLevel2, expected: []string{"1h", "5m", "raw", "unaware"}},
+	} {
+		t.Run(fmt.Sprintf("window=%d", tc.window), func(t *testing.T) {
+			srv := newStoreSeriesServer(context.Background())
+			testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+				MinTime:             0,
+				MaxTime:             100,
+				MaxResolutionWindow: tc.window,
+				Matchers:            []storepb.LabelMatcher{{Name: "store", Value: ".+", Type: storepb.LabelMatcher_RE}},
+			}, srv))
+			var series []string
+			for _, s := range srv.SeriesSet {
+				series = append(series, s.PromLabels().Get("store"))
+			}
+			testutil.Equals(t, tc.expected, series)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// groupTopology keeps the addresses of the stores of each replica of each group, rebuilt only when the
+// addresses of the stores change.
+type groupTopology struct {
+	mtx      sync.Mutex
+	hash     uint64
+	topology map[string]map[string][]string
+}
+
+// update rebuilds the topology if the addresses of the given stores differ from the last update.
+func (g *groupTopology) update(stores []Client) {
+	h := xxhash.New()
+	for _, st := range stores {
+		addr, _ := st.Addr()
+		_, _ = h.WriteString(addr)
+		_, _ = h.Write([]byte{0xff})
+	}
+	hash := h.Sum64()
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if g.topology != nil && g.hash == hash {
+		return
+	}
+	g.hash = hash
+	g.topology = buildGroupTopology(stores)
+}
+
+func (g *groupTopology) get() map[string]map[string][]string {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return copyGroupTopology(g.topology)
+}
+
+// WithGroupTopologyCaching keeps the group and replica topology of the stores between requests, rebuilding
+// it only when the store addresses change. See GroupTopology.
+func WithGroupTopologyCaching(enabled bool) ProxyStoreOption {
+	return func(s *ProxyStore) {
+		if !enabled {
+			s.groupTopology = nil
+			return
+		}
+		s.groupTopology = &groupTopology{}
+	}
+}
+
+// GroupTopology returns the addresses of the stores of each replica of each group, keyed by group and
+// replica key. The returned map is a copy and can be modified by the caller.
+func (s *ProxyStore) GroupTopology() map[string]map[string][]string {
+	if s.groupTopology == nil {
+		return buildGroupTopology(s.stores())
+	}
+	s.groupTopology.update(s.stores())
+	return s.groupTopology.get()
+}
+
+func buildGroupTopology(stores []Client) map[string]map[string][]string {
+	topology := make(map[string]map[string][]string)
+	for _, st := range stores {
+		addr, _ := st.Addr()
+		if _, ok := topology[st.GroupKey()]; !ok {
+			topology[st.GroupKey()] = make(map[string][]string)
+		}
+		topology[st.GroupKey()][st.ReplicaKey()] = append(topology[st.GroupKey()][st.ReplicaKey()], addr)
+	}
+	return topology
+}
+
+func copyGroupTopology(topology map[string]map[string][]string) map[string]map[string][]string {
+	c := make(map[string]map[string][]string, len(topology))
+	for group, replicas := range topology {
+		c[group] = make(map[string][]string, len(replicas))
+		for replica, addrs := range replicas {
+			c[group][replica] = append([]string(nil), addrs...)
+		}
+	}
+	return c
+}
@@ -0,0 +1,102 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/thanos-io/thanos/pkg/clientconfig"
+	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/info/infopb"
+	"github.com/thanos-io/thanos/pkg/promclient"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// remoteReadClient is a Client querying a Prometheus compatible remote read endpoint directly, without a sidecar.
+// It has no external labels and covers all time.
+type remoteReadClient struct {
+	storepb.StoreClient
+	addr string
+}
+
+// NewRemoteReadClient returns a Client for the Prometheus remote read endpoint at addr, e.g. "http://prometheus:9090",
+// using the given HTTP client. Series requests are sent as remote read requests and the read responses are streamed
+// back as series responses. Label names and values are fetched from the Prometheus HTTP API.
+func NewRemoteReadClient(addr string, client *http.Client) (Client, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse remote read address %s", addr)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	promStore, err := NewPrometheusStore(
+		nil,
+		nil,
+		promclient.NewClient(client, nil, clientconfig.ThanosUserAgent),
+		u,
+		component.Sidecar,
+		labels.EmptyLabels,
+		func() (int64, int64) { return math.MinInt64, math.MaxInt64 },
+		func() string { return "" },
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteReadClient{StoreClient: storepb.ServerAsClient(promStore), addr: addr}, nil
+}
+
+func (c *remoteReadClient) LabelSets() []labels.Labels {
+	return nil
+}
+
+func (c *remoteReadClient) TimeRange() (mint int64, maxt int64) {
+	return math.MinInt64, math.MaxInt64
+}
+
+func (c *remoteReadClient) TSDBInfos() []infopb.TSDBInfo {
+	return nil
+}
+
+// SupportsSharding returns false, as samples returned by older remote read versions are not sharded.
+func (c *remoteReadClient) SupportsSharding() bool {
+	return false
+}
+
+// SupportsWithoutReplicaLabels returns false, as replica labels are only removed from the external labels, which
+// remote read endpoints do not have. The proxy removes them from the series labels instead.
+func (c *remoteReadClient) SupportsWithoutReplicaLabels() bool {
+	return false
+}
+
+func (c *remoteReadClient) SupportsCompression(string) bool {
+	return false
+}
+
+func (c *remoteReadClient) ResponseTimeout() time.Duration {
+	return 0
+}
+
+func (c *remoteReadClient) String() string {
+	return "remote read: " + c.addr
+}
+
+func (c *remoteReadClient) Addr() (string, bool) {
+	return c.addr, false
+}
+
+func (c *remoteReadClient) ReplicaKey() string {
+	return ""
+}
+
+func (c *remoteReadClient) GroupKey() string {
+	return ""
+}
@@ -232,6 +232,9 @@ type InfoResponse struct {
 	StoreType StoreType                                              `protobuf:"varint,4,opt,name=storeType,proto3,enum=thanos.StoreType" json:"storeType,omitempty"`
 	// label_sets is an unsorted list of `ZLabelSet`s.
 	LabelSets []labelpb.ZLabelSet `protobuf:"bytes,5,rep,name=label_sets,json=labelSets,proto3" json:"label_sets"`
+	// max_series_per_query is the maximum number of series the store is willing to return
+	// for a single Series request. 0 means no limit.
+	MaxSeriesPerQuery int64 `protobuf:"varint,6,opt,name=max_series_per_query,json=maxSeriesPerQuery,proto3" json:"max_series_per_query,omitempty"`
 }
 
 func (m *InfoResponse) Reset()         { *m = InfoResponse{} }
@@ -353,6 +356,10 @@ type QueryHints struct {
 	Grouping *Grouping `protobuf:"bytes,4,opt,name=grouping,proto3" json:"grouping,omitempty"`
 	// Range vector selector.
 	Range *Range `protobuf:"bytes,5,opt,name=range,proto3" json:"range,omitempty"`
+	// The maximum number of series the store should return. 0 means no limit.
+	TotalLimit int64 `protobuf:"varint,6,opt,name=total_limit,json=totalLimit,proto3" json:"total_limit,omitempty"`
+	// Matchers selecting the series the query may need. Stores whose label sets do not match them can be skipped.
+	Matchers []LabelMatcher `protobuf:"bytes,7,rep,name=matchers,proto3" json:"matchers"`
 }
 
 func (m *QueryHints) Reset()         { *m = QueryHints{} }
@@ -850,91 +857,93 @@ func init() {
 func init() { proto.RegisterFile("store/storepb/rpc.proto", fileDescriptor_a938d55a388af629) }
 
 var fileDescriptor_a938d55a388af629 = []byte{
-	// 1331 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x57, 0x4f, 0x6f, 0x13, 0x47,
-	0x14, 0xf7, 0x7a, 0xbd, 0xfe, 0xf3, 0x9c, 0xb8, 0x66, 0x30, 0x61, 0x63, 0x24, 0xc7, 0x75, 0x55,
-	0xc9, 0x42, 0xd4, 0xa6, 0x06, 0x21, 0xb5, 0xe2, 0x92, 0x04, 0x43, 0xa2, 0x12, 0x53, 0xc6, 0x09,
-	0x69, 0xa9, 0x2a, 0x6b, 0x6d, 0x4f, 0xd6, 0x2b, 0xec, 0xdd, 0x65, 0x67, 0xb6, 0x89, 0xaf, 0xad,
-	0x7a, 0xab, 0xaa, 0xaa, 0x1f, 0xa1, 0x9f, 0x86, 0x23, 0xc7, 0xaa, 0x07, 0xd4, 0xc2, 0xbd, 0x9f,
-	0xa1, 0x9a, 0x3f, 0xbb, 0xf6, 0xa6, 0x21, 0x08, 0x91, 0x4b, 0x34, 0xef, 0xf7, 0x7b, 0xf3, 0xe6,
-	0xfd, 0xcf, 0x1a, 0xae, 0x52, 0xe6, 0x05, 0xa4, 0x2d, 0xfe, 0xfa, 0xc3, 0x76, 0xe0, 0x8f, 0x5a,
-	0x7e, 0xe0, 0x31, 0x0f, 0x65, 0xd9, 0xc4, 0x72, 0x3d, 0x5a, 0x5d, 0x4f, 0x2a, 0xb0, 0xb9, 0x4f,
-	0xa8, 0x54, 0xa9, 0x56, 0x6c, 0xcf, 0xf6, 0xc4, 0xb1, 0xcd, 0x4f, 0x0a, 0xad, 0x27, 0x2f, 0xf8,
-	0x81, 0x37, 0x3b, 0x75, 0x4f, 0x99, 0x9c, 0x5a, 0x43, 0x32, 0x3d, 0x4d, 0xd9, 0x9e, 0x67, 0x4f,
-	0x49, 0x5b, 0x48, 0xc3, 0xf0, 0xa8, 0x6d, 0xb9, 0x73, 0x49, 0x35, 0x3e, 0x82, 0xd5, 0xc3, 0xc0,
-	0x61, 0x04, 0x13, 0xea, 0x7b, 0x2e, 0x25, 0x8d, 0x9f, 0x34, 0x58, 0x51, 0xc8, 0xf3, 0x90, 0x50,
-	0x86, 0x36, 0x01, 0x98, 0x33, 0x23, 0x94, 0x04, 0x0e, 0xa1, 0xa6, 0x56, 0xd7, 0x9b, 0xc5, 0xce,
-	0x35, 0x7e, 0x7b, 0x46, 0xd8, 0x84, 0x84, 0x74, 0x30, 0xf2, 0xfc, 0x79, 0x6b, 0xdf, 0x99, 0x91,
-	0xbe, 0x50, 0xd9, 0xca, 0xbc, 0x78, 0xb5, 0x91, 0xc2, 0x4b, 0x97, 0xd0, 0x1a, 0x64, 0x19, 0x71,
-	0x2d, 0x97, 0x99, 0xe9, 0xba, 0xd6, 0x2c, 0x60, 0x25, 0x21, 0x13, 0x72, 0x01, 0xf1, 0xa7, 0xce,
-	0xc8, 0x32, 0xf5, 0xba, 0xd6, 0xd4, 0x71, 0x24, 0x36, 0x56, 0xa1, 0xb8, 0xeb, 0x1e, 0x79, 0xca,
-	0x87, 0xc6, 0xef, 0x69, 0x58, 0x91, 0xb2, 0xf4, 0x12, 0x8d, 0x20, 0x2b, 0x02, 0x8d, 0x1c, 0x5a,
-	0x6d, 0xc9, 0xc4, 0xb6, 0x1e, 0x72, 0x74, 0xeb, 0x2e, 0x77, 0xe1, 0xaf, 0x57, 0x1b, 0xb7, 0x6d,
-	0x87, 0x4d, 0xc2, 0x61, 0x6b, 0xe4, 0xcd, 0xda, 0x52, 0xe1, 0x33, 0xc7, 0x53, 0xa7, 0xb6, 0xff,
-	0xcc, 0x6e, 0x27, 0x72, 0xd6, 0x7a, 0x2a, 0x6e, 0x63, 0x65, 0x1a, 0xad, 0x43, 0x7e, 0xe6, 0xb8,
-	0x03, 0x1e, 0x88, 0x70, 0x5c, 0xc7, 0xb9, 0x99, 0xe3, 0xf2, 0x48, 0x05, 0x65, 0x9d, 0x48, 0x4a,
-	0xb9, 0x3e, 0xb3, 0x4e, 0x04, 0xd5, 0x86, 0x82, 0xb0, 0xba, 0x3f, 0xf7, 0x89, 0x99, 0xa9, 0x6b,
-	0xcd, 0x52, 0xe7, 0x52, 0xe4, 0x5d, 0x3f, 0x22, 0xf0, 0x42, 0x07, 0xdd, 0x01, 0x10, 0x0f, 0x0e,
-	0x28, 0x61, 0xd4, 0x34, 0x44, 0x3c, 0xf1, 0x0d, 0xe9, 0x52, 0x9f, 0x30, 0x95, 0xd6, 0xc2, 0x54,
-	0xc9, 0xb4, 0xf1, 0x8b, 0x01, 0xab, 0x32, 0xe5, 0x51, 0xa9, 0x96, 0x1d, 0xd6, 0xde, 0xee, 0x70,
-	0x3a, 0xe9, 0xf0, 0x1d, 0x4e, 0xb1, 0xd1, 0x84, 0x04, 0xd4, 0xd4, 0xc5, 0xeb, 0x95, 0x44, 0x36,
-	0xf7, 0x24, 0xa9, 0x1c, 0x88, 0x75, 0x51, 0x07, 0xae, 0x70, 0x93, 0x01, 0xa1, 0xde, 0x34, 0x64,
-	0x8e, 0xe7, 0x0e, 0x8e, 0x1d, 0x77, 0xec, 0x1d, 0x8b, 0xa0, 0x75, 0x7c, 0x79, 0x66, 0x9d, 0xe0,
-	0x98, 0x3b, 0x14, 0x14, 0xba, 0x01, 0x60, 0xd9, 0x76, 0x40, 0x6c, 0x8b, 0x11, 0x19, 0x6b, 0xa9,
-	0xb3, 0x12, 0xbd, 0xb6, 0x69, 0xdb, 0x01, 0x5e, 0xe2, 0xd1, 0x97, 0xb0, 0xee, 0x5b, 0x01, 0x73,
-	0xac, 0x29, 0x7f, 0x45, 0x54, 0x7e, 0x30, 0x76, 0xa8, 0x35, 0x9c, 0x92, 0xb1, 0x99, 0xad, 0x6b,
-	0xcd, 0x3c, 0xbe, 0xaa, 0x14, 0xa2, 0xce, 0xb8, 0xa7, 0x68, 0xf4, 0xdd, 0x19, 0x77, 0x29, 0x0b,
-	0x2c, 0x46, 0xec, 0xb9, 0x99, 0x13, 0x65, 0xd9, 0x88, 0x1e, 0xfe, 0x3a, 0x69, 0xa3, 0xaf, 0xd4,
-	0xfe, 0x67, 0x3c, 0x22, 0xd0, 0x06, 0x14, 0xe9, 0x33, 0xc7, 0x1f, 0x8c, 0x26, 0xa1, 0xfb, 0x8c,
-	0x9a, 0x79, 0xe1, 0x0a, 0x70, 0x68, 0x5b, 0x20, 0xe8, 0x3a, 0x18, 0x13, 0xc7, 0x65, 0xd4, 0x2c,
-	0xd4, 0x35, 0x91, 0x50, 0x39, 0x81, 0xad, 0x68, 0x02, 0x5b, 0x9b, 0xee, 0x1c, 0x4b, 0x15, 0x84,
-	0x20, 0x43, 0x19, 0xf1, 0x4d, 0x10, 0x69, 0x13, 0x67, 0x54, 0x01, 0x23, 0xb0, 0x5c, 0x9b, 0x98,
-	0x45, 0x01, 0x4a, 0x01, 0xdd, 0x82, 0xe2, 0xf3, 0x90, 0x04, 0xf3, 0x81, 0xb4, 0xbd, 0x22, 0x6c,
-	0xa3, 0x28, 0x8a, 0xc7, 0x9c, 0xda, 0xe1, 0x0c, 0x86, 0xe7, 0xf1, 0x19, 0xdd, 0x04, 0xa0, 0x13,
-	0x2b, 0x18, 0x0f, 0x1c, 0xf7, 0xc8, 0x33, 0x57, 0xc5, 0x9d, 0x45, 0x43, 0x72, 0x46, 0x4c, 0x56,
-	0x81, 0x46, 0x47, 0x74, 0x1b, 0xd6, 0x8e, 0x1d, 0x36, 0xf1, 0x42, 0x36, 0x50, 0xf3, 0x38, 0x50,
-	0xc3, 0x56, 0xaa, 0xeb, 0xcd, 0x02, 0xae, 0x28, 0x16, 0x4b, 0x52, 0x34, 0x09, 0x6d, 0xfc, 0xa1,
-	0x01, 0x2c, 0x5c, 0x10, 0x29, 0x62, 0xc4, 0x1f, 0xcc, 0x9c, 0xe9, 0xd4, 0xa1, 0xaa, 0x1d, 0x81,
-	0x43, 0x7b, 0x02, 0x41, 0x75, 0xc8, 0x1c, 0x85, 0xee, 0x48, 0x74, 0x63, 0x71, 0xd1, 0x04, 0xf7,
-	0x43, 0x77, 0x84, 0x05, 0x83, 0x6e, 0x40, 0xde, 0x0e, 0xbc, 0xd0, 0x77, 0x5c, 0x5b, 0xf4, 0x54,
-	0xb1, 0x53, 0x8e, 0xb4, 0x1e, 0x28, 0x1c, 0xc7, 0x1a, 0xe8, 0x93, 0x28, 0x65, 0x86, 0x50, 0x8d,
-	0x37, 0x02, 0xe6, 0xa0, 0xca, 0x60, 0xe3, 0x18, 0x0a, 0x71, 0xc8, 0xc2, 0x45, 0x95, 0x99, 0x31,
-	0x39, 0x89, 0x5d, 0x94, 0xfc, 0x98, 0x9c, 0xa0, 0x8f, 0x61, 0x85, 0x79, 0xcc, 0x9a, 0x0e, 0x04,
-	0x46, 0xd5, 0xe0, 0x14, 0x05, 0x26, 0xcc, 0x50, 0x54, 0x82, 0xf4, 0x70, 0x2e, 0x56, 0x40, 0x1e,
-	0xa7, 0x87, 0x73, 0xbe, 0xea, 0x54, 0xae, 0x32, 0x22, 0x57, 0x4a, 0x6a, 0x54, 0x21, 0xc3, 0x23,
-	0xe3, 0xc5, 0x76, 0x2d, 0x35, 0x9e, 0x05, 0x2c, 0xce, 0x8d, 0x0e, 0xe4, 0xa3, 0x78, 0x94, 0x3d,
-	0xed, 0x0c, 0x7b, 0x7a, 0xc2, 0xde, 0x06, 0x18, 0x22, 0x30, 0xae, 0x90, 0x48, 0xb1, 0x92, 0x1a,
-	0xbf, 0x6a, 0x50, 0x8a, 0xb6, 0x83, 0x5a, 0x9a, 0x4d, 0xc8, 0xc6, 0x5b, 0x9c, 0xa7, 0xa8, 0x14,
-	0x77, 0x81, 0x40, 0x77, 0x52, 0x58, 0xf1, 0xa8, 0x0a, 0xb9, 0x63, 0x2b, 0x70, 0x79, 0xe2, 0xc5,
-	0xc6, 0xde, 0x49, 0xe1, 0x08, 0x40, 0x37, 0xa2, 0xd6, 0xd6, 0xdf, 0xde, 0xda, 0x3b, 0x29, 0xd5,
-	0xdc, 0x5b, 0x79, 0xc8, 0x06, 0x84, 0x86, 0x53, 0xd6, 0xf8, 0x37, 0x0d, 0x97, 0x44, 0xab, 0xf4,
-	0xac, 0xd9, 0x62, 0x65, 0x9d, 0x3b, 0xe2, 0xda, 0x07, 0x8c, 0x78, 0xfa, 0x03, 0x47, 0xbc, 0x02,
-	0x06, 0x65, 0x56, 0xc0, 0xd4, 0x7a, 0x97, 0x02, 0x2a, 0x83, 0x4e, 0xdc, 0xb1, 0xda, 0x70, 0xfc,
-	0xb8, 0x98, 0x74, 0xe3, 0xdd, 0x93, 0xbe, 0xbc, 0x69, 0xb3, 0xef, 0xb1, 0x69, 0xdf, 0x3e, 0x90,
-	0xb9, 0x73, 0x06, 0x32, 0x00, 0xb4, 0x9c, 0x6f, 0xd5, 0x04, 0x15, 0x30, 0x78, 0xd3, 0xc9, 0x7f,
-	0x9c, 0x05, 0x2c, 0x05, 0x54, 0x85, 0xbc, 0xaa, 0x2f, 0xef, 0x72, 0x4e, 0xc4, 0xf2, 0x22, 0x42,
-	0xfd, 0x9d, 0x11, 0x36, 0x7e, 0xd6, 0xd5, 0xa3, 0x4f, 0xac, 0x69, 0xb8, 0xa8, 0x72, 0x05, 0x0c,
-	0xe1, 0xb0, 0x6a, 0x7b, 0x29, 0x9c, 0x5f, 0xfb, 0xf4, 0x07, 0xd4, 0x5e, 0xbf, 0xa8, 0xda, 0x67,
-	0xce, 0xa8, 0xbd, 0x71, 0x46, 0xed, 0xb3, 0xef, 0x57, 0xfb, 0xdc, 0x85, 0xd4, 0x3e, 0x7f, 0x4e,
-	0xed, 0x43, 0xb8, 0x9c, 0x28, 0x83, 0x2a, 0xfe, 0x1a, 0x64, 0x7f, 0x10, 0x88, 0xaa, 0xbe, 0x92,
-	0x2e, 0xaa, 0xfc, 0xd7, 0xbf, 0x87, 0x42, 0xfc, 0x89, 0x83, 0x8a, 0x90, 0x3b, 0xe8, 0x7d, 0xd5,
-	0x7b, 0x74, 0xd8, 0x2b, 0xa7, 0x50, 0x01, 0x8c, 0xc7, 0x07, 0x5d, 0xfc, 0x6d, 0x59, 0x43, 0x79,
-	0xc8, 0xe0, 0x83, 0x87, 0xdd, 0x72, 0x9a, 0x6b, 0xf4, 0x77, 0xef, 0x75, 0xb7, 0x37, 0x71, 0x59,
-	0xe7, 0x1a, 0xfd, 0xfd, 0x47, 0xb8, 0x5b, 0xce, 0x70, 0x1c, 0x77, 0xb7, 0xbb, 0xbb, 0x4f, 0xba,
-	0x65, 0x83, 0xe3, 0xf7, 0xba, 0x5b, 0x07, 0x0f, 0xca, 0xd9, 0xeb, 0x5b, 0x90, 0xe1, 0xdf, 0x08,
-	0x28, 0x07, 0x3a, 0xde, 0x3c, 0x94, 0x56, 0xb7, 0x1f, 0x1d, 0xf4, 0xf6, 0xcb, 0x1a, 0xc7, 0xfa,
-	0x07, 0x7b, 0xe5, 0x34, 0x3f, 0xec, 0xed, 0xf6, 0xca, 0xba, 0x38, 0x6c, 0x7e, 0x23, 0xcd, 0x09,
-	0xad, 0x2e, 0x2e, 0x1b, 0x9d, 0x1f, 0xd3, 0x60, 0x08, 0x1f, 0xd1, 0xe7, 0x90, 0x11, 0xff, 0x06,
-	0x2e, 0x47, 0x75, 0x58, 0xfa, 0xe2, 0xac, 0x56, 0x92, 0xa0, 0xca, 0xdf, 0x17, 0x90, 0x95, 0xbb,
-	0x12, 0x5d, 0x49, 0xee, 0xce, 0xe8, 0xda, 0xda, 0x69, 0x58, 0x5e, 0xbc, 0xa9, 0xa1, 0x6d, 0x80,
-	0xc5, 0x34, 0xa2, 0xf5, 0x44, 0xed, 0x97, 0x37, 0x62, 0xb5, 0x7a, 0x16, 0xa5, 0xde, 0xbf, 0x0f,
-	0xc5, 0xa5, 0xb2, 0xa2, 0xa4, 0x6a, 0x62, 0xe4, 0xaa, 0xd7, 0xce, 0xe4, 0xa4, 0x9d, 0x4e, 0x0f,
-	0x4a, 0xe2, 0x1b, 0x9f, 0xcf, 0x92, 0x4c, 0xc6, 0x5d, 0x28, 0x62, 0x32, 0xf3, 0x18, 0x11, 0x38,
-	0x8a, 0xc3, 0x5f, 0xfe, 0x29, 0x50, 0xbd, 0x72, 0x0a, 0x55, 0x3f, 0x19, 0x52, 0x5b, 0x9f, 0xbe,
-	0xf8, 0xa7, 0x96, 0x7a, 0xf1, 0xba, 0xa6, 0xbd, 0x7c, 0x5d, 0xd3, 0xfe, 0x7e, 0x5d, 0xd3, 0x7e,
-	0x7b, 0x53, 0x4b, 0xbd, 0x7c, 0x53, 0x4b, 0xfd, 0xf9, 0xa6, 0x96, 0x7a, 0x9a, 0x53, 0xbf, 0x5a,
-	0x86, 0x59, 0xd1, 0x33, 0xb7, 0xfe, 0x0b, 0x00, 0x00, 0xff, 0xff, 0xa0, 0x14, 0xa2, 0x0f, 0x1f,
-	0x0d, 0x00, 0x00,
+	// 1376 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x57, 0xcd, 0x6f, 0x13, 0x57,
+	0x10, 0xf7, 0x7a, 0xbd, 0xfe, 0x18, 0x27, 0xa9, 0x79, 0x98, 0xb0, 0x31, 0x52, 0xe2, 0xba, 0xaa,
+	0x14, 0x21, 0x6a, 0x53, 0x83, 0x90, 0x5a, 0x71, 0x49, 0x82, 0x21, 0x51, 0x49, 0x80, 0xe7, 0x84,
+	0xb4, 0x54, 0xd5, 0x6a, 0x6d, 0xbf, 0xac, 0x57, 0xec, 0x17, 0xfb, 0xde, 0x36, 0xf1, 0xb5, 0x55,
+	0x6f, 0x55, 0xd5, 0xff, 0xa9, 0x17, 0x8e, 0x1c, 0xab, 0x1e, 0x50, 0x0b, 0xf7, 0xfe, 0x03, 0xbd,
+	0x54, 0xef, 0x63, 0x6d, 0x6f, 0x1a, 0x42, 0x11, 0x5c, 0xac, 0x37, 0xf3, 0x9b, 0x37, 0x3b, 0x33,
+	0xbf, 0x99, 0x59, 0x2f, 0x5c, 0xa6, 0x2c, 0x8c, 0x49, 0x47, 0xfc, 0x46, 0x83, 0x4e, 0x1c, 0x0d,
+	0xdb, 0x51, 0x1c, 0xb2, 0x10, 0x15, 0xd9, 0xd8, 0x0e, 0x42, 0xda, 0x58, 0xc9, 0x1a, 0xb0, 0x49,
+	0x44, 0xa8, 0x34, 0x69, 0xd4, 0x9d, 0xd0, 0x09, 0xc5, 0xb1, 0xc3, 0x4f, 0x4a, 0xdb, 0xcc, 0x5e,
+	0x88, 0xe2, 0xd0, 0x3f, 0x75, 0x4f, 0xb9, 0xf4, 0xec, 0x01, 0xf1, 0x4e, 0x43, 0x4e, 0x18, 0x3a,
+	0x1e, 0xe9, 0x08, 0x69, 0x90, 0x1c, 0x75, 0xec, 0x60, 0x22, 0xa1, 0xd6, 0x47, 0xb0, 0x78, 0x18,
+	0xbb, 0x8c, 0x60, 0x42, 0xa3, 0x30, 0xa0, 0xa4, 0xf5, 0xa3, 0x06, 0x0b, 0x4a, 0xf3, 0x2c, 0x21,
+	0x94, 0xa1, 0x0d, 0x00, 0xe6, 0xfa, 0x84, 0x92, 0xd8, 0x25, 0xd4, 0xd4, 0x9a, 0xfa, 0x7a, 0xb5,
+	0x7b, 0x85, 0xdf, 0xf6, 0x09, 0x1b, 0x93, 0x84, 0x5a, 0xc3, 0x30, 0x9a, 0xb4, 0xf7, 0x5d, 0x9f,
+	0xf4, 0x85, 0xc9, 0x66, 0xe1, 0xf9, 0xcb, 0xb5, 0x1c, 0x9e, 0xbb, 0x84, 0x96, 0xa1, 0xc8, 0x48,
+	0x60, 0x07, 0xcc, 0xcc, 0x37, 0xb5, 0xf5, 0x0a, 0x56, 0x12, 0x32, 0xa1, 0x14, 0x93, 0xc8, 0x73,
+	0x87, 0xb6, 0xa9, 0x37, 0xb5, 0x75, 0x1d, 0xa7, 0x62, 0x6b, 0x11, 0xaa, 0x3b, 0xc1, 0x51, 0xa8,
+	0x62, 0x68, 0xfd, 0x96, 0x87, 0x05, 0x29, 0xcb, 0x28, 0xd1, 0x10, 0x8a, 0x22, 0xd1, 0x34, 0xa0,
+	0xc5, 0xb6, 0x2c, 0x6c, 0xfb, 0x3e, 0xd7, 0x6e, 0xde, 0xe6, 0x21, 0xfc, 0xf1, 0x72, 0xed, 0xa6,
+	0xe3, 0xb2, 0x71, 0x32, 0x68, 0x0f, 0x43, 0xbf, 0x23, 0x0d, 0x3e, 0x73, 0x43, 0x75, 0xea, 0x44,
+	0x4f, 0x9d, 0x4e, 0xa6, 0x66, 0xed, 0x27, 0xe2, 0x36, 0x56, 0xae, 0xd1, 0x0a, 0x94, 0x7d, 0x37,
+	0xb0, 0x78, 0x22, 0x22, 0x70, 0x1d, 0x97, 0x7c, 0x37, 0xe0, 0x99, 0x0a, 0xc8, 0x3e, 0x91, 0x90,
+	0x0a, 0xdd, 0xb7, 0x4f, 0x04, 0xd4, 0x81, 0x8a, 0xf0, 0xba, 0x3f, 0x89, 0x88, 0x59, 0x68, 0x6a,
+	0xeb, 0x4b, 0xdd, 0x0b, 0x69, 0x74, 0xfd, 0x14, 0xc0, 0x33, 0x1b, 0x74, 0x0b, 0x40, 0x3c, 0xd0,
+	0xa2, 0x84, 0x51, 0xd3, 0x10, 0xf9, 0x4c, 0x6f, 0xc8, 0x90, 0xfa, 0x84, 0xa9, 0xb2, 0x56, 0x3c,
+	0x25, 0x53, 0xd4, 0x81, 0x3a, 0x8f, 0x41, 0xd6, 0xd8, 0x8a, 0x48, 0x6c, 0x3d, 0x4b, 0x48, 0x3c,
+	0x31, 0x8b, 0x22, 0x9e, 0x0b, 0xbe, 0x7d, 0x22, 0x09, 0x79, 0x48, 0xe2, 0x47, 0x1c, 0x68, 0xfd,
+	0x6c, 0xc0, 0xa2, 0x54, 0xa5, 0xdc, 0xce, 0x67, 0xa8, 0xbd, 0x39, 0xc3, 0x7c, 0x36, 0xc3, 0x5b,
+	0x1c, 0x62, 0xc3, 0x31, 0x89, 0xa9, 0xa9, 0x8b, 0x70, 0xeb, 0x99, 0xf2, 0xef, 0x4a, 0x50, 0x45,
+	0x3c, 0xb5, 0x45, 0x5d, 0xb8, 0xc4, 0x5d, 0xc6, 0x84, 0x86, 0x5e, 0xc2, 0xdc, 0x30, 0xb0, 0x8e,
+	0xdd, 0x60, 0x14, 0x1e, 0x8b, 0x2a, 0xe9, 0xf8, 0xa2, 0x6f, 0x9f, 0xe0, 0x29, 0x76, 0x28, 0x20,
+	0x74, 0x0d, 0xc0, 0x76, 0x9c, 0x98, 0x38, 0x36, 0x23, 0xb2, 0x38, 0x4b, 0xdd, 0x85, 0xf4, 0x69,
+	0x1b, 0x8e, 0x13, 0xe3, 0x39, 0x1c, 0x7d, 0x09, 0x2b, 0x91, 0x1d, 0x33, 0xd7, 0xf6, 0xac, 0x58,
+	0xb5, 0x8a, 0x35, 0x72, 0xa9, 0x3d, 0xf0, 0xc8, 0x48, 0xd4, 0xa5, 0x8c, 0x2f, 0x2b, 0x83, 0xb4,
+	0x95, 0xee, 0x28, 0x18, 0x7d, 0x7b, 0xc6, 0x5d, 0xca, 0x62, 0x9b, 0x11, 0x67, 0x62, 0x96, 0x04,
+	0x8f, 0x6b, 0xe9, 0x83, 0x1f, 0x66, 0x7d, 0xf4, 0x95, 0xd9, 0x7f, 0x9c, 0xa7, 0x00, 0x5a, 0x83,
+	0x2a, 0x7d, 0xea, 0x46, 0xd6, 0x70, 0x9c, 0x04, 0x4f, 0xa9, 0x59, 0x16, 0xa1, 0x00, 0x57, 0x6d,
+	0x09, 0x0d, 0xba, 0x0a, 0xc6, 0xd8, 0x0d, 0x18, 0x35, 0x2b, 0x4d, 0x4d, 0x14, 0x54, 0x8e, 0x6c,
+	0x3b, 0x1d, 0xd9, 0xf6, 0x46, 0x30, 0xc1, 0xd2, 0x04, 0x21, 0x28, 0x50, 0x46, 0x22, 0x13, 0x44,
+	0xd9, 0xc4, 0x19, 0xd5, 0xc1, 0x88, 0xed, 0xc0, 0x21, 0x66, 0x55, 0x28, 0xa5, 0x80, 0x6e, 0x40,
+	0x55, 0xf4, 0x84, 0x25, 0x7d, 0x2f, 0x08, 0xdf, 0x28, 0xcd, 0x42, 0x74, 0xc5, 0x36, 0x47, 0x30,
+	0x3c, 0x9b, 0x9e, 0xd1, 0x75, 0x00, 0x3a, 0xb6, 0xe3, 0x91, 0xe5, 0x06, 0x47, 0xa1, 0xb9, 0xd8,
+	0xd4, 0xe6, 0xfb, 0xb1, 0xcf, 0x11, 0x31, 0x8a, 0x15, 0x9a, 0x1e, 0xd1, 0x4d, 0x58, 0x3e, 0x76,
+	0xd9, 0x38, 0x4c, 0x98, 0xa5, 0x06, 0xd8, 0x52, 0xd3, 0xb9, 0xd4, 0xd4, 0xd7, 0x2b, 0xb8, 0xae,
+	0x50, 0x2c, 0x41, 0xd1, 0x24, 0xb4, 0xf5, 0x8f, 0x06, 0x30, 0x0b, 0x41, 0x94, 0x88, 0x91, 0xc8,
+	0xf2, 0x5d, 0xcf, 0x73, 0xa9, 0x6a, 0x47, 0xe0, 0xaa, 0x5d, 0xa1, 0x41, 0x4d, 0x28, 0x1c, 0x25,
+	0xc1, 0x50, 0x74, 0x63, 0x75, 0xd6, 0x04, 0x77, 0x93, 0x60, 0x88, 0x05, 0x82, 0xae, 0x41, 0xd9,
+	0x89, 0xc3, 0x24, 0x72, 0x03, 0x47, 0xf4, 0x54, 0xb5, 0x5b, 0x4b, 0xad, 0xee, 0x29, 0x3d, 0x9e,
+	0x5a, 0xa0, 0x4f, 0xd2, 0x92, 0x19, 0x4d, 0x6d, 0x7e, 0x85, 0x60, 0xae, 0x4c, 0x2b, 0xb8, 0x06,
+	0x55, 0x16, 0x32, 0xdb, 0xb3, 0x3c, 0xd7, 0x77, 0x99, 0x9a, 0x2d, 0x10, 0xaa, 0xfb, 0x5c, 0x93,
+	0x19, 0x86, 0xd2, 0xff, 0x1f, 0x86, 0xd6, 0x31, 0x54, 0xa6, 0xb5, 0x14, 0xb9, 0xab, 0x92, 0x8f,
+	0xc8, 0xc9, 0x34, 0x77, 0x89, 0x8f, 0xc8, 0x09, 0xfa, 0x18, 0x16, 0x64, 0x18, 0x42, 0x47, 0xd5,
+	0x44, 0xca, 0xd0, 0x84, 0x1b, 0x8a, 0x96, 0x20, 0x3f, 0x98, 0x88, 0x65, 0x54, 0xc6, 0xf9, 0xc1,
+	0x84, 0x2f, 0x5d, 0x45, 0x42, 0x41, 0x90, 0xa0, 0xa4, 0x56, 0x03, 0x0a, 0xbc, 0x64, 0xbc, 0x8b,
+	0x02, 0x5b, 0xcd, 0x7d, 0x05, 0x8b, 0x73, 0xab, 0x0b, 0xe5, 0xb4, 0x50, 0xca, 0x9f, 0x76, 0x86,
+	0x3f, 0x3d, 0xe3, 0x6f, 0x0d, 0x0c, 0x51, 0x31, 0x6e, 0x90, 0xe1, 0x4e, 0x49, 0xad, 0x5f, 0x34,
+	0x58, 0x4a, 0xd7, 0x8e, 0x5a, 0xdf, 0xeb, 0x50, 0x9c, 0xbe, 0x4f, 0x78, 0xed, 0x97, 0xa6, 0xed,
+	0x25, 0xb4, 0xdb, 0x39, 0xac, 0x70, 0xd4, 0x80, 0xd2, 0xb1, 0x1d, 0x07, 0x9c, 0x51, 0xf1, 0xee,
+	0xd8, 0xce, 0xe1, 0x54, 0x81, 0xae, 0xa5, 0x33, 0xa3, 0xbf, 0x79, 0x66, 0xb6, 0x73, 0x6a, 0x6a,
+	0x36, 0xcb, 0x50, 0x8c, 0x09, 0x4d, 0x3c, 0xd6, 0xfa, 0x3b, 0x0f, 0x17, 0x04, 0x37, 0x7b, 0xb6,
+	0x3f, 0xdb, 0x85, 0xe7, 0xee, 0x0e, 0xed, 0x3d, 0x76, 0x47, 0xfe, 0x3d, 0x77, 0x47, 0x1d, 0x0c,
+	0xca, 0xec, 0x98, 0xa9, 0x17, 0x8d, 0x14, 0x50, 0x0d, 0x74, 0x12, 0x8c, 0xd4, 0xea, 0xe4, 0xc7,
+	0xd9, 0x0a, 0x31, 0xde, 0xbe, 0x42, 0xe6, 0xbb, 0xb6, 0xf8, 0x0e, 0x2b, 0xfc, 0xcd, 0x93, 0x5e,
+	0x3a, 0x67, 0xd2, 0x63, 0x40, 0xf3, 0xf5, 0x56, 0x4d, 0x50, 0x07, 0x83, 0x37, 0x9d, 0x7c, 0x85,
+	0x57, 0xb0, 0x14, 0x50, 0x03, 0xca, 0x8a, 0x5f, 0xde, 0xe5, 0x1c, 0x98, 0xca, 0xb3, 0x0c, 0xf5,
+	0xb7, 0x66, 0xd8, 0xfa, 0x49, 0x57, 0x0f, 0x7d, 0x6c, 0x7b, 0xc9, 0x8c, 0xe5, 0x3a, 0x18, 0x22,
+	0x60, 0xd5, 0xf6, 0x52, 0x38, 0x9f, 0xfb, 0xfc, 0x7b, 0x70, 0xaf, 0x7f, 0x28, 0xee, 0x0b, 0x67,
+	0x70, 0x6f, 0x9c, 0xc1, 0x7d, 0xf1, 0xdd, 0xb8, 0x2f, 0x7d, 0x10, 0xee, 0xcb, 0xe7, 0x70, 0x9f,
+	0xc0, 0xc5, 0x0c, 0x0d, 0x8a, 0xfc, 0x65, 0x28, 0x7e, 0x2f, 0x34, 0x8a, 0x7d, 0x25, 0x7d, 0x28,
+	0xfa, 0xaf, 0x7e, 0x07, 0x95, 0xe9, 0x9f, 0x2d, 0x54, 0x85, 0xd2, 0xc1, 0xde, 0x57, 0x7b, 0x0f,
+	0x0e, 0xf7, 0x6a, 0x39, 0x54, 0x01, 0xe3, 0xd1, 0x41, 0x0f, 0x7f, 0x53, 0xd3, 0x50, 0x19, 0x0a,
+	0xf8, 0xe0, 0x7e, 0xaf, 0x96, 0xe7, 0x16, 0xfd, 0x9d, 0x3b, 0xbd, 0xad, 0x0d, 0x5c, 0xd3, 0xb9,
+	0x45, 0x7f, 0xff, 0x01, 0xee, 0xd5, 0x0a, 0x5c, 0x8f, 0x7b, 0x5b, 0xbd, 0x9d, 0xc7, 0xbd, 0x9a,
+	0xc1, 0xf5, 0x77, 0x7a, 0x9b, 0x07, 0xf7, 0x6a, 0xc5, 0xab, 0x9b, 0x50, 0xe0, 0x7f, 0x3e, 0x50,
+	0x09, 0x74, 0xbc, 0x71, 0x28, 0xbd, 0x6e, 0x3d, 0x38, 0xd8, 0xdb, 0xaf, 0x69, 0x5c, 0xd7, 0x3f,
+	0xd8, 0xad, 0xe5, 0xf9, 0x61, 0x77, 0x67, 0xaf, 0xa6, 0x8b, 0xc3, 0xc6, 0xd7, 0xd2, 0x9d, 0xb0,
+	0xea, 0xe1, 0x9a, 0xd1, 0xfd, 0x21, 0x0f, 0x86, 0x88, 0x11, 0x7d, 0x0e, 0x05, 0xf1, 0x1a, 0xb8,
+	0x98, 0xf2, 0x30, 0xf7, 0xdf, 0xb7, 0x51, 0xcf, 0x2a, 0x55, 0xfd, 0xbe, 0x80, 0xa2, 0xdc, 0x95,
+	0xe8, 0x52, 0x76, 0x77, 0xa6, 0xd7, 0x96, 0x4f, 0xab, 0xe5, 0xc5, 0xeb, 0x1a, 0xda, 0x02, 0x98,
+	0x4d, 0x23, 0x5a, 0xc9, 0x70, 0x3f, 0xbf, 0x11, 0x1b, 0x8d, 0xb3, 0x20, 0xf5, 0xfc, 0xbb, 0x50,
+	0x9d, 0xa3, 0x15, 0x65, 0x4d, 0x33, 0x23, 0xd7, 0xb8, 0x72, 0x26, 0x26, 0xfd, 0x74, 0xf7, 0x60,
+	0x49, 0x7c, 0x6d, 0xf0, 0x59, 0x92, 0xc5, 0xb8, 0x0d, 0x55, 0x4c, 0xfc, 0x90, 0x11, 0xa1, 0x47,
+	0xd3, 0xf4, 0xe7, 0x3f, 0x4a, 0x1a, 0x97, 0x4e, 0x69, 0xd5, 0xc7, 0x4b, 0x6e, 0xf3, 0xd3, 0xe7,
+	0x7f, 0xad, 0xe6, 0x9e, 0xbf, 0x5a, 0xd5, 0x5e, 0xbc, 0x5a, 0xd5, 0xfe, 0x7c, 0xb5, 0xaa, 0xfd,
+	0xfa, 0x7a, 0x35, 0xf7, 0xe2, 0xf5, 0x6a, 0xee, 0xf7, 0xd7, 0xab, 0xb9, 0x27, 0x25, 0xf5, 0xfd,
+	0x34, 0x28, 0x8a, 0x9e, 0xb9, 0xf1, 0xef, 0x00, 0x53, 0x22, 0x6e, 0x1b, 0xa9, 0x0d, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -1370,6 +1379,11 @@ func (m *InfoResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if m.MaxSeriesPerQuery != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.MaxSeriesPerQuery))
+		i--
+		dAtA[i] = 0x30
+	}
 	if len(m.LabelSets) > 0 {
 		for iNdEx := len(m.LabelSets) - 1; iNdEx >= 0; iNdEx-- {
 			{
@@ -1586,6 +1600,25 @@ func (m *QueryHints) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.Matchers) > 0 {
+		for iNdEx := len(m.Matchers) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Matchers[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintRpc(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x3a
+		}
+	}
+	if m.TotalLimit != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.TotalLimit))
+		i--
+		dAtA[i] = 0x30
+	}
 	if m.Range != nil {
 		{
 			size, err := m.Range.MarshalToSizedBuffer(dAtA[:i])
@@ -2227,6 +2260,9 @@ func (m *InfoResponse) Size() (n int) {
 			n += 1 + l + sovRpc(uint64(l))
 		}
 	}
+	if m.MaxSeriesPerQuery != 0 {
+		n += 1 + sovRpc(uint64(m.MaxSeriesPerQuery))
+	}
 	return n
 }
 
@@ -2315,6 +2351,15 @@ func (m *QueryHints) Size() (n int) {
 		l = m.Range.Size()
 		n += 1 + l + sovRpc(uint64(l))
 	}
+	if m.TotalLimit != 0 {
+		n += 1 + sovRpc(uint64(m.TotalLimit))
+	}
+	if len(m.Matchers) > 0 {
+		for _, e := range m.Matchers {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
 	return n
 }
 
@@ -2954,6 +2999,25 @@ func (m *InfoResponse) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxSeriesPerQuery", wireType)
+			}
+			m.MaxSeriesPerQuery = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxSeriesPerQuery |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -3578,6 +3642,59 @@ func (m *QueryHints) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TotalLimit", wireType)
+			}
+			m.TotalLimit = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TotalLimit |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Matchers", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Matchers = append(m.Matchers, LabelMatcher{})
+			if err := m.Matchers[len(m.Matchers)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -16,6 +16,7 @@ var sep = []byte{'\xff'}
 type ShardMatcher struct {
 	buf              *[]byte
 	buffers          *sync.Pool
+	maxBufferSize    int
 	shardingLabelset map[string]struct{}
 
 	isSharded   bool
@@ -32,7 +33,8 @@ func (s *ShardMatcher) Close() {
 	if s == nil {
 		return
 	}
-	if s.buffers != nil {
+	// Buffers grown beyond the maximum size are dropped, so that the pool does not hold on to them.
+	if s.buffers != nil && (s.maxBufferSize <= 0 || cap(*s.buf) <= s.maxBufferSize) {
 		s.buffers.Put(s.buf)
 	}
 }
@@ -76,6 +78,12 @@ func shardByLabel(labelSet map[string]struct{}, zlabel labelpb.ZLabel, groupingB
 }
 
 func (m *ShardInfo) Matcher(buffers *sync.Pool) *ShardMatcher {
+	return m.MatcherWithMaxBufferSize(buffers, 0)
+}
+
+// MatcherWithMaxBufferSize returns a matcher which does not return its buffer to the pool on close if it grew
+// beyond maxBufferSize bytes. 0 means no limit.
+func (m *ShardInfo) MatcherWithMaxBufferSize(buffers *sync.Pool, maxBufferSize int) *ShardMatcher {
 	if m == nil || m.TotalShards < 1 {
 		return &ShardMatcher{
 			isSharded: false,
@@ -86,6 +94,7 @@ func (m *ShardInfo) Matcher(buffers *sync.Pool) *ShardMatcher {
 		isSharded:        true,
 		buf:              buffers.Get().(*[]byte),
 		buffers:          buffers,
+		maxBufferSize:    maxBufferSize,
 		shardingLabelset: m.labelSet(),
 		by:               m.By,
 		totalShards:      m.TotalShards,
@@ -4,6 +4,7 @@
 package storepb
 
 import (
+	"strings"
 	"sync"
 	"testing"
 
@@ -125,3 +126,22 @@ func TestShardInfo_MatchesSeries(t *testing.T) {
 		})
 	}
 }
+
+func TestShardMatcher_MaxBufferSize(t *testing.T) {
+	var allocated int
+	buffers := sync.Pool{New: func() interface{} {
+		allocated++
+		b := make([]byte, 0, 16)
+		return &b
+	}}
+	shardInfo := &ShardInfo{TotalShards: 2, By: true, Labels: []string{"a"}}
+
+	// A buffer which grew beyond the maximum is not returned to the pool, so the next matcher allocates one.
+	matcher := shardInfo.MatcherWithMaxBufferSize(&buffers, 64)
+	matcher.MatchesZLabels([]labelpb.ZLabel{{Name: "a", Value: strings.Repeat("x", 100)}})
+	matcher.Close()
+	shardInfo.MatcherWithMaxBufferSize(&buffers, 64).Close()
+	if allocated != 2 {
+		t.Fatalf("expected the grown buffer to be dropped, got %d allocations", allocated)
+	}
+}
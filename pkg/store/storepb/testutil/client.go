@@ -4,6 +4,8 @@
 package storetestutil
 
 import (
+	"time"
+
 	"github.com/prometheus/prometheus/model/labels"
 
 	"github.com/thanos-io/thanos/pkg/info/infopb"
@@ -21,6 +23,8 @@ type TestClient struct {
 	WithoutReplicaLabelsEnabled bool
 	IsLocalStore                bool
 	StoreTSDBInfos              []infopb.TSDBInfo
+	CompressionAlgos            []string
+	StoreResponseTimeout        time.Duration
 
 	GroupKeyStr   string
 	ReplicaKeyStr string
@@ -35,3 +39,13 @@ func (c TestClient) String() string                     { return c.Name }
 func (c TestClient) Addr() (string, bool)               { return c.Name, c.IsLocalStore }
 func (c TestClient) GroupKey() string                   { return c.GroupKeyStr }
 func (c TestClient) ReplicaKey() string                 { return c.ReplicaKeyStr }
+func (c TestClient) ResponseTimeout() time.Duration     { return c.StoreResponseTimeout }
+
+func (c TestClient) SupportsCompression(algo string) bool {
+	for _, a := range c.CompressionAlgos {
+		if a == algo {
+			return true
+		}
+	}
+	return false
+}
@@ -45,18 +45,23 @@ const (
 	/// If a group has more than one replicas, the group can tolerate any number of endpoint failures wihtin one replica. It doens't
 	///   tolerate endpoint failures across replicas.
 	PartialResponseStrategy_GROUP_REPLICA PartialResponseStrategy = 2
+	/// QUORUM strategy tells server to succeed as long as a majority of the endpoints of each group responded
+	/// without errors. Errors of the other endpoints are not returned as warnings.
+	PartialResponseStrategy_QUORUM PartialResponseStrategy = 3
 )
 
 var PartialResponseStrategy_name = map[int32]string{
 	0: "WARN",
 	1: "ABORT",
 	2: "GROUP_REPLICA",
+	3: "QUORUM",
 }
 
 var PartialResponseStrategy_value = map[string]int32{
 	"WARN":          0,
 	"ABORT":         1,
 	"GROUP_REPLICA": 2,
+	"QUORUM":        3,
 }
 
 func (x PartialResponseStrategy) String() string {
@@ -300,44 +305,44 @@ func init() {
 func init() { proto.RegisterFile("store/storepb/types.proto", fileDescriptor_121fba57de02d8e0) }
 
 var fileDescriptor_121fba57de02d8e0 = []byte{
-	// 583 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x53, 0x4d, 0x6f, 0xd3, 0x40,
-	0x10, 0xf5, 0x3a, 0x8e, 0x93, 0x0c, 0x2d, 0xb8, 0x4b, 0x05, 0x6e, 0x0f, 0x4e, 0x64, 0x84, 0x88,
-	0x2a, 0xd5, 0x96, 0x0a, 0x12, 0x17, 0x2e, 0x4e, 0x65, 0x4a, 0xa5, 0xb6, 0x69, 0xb7, 0x41, 0xa0,
-	0x5e, 0xaa, 0x8d, 0xbb, 0xb2, 0xad, 0xc6, 0x1f, 0xb2, 0xd7, 0x90, 0xfe, 0x0b, 0x10, 0x37, 0x0e,
-	0xfc, 0x9e, 0x1c, 0x7b, 0x44, 0x1c, 0x2a, 0x68, 0xff, 0x08, 0xf2, 0xda, 0xa1, 0x44, 0xca, 0xc5,
-	0x1a, 0xbf, 0xf7, 0x66, 0x66, 0xe7, 0xed, 0x2c, 0x6c, 0xe4, 0x3c, 0xc9, 0x98, 0x2d, 0xbe, 0xe9,
-	0xd8, 0xe6, 0x57, 0x29, 0xcb, 0xad, 0x34, 0x4b, 0x78, 0x82, 0x55, 0x1e, 0xd0, 0x38, 0xc9, 0x37,
-	0xd7, 0xfd, 0xc4, 0x4f, 0x04, 0x64, 0x97, 0x51, 0xc5, 0x6e, 0xd6, 0x89, 0x13, 0x3a, 0x66, 0x93,
-	0xc5, 0x44, 0xf3, 0x3b, 0x82, 0xe6, 0x6e, 0x50, 0xc4, 0x97, 0x78, 0x0b, 0x94, 0x92, 0xd0, 0x51,
-	0x0f, 0xf5, 0x1f, 0xee, 0x3c, 0xb1, 0xaa, 0x8a, 0x96, 0x20, 0x2d, 0x37, 0xf6, 0x92, 0x8b, 0x30,
-	0xf6, 0x89, 0xd0, 0x60, 0x0c, 0xca, 0x05, 0xe5, 0x54, 0x97, 0x7b, 0xa8, 0xbf, 0x42, 0x44, 0x8c,
-	0x75, 0x50, 0x02, 0x9a, 0x07, 0x7a, 0xa3, 0x87, 0xfa, 0xca, 0x40, 0x99, 0xdd, 0x74, 0x11, 0x11,
-	0x88, 0xf9, 0x1a, 0xda, 0xf3, 0x7c, 0xdc, 0x82, 0xc6, 0xc7, 0x21, 0xd1, 0x24, 0xbc, 0x0a, 0x9d,
-	0x77, 0xfb, 0xa7, 0xa3, 0xe1, 0x1e, 0x71, 0x0e, 0x35, 0x84, 0x1f, 0xc3, 0xa3, 0xb7, 0x07, 0x43,
-	0x67, 0x74, 0x7e, 0x0f, 0xca, 0xe6, 0x0f, 0x04, 0xea, 0x29, 0xcb, 0x42, 0x96, 0x63, 0x0f, 0x54,
-	0x71, 0xfc, 0x5c, 0x47, 0xbd, 0x46, 0xff, 0xc1, 0xce, 0xea, 0xfc, 0x7c, 0x07, 0x25, 0x3a, 0x78,
-	0x33, 0xbb, 0xe9, 0x4a, 0xbf, 0x6e, 0xba, 0xaf, 0xfc, 0x90, 0x07, 0xc5, 0xd8, 0xf2, 0x92, 0xc8,
-	0xae, 0x04, 0xdb, 0x61, 0x52, 0x47, 0x76, 0x7a, 0xe9, 0xdb, 0x0b, 0x4e, 0x58, 0x67, 0x22, 0x9b,
-	0xd4, 0xa5, 0xb1, 0x0d, 0xaa, 0x57, 0x8e, 0x9b, 0xeb, 0xb2, 0x68, 0xb2, 0x36, 0x6f, 0xe2, 0xf8,
-	0x7e, 0x26, 0x8c, 0x10, 0x73, 0x49, 0xa4, 0x96, 0x99, 0xdf, 0x64, 0xe8, 0xfc, 0xe3, 0xf0, 0x06,
-	0xb4, 0xa3, 0x30, 0x3e, 0xe7, 0x61, 0x54, 0xb9, 0xd8, 0x20, 0xad, 0x28, 0x8c, 0x47, 0x61, 0xc4,
-	0x04, 0x45, 0xa7, 0x15, 0x25, 0xd7, 0x14, 0x9d, 0x0a, 0xaa, 0x0b, 0x8d, 0x8c, 0x7e, 0x16, 0xb6,
-	0xfd, 0x37, 0x96, 0xa8, 0x48, 0x4a, 0x06, 0x3f, 0x83, 0xa6, 0x97, 0x14, 0x31, 0xd7, 0x95, 0x65,
-	0x92, 0x8a, 0x2b, 0xab, 0xe4, 0x45, 0xa4, 0x37, 0x97, 0x56, 0xc9, 0x8b, 0xa8, 0x14, 0x44, 0x61,
-	0xac, 0xab, 0x4b, 0x05, 0x51, 0x18, 0x0b, 0x01, 0x9d, 0xea, 0xad, 0xe5, 0x02, 0x3a, 0xc5, 0x2f,
-	0xa0, 0x25, 0x7a, 0xb1, 0x4c, 0x6f, 0x2f, 0x13, 0xcd, 0x59, 0xf3, 0x2b, 0x82, 0x15, 0x61, 0xec,
-	0x21, 0xe5, 0x5e, 0xc0, 0x32, 0xbc, 0xbd, 0xb0, 0x5a, 0x1b, 0x0b, 0x57, 0x57, 0x6b, 0xac, 0xd1,
-	0x55, 0xca, 0xee, 0xb7, 0x2b, 0xa6, 0xb5, 0x51, 0x1d, 0x22, 0x62, 0xbc, 0x0e, 0xcd, 0x4f, 0x74,
-	0x52, 0x30, 0xe1, 0x53, 0x87, 0x54, 0x3f, 0x66, 0x1f, 0x94, 0x32, 0x0f, 0xab, 0x20, 0xbb, 0x27,
-	0x9a, 0x54, 0x6e, 0xd7, 0x91, 0x7b, 0xa2, 0xa1, 0x12, 0x20, 0xae, 0x26, 0x0b, 0x80, 0xb8, 0x5a,
-	0x63, 0xcb, 0x81, 0xa7, 0xc7, 0x34, 0xe3, 0x21, 0x9d, 0x10, 0x96, 0xa7, 0x49, 0x9c, 0xb3, 0x53,
-	0x9e, 0x51, 0xce, 0xfc, 0x2b, 0xdc, 0x06, 0xe5, 0x83, 0x43, 0x8e, 0x34, 0x09, 0x77, 0xa0, 0xe9,
-	0x0c, 0x86, 0x64, 0xa4, 0x21, 0xbc, 0x06, 0xab, 0x7b, 0x64, 0xf8, 0xfe, 0xf8, 0x9c, 0xb8, 0xc7,
-	0x07, 0xfb, 0xbb, 0x8e, 0x26, 0x0f, 0x9e, 0xcf, 0xfe, 0x18, 0xd2, 0xec, 0xd6, 0x40, 0xd7, 0xb7,
-	0x06, 0xfa, 0x7d, 0x6b, 0xa0, 0x2f, 0x77, 0x86, 0x74, 0x7d, 0x67, 0x48, 0x3f, 0xef, 0x0c, 0xe9,
-	0xac, 0x55, 0x3f, 0xcb, 0xb1, 0x2a, 0x1e, 0xd6, 0xcb, 0xbf, 0x01, 0x00, 0x00, 0xff, 0xff, 0xee,
-	0x6c, 0xcf, 0x99, 0xae, 0x03, 0x00, 0x00,
+	// 591 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x53, 0x4f, 0x6f, 0xd3, 0x4e,
+	0x10, 0xf5, 0xda, 0x8e, 0x93, 0xcc, 0xaf, 0xfd, 0xe1, 0x2e, 0x15, 0xb8, 0x3d, 0x38, 0x51, 0x10,
+	0x22, 0xaa, 0x54, 0x5b, 0x2a, 0x48, 0x5c, 0xb8, 0x24, 0x55, 0x28, 0x95, 0x9a, 0xba, 0xdd, 0xa6,
+	0x02, 0xf5, 0x52, 0x6d, 0xdc, 0x95, 0x6d, 0x35, 0xfe, 0x23, 0x7b, 0x0d, 0xe9, 0xb7, 0x00, 0x71,
+	0xe3, 0xc0, 0xe7, 0xc9, 0xb1, 0x47, 0xc4, 0xa1, 0x82, 0xe6, 0x8b, 0x20, 0xaf, 0x1d, 0x4a, 0xa4,
+	0x5c, 0xac, 0xf1, 0x7b, 0x6f, 0x66, 0x76, 0xde, 0xce, 0xc2, 0x56, 0xc6, 0xe3, 0x94, 0xd9, 0xe2,
+	0x9b, 0x8c, 0x6d, 0x7e, 0x93, 0xb0, 0xcc, 0x4a, 0xd2, 0x98, 0xc7, 0x58, 0xe3, 0x3e, 0x8d, 0xe2,
+	0x6c, 0x7b, 0xd3, 0x8b, 0xbd, 0x58, 0x40, 0x76, 0x11, 0x95, 0xec, 0x76, 0x95, 0x38, 0xa1, 0x63,
+	0x36, 0x59, 0x4e, 0xec, 0x7c, 0x43, 0x50, 0xdb, 0xf7, 0xf3, 0xe8, 0x1a, 0xef, 0x80, 0x5a, 0x10,
+	0x06, 0x6a, 0xa3, 0xee, 0xff, 0x7b, 0x4f, 0xac, 0xb2, 0xa2, 0x25, 0x48, 0x6b, 0x10, 0xb9, 0xf1,
+	0x55, 0x10, 0x79, 0x44, 0x68, 0x30, 0x06, 0xf5, 0x8a, 0x72, 0x6a, 0xc8, 0x6d, 0xd4, 0x5d, 0x23,
+	0x22, 0xc6, 0x06, 0xa8, 0x3e, 0xcd, 0x7c, 0x43, 0x69, 0xa3, 0xae, 0xda, 0x57, 0x67, 0x77, 0x2d,
+	0x44, 0x04, 0xd2, 0x79, 0x0d, 0x8d, 0x45, 0x3e, 0xae, 0x83, 0xf2, 0xc1, 0x21, 0xba, 0x84, 0xd7,
+	0xa1, 0xf9, 0xee, 0xf0, 0x6c, 0xe4, 0x1c, 0x90, 0xde, 0x50, 0x47, 0xf8, 0x31, 0x3c, 0x7a, 0x7b,
+	0xe4, 0xf4, 0x46, 0x97, 0x0f, 0xa0, 0xdc, 0xf9, 0x8e, 0x40, 0x3b, 0x63, 0x69, 0xc0, 0x32, 0xec,
+	0x82, 0x26, 0x8e, 0x9f, 0x19, 0xa8, 0xad, 0x74, 0xff, 0xdb, 0x5b, 0x5f, 0x9c, 0xef, 0xa8, 0x40,
+	0xfb, 0x6f, 0x66, 0x77, 0x2d, 0xe9, 0xe7, 0x5d, 0xeb, 0x95, 0x17, 0x70, 0x3f, 0x1f, 0x5b, 0x6e,
+	0x1c, 0xda, 0xa5, 0x60, 0x37, 0x88, 0xab, 0xc8, 0x4e, 0xae, 0x3d, 0x7b, 0xc9, 0x09, 0xeb, 0x42,
+	0x64, 0x93, 0xaa, 0x34, 0xb6, 0x41, 0x73, 0x8b, 0x71, 0x33, 0x43, 0x16, 0x4d, 0x36, 0x16, 0x4d,
+	0x7a, 0x9e, 0x97, 0x0a, 0x23, 0xc4, 0x5c, 0x12, 0xa9, 0x64, 0x9d, 0xaf, 0x32, 0x34, 0xff, 0x72,
+	0x78, 0x0b, 0x1a, 0x61, 0x10, 0x5d, 0xf2, 0x20, 0x2c, 0x5d, 0x54, 0x48, 0x3d, 0x0c, 0xa2, 0x51,
+	0x10, 0x32, 0x41, 0xd1, 0x69, 0x49, 0xc9, 0x15, 0x45, 0xa7, 0x82, 0x6a, 0x81, 0x92, 0xd2, 0x4f,
+	0xc2, 0xb6, 0x7f, 0xc6, 0x12, 0x15, 0x49, 0xc1, 0xe0, 0x67, 0x50, 0x73, 0xe3, 0x3c, 0xe2, 0x86,
+	0xba, 0x4a, 0x52, 0x72, 0x45, 0x95, 0x2c, 0x0f, 0x8d, 0xda, 0xca, 0x2a, 0x59, 0x1e, 0x16, 0x82,
+	0x30, 0x88, 0x0c, 0x6d, 0xa5, 0x20, 0x0c, 0x22, 0x21, 0xa0, 0x53, 0xa3, 0xbe, 0x5a, 0x40, 0xa7,
+	0xf8, 0x05, 0xd4, 0x45, 0x2f, 0x96, 0x1a, 0x8d, 0x55, 0xa2, 0x05, 0xdb, 0xf9, 0x82, 0x60, 0x4d,
+	0x18, 0x3b, 0xa4, 0xdc, 0xf5, 0x59, 0x8a, 0x77, 0x97, 0x56, 0x6b, 0x6b, 0xe9, 0xea, 0x2a, 0x8d,
+	0x35, 0xba, 0x49, 0xd8, 0xc3, 0x76, 0x45, 0xb4, 0x32, 0xaa, 0x49, 0x44, 0x8c, 0x37, 0xa1, 0xf6,
+	0x91, 0x4e, 0x72, 0x26, 0x7c, 0x6a, 0x92, 0xf2, 0xa7, 0xd3, 0x05, 0xb5, 0xc8, 0xc3, 0x1a, 0xc8,
+	0x83, 0x53, 0x5d, 0x2a, 0xb6, 0xeb, 0x78, 0x70, 0xaa, 0xa3, 0x02, 0x20, 0x03, 0x5d, 0x16, 0x00,
+	0x19, 0xe8, 0xca, 0xce, 0x10, 0x9e, 0x9e, 0xd0, 0x94, 0x07, 0x74, 0x42, 0x58, 0x96, 0xc4, 0x51,
+	0xc6, 0xce, 0x78, 0x4a, 0x39, 0xf3, 0x6e, 0x70, 0x03, 0xd4, 0xf7, 0x3d, 0x72, 0xac, 0x4b, 0xb8,
+	0x09, 0xb5, 0x5e, 0xdf, 0x21, 0x23, 0x1d, 0xe1, 0x0d, 0x58, 0x3f, 0x20, 0xce, 0xf9, 0xc9, 0x25,
+	0x19, 0x9c, 0x1c, 0x1d, 0xee, 0xf7, 0x74, 0x19, 0x03, 0x68, 0xa7, 0xe7, 0x0e, 0x39, 0x1f, 0xea,
+	0x4a, 0xff, 0xf9, 0xec, 0xb7, 0x29, 0xcd, 0xee, 0x4d, 0x74, 0x7b, 0x6f, 0xa2, 0x5f, 0xf7, 0x26,
+	0xfa, 0x3c, 0x37, 0xa5, 0xdb, 0xb9, 0x29, 0xfd, 0x98, 0x9b, 0xd2, 0x45, 0xbd, 0x7a, 0xa2, 0x63,
+	0x4d, 0x3c, 0xb2, 0x97, 0x7f, 0x06, 0x00, 0xf1, 0xe0, 0x64, 0x37, 0xba, 0x03, 0x00, 0x00,
 }
 
 func (m *Chunk) Marshal() (dAtA []byte, err error) {
@@ -20,15 +20,19 @@ var DefaultSelector = noopSelector()
 
 type TSDBSelector struct {
 	relabelConfig []*relabel.Config
+	matchers      []*labels.Matcher
 }
 
 func noopSelector() *TSDBSelector {
 	return NewTSDBSelector(nil)
 }
 
-func NewTSDBSelector(relabelConfig []*relabel.Config) *TSDBSelector {
+// NewTSDBSelector returns a selector keeping the label sets which are kept by the given relabel config and
+// match all the given matchers. A label missing from a label set is matched as an empty value.
+func NewTSDBSelector(relabelConfig []*relabel.Config, matchers ...*labels.Matcher) *TSDBSelector {
 	return &TSDBSelector{
 		relabelConfig: relabelConfig,
+		matchers:      matchers,
 	}
 }
 
@@ -36,7 +40,7 @@ func NewTSDBSelector(relabelConfig []*relabel.Config) *TSDBSelector {
 // As a second parameter, it returns the matched label sets if they are a subset of the given input.
 // Otherwise the second return value is nil.
 func (sr *TSDBSelector) MatchLabelSets(labelSets ...labels.Labels) (bool, []labels.Labels) {
-	if sr.relabelConfig == nil || len(labelSets) == 0 {
+	if (sr.relabelConfig == nil && len(sr.matchers) == 0) || len(labelSets) == 0 {
 		return true, nil
 	}
 	matchedLabelSets := sr.runRelabelRules(labelSets)
@@ -49,6 +53,9 @@ func (sr *TSDBSelector) runRelabelRules(labelSets []labels.Labels) []labels.Labe
 		if _, keep := relabel.Process(labelSet, sr.relabelConfig...); !keep {
 			continue
 		}
+		if !sr.matches(labelSet) {
+			continue
+		}
 
 		result = append(result, labelSet)
 	}
@@ -56,6 +63,15 @@ func (sr *TSDBSelector) runRelabelRules(labelSets []labels.Labels) []labels.Labe
 	return result
 }
 
+func (sr *TSDBSelector) matches(labelSet labels.Labels) bool {
+	for _, m := range sr.matchers {
+		if !m.Matches(labelSet.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
 // MatchersForLabelSets generates a list of label matchers for the given label sets.
 func MatchersForLabelSets(labelSets []labels.Labels) []storepb.LabelMatcher {
 	var (
@@ -100,3 +116,38 @@ func MatchersForLabelSets(labelSets []labels.Labels) []storepb.LabelMatcher {
 
 	return matchers
 }
+
+// PruneLabelSetMatchers removes the label set matchers generated by MatchersForLabelSets that are implied
+// by a more specific equality matcher on the same label name in the request matchers.
+func PruneLabelSetMatchers(requestMatchers, labelSetMatchers []storepb.LabelMatcher) []storepb.LabelMatcher {
+	equal := make(map[string][]string)
+	for _, m := range requestMatchers {
+		if m.Type == storepb.LabelMatcher_EQ {
+			equal[m.Name] = append(equal[m.Name], m.Value)
+		}
+	}
+
+	pruned := make([]storepb.LabelMatcher, 0, len(labelSetMatchers))
+	for _, m := range labelSetMatchers {
+		if !impliedByEqualMatchers(m, equal[m.Name]) {
+			pruned = append(pruned, m)
+		}
+	}
+	return pruned
+}
+
+func impliedByEqualMatchers(m storepb.LabelMatcher, equalValues []string) bool {
+	if len(equalValues) == 0 {
+		return false
+	}
+	pm, err := storepb.MatchersToPromMatchers(m)
+	if err != nil {
+		return false
+	}
+	for _, v := range equalValues {
+		if pm[0].Matches(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -89,3 +89,143 @@ func TestMatchersForLabelSets(t *testing.T) {
 		})
 	}
 }
+
+func TestPruneLabelSetMatchers(t *testing.T) {
+	labelSetMatchers := []storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_RE, Name: "a", Value: "1|2"},
+		{Type: storepb.LabelMatcher_RE, Name: "b", Value: "1|2"},
+		{Type: storepb.LabelMatcher_RE, Name: "c", Value: "1|2"},
+	}
+	for _, tc := range []struct {
+		name            string
+		requestMatchers []storepb.LabelMatcher
+		want            []storepb.LabelMatcher
+	}{
+		{
+			name: "no request matchers",
+			want: labelSetMatchers,
+		},
+		{
+			name: "equality matchers imply label set matchers",
+			requestMatchers: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"},
+				{Type: storepb.LabelMatcher_EQ, Name: "b", Value: "2"},
+			},
+			want: labelSetMatchers[2:],
+		},
+		{
+			name: "equality matcher not matching label set matcher",
+			requestMatchers: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "3"},
+			},
+			want: labelSetMatchers,
+		},
+		{
+			name: "regexp matchers do not imply label set matchers",
+			requestMatchers: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_RE, Name: "a", Value: "1"},
+			},
+			want: labelSetMatchers,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.Equals(t, tc.want, PruneLabelSetMatchers(tc.requestMatchers, labelSetMatchers))
+		})
+	}
+}
+
+func TestTSDBSelector_MatchLabelSets(t *testing.T) {
+	var (
+		euWest  = labels.FromStrings("cluster", "eu-west", "env", "prod")
+		euEast  = labels.FromStrings("cluster", "eu-east", "env", "dev")
+		usEast  = labels.FromStrings("cluster", "us-east", "env", "prod")
+		noLabel = labels.FromStrings("env", "prod")
+	)
+	for _, tc := range []struct {
+		name          string
+		matchers      []*labels.Matcher
+		labelSets     []labels.Labels
+		wantMatch     bool
+		wantLabelSets []labels.Labels
+	}{
+		{
+			name:      "no matchers",
+			labelSets: []labels.Labels{euWest, usEast},
+			wantMatch: true,
+		},
+		{
+			name:      "no label sets",
+			matchers:  []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "cluster", "eu-west")},
+			wantMatch: true,
+		},
+		{
+			name:          "equal",
+			matchers:      []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "cluster", "eu-west")},
+			labelSets:     []labels.Labels{euWest, euEast, usEast},
+			wantMatch:     true,
+			wantLabelSets: []labels.Labels{euWest},
+		},
+		{
+			name:          "not equal",
+			matchers:      []*labels.Matcher{labels.MustNewMatcher(labels.MatchNotEqual, "cluster", "eu-west")},
+			labelSets:     []labels.Labels{euWest, euEast, usEast},
+			wantMatch:     true,
+			wantLabelSets: []labels.Labels{euEast, usEast},
+		},
+		{
+			name:          "regexp",
+			matchers:      []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "cluster", "eu-.*")},
+			labelSets:     []labels.Labels{euWest, euEast, usEast},
+			wantMatch:     true,
+			wantLabelSets: []labels.Labels{euWest, euEast},
+		},
+		{
+			name:          "not regexp",
+			matchers:      []*labels.Matcher{labels.MustNewMatcher(labels.MatchNotRegexp, "cluster", "eu-.*")},
+			labelSets:     []labels.Labels{euWest, euEast, usEast},
+			wantMatch:     true,
+			wantLabelSets: []labels.Labels{usEast},
+		},
+		{
+			name: "multiple matchers",
+			matchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchRegexp, "cluster", "eu-.*"),
+				labels.MustNewMatcher(labels.MatchEqual, "env", "prod"),
+			},
+			labelSets:     []labels.Labels{euWest, euEast, usEast},
+			wantMatch:     true,
+			wantLabelSets: []labels.Labels{euWest},
+		},
+		{
+			name:          "missing label matched as empty value",
+			matchers:      []*labels.Matcher{labels.MustNewMatcher(labels.MatchNotRegexp, "cluster", "us-.*")},
+			labelSets:     []labels.Labels{usEast, noLabel},
+			wantMatch:     true,
+			wantLabelSets: []labels.Labels{noLabel},
+		},
+		{
+			name:          "no label set matching",
+			matchers:      []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "cluster", "ap-.*")},
+			labelSets:     []labels.Labels{euWest, usEast},
+			wantMatch:     false,
+			wantLabelSets: []labels.Labels{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			match, labelSets := NewTSDBSelector(nil, tc.matchers...).MatchLabelSets(tc.labelSets...)
+			testutil.Equals(t, tc.wantMatch, match)
+			testutil.Equals(t, tc.wantLabelSets, labelSets)
+		})
+	}
+
+	// The matched label sets are turned into the matchers added to the requests sent to the store.
+	_, labelSets := NewTSDBSelector(nil, labels.MustNewMatcher(labels.MatchRegexp, "cluster", "eu-.*")).MatchLabelSets(euWest, euEast, usEast)
+	matchers := MatchersForLabelSets(labelSets)
+	sort.Slice(matchers, func(i, j int) bool {
+		return matchers[i].Name < matchers[j].Name
+	})
+	testutil.Equals(t, []storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_RE, Name: "cluster", Value: "eu-east|eu-west"},
+		{Type: storepb.LabelMatcher_RE, Name: "env", Value: "dev|prod"},
+	}, matchers)
+}
@@ -32,6 +32,64 @@ func MergeUnsortedSlices(a ...[]string) []string {
 	return MergeSlices(a...)
 }
 
+// HeapMergeSlices merges a set of sorted string slices into a single one while removing all duplicates,
+// like MergeSlices. It merges all slices at once using a heap, allocating only the result instead of
+// the intermediate slices of pairwise merges.
+func HeapMergeSlices(a ...[]string) []string {
+	if len(a) == 0 {
+		return nil
+	}
+
+	h := make([][]string, 0, len(a))
+	maxl := 0
+	for _, s := range a {
+		if len(s) > 0 {
+			h = append(h, s)
+		}
+		if len(s) > maxl {
+			maxl = len(s)
+		}
+	}
+	for i := len(h)/2 - 1; i >= 0; i-- {
+		siftDown(h, i)
+	}
+
+	res := make([]string, 0, maxl)
+	for len(h) > 0 {
+		s := h[0]
+		if len(res) == 0 || res[len(res)-1] != s[0] {
+			res = append(res, s[0])
+		}
+		if len(s) == 1 {
+			h[0] = h[len(h)-1]
+			h = h[:len(h)-1]
+		} else {
+			h[0] = s[1:]
+		}
+		siftDown(h, 0)
+	}
+	return res
+}
+
+// siftDown restores the order of a min-heap of non-empty sorted string slices, ordered by their first
+// element, after the slice at index i was changed.
+func siftDown(h [][]string, i int) {
+	for {
+		smallest := i
+		if l := 2*i + 1; l < len(h) && h[l][0] < h[smallest][0] {
+			smallest = l
+		}
+		if r := 2*i + 2; r < len(h) && h[r][0] < h[smallest][0] {
+			smallest = r
+		}
+		if smallest == i {
+			return
+		}
+		h[i], h[smallest] = h[smallest], h[i]
+		i = smallest
+	}
+}
+
 func mergeTwoStringSlices(a, b []string) []string {
 	maxl := len(a)
 	if len(b) > len(a) {
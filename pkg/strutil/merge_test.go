@@ -0,0 +1,62 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package strutil
+
+import (
+	"testing"
+
+	"github.com/efficientgo/core/testutil"
+)
+
+func TestHeapMergeSlices(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    [][]string
+		expected []string
+	}{
+		{
+			name:     "no input",
+			expected: nil,
+		},
+		{
+			name:     "empty inputs",
+			input:    [][]string{{}, nil, {}},
+			expected: []string{},
+		},
+		{
+			name:     "single input",
+			input:    [][]string{{"a", "b", "c"}},
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			name:     "single input with duplicates",
+			input:    [][]string{{"a", "a", "b", "c", "c"}},
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			name:     "empty and non empty inputs",
+			input:    [][]string{nil, {"b"}, {}, {"a", "c"}},
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			name:     "duplicates across inputs",
+			input:    [][]string{{"a", "c", "e"}, {"a", "b", "c"}, {"c", "d", "e"}, {"e"}},
+			expected: []string{"a", "b", "c", "d", "e"},
+		},
+		{
+			name:     "identical inputs",
+			input:    [][]string{{"a", "b"}, {"a", "b"}, {"a", "b"}},
+			expected: []string{"a", "b"},
+		},
+		{
+			name:     "disjoint inputs",
+			input:    [][]string{{"g", "h"}, {"a", "b"}, {"e", "f"}, {"c", "d"}, {"i"}},
+			expected: []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testutil.Equals(t, tc.expected, HeapMergeSlices(tc.input...))
+		})
+	}
+}